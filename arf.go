@@ -0,0 +1,116 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// ARF feedback report of a mailbox provider (RFC 5965)
+type ARF struct {
+	FeedbackType     string // abuse, fraud, virus, not-spam, auth-failure or other
+	UserAgent        string
+	Version          string
+	OriginalMailFrom string
+	OriginalRcptTo   []string
+	ArrivalDate      time.Time
+	ReportingMTA     string
+	SourceIP         string
+	ReportedDomain   []string
+	OriginalHeaders  textproto.MIMEHeader // header of the reported message
+	raw              []byte
+}
+
+// ParseARF parse a feedback report, also when it is nested in another
+// multipart
+func ParseARF(message []byte) (*ARF, error) {
+	header, body, err := splitMIMEEntity(message)
+	if err != nil {
+		return nil, err
+	}
+	report, err := findReport(header, body, "feedback-report")
+	if err != nil {
+		return nil, err
+	}
+
+	arf := &ARF{raw: message}
+	found := false
+	for _, part := range report {
+		mediaType, _, _ := mime.ParseMediaType(part.header.Get("Content-Type"))
+		switch mediaType {
+		case "message/feedback-report":
+			r := textproto.NewReader(bufio.NewReader(bytes.NewReader(append(toCRLF(bytes.TrimSpace(part.body)), "\r\n\r\n"...))))
+			fields, err := r.ReadMIMEHeader()
+			if err != nil && len(fields) == 0 {
+				return nil, fmt.Errorf("Invalid feedback-report fields: %s", err)
+			}
+			arf.FeedbackType = strings.ToLower(strings.TrimSpace(fields.Get("Feedback-Type")))
+			arf.UserAgent = fields.Get("User-Agent")
+			arf.Version = fields.Get("Version")
+			arf.OriginalMailFrom = strings.Trim(fields.Get("Original-Mail-From"), "<>")
+			for _, v := range fields["Original-Rcpt-To"] {
+				arf.OriginalRcptTo = append(arf.OriginalRcptTo, strings.Trim(strings.TrimSpace(v), "<>"))
+			}
+			if t, err := mail.ParseDate(fields.Get("Arrival-Date")); err == nil {
+				arf.ArrivalDate = t.UTC()
+			} else if t, err := mail.ParseDate(fields.Get("Received-Date")); err == nil {
+				arf.ArrivalDate = t.UTC()
+			}
+			arf.ReportingMTA = dsnValue(fields.Get("Reporting-Mta"))
+			arf.SourceIP = fields.Get("Source-Ip")
+			arf.ReportedDomain = fields["Reported-Domain"]
+			found = true
+		case "message/rfc822", "text/rfc822-headers", "message/global", "message/global-headers":
+			if arf.OriginalHeaders == nil {
+				arf.OriginalHeaders = originalHeader(part.body)
+			}
+		}
+	}
+	if !found || arf.FeedbackType == "" {
+		return nil, fmt.Errorf("Feedback report without Feedback-Type")
+	}
+	return arf, nil
+}
+
+// Recipient address that complained: the Original-Rcpt-To, else the To of
+// the reported message, which some providers redact
+func (arf *ARF) Recipient() string {
+	if len(arf.OriginalRcptTo) > 0 {
+		return arf.OriginalRcptTo[0]
+	}
+	if to := arf.OriginalHeaders.Get("To"); to != "" {
+		if addr, err := mail.ParseAddress(to); err == nil {
+			return addr.Address
+		}
+		return strings.TrimSpace(to)
+	}
+	return ""
+}
+
+// Event complained event of the report, with the feedback type as Reason;
+// ok is false for not-spam reports
+func (arf *ARF) Event() (Event, bool) {
+	if arf.FeedbackType == "not-spam" {
+		return Event{}, false
+	}
+	ts := arf.ArrivalDate
+	if ts.IsZero() {
+		if t, err := mail.ParseDate(arf.OriginalHeaders.Get("Date")); err == nil {
+			ts = t.UTC()
+		}
+	}
+	return Event{
+		Provider:  "arf",
+		Type:      EventComplained,
+		Email:     arf.Recipient(),
+		MessageID: strings.TrimSpace(arf.OriginalHeaders.Get("Message-Id")),
+		Timestamp: ts,
+		Reason:    arf.FeedbackType,
+		Raw:       arf.raw,
+	}, true
+}
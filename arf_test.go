@@ -0,0 +1,99 @@
+package mailer_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/thiagozs/mailer-go"
+)
+
+func TestParseARF(t *testing.T) {
+	t.Log("Parse feedback reports of mailbox providers... (NOT expected some err)")
+
+	for _, c := range []struct {
+		file, feedbackType, userAgent, recipient, messageID, sourceIP string
+		arrival                                                       time.Time
+		complaint                                                     bool
+	}{
+		{"rfc5965-abuse.eml", "abuse", "SomeGenerator/1.0", "user@example.com",
+			"8787KJKJ3K4J3K4J3K4J3.mail@example.net", "192.0.2.1",
+			time.Date(2005, 3, 8, 14, 0, 0, 0, time.UTC), true},
+		{"yahoo-redacted.eml", "abuse", "Yahoo!-Mail-Feedback/2.0", "jane.doe@yahoo.com",
+			"<1760867882.2c3d@example.com>", "198.51.100.7",
+			time.Date(2026, 10, 19, 9, 58, 3, 0, time.UTC), true},
+		{"headers-only-not-spam.eml", "not-spam", "ISPFeedback/3.1", "happy@isp.example.org",
+			"<1760871000.11@example.com>", "",
+			time.Date(2026, 10, 19, 11, 0, 0, 0, time.UTC), false},
+	} {
+		data, err := os.ReadFile("testdata/arf/" + c.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		arf, err := mailer.ParseARF(data)
+		if err != nil {
+			t.Errorf("ParseARF %s got: %s", c.file, err)
+			continue
+		}
+		if arf.FeedbackType != c.feedbackType || arf.UserAgent != c.userAgent || arf.SourceIP != c.sourceIP ||
+			!arf.ArrivalDate.Equal(c.arrival) || arf.Recipient() != c.recipient {
+			t.Errorf("ParseARF %s got: %+v", c.file, arf)
+		}
+		if got := arf.OriginalHeaders.Get("Message-Id"); got != c.messageID {
+			t.Errorf("ParseARF %s Message-Id got: %s", c.file, got)
+		}
+
+		e, ok := arf.Event()
+		if ok != c.complaint {
+			t.Errorf("Event %s got: %v", c.file, ok)
+			continue
+		}
+		if ok && (e.Type != mailer.EventComplained || e.Provider != "arf" || e.Email != c.recipient ||
+			e.MessageID != c.messageID || e.Reason != c.feedbackType || !e.Timestamp.Equal(c.arrival)) {
+			t.Errorf("Event %s got: %+v", c.file, e)
+		}
+	}
+}
+
+func TestARFSuppression(t *testing.T) {
+	t.Log("Suppress the recipient of a complaint report... (NOT expected some err)")
+
+	data, err := os.ReadFile("testdata/arf/yahoo-redacted.eml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	arf, err := mailer.ParseARF(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := mailer.NewSuppressionList()
+	if e, ok := arf.Event(); ok {
+		if err := list.HandleEvent(e); err != nil {
+			t.Errorf("HandleEvent got: %s", err)
+		}
+	}
+	if s, ok := list.Check("Jane.Doe@yahoo.com"); !ok || s.Reason != mailer.SuppressionComplained || s.Detail != "abuse" {
+		t.Errorf("Check got: %+v %v", s, ok)
+	}
+}
+
+func TestParseARFErrors(t *testing.T) {
+	t.Log("Parse messages that are not feedback reports... (expected some err)")
+
+	dsn, err := os.ReadFile("testdata/dsn/postfix-user-unknown.eml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, message := range map[string]string{
+		"bounce":    string(dsn),
+		"no header": "just text",
+		"no feedback type": "Content-Type: multipart/report; report-type=feedback-report; boundary=b\r\n\r\n" +
+			"--b\r\nContent-Type: message/feedback-report\r\n\r\nUser-Agent: x/1\r\nVersion: 1\r\n\r\n--b--\r\n",
+		"no feedback part": "Content-Type: multipart/report; report-type=feedback-report; boundary=b\r\n\r\n" +
+			"--b\r\nContent-Type: text/plain\r\n\r\nspam\r\n--b--\r\n",
+	} {
+		if _, err := mailer.ParseARF([]byte(message)); err == nil {
+			t.Errorf("ParseARF %s expected an error", name)
+		}
+	}
+}
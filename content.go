@@ -0,0 +1,24 @@
+package mailer
+
+// renderContent final HTML and plain text bodies of a message; markdown,
+// when given, fills whichever of html and text is empty, inlineCSS moves
+// the <style> rules into style attributes, and an HTML-only body gets a
+// text part converted from it
+func renderContent(markdown, html, text string, inlineCSS bool) (string, string) {
+	if markdown != "" {
+		mdHTML, mdText := RenderMarkdown(markdown)
+		if html == "" {
+			html = mdHTML
+		}
+		if text == "" {
+			text = mdText
+		}
+	}
+	if inlineCSS && html != "" {
+		html = InlineCSS(html)
+	}
+	if text == "" && html != "" {
+		text = HTMLToText(html)
+	}
+	return html, text
+}
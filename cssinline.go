@@ -0,0 +1,763 @@
+package mailer
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// InlineCSS apply the rules of the <style> blocks of an HTML body as inline
+// style attributes, honoring selector specificity, source order and
+// !important. Rules that can't be inlined (@media, @font-face, :hover and
+// other dynamic pseudo-classes, ::pseudo-elements) stay in a <style> block
+// for the clients that support them. A <style data-inline="false"> block, or
+// one with a media other than all or screen, is left untouched.
+func InlineCSS(src string) string {
+	doc := parseHTML(src)
+
+	var sheets []*htmlNode
+	doc.walk(func(n *htmlNode) bool {
+		if n.typ == htmlElementNode && n.tag == "style" {
+			v, _ := n.attr("data-inline")
+			media, _ := n.attr("media")
+			if v != "false" && isInlineMedia(media) {
+				sheets = append(sheets, n)
+			}
+			return false
+		}
+		return true
+	})
+	if len(sheets) == 0 {
+		return src
+	}
+
+	var rules []cssRule
+	order := 0
+	for _, sheet := range sheets {
+		var kept []string
+		for _, item := range parseCSS(sheet.textContent()) {
+			if item.raw != "" {
+				kept = append(kept, item.raw)
+				continue
+			}
+			var keepSelectors []string
+			for _, sel := range splitCSSList(item.selectors, ',') {
+				sel = strings.TrimSpace(sel)
+				complex, ok := parseCSSSelector(sel)
+				if !ok {
+					keepSelectors = append(keepSelectors, sel)
+					continue
+				}
+				for _, d := range item.decls {
+					order++
+					rules = append(rules, cssRule{selector: complex, decl: d, order: order})
+				}
+			}
+			if len(keepSelectors) > 0 {
+				kept = append(kept, strings.Join(keepSelectors, ", ")+" { "+formatCSSDecls(item.decls, true)+" }")
+			}
+		}
+
+		if len(kept) == 0 {
+			sheet.parent.removeChild(sheet)
+			continue
+		}
+		sheet.children = []*htmlNode{{typ: htmlTextNode, text: "\n" + strings.Join(kept, "\n") + "\n", parent: sheet}}
+	}
+
+	doc.walk(func(n *htmlNode) bool {
+		if n.typ != htmlElementNode {
+			return true
+		}
+		if n.tag == "head" || n.tag == "style" || n.tag == "script" {
+			return false
+		}
+		applyCSSRules(n, rules)
+		return true
+	})
+
+	return doc.String()
+}
+
+// isInlineMedia report whether the rules of a <style> with the media
+// attribute apply on screen whatever its size, as the inlined ones do
+func isInlineMedia(media string) bool {
+	switch strings.ToLower(strings.TrimSpace(media)) {
+	case "", "all", "screen":
+		return true
+	}
+	return false
+}
+
+// cssDecl one property: value declaration
+type cssDecl struct {
+	property  string
+	value     string
+	important bool
+}
+
+// cssItem top level item of a stylesheet: a rule set, or a raw at-rule
+type cssItem struct {
+	selectors string
+	decls     []cssDecl
+	raw       string
+}
+
+// cssRule declaration of a selector, in stylesheet order
+type cssRule struct {
+	selector cssSelector
+	decl     cssDecl
+	order    int
+}
+
+// cssSelector compound selectors joined by combinators, left to right
+type cssSelector struct {
+	compounds   []cssCompound
+	combinators []byte // combinators[i] joins compounds[i] and compounds[i+1]
+}
+
+// cssCompound simple selectors that must all match one element
+type cssCompound struct {
+	tag     string
+	id      string
+	classes []string
+	attrs   []cssAttrSelector
+	pseudos []cssPseudo
+}
+
+// cssAttrSelector [name], [name=val], [name~=val], [name^=val], ...
+type cssAttrSelector struct {
+	name string
+	op   string
+	val  string
+}
+
+// cssPseudo structural pseudo-class (:first-child, :nth-child(2n+1), ...)
+type cssPseudo struct {
+	name string
+	a, b int
+}
+
+// applyCSSRules merge the matching rules and the existing style of n into
+// its style attribute
+func applyCSSRules(n *htmlNode, rules []cssRule) {
+	type candidate struct {
+		decl   cssDecl
+		inline bool
+		spec   [3]int
+		order  int
+	}
+
+	wins := func(x, y candidate) bool {
+		if x.decl.important != y.decl.important {
+			return x.decl.important
+		}
+		if x.inline != y.inline {
+			return x.inline
+		}
+		if x.spec != y.spec {
+			for i := range x.spec {
+				if x.spec[i] != y.spec[i] {
+					return x.spec[i] > y.spec[i]
+				}
+			}
+		}
+		return x.order > y.order
+	}
+
+	best := make(map[string]candidate)
+	for _, r := range rules {
+		if !r.selector.matches(n) {
+			continue
+		}
+		c := candidate{decl: r.decl, spec: r.selector.specificity(), order: r.order}
+		if cur, ok := best[r.decl.property]; !ok || wins(c, cur) {
+			best[r.decl.property] = c
+		}
+	}
+	if len(best) == 0 {
+		return
+	}
+
+	style, _ := n.attr("style")
+	for i, d := range parseCSSDecls(style) {
+		c := candidate{decl: d, inline: true, order: i}
+		if cur, ok := best[d.property]; !ok || wins(c, cur) {
+			best[d.property] = c
+		}
+	}
+
+	list := make([]candidate, 0, len(best))
+	for _, c := range best {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].inline != list[j].inline {
+			return !list[i].inline
+		}
+		return list[i].order < list[j].order
+	})
+
+	decls := make([]cssDecl, len(list))
+	for i, c := range list {
+		decls[i] = c.decl
+		// an inline !important would defeat the @media overrides kept in
+		// the <style> block
+		decls[i].important = false
+	}
+	n.setAttr("style", formatCSSDecls(decls, false))
+}
+
+// parseCSS split a stylesheet into rule sets and raw at-rules
+func parseCSS(css string) []cssItem {
+	css = stripCSSComments(css)
+
+	var items []cssItem
+	for i := 0; i < len(css); {
+		for i < len(css) && isHTMLSpace(css[i]) {
+			i++
+		}
+		if i >= len(css) {
+			break
+		}
+
+		// HTML comment markers are allowed around stylesheets
+		if strings.HasPrefix(css[i:], "<!--") {
+			i += 4
+			continue
+		}
+		if strings.HasPrefix(css[i:], "-->") {
+			i += 3
+			continue
+		}
+
+		if css[i] == '@' {
+			end := scanCSSAtRule(css, i)
+			items = append(items, cssItem{raw: strings.TrimSpace(css[i:end])})
+			i = end
+			continue
+		}
+
+		open := indexCSS(css, i, '{')
+		if open < 0 {
+			break
+		}
+		close := indexCSS(css, open+1, '}')
+		if close < 0 {
+			close = len(css)
+		}
+		items = append(items, cssItem{
+			selectors: strings.TrimSpace(css[i:open]),
+			decls:     parseCSSDecls(css[open+1 : close]),
+		})
+		i = close + 1
+	}
+	return items
+}
+
+// scanCSSAtRule index after the at-rule starting at i (its ';' or block)
+func scanCSSAtRule(css string, i int) int {
+	depth := 0
+	for j := i; j < len(css); j++ {
+		switch css[j] {
+		case '"', '\'':
+			j = skipCSSString(css, j)
+		case ';':
+			if depth == 0 {
+				return j + 1
+			}
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return j + 1
+			}
+		}
+	}
+	return len(css)
+}
+
+// parseCSSDecls parse "a: b; c: d !important"
+func parseCSSDecls(s string) []cssDecl {
+	var decls []cssDecl
+	for _, part := range splitCSSList(s, ';') {
+		colon := strings.IndexByte(part, ':')
+		if colon < 0 {
+			continue
+		}
+		prop := strings.ToLower(strings.TrimSpace(part[:colon]))
+		val := strings.TrimSpace(part[colon+1:])
+		if prop == "" || val == "" {
+			continue
+		}
+		d := cssDecl{property: prop, value: val}
+		lower := strings.ToLower(val)
+		if i := strings.LastIndex(lower, "!important"); i >= 0 && strings.TrimSpace(lower[i+len("!important"):]) == "" {
+			d.important = true
+			d.value = strings.TrimSpace(val[:i])
+		}
+		decls = append(decls, d)
+	}
+	return decls
+}
+
+// formatCSSDecls serialize declarations
+func formatCSSDecls(decls []cssDecl, important bool) string {
+	parts := make([]string, len(decls))
+	for i, d := range decls {
+		parts[i] = d.property + ": " + d.value
+		if important && d.important {
+			parts[i] += " !important"
+		}
+	}
+	return strings.Join(parts, "; ") + ";"
+}
+
+// parseCSSSelector parse a complex selector; false when it can't be
+// matched statically
+func parseCSSSelector(s string) (cssSelector, bool) {
+	var sel cssSelector
+	var cur cssCompound
+	empty := true
+	pending := byte(0)
+
+	push := func() bool {
+		if empty {
+			return false
+		}
+		if len(sel.compounds) > 0 {
+			if pending == 0 {
+				pending = ' '
+			}
+			sel.combinators = append(sel.combinators, pending)
+		}
+		sel.compounds = append(sel.compounds, cur)
+		cur = cssCompound{}
+		empty = true
+		pending = 0
+		return true
+	}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case isHTMLSpace(c):
+			if !empty && !push() {
+				return sel, false
+			}
+			i++
+		case c == '>' || c == '+' || c == '~':
+			if !empty && !push() {
+				return sel, false
+			}
+			if len(sel.compounds) == 0 || pending != 0 && pending != ' ' {
+				return sel, false
+			}
+			pending = c
+			i++
+		case c == '*':
+			cur.tag = "*"
+			empty = false
+			i++
+		case c == '#' || c == '.':
+			name, j := scanCSSIdent(s, i+1)
+			if name == "" {
+				return sel, false
+			}
+			if c == '#' {
+				cur.id = name
+			} else {
+				cur.classes = append(cur.classes, name)
+			}
+			empty = false
+			i = j
+		case c == '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return sel, false
+			}
+			a, ok := parseCSSAttrSelector(s[i+1 : i+end])
+			if !ok {
+				return sel, false
+			}
+			cur.attrs = append(cur.attrs, a)
+			empty = false
+			i += end + 1
+		case c == ':':
+			if i+1 < len(s) && s[i+1] == ':' {
+				return sel, false // pseudo-element
+			}
+			name, j := scanCSSIdent(s, i+1)
+			name = strings.ToLower(name)
+			arg := ""
+			if j < len(s) && s[j] == '(' {
+				end := strings.IndexByte(s[j:], ')')
+				if end < 0 {
+					return sel, false
+				}
+				arg = strings.TrimSpace(s[j+1 : j+end])
+				j += end + 1
+			}
+			p, ok := parseCSSPseudo(name, arg)
+			if !ok {
+				return sel, false
+			}
+			cur.pseudos = append(cur.pseudos, p)
+			empty = false
+			i = j
+		default:
+			name, j := scanCSSIdent(s, i)
+			if name == "" {
+				return sel, false
+			}
+			cur.tag = strings.ToLower(name)
+			empty = false
+			i = j
+		}
+	}
+
+	if empty {
+		if pending != 0 && pending != ' ' {
+			return sel, false
+		}
+		return sel, len(sel.compounds) > 0
+	}
+	push()
+	return sel, true
+}
+
+// parseCSSAttrSelector parse the inside of [name op "val"]
+func parseCSSAttrSelector(s string) (cssAttrSelector, bool) {
+	for _, op := range []string{"~=", "|=", "^=", "$=", "*=", "="} {
+		if i := strings.Index(s, op); i >= 0 {
+			val := strings.TrimSpace(s[i+len(op):])
+			val = strings.Trim(val, "\"'")
+			return cssAttrSelector{name: strings.ToLower(strings.TrimSpace(s[:i])), op: op, val: val}, true
+		}
+	}
+	name := strings.ToLower(strings.TrimSpace(s))
+	return cssAttrSelector{name: name}, name != ""
+}
+
+// parseCSSPseudo structural pseudo-classes as an+b positions
+func parseCSSPseudo(name, arg string) (cssPseudo, bool) {
+	switch name {
+	case "first-child", "first-of-type":
+		return cssPseudo{name: name, a: 0, b: 1}, true
+	case "last-child", "last-of-type", "only-child", "only-of-type", "empty", "root":
+		return cssPseudo{name: name}, true
+	case "nth-child", "nth-last-child", "nth-of-type", "nth-last-of-type":
+		a, b, ok := parseCSSNth(arg)
+		return cssPseudo{name: name, a: a, b: b}, ok
+	}
+	return cssPseudo{}, false
+}
+
+// parseCSSNth parse "odd", "even", "3", "2n+1", "-n+3"
+func parseCSSNth(arg string) (int, int, bool) {
+	arg = strings.ToLower(strings.ReplaceAll(arg, " ", ""))
+	switch arg {
+	case "odd":
+		return 2, 1, true
+	case "even":
+		return 2, 0, true
+	}
+	i := strings.IndexByte(arg, 'n')
+	if i < 0 {
+		b, err := strconv.Atoi(arg)
+		return 0, b, err == nil
+	}
+	var a int
+	switch arg[:i] {
+	case "", "+":
+		a = 1
+	case "-":
+		a = -1
+	default:
+		v, err := strconv.Atoi(arg[:i])
+		if err != nil {
+			return 0, 0, false
+		}
+		a = v
+	}
+	b := 0
+	if rest := arg[i+1:]; rest != "" {
+		v, err := strconv.Atoi(rest)
+		if err != nil {
+			return 0, 0, false
+		}
+		b = v
+	}
+	return a, b, true
+}
+
+// specificity (ids, classes/attributes/pseudo-classes, types)
+func (sel cssSelector) specificity() [3]int {
+	var s [3]int
+	for _, c := range sel.compounds {
+		if c.id != "" {
+			s[0]++
+		}
+		s[1] += len(c.classes) + len(c.attrs) + len(c.pseudos)
+		if c.tag != "" && c.tag != "*" {
+			s[2]++
+		}
+	}
+	return s
+}
+
+// matches report whether n matches the selector
+func (sel cssSelector) matches(n *htmlNode) bool {
+	return sel.matchAt(n, len(sel.compounds)-1)
+}
+
+// matchAt match compounds[:i+1] with compounds[i] on n
+func (sel cssSelector) matchAt(n *htmlNode, i int) bool {
+	if !sel.compounds[i].matches(n) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+
+	switch sel.combinators[i-1] {
+	case '>':
+		p := n.parent
+		return p != nil && p.typ == htmlElementNode && sel.matchAt(p, i-1)
+	case '+':
+		prev := previousElementSibling(n)
+		return prev != nil && sel.matchAt(prev, i-1)
+	case '~':
+		for prev := previousElementSibling(n); prev != nil; prev = previousElementSibling(prev) {
+			if sel.matchAt(prev, i-1) {
+				return true
+			}
+		}
+		return false
+	default:
+		for p := n.parent; p != nil && p.typ == htmlElementNode; p = p.parent {
+			if sel.matchAt(p, i-1) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// matches report whether n matches every simple selector of c
+func (c cssCompound) matches(n *htmlNode) bool {
+	if n.typ != htmlElementNode {
+		return false
+	}
+	if c.tag != "" && c.tag != "*" && c.tag != n.tag {
+		return false
+	}
+	if c.id != "" {
+		if id, _ := n.attr("id"); id != c.id {
+			return false
+		}
+	}
+	if len(c.classes) > 0 {
+		class, _ := n.attr("class")
+		have := strings.Fields(class)
+		for _, want := range c.classes {
+			if !stringInSlice(want, have) {
+				return false
+			}
+		}
+	}
+	for _, a := range c.attrs {
+		if !a.matches(n) {
+			return false
+		}
+	}
+	for _, p := range c.pseudos {
+		if !p.matches(n) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches report whether n satisfies the attribute selector
+func (a cssAttrSelector) matches(n *htmlNode) bool {
+	v, ok := n.attr(a.name)
+	if !ok {
+		return false
+	}
+	switch a.op {
+	case "=":
+		return v == a.val
+	case "~=":
+		return stringInSlice(a.val, strings.Fields(v))
+	case "|=":
+		return v == a.val || strings.HasPrefix(v, a.val+"-")
+	case "^=":
+		return a.val != "" && strings.HasPrefix(v, a.val)
+	case "$=":
+		return a.val != "" && strings.HasSuffix(v, a.val)
+	case "*=":
+		return a.val != "" && strings.Contains(v, a.val)
+	}
+	return true
+}
+
+// matches report whether n satisfies the structural pseudo-class
+func (p cssPseudo) matches(n *htmlNode) bool {
+	var siblings []*htmlNode
+	if n.parent != nil {
+		siblings = n.parent.elementChildren()
+	}
+	ofType := strings.HasSuffix(p.name, "of-type")
+	if ofType {
+		var same []*htmlNode
+		for _, s := range siblings {
+			if s.tag == n.tag {
+				same = append(same, s)
+			}
+		}
+		siblings = same
+	}
+
+	pos, count := 0, len(siblings)
+	for i, s := range siblings {
+		if s == n {
+			pos = i + 1
+		}
+	}
+
+	switch p.name {
+	case "root":
+		return n.parent == nil || n.parent.typ == htmlDocumentNode
+	case "empty":
+		return len(n.elementChildren()) == 0 && strings.TrimSpace(n.textContent()) == ""
+	case "last-child", "last-of-type":
+		return pos == count
+	case "only-child", "only-of-type":
+		return count == 1
+	case "nth-last-child", "nth-last-of-type":
+		pos = count - pos + 1
+	}
+	return nthMatches(p.a, p.b, pos)
+}
+
+// nthMatches report whether pos = a*k + b for some k >= 0
+func nthMatches(a, b, pos int) bool {
+	if a == 0 {
+		return pos == b
+	}
+	k := pos - b
+	return k%a == 0 && k/a >= 0
+}
+
+// previousElementSibling element before n under the same parent
+func previousElementSibling(n *htmlNode) *htmlNode {
+	if n.parent == nil {
+		return nil
+	}
+	var prev *htmlNode
+	for _, s := range n.parent.children {
+		if s == n {
+			return prev
+		}
+		if s.typ == htmlElementNode {
+			prev = s
+		}
+	}
+	return nil
+}
+
+// scanCSSIdent identifier starting at i, and the index after it
+func scanCSSIdent(s string, i int) (string, int) {
+	j := i
+	for j < len(s) {
+		c := s[j]
+		if c == '\\' && j+1 < len(s) {
+			j += 2
+			continue
+		}
+		if c == '-' || c == '_' || c >= 0x80 || isASCIILetter(c) || (c >= '0' && c <= '9') {
+			j++
+			continue
+		}
+		break
+	}
+	return strings.ReplaceAll(s[i:j], "\\", ""), j
+}
+
+// splitCSSList split s on sep outside strings, parentheses and brackets
+func splitCSSList(s string, sep byte) []string {
+	var parts []string
+	depth := 0
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"', '\'':
+			i = skipCSSString(s, i)
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// indexCSS index of c at or after i, outside strings
+func indexCSS(s string, i int, c byte) int {
+	for j := i; j < len(s); j++ {
+		switch s[j] {
+		case '"', '\'':
+			j = skipCSSString(s, j)
+		case c:
+			return j
+		}
+	}
+	return -1
+}
+
+// skipCSSString index of the quote closing the string opened at i
+func skipCSSString(s string, i int) int {
+	q := s[i]
+	for j := i + 1; j < len(s); j++ {
+		if s[j] == '\\' {
+			j++
+			continue
+		}
+		if s[j] == q {
+			return j
+		}
+	}
+	return len(s)
+}
+
+// stripCSSComments remove /* comments */ outside strings
+func stripCSSComments(s string) string {
+	var w strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"' || s[i] == '\'':
+			end := skipCSSString(s, i)
+			if end >= len(s) {
+				end = len(s) - 1
+			}
+			w.WriteString(s[i : end+1])
+			i = end
+		case s[i] == '/' && i+1 < len(s) && s[i+1] == '*':
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				return w.String()
+			}
+			i += end + 3
+		default:
+			w.WriteByte(s[i])
+		}
+	}
+	return w.String()
+}
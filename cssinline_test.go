@@ -0,0 +1,88 @@
+package mailer_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/thiagozs/mailer-go"
+)
+
+const cssSample = `<html><head><style>
+/* base */
+p { color: black; font-size: 14px; }
+.note { color: blue; }
+#main p.note { color: green; }
+td > p { margin: 0; }
+h1 + p { font-weight: bold; }
+a { color: red !important; }
+li:first-child { padding-top: 0; }
+li:nth-child(2n) { background: #eee; }
+a[href^="https"] { text-decoration: none; }
+a:hover, .btn { color: purple; }
+@media only screen and (max-width: 600px) { .note { font-size: 18px !important; } }
+</style><style data-inline="false">.keep { color: gray; }</style></head>
+<body><div id="main"><h1>Title</h1><p class="note">First</p><p style="color: orange; margin: 4px">Second</p>
+<table><tr><td><p>Cell</p></td></tr></table>
+<a href="https://example.com" style="color: yellow">link</a>
+<ul><li>a</li><li>b</li><li>c</li></ul></div></body></html>`
+
+func TestInlineCSS(t *testing.T) {
+	t.Log("InlineCSS... (NOT expected some err)")
+	got := mailer.InlineCSS(cssSample)
+
+	contains := []string{
+		// #main p.note beats .note and p; h1 + p adds font-weight
+		`<p class="note" style="font-size: 14px; color: green; font-weight: bold;">First</p>`,
+		// inline style beats the stylesheet, margin keeps the inline value
+		`<p style="font-size: 14px; color: orange; margin: 4px;">Second</p>`,
+		`<td><p style="color: black; font-size: 14px; margin: 0;">Cell</p></td>`,
+		// !important beats the inline style, and is dropped once inlined
+		`<a href="https://example.com" style="color: red; text-decoration: none;">link</a>`,
+		`<li style="padding-top: 0;">a</li><li style="background: #eee;">b</li><li>c</li>`,
+		// rules that can't be inlined stay in a style block
+		"a:hover { color: purple; }",
+		"@media only screen and (max-width: 600px) { .note { font-size: 18px !important; } }",
+		`<style data-inline="false">.keep { color: gray; }</style>`,
+	}
+	for _, c := range contains {
+		if !strings.Contains(got, c) {
+			t.Errorf("InlineCSS missing %q\ngot: %s", c, got)
+		}
+	}
+
+	for _, c := range []string{"/* base */", "#main p.note", "td > p"} {
+		if strings.Contains(got, c) {
+			t.Errorf("InlineCSS kept %q\ngot: %s", c, got)
+		}
+	}
+}
+
+func TestInlineCSSWithoutStyle(t *testing.T) {
+	t.Log("InlineCSS without style blocks... (NOT expected some err)")
+	src := `<p style="color: red">Hi &amp; bye</p>`
+	if got := mailer.InlineCSS(src); got != src {
+		t.Errorf("InlineCSS got: %s", got)
+	}
+}
+
+func TestInlineCSSDropsEmptyStyle(t *testing.T) {
+	t.Log("InlineCSS all rules inlined... (NOT expected some err)")
+	got := mailer.InlineCSS(`<head><style>p{color:red}</style></head><body><p>x</p></body>`)
+	expected := `<head></head><body><p style="color: red;">x</p></body>`
+	if got != expected {
+		t.Errorf("InlineCSS got: %s", got)
+	}
+}
+
+func TestInlineCSSMedia(t *testing.T) {
+	t.Log("InlineCSS of style blocks with a media... (NOT expected some err)")
+	got := mailer.InlineCSS(`<head><style media="print">p{color:black}</style>` +
+		`<style media="screen and (max-width: 600px)">p{font-size:18px}</style>` +
+		`<style media="Screen">p{margin:0}</style><style media="all">p{padding:0}</style></head><body><p>x</p></body>`)
+	expected := `<head><style media="print">p{color:black}</style>` +
+		`<style media="screen and (max-width: 600px)">p{font-size:18px}</style></head>` +
+		`<body><p style="margin: 0; padding: 0;">x</p></body>`
+	if got != expected {
+		t.Errorf("InlineCSS got: %s", got)
+	}
+}
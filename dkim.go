@@ -0,0 +1,536 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Canonicalization algorithms of DKIM (RFC 6376 3.4)
+const (
+	DKIMSimple  = "simple"
+	DKIMRelaxed = "relaxed"
+)
+
+// DKIMDefaultHeaders headers signed when DKIMOptions.Headers is empty; the
+// ones missing from a message are left out of the signature
+var DKIMDefaultHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID",
+	"In-Reply-To", "References", "MIME-Version", "Content-Type",
+	"Content-Transfer-Encoding", "List-Unsubscribe", "List-Unsubscribe-Post",
+}
+
+// DKIMOptions DKIM-Signature settings of a sender. PrivateKey is an
+// *rsa.PrivateKey (rsa-sha256) or an ed25519.PrivateKey (ed25519-sha256)
+type DKIMOptions struct {
+	Domain                 string
+	Selector               string
+	PrivateKey             crypto.Signer
+	Headers                []string
+	HeaderCanonicalization string // relaxed by default
+	BodyCanonicalization   string // simple by default
+}
+
+// NewDKIMOptions options signing for domain with the PEM private key in
+// keyFile, published under selector
+func NewDKIMOptions(domain, selector, keyFile string) (*DKIMOptions, error) {
+	key, err := LoadDKIMPrivateKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &DKIMOptions{Domain: domain, Selector: selector, PrivateKey: key}, nil
+}
+
+// LoadDKIMPrivateKey read a PEM private key file
+func LoadDKIMPrivateKey(path string) (crypto.Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDKIMPrivateKey(data)
+}
+
+// ParseDKIMPrivateKey parse a PKCS#1 RSA or a PKCS#8 RSA/Ed25519 PEM key
+func ParseDKIMPrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM data in DKIM key")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			return k, nil
+		case ed25519.PrivateKey:
+			return k, nil
+		}
+		return nil, fmt.Errorf("Unsupported DKIM key type %T", key)
+	}
+	return nil, fmt.Errorf("Unsupported PEM block %q in DKIM key", block.Type)
+}
+
+// DKIMRecord value of the <selector>._domainkey TXT record publishing pub
+func DKIMRecord(pub crypto.PublicKey) (string, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(k)
+		if err != nil {
+			return "", err
+		}
+		return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der), nil
+	case ed25519.PublicKey:
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(k), nil
+	}
+	return "", fmt.Errorf("Unsupported DKIM key type %T", pub)
+}
+
+// DKIMSign prepend a DKIM-Signature header to message; line endings are
+// normalized to CRLF
+func DKIMSign(message []byte, opts *DKIMOptions) ([]byte, error) {
+	if opts == nil || opts.PrivateKey == nil {
+		return nil, fmt.Errorf("Empty DKIM private key")
+	}
+	if opts.Domain == "" || opts.Selector == "" {
+		return nil, fmt.Errorf("Empty DKIM domain or selector")
+	}
+
+	var algo string
+	switch opts.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		algo = "rsa-sha256"
+	case ed25519.PrivateKey:
+		algo = "ed25519-sha256"
+	default:
+		return nil, fmt.Errorf("Unsupported DKIM key type %T", opts.PrivateKey)
+	}
+
+	headerCanon := opts.HeaderCanonicalization
+	if headerCanon == "" {
+		headerCanon = DKIMRelaxed
+	}
+	bodyCanon := opts.BodyCanonicalization
+	if bodyCanon == "" {
+		bodyCanon = DKIMSimple
+	}
+	if !isDKIMCanonicalization(headerCanon) || !isDKIMCanonicalization(bodyCanon) {
+		return nil, fmt.Errorf("Unknown DKIM canonicalization %s/%s", headerCanon, bodyCanon)
+	}
+
+	message = toCRLF(message)
+	fields, body := splitDKIMMessage(message)
+
+	names := opts.Headers
+	if len(names) == 0 {
+		for _, name := range DKIMDefaultHeaders {
+			if dkimHasField(fields, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	if !dkimContainsFold(names, "From") {
+		return nil, fmt.Errorf("DKIM signed headers must include From")
+	}
+
+	bodyHash := sha256.Sum256(canonicalizeDKIMBody(body, bodyCanon))
+
+	tags := []string{
+		"v=1",
+		"a=" + algo,
+		"c=" + headerCanon + "/" + bodyCanon,
+		"d=" + opts.Domain,
+		"s=" + opts.Selector,
+		"t=" + strconv.FormatInt(time.Now().Unix(), 10),
+		"h=" + strings.Join(names, ":"),
+		"bh=" + base64.StdEncoding.EncodeToString(bodyHash[:]),
+		"b=",
+	}
+	field := "DKIM-Signature: " + strings.Join(tags, ";\r\n\t")
+
+	digest := dkimHeaderHash(fields, names, field, headerCanon)
+	sig, err := opts.PrivateKey.Sign(rand.Reader, digest, dkimSignerOpts(algo))
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString(field)
+	out.WriteString(foldDKIMValue(base64.StdEncoding.EncodeToString(sig)))
+	out.WriteString("\r\n")
+	out.Write(message)
+	return out.Bytes(), nil
+}
+
+// signedBytes serialized message, DKIM signed when opts is set
+func (m *rawMessage) signedBytes(opts *DKIMOptions) ([]byte, error) {
+	if opts == nil {
+		return m.bytes(), nil
+	}
+	return DKIMSign(m.bytes(), opts)
+}
+
+// VerifyDKIM check the DKIM signatures of message; lookupTXT resolves the
+// key records (net.LookupTXT when nil). It succeeds when one signature
+// verifies and returns the signing domain
+func VerifyDKIM(message []byte, lookupTXT func(name string) ([]string, error)) (string, error) {
+	if lookupTXT == nil {
+		lookupTXT = net.LookupTXT
+	}
+
+	message = toCRLF(message)
+	fields, body := splitDKIMMessage(message)
+
+	err := fmt.Errorf("No DKIM-Signature header")
+	for _, f := range fields {
+		if !strings.EqualFold(dkimFieldName(f), "DKIM-Signature") {
+			continue
+		}
+		var domain string
+		domain, err = verifyDKIMField(f, fields, body, lookupTXT)
+		if err == nil {
+			return domain, nil
+		}
+	}
+	return "", err
+}
+
+// verifyDKIMField check one DKIM-Signature field
+func verifyDKIMField(field string, fields []string, body []byte, lookupTXT func(string) ([]string, error)) (string, error) {
+	value := field[strings.IndexByte(field, ':')+1:]
+	tags := parseDKIMTags(value)
+
+	if tags["v"] != "1" {
+		return "", fmt.Errorf("Unsupported DKIM version %q", tags["v"])
+	}
+	algo := tags["a"]
+	if algo != "rsa-sha256" && algo != "ed25519-sha256" {
+		return "", fmt.Errorf("Unsupported DKIM algorithm %q", algo)
+	}
+	domain, selector := tags["d"], tags["s"]
+	if domain == "" || selector == "" {
+		return "", fmt.Errorf("DKIM signature without domain or selector")
+	}
+
+	headerCanon, bodyCanon := DKIMSimple, DKIMSimple
+	if c := tags["c"]; c != "" {
+		parts := strings.SplitN(c, "/", 2)
+		headerCanon = parts[0]
+		if len(parts) == 2 {
+			bodyCanon = parts[1]
+		}
+	}
+	if !isDKIMCanonicalization(headerCanon) || !isDKIMCanonicalization(bodyCanon) {
+		return "", fmt.Errorf("Unknown DKIM canonicalization %q", tags["c"])
+	}
+
+	if x := tags["x"]; x != "" {
+		exp, err := strconv.ParseInt(x, 10, 64)
+		if err != nil || time.Now().Unix() > exp {
+			return "", fmt.Errorf("DKIM signature expired")
+		}
+	}
+
+	var names []string
+	for _, n := range strings.Split(tags["h"], ":") {
+		if n = strings.TrimSpace(n); n != "" {
+			names = append(names, n)
+		}
+	}
+	if !dkimContainsFold(names, "From") {
+		return "", fmt.Errorf("DKIM signature does not cover From")
+	}
+
+	canonBody := canonicalizeDKIMBody(body, bodyCanon)
+	if l := tags["l"]; l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n > len(canonBody) {
+			return "", fmt.Errorf("Invalid DKIM body length %q", l)
+		}
+		canonBody = canonBody[:n]
+	}
+	bodyHash := sha256.Sum256(canonBody)
+	if base64.StdEncoding.EncodeToString(bodyHash[:]) != tags["bh"] {
+		return "", fmt.Errorf("DKIM body hash mismatch")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return "", fmt.Errorf("Invalid DKIM signature encoding")
+	}
+
+	pub, err := lookupDKIMKey(domain, selector, lookupTXT)
+	if err != nil {
+		return "", err
+	}
+
+	// the signature field itself is hashed with an empty b= value and
+	// without it among the selected fields
+	var others []string
+	for _, f := range fields {
+		if f != field {
+			others = append(others, f)
+		}
+	}
+	digest := dkimHeaderHash(others, names, stripDKIMSignature(field), headerCanon)
+
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if algo != "rsa-sha256" {
+			return "", fmt.Errorf("DKIM key type does not match %s", algo)
+		}
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest, sig); err != nil {
+			return "", fmt.Errorf("DKIM signature mismatch")
+		}
+	case ed25519.PublicKey:
+		if algo != "ed25519-sha256" {
+			return "", fmt.Errorf("DKIM key type does not match %s", algo)
+		}
+		if !ed25519.Verify(k, digest, sig) {
+			return "", fmt.Errorf("DKIM signature mismatch")
+		}
+	}
+	return domain, nil
+}
+
+// lookupDKIMKey public key published at <selector>._domainkey.<domain>
+func lookupDKIMKey(domain, selector string, lookupTXT func(string) ([]string, error)) (crypto.PublicKey, error) {
+	records, err := lookupTXT(selector + "._domainkey." + domain)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("No DKIM key for %s._domainkey.%s", selector, domain)
+	}
+
+	tags := parseDKIMTags(strings.Join(records, ""))
+	if tags["p"] == "" {
+		return nil, fmt.Errorf("DKIM key revoked")
+	}
+	der, err := base64.StdEncoding.DecodeString(tags["p"])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid DKIM key encoding")
+	}
+
+	switch tags["k"] {
+	case "", "rsa":
+		pub, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			return nil, err
+		}
+		rsaPub, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("DKIM key is not RSA")
+		}
+		return rsaPub, nil
+	case "ed25519":
+		if len(der) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("Invalid Ed25519 DKIM key")
+		}
+		return ed25519.PublicKey(der), nil
+	}
+	return nil, fmt.Errorf("Unsupported DKIM key type %q", tags["k"])
+}
+
+// dkimHeaderHash SHA-256 of the selected header fields and the signature
+// field, canonicalized
+func dkimHeaderHash(fields []string, names []string, sigField, canon string) []byte {
+	used := make(map[int]bool)
+	h := sha256.New()
+	for _, name := range names {
+		// instances are taken from the bottom up
+		for i := len(fields) - 1; i >= 0; i-- {
+			if used[i] || !strings.EqualFold(dkimFieldName(fields[i]), name) {
+				continue
+			}
+			used[i] = true
+			h.Write([]byte(canonicalizeDKIMHeader(fields[i], canon) + "\r\n"))
+			break
+		}
+	}
+	h.Write([]byte(canonicalizeDKIMHeader(sigField, canon)))
+	return h.Sum(nil)
+}
+
+// dkimSignerOpts hash option of crypto.Signer.Sign; Ed25519 signs the
+// digest itself (RFC 8463)
+func dkimSignerOpts(algo string) crypto.SignerOpts {
+	if algo == "ed25519-sha256" {
+		return crypto.Hash(0)
+	}
+	return crypto.SHA256
+}
+
+// canonicalizeDKIMHeader canonical form of a field, without the final CRLF
+func canonicalizeDKIMHeader(field, canon string) string {
+	if canon == DKIMSimple {
+		return field
+	}
+	i := strings.IndexByte(field, ':')
+	name := strings.ToLower(strings.TrimSpace(field[:i]))
+	value := strings.ReplaceAll(field[i+1:], "\r\n", "")
+	return name + ":" + strings.Trim(collapseWSP(value), " ")
+}
+
+// canonicalizeDKIMBody canonical form of a CRLF body
+func canonicalizeDKIMBody(body []byte, canon string) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	if canon == DKIMRelaxed {
+		for i, l := range lines {
+			lines[i] = strings.TrimRight(collapseWSP(l), " ")
+		}
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		if canon == DKIMSimple {
+			return []byte("\r\n")
+		}
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// collapseWSP reduce every run of spaces and tabs to a single space
+func collapseWSP(s string) string {
+	var w strings.Builder
+	space := false
+	for i := 0; i < len(s); i++ {
+		if s[i] == ' ' || s[i] == '\t' {
+			if !space {
+				w.WriteByte(' ')
+			}
+			space = true
+			continue
+		}
+		space = false
+		w.WriteByte(s[i])
+	}
+	return w.String()
+}
+
+// splitDKIMMessage header fields (folded lines joined, CRLFs kept) and body
+func splitDKIMMessage(message []byte) ([]string, []byte) {
+	var header, body []byte
+	if i := bytes.Index(message, []byte("\r\n\r\n")); i >= 0 {
+		header, body = message[:i+2], message[i+4:]
+	} else {
+		header = message
+	}
+
+	var fields []string
+	for _, line := range strings.SplitAfter(string(header), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	for i, f := range fields {
+		fields[i] = strings.TrimSuffix(f, "\r\n")
+	}
+	return fields, body
+}
+
+// dkimFieldName name of a header field
+func dkimFieldName(field string) string {
+	if i := strings.IndexByte(field, ':'); i >= 0 {
+		return strings.TrimSpace(field[:i])
+	}
+	return ""
+}
+
+// dkimHasField report whether a field named name exists
+func dkimHasField(fields []string, name string) bool {
+	for _, f := range fields {
+		if strings.EqualFold(dkimFieldName(f), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// dkimContainsFold report whether list holds s, ignoring case
+func dkimContainsFold(list []string, s string) bool {
+	for _, l := range list {
+		if strings.EqualFold(l, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDKIMTags parse a tag=value list, removing folding white space
+func parseDKIMTags(s string) map[string]string {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(s, ";") {
+		i := strings.IndexByte(part, '=')
+		if i < 0 {
+			continue
+		}
+		key := strings.TrimSpace(part[:i])
+		tags[key] = strings.Join(strings.Fields(part[i+1:]), "")
+	}
+	return tags
+}
+
+// stripDKIMSignature empty the b= value of a DKIM-Signature field
+func stripDKIMSignature(field string) string {
+	i := strings.IndexByte(field, ':') + 1
+	for i < len(field) {
+		end := strings.IndexByte(field[i:], ';')
+		if end < 0 {
+			end = len(field) - i
+		}
+		part := field[i : i+end]
+		if eq := strings.IndexByte(part, '='); eq >= 0 && strings.TrimSpace(part[:eq]) == "b" {
+			return field[:i+eq+1] + field[i+end:]
+		}
+		i += end + 1
+	}
+	return field
+}
+
+// foldDKIMValue fold a long base64 value across continuation lines
+func foldDKIMValue(s string) string {
+	var w strings.Builder
+	for len(s) > 72 {
+		w.WriteString(s[:72])
+		w.WriteString("\r\n\t")
+		s = s[72:]
+	}
+	w.WriteString(s)
+	return w.String()
+}
+
+// isDKIMCanonicalization report whether c is simple or relaxed
+func isDKIMCanonicalization(c string) bool {
+	return c == DKIMSimple || c == DKIMRelaxed
+}
+
+// toCRLF normalize bare LF line endings to CRLF
+func toCRLF(b []byte) []byte {
+	b = bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(b, []byte("\n"), []byte("\r\n"))
+}
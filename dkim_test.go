@@ -0,0 +1,188 @@
+package mailer_test
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/thiagozs/mailer-go"
+)
+
+const dkimMessage = "From: Sender <sender@example.com>\r\n" +
+	"To: rcpt@example.org\r\n" +
+	"Subject: Hello   DKIM\r\n" +
+	"Date: Mon, 19 Oct 2026 10:00:00 +0000\r\n" +
+	"Message-ID: <1@example.com>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: text/plain; charset=\"UTF-8\"\r\n" +
+	"\r\n" +
+	"Hi there,  \r\n" +
+	"signed body.\r\n" +
+	"\r\n" +
+	"\r\n"
+
+func dkimKeys(t *testing.T) map[string]crypto.Signer {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]crypto.Signer{"rsa": rsaKey, "ed25519": edKey}
+}
+
+func dkimLookup(t *testing.T, key crypto.Signer) func(string) ([]string, error) {
+	record, err := mailer.DKIMRecord(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func(name string) ([]string, error) {
+		if name != "mail._domainkey.example.com" {
+			return nil, fmt.Errorf("no such host %s", name)
+		}
+		// long records come split in 255 byte strings
+		var parts []string
+		for len(record) > 255 {
+			parts = append(parts, record[:255])
+			record = record[255:]
+		}
+		return append(parts, record), nil
+	}
+}
+
+func TestDKIMSignVerify(t *testing.T) {
+	t.Log("DKIM sign and verify... (NOT expected some err)")
+
+	for name, key := range dkimKeys(t) {
+		for _, canon := range [][2]string{
+			{mailer.DKIMRelaxed, mailer.DKIMSimple},
+			{mailer.DKIMRelaxed, mailer.DKIMRelaxed},
+			{mailer.DKIMSimple, mailer.DKIMSimple},
+			{mailer.DKIMSimple, mailer.DKIMRelaxed},
+		} {
+			opts := &mailer.DKIMOptions{
+				Domain:                 "example.com",
+				Selector:               "mail",
+				PrivateKey:             key,
+				HeaderCanonicalization: canon[0],
+				BodyCanonicalization:   canon[1],
+			}
+			signed, err := mailer.DKIMSign([]byte(dkimMessage), opts)
+			if err != nil {
+				t.Fatalf("DKIMSign %s %v got: %s", name, canon, err)
+			}
+			if !strings.HasPrefix(string(signed), "DKIM-Signature: v=1;") {
+				t.Errorf("DKIMSign got: %s", signed)
+			}
+
+			domain, err := mailer.VerifyDKIM(signed, dkimLookup(t, key))
+			if err != nil || domain != "example.com" {
+				t.Errorf("VerifyDKIM %s %v got: %s %v", name, canon, domain, err)
+			}
+
+			tampered := strings.Replace(string(signed), "signed body", "changed body", 1)
+			if _, err := mailer.VerifyDKIM([]byte(tampered), dkimLookup(t, key)); err == nil {
+				t.Errorf("VerifyDKIM %s %v accepted a changed body", name, canon)
+			}
+			tampered = strings.Replace(string(signed), "Hello   DKIM", "Hello DKIM!", 1)
+			if _, err := mailer.VerifyDKIM([]byte(tampered), dkimLookup(t, key)); err == nil {
+				t.Errorf("VerifyDKIM %s %v accepted a changed subject", name, canon)
+			}
+		}
+	}
+}
+
+func TestDKIMRelaxedTolerance(t *testing.T) {
+	t.Log("DKIM relaxed canonicalization... (NOT expected some err)")
+
+	keys := dkimKeys(t)
+	opts := &mailer.DKIMOptions{
+		Domain:                 "example.com",
+		Selector:               "mail",
+		PrivateKey:             keys["ed25519"],
+		Headers:                []string{"From", "Subject", "To"},
+		HeaderCanonicalization: mailer.DKIMRelaxed,
+		BodyCanonicalization:   mailer.DKIMRelaxed,
+	}
+	signed, err := mailer.DKIMSign([]byte(dkimMessage), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(signed), "h=From:Subject:To;") {
+		t.Errorf("DKIMSign got: %s", signed)
+	}
+
+	// relays may refold headers and change white space in the body
+	changed := strings.Replace(string(signed), "Subject: Hello   DKIM", "subject:Hello\r\n DKIM", 1)
+	changed = strings.Replace(changed, "Hi there,  \r\n", "Hi   there,\r\n", 1)
+	changed = strings.Replace(changed, "Date: Mon", "Date: Tue", 1)
+	if _, err := mailer.VerifyDKIM([]byte(changed), dkimLookup(t, keys["ed25519"])); err != nil {
+		t.Errorf("VerifyDKIM got: %s", err)
+	}
+}
+
+func TestDKIMSignErrors(t *testing.T) {
+	t.Log("DKIM options errors... (expected some err)")
+
+	keys := dkimKeys(t)
+	for _, opts := range []*mailer.DKIMOptions{
+		nil,
+		{Domain: "example.com", Selector: "mail"},
+		{Selector: "mail", PrivateKey: keys["rsa"]},
+		{Domain: "example.com", Selector: "mail", PrivateKey: keys["rsa"], Headers: []string{"Subject"}},
+		{Domain: "example.com", Selector: "mail", PrivateKey: keys["rsa"], BodyCanonicalization: "nowsp"},
+	} {
+		if _, err := mailer.DKIMSign([]byte(dkimMessage), opts); err == nil {
+			t.Errorf("DKIMSign %+v expected an error", opts)
+		}
+	}
+
+	if _, err := mailer.VerifyDKIM([]byte(dkimMessage), dkimLookup(t, keys["rsa"])); err == nil {
+		t.Errorf("VerifyDKIM expected an error on an unsigned message")
+	}
+}
+
+func TestParseDKIMPrivateKey(t *testing.T) {
+	t.Log("DKIM PEM keys... (NOT expected some err)")
+
+	keys := dkimKeys(t)
+	blocks := map[string]*pem.Block{
+		"pkcs1": {Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(keys["rsa"].(*rsa.PrivateKey))},
+	}
+	for _, name := range []string{"rsa", "ed25519"} {
+		der, err := x509.MarshalPKCS8PrivateKey(keys[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks["pkcs8-"+name] = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+
+	for name, block := range blocks {
+		key, err := mailer.ParseDKIMPrivateKey(pem.EncodeToMemory(block))
+		if err != nil {
+			t.Errorf("ParseDKIMPrivateKey %s got: %s", name, err)
+			continue
+		}
+		opts := &mailer.DKIMOptions{Domain: "example.com", Selector: "mail", PrivateKey: key}
+		signed, err := mailer.DKIMSign([]byte(dkimMessage), opts)
+		if err != nil {
+			t.Errorf("DKIMSign %s got: %s", name, err)
+			continue
+		}
+		if _, err := mailer.VerifyDKIM(signed, dkimLookup(t, key)); err != nil {
+			t.Errorf("VerifyDKIM %s got: %s", name, err)
+		}
+	}
+
+	if _, err := mailer.ParseDKIMPrivateKey([]byte("not a key")); err == nil {
+		t.Errorf("ParseDKIMPrivateKey expected an error")
+	}
+}
@@ -0,0 +1,233 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"mime"
+	"net/textproto"
+	"regexp"
+	"strings"
+)
+
+// BounceClass hard or soft bounce
+type BounceClass string
+
+// bounce classes: a hard bounce means the address must not be used again
+const (
+	BounceHard BounceClass = "hard"
+	BounceSoft BounceClass = "soft"
+)
+
+// DSN delivery status notification (RFC 3464)
+type DSN struct {
+	ReportingMTA      string
+	EnvelopeID        string // Original-Envelope-Id, the ENVID of the send
+	OriginalMessageID string // Message-ID of the returned message
+	Recipients        []DSNRecipient
+}
+
+// DSNRecipient delivery status of one recipient
+type DSNRecipient struct {
+	OriginalRecipient string // address as given by the sender, when reported
+	FinalRecipient    string
+	Action            string // failed, delayed, delivered, relayed or expanded
+	Status            string // enhanced status code, e.g. 5.1.1
+	DiagnosticCode    string // reply of the remote MTA, e.g. 550 5.1.1 User unknown
+	RemoteMTA         string
+	Class             BounceClass // empty unless failed or delayed
+}
+
+// Email original recipient, or the final one when it is not reported
+func (r DSNRecipient) Email() string {
+	if r.OriginalRecipient != "" {
+		return r.OriginalRecipient
+	}
+	return r.FinalRecipient
+}
+
+// statusCode enhanced status code in a diagnostic
+var statusCode = regexp.MustCompile(`\b([245])\.(\d{1,3})\.(\d{1,3})\b`)
+
+// replyCode SMTP reply code starting a diagnostic
+var replyCode = regexp.MustCompile(`^([245])\d\d\b`)
+
+// ParseDSN parse a bounce in the multipart/report format of RFC 3464,
+// also when it is nested in another multipart
+func ParseDSN(message []byte) (*DSN, error) {
+	header, body, err := splitMIMEEntity(message)
+	if err != nil {
+		return nil, err
+	}
+	report, err := findReport(header, body, "delivery-status")
+	if err != nil {
+		return nil, err
+	}
+
+	dsn := &DSN{}
+	for _, part := range report {
+		mediaType, _, _ := mime.ParseMediaType(part.header.Get("Content-Type"))
+		switch mediaType {
+		case "message/delivery-status", "message/global-delivery-status":
+			if err := dsn.parseStatus(part.body); err != nil {
+				return nil, err
+			}
+		case "text/rfc822-headers", "message/rfc822", "message/global", "message/global-headers":
+			if dsn.OriginalMessageID == "" {
+				dsn.OriginalMessageID = originalHeader(part.body).Get("Message-Id")
+			}
+		}
+	}
+	if len(dsn.Recipients) == 0 {
+		return nil, fmt.Errorf("DSN without recipients")
+	}
+	return dsn, nil
+}
+
+// reportPart decoded part of a multipart/report
+type reportPart struct {
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+// findReport parts of the first multipart/report of reportType in the
+// entity
+func findReport(header textproto.MIMEHeader, body []byte, reportType string) ([]reportPart, error) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, fmt.Errorf("Not a %s report", reportType)
+	}
+
+	// returned messages are often cut, closing delimiter included
+	boundary := params["boundary"]
+	if !bytes.Contains(body, []byte("--"+boundary+"--")) {
+		body = append(toCRLF(body), []byte("\r\n--"+boundary+"--\r\n")...)
+	}
+
+	var parts []reportPart
+	for _, raw := range splitMultipartRaw(toCRLF(body), boundary) {
+		h, b, err := splitMIMEEntity(raw)
+		if err != nil {
+			// a part may be headers only
+			if h, b, err = splitMIMEEntity(append(raw, "\r\n\r\n"...)); err != nil {
+				continue
+			}
+		}
+		if mediaType != "multipart/report" {
+			if nested, err := findReport(h, b, reportType); err == nil {
+				return nested, nil
+			}
+			continue
+		}
+		if b, err = decodeTransferEncoding(h.Get("Content-Transfer-Encoding"), b); err != nil {
+			return nil, err
+		}
+		parts = append(parts, reportPart{header: h, body: b})
+	}
+
+	if mediaType != "multipart/report" || !strings.EqualFold(params["report-type"], reportType) {
+		return nil, fmt.Errorf("Not a %s report", reportType)
+	}
+	return parts, nil
+}
+
+// originalHeader header of a returned message or of its headers alone
+func originalHeader(body []byte) textproto.MIMEHeader {
+	body = toCRLF(body)
+	if i := bytes.Index(body, []byte("\r\n\r\n")); i >= 0 {
+		body = body[:i]
+	}
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(append(body, "\r\n\r\n"...))))
+	header, _ := r.ReadMIMEHeader()
+	return header
+}
+
+// parseStatus read the per-message and per-recipient fields of a
+// message/delivery-status body
+func (dsn *DSN) parseStatus(body []byte) error {
+	for _, block := range bytes.Split(toCRLF(body), []byte("\r\n\r\n")) {
+		if len(bytes.TrimSpace(block)) == 0 {
+			continue
+		}
+		r := textproto.NewReader(bufio.NewReader(bytes.NewReader(append(block, "\r\n\r\n"...))))
+		fields, err := r.ReadMIMEHeader()
+		if err != nil && len(fields) == 0 {
+			return fmt.Errorf("Invalid delivery-status fields: %s", err)
+		}
+
+		if fields.Get("Final-Recipient") == "" && fields.Get("Original-Recipient") == "" {
+			if v := fields.Get("Reporting-Mta"); v != "" {
+				dsn.ReportingMTA = dsnValue(v)
+			}
+			if v := fields.Get("Original-Envelope-Id"); v != "" {
+				dsn.EnvelopeID = v
+			}
+			continue
+		}
+
+		rcpt := DSNRecipient{
+			OriginalRecipient: dsnAddress(fields.Get("Original-Recipient")),
+			FinalRecipient:    dsnAddress(fields.Get("Final-Recipient")),
+			Action:            strings.ToLower(strings.TrimSpace(fields.Get("Action"))),
+			Status:            strings.TrimSpace(fields.Get("Status")),
+			DiagnosticCode:    dsnValue(fields.Get("Diagnostic-Code")),
+			RemoteMTA:         dsnValue(fields.Get("Remote-Mta")),
+		}
+		// some MTAs append a comment to the status, e.g. 5.0.0 (permanent failure)
+		if m := statusCode.FindString(rcpt.Status); m != "" {
+			rcpt.Status = m
+		}
+		if rcpt.Status == "" || strings.HasSuffix(rcpt.Status, ".0.0") {
+			if m := statusCode.FindString(rcpt.DiagnosticCode); m != "" {
+				rcpt.Status = m
+			}
+		}
+		rcpt.Class = classifyBounce(rcpt.Action, rcpt.Status, rcpt.DiagnosticCode)
+		dsn.Recipients = append(dsn.Recipients, rcpt)
+	}
+	return nil
+}
+
+// classifyBounce hard or soft bounce of a recipient status. A failure is
+// hard when the address itself is refused; a full mailbox, a too large
+// message or a policy (5.7.x) refusal is soft, as is every delay and
+// temporary failure
+func classifyBounce(action, status, diagnostic string) BounceClass {
+	if action != "failed" && action != "delayed" {
+		return ""
+	}
+	if action == "delayed" {
+		return BounceSoft
+	}
+
+	class := ""
+	if m := statusCode.FindStringSubmatch(status); m != nil {
+		class = m[1]
+		switch {
+		case m[1] == "5" && m[2] == "2" && (m[3] == "2" || m[3] == "3"),
+			m[1] == "5" && m[2] == "3" && m[3] == "4",
+			m[1] == "5" && m[2] == "7":
+			return BounceSoft
+		}
+	} else if m := replyCode.FindStringSubmatch(diagnostic); m != nil {
+		class = m[1]
+	}
+	if class == "4" {
+		return BounceSoft
+	}
+	return BounceHard
+}
+
+// dsnValue value of a typed field such as "smtp; 550 User unknown"
+func dsnValue(v string) string {
+	v = strings.TrimSpace(v)
+	if i := strings.Index(v, ";"); i >= 0 {
+		v = strings.TrimSpace(v[i+1:])
+	}
+	return v
+}
+
+// dsnAddress address of a recipient field such as "rfc822; <a@host.com>"
+func dsnAddress(v string) string {
+	return strings.Trim(dsnValue(v), "<>")
+}
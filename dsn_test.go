@@ -0,0 +1,89 @@
+package mailer_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/thiagozs/mailer-go"
+)
+
+func TestParseDSN(t *testing.T) {
+	t.Log("Parse bounces of common MTAs... (NOT expected some err)")
+
+	type rcpt struct {
+		email, action, status string
+		class                 mailer.BounceClass
+		diagnostic            string // prefix
+	}
+	for _, c := range []struct {
+		file, mta, messageID, envelopeID string
+		recipients                       []rcpt
+	}{
+		{"postfix-user-unknown.eml", "mx.example.com", "<1760865160.a1b2c3@example.com>", "", []rcpt{
+			{"nobody@host.com", "failed", "5.1.1", mailer.BounceHard,
+				"550 5.1.1 <nobody@host.com>: Recipient address rejected: User unknown in virtual mailbox table"},
+		}},
+		{"gmail-mailbox-full.eml", "googlemail.com", "<1760865181.d4e5f6@example.com>", "", []rcpt{
+			{"full.user@gmail.com", "failed", "5.2.2", mailer.BounceSoft, "552-5.2.2 The recipient's inbox is out of storage space"},
+		}},
+		{"office365-recipient-not-found.eml", "DM6PR12MB4321.namprd12.prod.outlook.com", "<1760865609.0f9e8d@example.com>", "", []rcpt{
+			{"former.employee@contoso.com", "failed", "5.1.10", mailer.BounceHard, "550 5.1.10 RESOLVER.ADR.RecipientNotFound"},
+		}},
+		{"sendmail-delayed.eml", "relay.example.com", "<1760865290.77aa@example.com>", "5a1f9c", []rcpt{
+			{"slow@busy.example.net", "delayed", "4.4.1", mailer.BounceSoft, ""},
+		}},
+		{"exim-two-recipients.eml", "mail.example.org", "<1760866000.5566@example.com>", "", []rcpt{
+			{"gone@example.org", "failed", "5.1.1", mailer.BounceHard, "550 5.1.1"},
+			{"filtered@example.org", "failed", "5.7.1", mailer.BounceSoft, "550 5.7.1 Message rejected as spam"},
+		}},
+		{"gateway-nested-base64.eml", "gateway.example.com", "<1760866500.42@example.com>", "env-42", []rcpt{
+			{"list@example.com", "failed", "", mailer.BounceHard, "554 Transaction failed"},
+		}},
+	} {
+		data, err := os.ReadFile("testdata/dsn/" + c.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dsn, err := mailer.ParseDSN(data)
+		if err != nil {
+			t.Errorf("ParseDSN %s got: %s", c.file, err)
+			continue
+		}
+		if dsn.ReportingMTA != c.mta || dsn.OriginalMessageID != c.messageID || dsn.EnvelopeID != c.envelopeID {
+			t.Errorf("ParseDSN %s got: %+v", c.file, dsn)
+		}
+		if len(dsn.Recipients) != len(c.recipients) {
+			t.Errorf("ParseDSN %s got: %d recipients", c.file, len(dsn.Recipients))
+			continue
+		}
+		for i, want := range c.recipients {
+			got := dsn.Recipients[i]
+			if got.Email() != want.email || got.Action != want.action || got.Status != want.status ||
+				got.Class != want.class || !strings.HasPrefix(got.DiagnosticCode, want.diagnostic) {
+				t.Errorf("ParseDSN %s recipient %d got: %+v", c.file, i, got)
+			}
+		}
+	}
+}
+
+func TestParseDSNErrors(t *testing.T) {
+	t.Log("Parse messages that are not DSNs... (expected some err)")
+
+	qmail, err := os.ReadFile("testdata/dsn/qmail-plain.eml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, message := range map[string]string{
+		"plain text": string(qmail),
+		"no header":  "just text",
+		"other report": "Content-Type: multipart/report; report-type=feedback-report; boundary=b\r\n\r\n" +
+			"--b\r\nContent-Type: message/feedback-report\r\n\r\nFeedback-Type: abuse\r\n\r\n--b--\r\n",
+		"no recipients": "Content-Type: multipart/report; report-type=delivery-status; boundary=b\r\n\r\n" +
+			"--b\r\nContent-Type: message/delivery-status\r\n\r\nReporting-MTA: dns; mx\r\n\r\n--b--\r\n",
+	} {
+		if _, err := mailer.ParseDSN([]byte(message)); err == nil {
+			t.Errorf("ParseDSN %s expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package mailer
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// TextWidth column at which HTMLToText wraps lines
+const TextWidth = 78
+
+// htmlBlockElements elements rendered on lines of their own
+var htmlBlockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "center": true,
+	"dd": true, "div": true, "dl": true, "dt": true, "fieldset": true,
+	"figcaption": true, "figure": true, "footer": true, "form": true,
+	"header": true, "main": true, "nav": true, "section": true, "tr": true,
+}
+
+// htmlParagraphElements block elements followed by a blank line
+var htmlParagraphElements = map[string]bool{
+	"blockquote": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "hr": true, "ol": true, "p": true, "pre": true,
+	"table": true, "ul": true,
+}
+
+// htmlSkippedElements elements without readable contents
+var htmlSkippedElements = map[string]bool{
+	"head": true, "script": true, "style": true, "title": true,
+	"noscript": true, "template": true, "select": true, "button": true,
+	"input": true, "textarea": true, "object": true, "iframe": true,
+}
+
+// HTMLToText convert an HTML body into readable plain text: tags are
+// stripped, entities decoded, links kept as numbered footnotes, lists and
+// tables laid out, and lines wrapped at TextWidth columns
+func HTMLToText(src string) string {
+	c := &htmlTextConverter{}
+	c.children(parseHTML(src))
+	c.flush()
+
+	lines := c.lines
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	lines = append(lines, c.notes.lines()...)
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// htmlTextConverter state of an HTMLToText conversion
+type htmlTextConverter struct {
+	lines  []string
+	inline strings.Builder // words of the current block; "\n" is a <br>
+	prefix string          // prefix of every line of the current block
+	marker string          // replaces prefix on the first line (list items)
+	gap    bool            // a blank line is due before the next block
+	gapPfx string          // prefix when the blank line was asked for
+	lists  int             // depth of nested lists
+	notes  textNotes
+}
+
+// children convert the children of n
+func (c *htmlTextConverter) children(n *htmlNode) {
+	for _, ch := range n.children {
+		c.node(ch)
+	}
+}
+
+// node convert n
+func (c *htmlTextConverter) node(n *htmlNode) {
+	switch n.typ {
+	case htmlTextNode:
+		c.text(html.UnescapeString(n.text))
+		return
+	case htmlElementNode:
+	default:
+		return
+	}
+
+	if htmlSkippedElements[n.tag] {
+		return
+	}
+
+	switch n.tag {
+	case "br":
+		c.inline.WriteString("\n")
+
+	case "hr":
+		c.block(true)
+		c.emit(strings.Repeat("-", 40))
+		c.block(true)
+
+	case "img":
+		if alt, _ := n.attr("alt"); strings.TrimSpace(alt) != "" {
+			c.text("[" + strings.TrimSpace(alt) + "]")
+		}
+
+	case "a":
+		c.children(n)
+		href, _ := n.attr("href")
+		href = strings.TrimSpace(href)
+		label := anchorLabel(n)
+		switch {
+		case href == "" || strings.HasPrefix(href, "#") || !isSafeHref(href):
+		case label == href || "mailto:"+label == href:
+		case label == "":
+			c.text(href)
+		default:
+			c.text(fmt.Sprintf(" [%d]", c.notes.add(href)))
+		}
+
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		c.block(true)
+		c.children(n)
+		text := strings.Join(strings.Fields(c.inline.String()), " ")
+		c.inline.Reset()
+		c.emit(text)
+		switch n.tag {
+		case "h1":
+			c.emit(strings.Repeat("=", utf8.RuneCountInString(text)))
+		case "h2":
+			c.emit(strings.Repeat("-", utf8.RuneCountInString(text)))
+		}
+		c.block(true)
+
+	case "pre":
+		c.block(true)
+		text := strings.TrimRight(strings.TrimPrefix(n.textContent(), "\n"), "\n ")
+		for _, l := range strings.Split(text, "\n") {
+			c.emit("    " + strings.TrimRight(l, " \t\r"))
+		}
+		c.block(true)
+
+	case "blockquote":
+		c.block(true)
+		saved := c.prefix
+		c.prefix += "> "
+		c.children(n)
+		c.block(false)
+		c.prefix = saved
+		c.block(true)
+
+	case "ul", "ol":
+		c.block(c.lists == 0)
+		c.lists++
+		number := 1
+		if v, ok := n.attr("start"); ok {
+			if s, err := strconv.Atoi(v); err == nil {
+				number = s
+			}
+		}
+		for _, item := range n.children {
+			if item.typ != htmlElementNode || item.tag != "li" {
+				c.node(item)
+				continue
+			}
+			marker := "- "
+			if n.tag == "ol" {
+				marker = fmt.Sprintf("%d. ", number)
+				number++
+			}
+			c.block(false)
+			saved := c.prefix
+			c.marker = c.prefix + marker
+			c.prefix += strings.Repeat(" ", len(marker))
+			c.children(item)
+			c.block(false)
+			c.prefix = saved
+			c.marker = ""
+		}
+		c.lists--
+		c.block(c.lists == 0)
+
+	case "table":
+		c.table(n)
+
+	default:
+		switch {
+		case htmlParagraphElements[n.tag]:
+			c.block(true)
+			c.children(n)
+			c.block(true)
+		case htmlBlockElements[n.tag] || n.tag == "li" || n.tag == "td" || n.tag == "th":
+			c.block(false)
+			c.children(n)
+			c.block(false)
+		default:
+			c.children(n)
+		}
+	}
+}
+
+// text append inline text, collapsing white space
+func (c *htmlTextConverter) text(s string) {
+	s = strings.ReplaceAll(s, "\u00a0", " ")
+	if s == "" {
+		return
+	}
+	cur := c.inline.String()
+	lead := isHTMLSpace(s[0])
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		if cur != "" && !strings.HasSuffix(cur, " ") && !strings.HasSuffix(cur, "\n") {
+			c.inline.WriteString(" ")
+		}
+		return
+	}
+	if lead && cur != "" && !strings.HasSuffix(cur, " ") && !strings.HasSuffix(cur, "\n") {
+		c.inline.WriteString(" ")
+	}
+	c.inline.WriteString(strings.Join(words, " "))
+	if isHTMLSpace(s[len(s)-1]) {
+		c.inline.WriteString(" ")
+	}
+}
+
+// block end the current block; gap asks for a blank line before the next
+func (c *htmlTextConverter) block(gap bool) {
+	c.flush()
+	if gap && !c.gap {
+		c.gap = true
+		c.gapPfx = c.prefix
+	}
+}
+
+// flush wrap the pending inline text into lines
+func (c *htmlTextConverter) flush() {
+	text := c.inline.String()
+	c.inline.Reset()
+
+	var paras []string
+	for _, p := range strings.Split(text, "\n") {
+		paras = append(paras, strings.TrimSpace(p))
+	}
+	for len(paras) > 0 && paras[len(paras)-1] == "" {
+		paras = paras[:len(paras)-1]
+	}
+	for len(paras) > 0 && paras[0] == "" {
+		paras = paras[1:]
+	}
+
+	for _, p := range paras {
+		width := TextWidth - utf8.RuneCountInString(c.prefix)
+		if width < 20 {
+			width = 20
+		}
+		wrapped := wrapText(p, width)
+		if len(wrapped) == 0 {
+			wrapped = []string{""}
+		}
+		for _, l := range wrapped {
+			c.emit(l)
+		}
+	}
+}
+
+// emit append a finished line with the current prefix
+func (c *htmlTextConverter) emit(line string) {
+	if c.gap && len(c.lines) > 0 && strings.Trim(c.lines[len(c.lines)-1], "> ") != "" {
+		blank := c.gapPfx
+		if len(c.prefix) < len(blank) {
+			blank = c.prefix
+		}
+		c.lines = append(c.lines, strings.TrimRight(blank, " "))
+	}
+	c.gap = false
+
+	prefix := c.prefix
+	if c.marker != "" {
+		prefix = c.marker
+		c.marker = ""
+	}
+	c.lines = append(c.lines, strings.TrimRight(prefix+line, " "))
+}
+
+// table lay out a data table as aligned columns, or a layout table (the
+// usual email scaffolding) as a sequence of blocks
+func (c *htmlTextConverter) table(n *htmlNode) {
+	var rows [][]*htmlNode
+	header := make(map[int]bool)
+	layout := false
+
+	var collect func(*htmlNode)
+	collect = func(t *htmlNode) {
+		for _, ch := range t.elementChildren() {
+			switch ch.tag {
+			case "thead", "tbody", "tfoot":
+				collect(ch)
+			case "tr":
+				var cells []*htmlNode
+				allTH := true
+				for _, cell := range ch.elementChildren() {
+					if cell.tag == "td" || cell.tag == "th" {
+						cells = append(cells, cell)
+						allTH = allTH && cell.tag == "th"
+					}
+				}
+				if allTH && len(cells) > 0 {
+					header[len(rows)] = true
+				}
+				rows = append(rows, cells)
+			}
+		}
+	}
+	collect(n)
+
+	// Convert every cell on its own; block content means layout
+	texts := make([][]string, len(rows))
+	widths := []int{}
+	for r, cells := range rows {
+		for i, cell := range cells {
+			sub := &htmlTextConverter{}
+			sub.children(cell)
+			sub.flush()
+			if len(sub.lines) > 1 || len(sub.notes) > 0 {
+				layout = true
+			}
+			text := strings.Join(sub.lines, " ")
+			texts[r] = append(texts[r], text)
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if w := utf8.RuneCountInString(text); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+
+	total := 0
+	for _, w := range widths {
+		total += w + 2
+	}
+	if layout || len(widths) < 2 || total > TextWidth-len(c.prefix) {
+		for _, cells := range rows {
+			for _, cell := range cells {
+				c.block(false)
+				c.children(cell)
+				c.block(false)
+			}
+		}
+		return
+	}
+
+	c.block(true)
+	for r, cells := range texts {
+		var line strings.Builder
+		for i, text := range cells {
+			if i < len(cells)-1 {
+				text += strings.Repeat(" ", widths[i]-utf8.RuneCountInString(text)+2)
+			}
+			line.WriteString(text)
+		}
+		c.emit(line.String())
+		if header[r] {
+			var sep []string
+			for _, w := range widths[:len(cells)] {
+				sep = append(sep, strings.Repeat("-", w))
+			}
+			c.emit(strings.Join(sep, "  "))
+		}
+	}
+	c.block(true)
+}
+
+// anchorLabel text of the children of an anchor, converted on their own
+// as block content in the anchor (a linked button or card) flushes the
+// inline text of the converter
+func anchorLabel(n *htmlNode) string {
+	sub := &htmlTextConverter{}
+	sub.children(n)
+	sub.flush()
+	return strings.Join(strings.Fields(strings.Join(sub.lines, " ")), " ")
+}
+
+// isSafeHref report whether href is worth showing in the text part
+func isSafeHref(href string) bool {
+	return safeMarkdownURL(href) == href
+}
+
+// wrapText word wrap s at width columns; longer words get a line of their own
+func wrapText(s string, width int) []string {
+	var lines []string
+	var line strings.Builder
+	n := 0
+	for _, w := range strings.Fields(s) {
+		wl := utf8.RuneCountInString(w)
+		if n > 0 && n+1+wl > width {
+			lines = append(lines, line.String())
+			line.Reset()
+			n = 0
+		}
+		if n > 0 {
+			line.WriteString(" ")
+			n++
+		}
+		line.WriteString(w)
+		n += wl
+	}
+	if n > 0 {
+		lines = append(lines, line.String())
+	}
+	return lines
+}
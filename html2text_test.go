@@ -0,0 +1,115 @@
+package mailer_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/thiagozs/mailer-go"
+)
+
+const htmlSample = `<!DOCTYPE html>
+<html><head><title>Order</title><style>p { color: red; }</style></head>
+<body>
+<!--[if mso]><table><tr><td><![endif]-->
+<table width="100%"><tr><td>
+<h1>Hello &amp; welcome</h1>
+<p>Your   order is <b>ready</b>. See <a href="https://example.com/orders/1">the details</a> and keep reading this paragraph, since it is long enough to be wrapped.</p>
+<p>Line one<br>Line&nbsp;two &copy; 2026</p>
+<ul><li>One</li><li>Two<ol><li>first</li><li>second <a href="https://example.com/orders/1">again</a></li></ol></li></ul>
+<blockquote><p>Quoted</p><p>Text</p></blockquote>
+<table>
+<tr><th>Item</th><th>Qty</th><th>Price</th></tr>
+<tr><td>Widget</td><td>2</td><td>$10.00</td></tr>
+<tr><td>Gadget</td><td>10</td><td>$5.00</td></tr>
+</table>
+<p><img src="logo.png" alt="Logo"><img src="pixel.gif" width="1"> <a href="mailto:help@example.com">help@example.com</a> <a href="javascript:void(0)">x</a></p>
+<script>document.write("no")</script>
+</td></tr></table>
+</body></html>`
+
+func TestHTMLToText(t *testing.T) {
+	t.Log("HTMLToText... (NOT expected some err)")
+	got := mailer.HTMLToText(htmlSample)
+
+	expected := `Hello & welcome
+===============
+
+Your order is ready. See the details [1] and keep reading this paragraph,
+since it is long enough to be wrapped.
+
+Line one
+Line two © 2026
+
+- One
+- Two
+  1. first
+  2. second again [1]
+
+> Quoted
+>
+> Text
+
+Item    Qty  Price
+------  ---  ------
+Widget  2    $10.00
+Gadget  10   $5.00
+
+[Logo] help@example.com x
+
+[1] https://example.com/orders/1
+`
+	if got != expected {
+		t.Errorf("HTMLToText got:\n%s", got)
+	}
+
+	for _, line := range strings.Split(got, "\n") {
+		if len([]rune(line)) > mailer.TextWidth {
+			t.Errorf("HTMLToText line longer than %d: %q", mailer.TextWidth, line)
+		}
+	}
+}
+
+func TestConfigFromHTMLOnly(t *testing.T) {
+	t.Log("CheckIsEmptyCfg(Sendgrid) with only ContentHTML... (NOT expected some err)")
+	sg := mailer.NewMailerSendGrid("")
+	sg.ConfigEmail = mailer.ConfigEmailSendgrid{
+		ContentHTML:   "<b>test</b>",
+		EmailFrom:     "sender@host.com",
+		EmailFromName: "Sender name",
+		EmailTo:       "client@host.com",
+		EmailToName:   "Client name",
+		Subject:       "Test",
+	}
+
+	if mailer.CheckIsEmptyCfg(sg) {
+		t.Errorf("CheckIsEmptyCfg(Sendgrid) with html only got: empty")
+	}
+}
+
+func TestHTMLToTextBlockLinks(t *testing.T) {
+	t.Log("HTMLToText of links around blocks... (NOT expected some err)")
+	for src, expected := range map[string]string{
+		`Hi <a href="https://x.com/a"><div>Go</div></a>`:            "Hi\nGo\n[1]\n\n[1] https://x.com/a\n",
+		`<td>Hello <a href="https://x.com/a"><p>Go</p></a></td>`:    "Hello\n\nGo\n\n[1]\n\n[1] https://x.com/a\n",
+		`<a href="https://x.com/a"><div><img alt="Logo"></div></a>`: "[Logo]\n[1]\n\n[1] https://x.com/a\n",
+	} {
+		if got := mailer.HTMLToText(src); got != expected {
+			t.Errorf("HTMLToText %s got: %q", src, got)
+		}
+	}
+}
+
+func TestHTMLUnterminatedRawText(t *testing.T) {
+	t.Log("HTMLToText and InlineCSS of unterminated raw text... (NOT expected some err)")
+	for _, src := range []string{
+		"<stYle>000\x97\xdc\xfa0\xb10</stYle",
+		"<script>İİİİ</SCRIPT",
+		"<p>İ</p><style>İİ p{color:red}</STYLE><p>x</p>",
+	} {
+		mailer.HTMLToText(src)
+		mailer.InlineCSS(src)
+	}
+	if got := mailer.HTMLToText("<p>İ</p><style>İİ p{color:red}</STYLE><p>x</p>"); got != "İ\n\nx\n" {
+		t.Errorf("HTMLToText got: %q", got)
+	}
+}
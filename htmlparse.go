@@ -0,0 +1,448 @@
+package mailer
+
+import (
+	"html"
+	"strings"
+)
+
+// Small, lenient HTML parser shared by the HTML-to-text converter, the CSS
+// inliner and the link rewriter. It keeps text, comments (including the
+// <!--[if mso]> conditionals of email HTML) and doctypes as written, so an
+// unmodified tree renders back to an equivalent document.
+
+type htmlNodeType int
+
+const (
+	htmlDocumentNode htmlNodeType = iota
+	htmlElementNode
+	htmlTextNode
+	htmlCommentNode
+	htmlDoctypeNode
+)
+
+// htmlAttr attribute of an element; key is lower case, val is decoded
+type htmlAttr struct {
+	key string
+	val string
+}
+
+// htmlNode node of a parsed HTML document
+type htmlNode struct {
+	typ      htmlNodeType
+	tag      string // lower case element name
+	attrs    []htmlAttr
+	text     string // raw text, comment or doctype contents
+	parent   *htmlNode
+	children []*htmlNode
+}
+
+// htmlVoidElements elements without contents or end tag
+var htmlVoidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"param": true, "source": true, "track": true, "wbr": true,
+}
+
+// htmlRawTextElements elements whose contents are not parsed as HTML
+var htmlRawTextElements = map[string]bool{
+	"script": true, "style": true, "textarea": true, "title": true,
+}
+
+// htmlClosesP block elements that implicitly close an open <p>
+var htmlClosesP = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true,
+	"div": true, "dl": true, "fieldset": true, "footer": true, "form": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"header": true, "hr": true, "main": true, "nav": true, "ol": true,
+	"p": true, "pre": true, "section": true, "table": true, "ul": true,
+}
+
+// htmlImpliedEnd start tags that close an open sibling of the listed kinds,
+// without crossing the listed boundaries
+var htmlImpliedEnd = map[string]struct {
+	closes   []string
+	boundary []string
+}{
+	"li":     {[]string{"li"}, []string{"ul", "ol"}},
+	"dt":     {[]string{"dt", "dd"}, []string{"dl"}},
+	"dd":     {[]string{"dt", "dd"}, []string{"dl"}},
+	"tr":     {[]string{"tr", "td", "th"}, []string{"table", "thead", "tbody", "tfoot"}},
+	"td":     {[]string{"td", "th"}, []string{"tr", "table"}},
+	"th":     {[]string{"td", "th"}, []string{"tr", "table"}},
+	"thead":  {[]string{"thead", "tbody", "tfoot", "tr", "td", "th"}, []string{"table"}},
+	"tbody":  {[]string{"thead", "tbody", "tfoot", "tr", "td", "th"}, []string{"table"}},
+	"tfoot":  {[]string{"thead", "tbody", "tfoot", "tr", "td", "th"}, []string{"table"}},
+	"option": {[]string{"option"}, []string{"select", "datalist"}},
+}
+
+// parseHTML parse src into a document node; it never fails
+func parseHTML(src string) *htmlNode {
+	doc := &htmlNode{typ: htmlDocumentNode}
+	cur := doc
+
+	appendChild := func(n *htmlNode) {
+		n.parent = cur
+		cur.children = append(cur.children, n)
+	}
+
+	var text strings.Builder
+	flushText := func() {
+		if text.Len() > 0 {
+			appendChild(&htmlNode{typ: htmlTextNode, text: text.String()})
+			text.Reset()
+		}
+	}
+
+	for i := 0; i < len(src); {
+		if src[i] != '<' || i+1 >= len(src) {
+			text.WriteByte(src[i])
+			i++
+			continue
+		}
+
+		next := src[i+1]
+		switch {
+		case strings.HasPrefix(src[i:], "<!--"):
+			flushText()
+			end := strings.Index(src[i+4:], "-->")
+			if end < 0 {
+				appendChild(&htmlNode{typ: htmlCommentNode, text: src[i+4:]})
+				i = len(src)
+				continue
+			}
+			appendChild(&htmlNode{typ: htmlCommentNode, text: src[i+4 : i+4+end]})
+			i += 4 + end + 3
+
+		case next == '!' || next == '?':
+			flushText()
+			end := strings.IndexByte(src[i:], '>')
+			if end < 0 {
+				end = len(src) - i - 1
+			}
+			appendChild(&htmlNode{typ: htmlDoctypeNode, text: src[i+2 : i+end]})
+			i += end + 1
+
+		case next == '/' && i+2 < len(src) && isASCIILetter(src[i+2]):
+			flushText()
+			name, j := scanTagName(src, i+2)
+			end := strings.IndexByte(src[j:], '>')
+			if end < 0 {
+				i = len(src)
+			} else {
+				i = j + end + 1
+			}
+			for n := cur; n != nil && n.typ == htmlElementNode; n = n.parent {
+				if n.tag == name {
+					cur = n.parent
+					break
+				}
+			}
+
+		case isASCIILetter(next):
+			flushText()
+			n, j, selfClosing := parseStartTag(src, i+1)
+			i = j
+
+			if htmlClosesP[n.tag] {
+				closeOpenElement(&cur, []string{"p"}, []string{"table", "td", "th", "li", "blockquote", "div"})
+			}
+			if rule, ok := htmlImpliedEnd[n.tag]; ok {
+				closeOpenElement(&cur, rule.closes, rule.boundary)
+			}
+
+			appendChild(n)
+			if htmlVoidElements[n.tag] || selfClosing {
+				continue
+			}
+
+			if htmlRawTextElements[n.tag] {
+				end := indexFold(src[i:], "</"+n.tag)
+				if end < 0 {
+					end = len(src) - i
+				}
+				if end > 0 {
+					n.children = append(n.children, &htmlNode{typ: htmlTextNode, text: src[i : i+end], parent: n})
+				}
+				i += end
+				if gt := strings.IndexByte(src[i:], '>'); gt >= 0 {
+					i += gt + 1
+				}
+				continue
+			}
+
+			cur = n
+
+		default:
+			text.WriteByte(src[i])
+			i++
+		}
+	}
+
+	flushText()
+	return doc
+}
+
+// closeOpenElement move cur above the nearest open element named in closes,
+// unless an element named in boundary comes first
+func closeOpenElement(cur **htmlNode, closes []string, boundary []string) {
+	for n := *cur; n != nil && n.typ == htmlElementNode; n = n.parent {
+		if stringInSlice(n.tag, boundary) {
+			return
+		}
+		if stringInSlice(n.tag, closes) {
+			*cur = n.parent
+			return
+		}
+	}
+}
+
+// parseStartTag parse a start tag whose name begins at i; returns the node,
+// the index after '>' and whether the tag ended with "/>"
+func parseStartTag(src string, i int) (*htmlNode, int, bool) {
+	name, j := scanTagName(src, i)
+	n := &htmlNode{typ: htmlElementNode, tag: name}
+
+	for j < len(src) {
+		for j < len(src) && isHTMLSpace(src[j]) {
+			j++
+		}
+		if j >= len(src) {
+			break
+		}
+		if src[j] == '>' {
+			return n, j + 1, false
+		}
+		if src[j] == '/' && j+1 < len(src) && src[j+1] == '>' {
+			return n, j + 2, true
+		}
+		if src[j] == '/' {
+			j++
+			continue
+		}
+
+		start := j
+		for j < len(src) && !isHTMLSpace(src[j]) && src[j] != '=' && src[j] != '>' && !(src[j] == '/' && j+1 < len(src) && src[j+1] == '>') {
+			j++
+		}
+		key := strings.ToLower(src[start:j])
+		for j < len(src) && isHTMLSpace(src[j]) {
+			j++
+		}
+
+		val := ""
+		if j < len(src) && src[j] == '=' {
+			j++
+			for j < len(src) && isHTMLSpace(src[j]) {
+				j++
+			}
+			if j < len(src) && (src[j] == '"' || src[j] == '\'') {
+				q := src[j]
+				end := strings.IndexByte(src[j+1:], q)
+				if end < 0 {
+					val = src[j+1:]
+					j = len(src)
+				} else {
+					val = src[j+1 : j+1+end]
+					j += end + 2
+				}
+			} else {
+				start := j
+				for j < len(src) && !isHTMLSpace(src[j]) && src[j] != '>' {
+					j++
+				}
+				val = src[start:j]
+			}
+		}
+
+		if key != "" {
+			n.attrs = append(n.attrs, htmlAttr{key: key, val: html.UnescapeString(val)})
+		}
+	}
+
+	return n, len(src), false
+}
+
+// scanTagName lower case tag name starting at i, and the index after it
+func scanTagName(src string, i int) (string, int) {
+	j := i
+	for j < len(src) && !isHTMLSpace(src[j]) && src[j] != '>' && src[j] != '/' {
+		j++
+	}
+	return strings.ToLower(src[i:j]), j
+}
+
+// render write the node and its descendants as HTML
+func (n *htmlNode) render(w *strings.Builder) {
+	switch n.typ {
+	case htmlDocumentNode:
+		for _, c := range n.children {
+			c.render(w)
+		}
+	case htmlTextNode:
+		w.WriteString(n.text)
+	case htmlCommentNode:
+		w.WriteString("<!--" + n.text + "-->")
+	case htmlDoctypeNode:
+		w.WriteString("<!" + n.text + ">")
+	case htmlElementNode:
+		w.WriteString("<" + n.tag)
+		for _, a := range n.attrs {
+			w.WriteString(" " + a.key + "=\"" + html.EscapeString(a.val) + "\"")
+		}
+		w.WriteString(">")
+		if htmlVoidElements[n.tag] {
+			return
+		}
+		for _, c := range n.children {
+			c.render(w)
+		}
+		w.WriteString("</" + n.tag + ">")
+	}
+}
+
+// String rendered HTML of the node
+func (n *htmlNode) String() string {
+	var w strings.Builder
+	n.render(&w)
+	return w.String()
+}
+
+// attr value of the attribute key
+func (n *htmlNode) attr(key string) (string, bool) {
+	for _, a := range n.attrs {
+		if a.key == key {
+			return a.val, true
+		}
+	}
+	return "", false
+}
+
+// setAttr set or add the attribute key
+func (n *htmlNode) setAttr(key, val string) {
+	for i, a := range n.attrs {
+		if a.key == key {
+			n.attrs[i].val = val
+			return
+		}
+	}
+	n.attrs = append(n.attrs, htmlAttr{key, val})
+}
+
+// delAttr remove the attribute key
+func (n *htmlNode) delAttr(key string) {
+	out := n.attrs[:0]
+	for _, a := range n.attrs {
+		if a.key != key {
+			out = append(out, a)
+		}
+	}
+	n.attrs = out
+}
+
+// removeChild detach c from n
+func (n *htmlNode) removeChild(c *htmlNode) {
+	for i, x := range n.children {
+		if x == c {
+			n.children = append(n.children[:i], n.children[i+1:]...)
+			c.parent = nil
+			return
+		}
+	}
+}
+
+// appendChild attach c as the last child of n
+func (n *htmlNode) appendChild(c *htmlNode) {
+	c.parent = n
+	n.children = append(n.children, c)
+}
+
+// walk call fn for n and its descendants in document order; returning
+// false skips the children of a node
+func (n *htmlNode) walk(fn func(*htmlNode) bool) {
+	if !fn(n) {
+		return
+	}
+	for _, c := range append([]*htmlNode(nil), n.children...) {
+		c.walk(fn)
+	}
+}
+
+// find first descendant element named tag
+func (n *htmlNode) find(tag string) *htmlNode {
+	var found *htmlNode
+	n.walk(func(c *htmlNode) bool {
+		if found != nil {
+			return false
+		}
+		if c.typ == htmlElementNode && c.tag == tag {
+			found = c
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// textContent decoded text of n and its descendants
+func (n *htmlNode) textContent() string {
+	var w strings.Builder
+	n.walk(func(c *htmlNode) bool {
+		if c.typ == htmlTextNode {
+			w.WriteString(html.UnescapeString(c.text))
+		}
+		return c.typ != htmlCommentNode
+	})
+	return w.String()
+}
+
+// elementChildren child elements of n
+func (n *htmlNode) elementChildren() []*htmlNode {
+	var out []*htmlNode
+	for _, c := range n.children {
+		if c.typ == htmlElementNode {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// indexFold index of substr in s, ignoring ASCII case. It compares bytes,
+// as lowering s would shift the offsets of invalid UTF-8 and of runes like
+// İ that change length
+func indexFold(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		j := 0
+		for j < len(substr) && lowerASCII(s[i+j]) == lowerASCII(substr[j]) {
+			j++
+		}
+		if j == len(substr) {
+			return i
+		}
+	}
+	return -1
+}
+
+// lowerASCII lower case of an ASCII letter, other bytes as they are
+func lowerASCII(b byte) byte {
+	if 'A' <= b && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
+
+func stringInSlice(s string, list []string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -0,0 +1,562 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// InboundMessage email received through Sendgrid Inbound Parse, a Mailgun
+// route or as raw MIME, e.g. from SES to S3 or an SMTP listener
+type InboundMessage struct {
+	Provider     string               // sendgrid, mailgun or raw
+	Header       textproto.MIMEHeader // as received, words not decoded
+	From         *mail.Address
+	To           []*mail.Address
+	Cc           []*mail.Address
+	ReplyTo      []*mail.Address
+	Subject      string
+	MessageID    string
+	InReplyTo    string
+	References   []string
+	Date         time.Time
+	EnvelopeFrom string   // MAIL FROM, when the source tells
+	EnvelopeTo   []string // RCPT TO, when the source tells
+	Text         string
+	HTML         string
+	Attachments  []Attachment // inline parts included
+	SPF          string       // pass, fail, softfail, neutral, none...; empty when not reported
+	DKIM         string       // pass when a signature passed, else the first verdict; empty when not reported
+	Raw          []byte       // MIME source, when the source has it
+
+	UnknownCharsets []string // charsets of the text not decoded, its invalid bytes replaced by U+FFFD
+}
+
+// InboundHandler receives the messages of an inbound endpoint; an error
+// makes it answer 500 so the provider posts again later
+type InboundHandler func(*InboundMessage) error
+
+// SendgridInbound http.Handler of Sendgrid Inbound Parse posts, in the
+// default and the raw formats
+type SendgridInbound struct {
+	Handle  InboundHandler
+	MaxSize int64 // largest post read, InboundMaxSize when 0
+}
+
+// MailgunInbound http.Handler of the messages forwarded by Mailgun routes,
+// parsed or, with a URL ending in mime, raw
+type MailgunInbound struct {
+	APIKey  string // key signing the posts (webhook signing key on new accounts)
+	Handle  InboundHandler
+	MaxSize int64 // largest post read, InboundMaxSize when 0
+}
+
+// NewSendgridInbound return an endpoint passing Sendgrid inbound messages
+// to handle
+func NewSendgridInbound(handle InboundHandler) *SendgridInbound {
+	return &SendgridInbound{Handle: handle}
+}
+
+// NewMailgunInbound return an endpoint passing the messages of Mailgun
+// routes signed with apiKey to handle
+func NewMailgunInbound(apiKey string, handle InboundHandler) *MailgunInbound {
+	return &MailgunInbound{APIKey: apiKey, Handle: handle}
+}
+
+// InboundMaxSize default size of an inbound post read, above the message
+// limits of Sendgrid (30 MB) and Mailgun (25 MB)
+const InboundMaxSize = 40 << 20
+
+// inboundMaxMemory form size kept in memory, the rest going to files
+const inboundMaxMemory = 32 << 20
+
+// inboundMaxDepth nesting of multiparts read
+const inboundMaxDepth = 10
+
+// authResult result of a method in Authentication-Results (RFC 8601)
+var authResult = regexp.MustCompile(`(?i)\b(spf|dkim)\s*=\s*([a-z]+)`)
+
+// sendgridDKIM verdicts of the dkim field of Sendgrid, e.g. {@host.com : pass}
+var sendgridDKIM = regexp.MustCompile(`:\s*([a-zA-Z]+)`)
+
+// ParseInbound parse a raw MIME message
+func ParseInbound(raw []byte) (*InboundMessage, error) {
+	header, body, err := splitMIMEEntity(raw)
+	if err != nil {
+		return nil, err
+	}
+	m := &InboundMessage{Provider: "raw", Raw: raw}
+	m.setHeader(header)
+	if err := m.addEntity(header, body, 0); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ParseSendgridInbound parse a Sendgrid Inbound Parse post
+func ParseSendgridInbound(r *http.Request) (*InboundMessage, error) {
+	if err := r.ParseMultipartForm(inboundMaxMemory); err != nil {
+		return nil, fmt.Errorf("Invalid Sendgrid inbound post: %w", err)
+	}
+
+	var m *InboundMessage
+	if raw := r.PostFormValue("email"); raw != "" {
+		var err error
+		if m, err = ParseInbound([]byte(raw)); err != nil {
+			return nil, err
+		}
+	} else {
+		m = &InboundMessage{}
+		header, _ := textproto.NewReader(bufio.NewReader(strings.NewReader(
+			strings.TrimRight(toLF(r.PostFormValue("headers")), "\n") + "\n\n"))).ReadMIMEHeader()
+		if header == nil {
+			header = textproto.MIMEHeader{}
+		}
+		m.setHeader(header)
+
+		charsets := map[string]string{}
+		json.Unmarshal([]byte(r.PostFormValue("charsets")), &charsets)
+		m.Text = toLF(m.decodeText(charsets["text"], []byte(r.PostFormValue("text"))))
+		m.HTML = toLF(m.decodeText(charsets["html"], []byte(r.PostFormValue("html"))))
+		if m.Subject == "" {
+			m.Subject = m.decodeText(charsets["subject"], []byte(r.PostFormValue("subject")))
+		}
+		if m.From == nil {
+			m.From, _ = mail.ParseAddress(r.PostFormValue("from"))
+		}
+		if len(m.To) == 0 {
+			m.To = parseAddressList(r.PostFormValue("to"))
+		}
+
+		var info map[string]struct {
+			Filename string `json:"filename"`
+			Type     string `json:"type"`
+		}
+		json.Unmarshal([]byte(r.PostFormValue("attachment-info")), &info)
+		count, _ := strconv.Atoi(r.PostFormValue("attachments"))
+		for i := 1; i <= count && r.MultipartForm != nil; i++ {
+			name := fmt.Sprintf("attachment%d", i)
+			files := r.MultipartForm.File[name]
+			if len(files) == 0 {
+				continue
+			}
+			a, err := formAttachment(files[0])
+			if err != nil {
+				return nil, err
+			}
+			if i, ok := info[name]; ok {
+				if i.Filename != "" {
+					a.Filename = i.Filename
+				}
+				if i.Type != "" {
+					a.ContentType = i.Type
+				}
+			}
+			m.Attachments = append(m.Attachments, a)
+		}
+	}
+	m.Provider = "sendgrid"
+
+	var envelope struct {
+		To   []string `json:"to"`
+		From string   `json:"from"`
+	}
+	if json.Unmarshal([]byte(r.PostFormValue("envelope")), &envelope) == nil {
+		m.EnvelopeFrom, m.EnvelopeTo = envelope.From, envelope.To
+	}
+	if v := r.PostFormValue("SPF"); v != "" {
+		m.SPF = strings.ToLower(strings.TrimSpace(v))
+	}
+	var verdicts []string
+	for _, v := range sendgridDKIM.FindAllStringSubmatch(r.PostFormValue("dkim"), -1) {
+		verdicts = append(verdicts, v[1])
+	}
+	if v := dkimVerdict(verdicts); v != "" {
+		m.DKIM = v
+	}
+	return m, nil
+}
+
+// ParseMailgunInbound parse the post of a Mailgun route signed with apiKey.
+// The signature token is taken, so the same post parses only once
+func ParseMailgunInbound(r *http.Request, apiKey string) (*InboundMessage, error) {
+	if err := parseInboundForm(r); err != nil {
+		return nil, err
+	}
+	release, err := checkMailgunSignature(apiKey, r.PostFormValue("timestamp"), r.PostFormValue("token"), r.PostFormValue("signature"))
+	if err != nil {
+		return nil, err
+	}
+	m, err := mailgunInbound(r)
+	if err != nil {
+		release()
+	}
+	return m, err
+}
+
+// parseInboundForm parse a multipart or urlencoded form post
+func parseInboundForm(r *http.Request) error {
+	// ParseMultipartForm drops the errors of a form that is not multipart
+	if err := r.ParseForm(); err != nil {
+		return fmt.Errorf("Invalid inbound post: %w", err)
+	}
+	if err := r.ParseMultipartForm(inboundMaxMemory); err != nil && err != http.ErrNotMultipart {
+		return fmt.Errorf("Invalid inbound post: %w", err)
+	}
+	return nil
+}
+
+// mailgunInbound message of a parsed Mailgun route post
+func mailgunInbound(r *http.Request) (*InboundMessage, error) {
+	var m *InboundMessage
+	if raw := r.PostFormValue("body-mime"); raw != "" {
+		var err error
+		if m, err = ParseInbound([]byte(raw)); err != nil {
+			return nil, err
+		}
+	} else {
+		m = &InboundMessage{}
+		var pairs [][2]string
+		if err := json.Unmarshal([]byte(r.PostFormValue("message-headers")), &pairs); err != nil {
+			return nil, fmt.Errorf("Invalid Mailgun message-headers: %s", err)
+		}
+		header := textproto.MIMEHeader{}
+		for _, p := range pairs {
+			header.Add(p[0], p[1])
+		}
+		m.setHeader(header)
+		m.Text = toLF(r.PostFormValue("body-plain"))
+		m.HTML = toLF(r.PostFormValue("body-html"))
+		if m.Subject == "" {
+			m.Subject = r.PostFormValue("subject")
+		}
+
+		count, _ := strconv.Atoi(r.PostFormValue("attachment-count"))
+		for i := 1; i <= count && r.MultipartForm != nil; i++ {
+			files := r.MultipartForm.File[fmt.Sprintf("attachment-%d", i)]
+			if len(files) == 0 {
+				continue
+			}
+			a, err := formAttachment(files[0])
+			if err != nil {
+				return nil, err
+			}
+			m.Attachments = append(m.Attachments, a)
+		}
+		if v := header.Get("X-Mailgun-Spf"); v != "" {
+			m.SPF = strings.ToLower(v)
+		}
+		if v := header.Get("X-Mailgun-Dkim-Check-Result"); v != "" {
+			m.DKIM = strings.ToLower(v)
+		}
+	}
+	m.Provider = "mailgun"
+	m.EnvelopeFrom = r.PostFormValue("sender")
+	for _, to := range strings.Split(r.PostFormValue("recipient"), ",") {
+		if to = strings.TrimSpace(to); to != "" {
+			m.EnvelopeTo = append(m.EnvelopeTo, to)
+		}
+	}
+	return m, nil
+}
+
+// ServeHTTP parse the posted message
+func (h *SendgridInbound) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limitInbound(w, r, h.MaxSize)
+	m, err := ParseSendgridInbound(r)
+	if err != nil {
+		inboundError(w, err)
+		return
+	}
+	handleInbound(w, h.Handle, m)
+}
+
+// ServeHTTP parse the forwarded message
+func (h *MailgunInbound) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limitInbound(w, r, h.MaxSize)
+	if err := parseInboundForm(r); err != nil {
+		inboundError(w, err)
+		return
+	}
+	release, err := checkMailgunSignature(h.APIKey, r.PostFormValue("timestamp"), r.PostFormValue("token"), r.PostFormValue("signature"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	m, err := mailgunInbound(r)
+	if err != nil {
+		release()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !handleInbound(w, h.Handle, m) {
+		release()
+	}
+}
+
+// limitInbound limit the body of r to maxSize, InboundMaxSize when 0
+func limitInbound(w http.ResponseWriter, r *http.Request, maxSize int64) {
+	if maxSize <= 0 {
+		maxSize = InboundMaxSize
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+}
+
+// inboundError answer a post that failed to parse, 413 when it is too large
+func inboundError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "Body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// handleInbound pass m to handle and answer the post; false when handle
+// failed
+func handleInbound(w http.ResponseWriter, handle InboundHandler, m *InboundMessage) bool {
+	if handle != nil {
+		if err := handle(m); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return false
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	return true
+}
+
+// setHeader set the fields of the message header, and the SPF and DKIM
+// verdicts of the receiving MTA, if any
+func (m *InboundMessage) setHeader(header textproto.MIMEHeader) {
+	dec := &mime.WordDecoder{CharsetReader: charsetReader}
+	m.Header = header
+	if list := parseAddressList(header.Get("From")); len(list) > 0 {
+		m.From = list[0]
+	}
+	m.To = parseAddressList(strings.Join(header["To"], ", "))
+	m.Cc = parseAddressList(strings.Join(header["Cc"], ", "))
+	m.ReplyTo = parseAddressList(header.Get("Reply-To"))
+	if s, err := dec.DecodeHeader(header.Get("Subject")); err == nil {
+		m.Subject = s
+	} else {
+		m.Subject = header.Get("Subject")
+	}
+	m.MessageID = strings.TrimSpace(header.Get("Message-Id"))
+	m.InReplyTo = strings.TrimSpace(header.Get("In-Reply-To"))
+	m.References = strings.Fields(header.Get("References"))
+	if t, err := mail.ParseDate(header.Get("Date")); err == nil {
+		m.Date = t
+	}
+
+	// the topmost Authentication-Results is the one of the receiving MTA
+	var dkim []string
+	for _, r := range authResult.FindAllStringSubmatch(header.Get("Authentication-Results"), -1) {
+		switch strings.ToLower(r[1]) {
+		case "spf":
+			if m.SPF == "" {
+				m.SPF = strings.ToLower(r[2])
+			}
+		case "dkim":
+			dkim = append(dkim, r[2])
+		}
+	}
+	m.DKIM = dkimVerdict(dkim)
+	if m.SPF == "" {
+		if f := strings.Fields(header.Get("Received-Spf")); len(f) > 0 {
+			m.SPF = strings.ToLower(f[0])
+		}
+	}
+}
+
+// addEntity read the text, HTML and attachments of a MIME entity
+func (m *InboundMessage) addEntity(header textproto.MIMEHeader, body []byte, depth int) error {
+	if depth > inboundMaxDepth {
+		return fmt.Errorf("MIME nested deeper than %d", inboundMaxDepth)
+	}
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		boundary := params["boundary"]
+		if !bytes.Contains(body, []byte("--"+boundary+"--")) {
+			body = append(toCRLF(body), []byte("\r\n--"+boundary+"--\r\n")...)
+		}
+		for _, raw := range splitMultipartRaw(toCRLF(body), boundary) {
+			h, b, err := splitMIMEEntity(raw)
+			if err != nil {
+				continue
+			}
+			if err := m.addEntity(h, b, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	data, err := decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body)
+	if err != nil {
+		return err
+	}
+	disposition, dparams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := dparams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	if f, err := (&mime.WordDecoder{CharsetReader: charsetReader}).DecodeHeader(filename); err == nil {
+		filename = f
+	}
+
+	if disposition != "attachment" && filename == "" {
+		switch {
+		case mediaType == "text/plain" && m.Text == "":
+			m.Text = toLF(m.decodeText(params["charset"], data))
+			return nil
+		case mediaType == "text/html" && m.HTML == "":
+			m.HTML = toLF(m.decodeText(params["charset"], data))
+			return nil
+		}
+	}
+	if filename == "" && mediaType == "message/rfc822" {
+		filename = "message.eml"
+	}
+	m.Attachments = append(m.Attachments, Attachment{Filename: filename, ContentType: mediaType, Data: data})
+	return nil
+}
+
+// parseAddressList addresses of a header, skipping the invalid ones
+func parseAddressList(v string) []*mail.Address {
+	if strings.TrimSpace(v) == "" {
+		return nil
+	}
+	if list, err := mail.ParseAddressList(v); err == nil {
+		return list
+	}
+	var list []*mail.Address
+	for _, s := range strings.Split(v, ",") {
+		if a, err := mail.ParseAddress(s); err == nil {
+			list = append(list, a)
+		}
+	}
+	return list
+}
+
+// dkimVerdict pass when a signature passed, else the first verdict
+func dkimVerdict(verdicts []string) string {
+	for _, v := range verdicts {
+		if strings.EqualFold(v, "pass") {
+			return "pass"
+		}
+	}
+	if len(verdicts) > 0 {
+		return strings.ToLower(verdicts[0])
+	}
+	return ""
+}
+
+// cp1252 runes of the bytes 0x80 to 0x9F in windows-1252, C1 controls in
+// ISO-8859-1; the five undefined bytes keep their C1 control
+var cp1252 = [32]rune{
+	'\u20ac', '\u0081', '\u201a', '\u0192', '\u201e', '\u2026', '\u2020', '\u2021',
+	'\u02c6', '\u2030', '\u0160', '\u2039', '\u0152', '\u008d', '\u017d', '\u008f',
+	'\u0090', '\u2018', '\u2019', '\u201c', '\u201d', '\u2022', '\u2013', '\u2014',
+	'\u02dc', '\u2122', '\u0161', '\u203a', '\u0153', '\u009d', '\u017e', '\u0178',
+}
+
+// decodeCharset text of data in charset; only UTF-8, US-ASCII and the
+// Latin-1 family are known. Latin-1 is read as windows-1252, as browsers do,
+// since mail is rarely meant to hold C1 controls. False when the charset is
+// unknown or the text is not valid in it, invalid bytes replaced by U+FFFD
+func decodeCharset(charset string, data []byte) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(charset)) {
+	case "iso-8859-1", "latin1", "l1", "windows-1252", "cp1252":
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+			if b >= 0x80 && b <= 0x9f {
+				runes[i] = cp1252[b-0x80]
+			}
+		}
+		return string(runes), true
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		if utf8.Valid(data) {
+			return string(data), true
+		}
+	}
+	return strings.ToValidUTF8(string(data), "\ufffd"), false
+}
+
+// decodeText text of data in charset, noting the charsets not decoded
+func (m *InboundMessage) decodeText(charset string, data []byte) string {
+	s, ok := decodeCharset(charset, data)
+	if !ok {
+		charset = strings.ToLower(strings.TrimSpace(charset))
+		if charset == "" {
+			charset = "us-ascii"
+		}
+		for _, c := range m.UnknownCharsets {
+			if c == charset {
+				return s
+			}
+		}
+		m.UnknownCharsets = append(m.UnknownCharsets, charset)
+	}
+	return s
+}
+
+// charsetReader decode the encoded words in the charsets of decodeCharset
+// not known to mime.WordDecoder
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	data, err := ioutil.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := decodeCharset(charset, data)
+	if !ok {
+		return nil, fmt.Errorf("Unknown charset %s", charset)
+	}
+	return strings.NewReader(s), nil
+}
+
+// toLF text with LF line ends
+func toLF(s string) string {
+	return strings.ReplaceAll(s, "\r\n", "\n")
+}
+
+// formAttachment attachment of a posted file
+func formAttachment(fh *multipart.FileHeader) (Attachment, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return Attachment{}, err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return Attachment{}, err
+	}
+	contentType, _, _ := mime.ParseMediaType(fh.Header.Get("Content-Type"))
+	return Attachment{Filename: fh.Filename, ContentType: contentType, Data: data}, nil
+}
@@ -0,0 +1,319 @@
+package mailer_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thiagozs/mailer-go"
+)
+
+// sendgridInboundData sample posts of the vendored Inbound Parse helper
+const sendgridInboundData = "vendor/github.com/sendgrid/sendgrid-go/helpers/inbound/sample_data/"
+
+// postInbound serve a post of body to h, returning the status
+func postInbound(h http.Handler, contentType string, body []byte) int {
+	req := httptest.NewRequest(http.MethodPost, "/inbound", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestParseInbound(t *testing.T) {
+	t.Log("Parse a raw MIME reply... (NOT expected some err)")
+
+	raw, err := os.ReadFile("testdata/inbound/reply.eml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := mailer.ParseInbound(raw)
+	if err != nil {
+		t.Fatalf("ParseInbound got: %s", err)
+	}
+
+	if m.Provider != "raw" || m.From.Name != "Ana Araújo" || m.From.Address != "ana@example.org" ||
+		m.Subject != "Re: Pedido nº 42" || m.MessageID != "<reply-1@example.org>" ||
+		m.InReplyTo != "<1760880000.abc@example.com>" || len(m.References) != 2 || !bytes.Equal(m.Raw, raw) {
+		t.Errorf("ParseInbound got: %+v", m)
+	}
+	if len(m.To) != 2 || m.To[1].Name != "Sales, EMEA" || m.To[1].Address != "sales@example.com" ||
+		len(m.Cc) != 1 || m.Cc[0].Address != "bob@example.org" ||
+		len(m.ReplyTo) != 1 || m.ReplyTo[0].Address != "ana.reply@example.org" {
+		t.Errorf("ParseInbound addresses got: %v %v %v", m.To, m.Cc, m.ReplyTo)
+	}
+	if !m.Date.Equal(time.Date(2026, 10, 19, 13, 15, 0, 0, time.UTC)) {
+		t.Errorf("ParseInbound Date got: %s", m.Date)
+	}
+	if m.Text != "Olá, o pedido nº 42 chegou quebrado.\n\n> Seu pedido foi enviado." ||
+		m.HTML != `<p>Olá, o pedido nº 42 chegou quebrado.</p><img src="cid:foto1">` {
+		t.Errorf("ParseInbound content got: %q %q", m.Text, m.HTML)
+	}
+	// the verdicts of the receiving MTA, a passing signature winning
+	if m.SPF != "softfail" || m.DKIM != "pass" {
+		t.Errorf("ParseInbound verdicts got: %s %s", m.SPF, m.DKIM)
+	}
+
+	if len(m.Attachments) != 2 {
+		t.Fatalf("ParseInbound got: %d attachments", len(m.Attachments))
+	}
+	if a := m.Attachments[0]; a.Filename != "foto.png" || a.ContentType != "image/png" || !bytes.HasPrefix(a.Data, []byte("\x89PNG")) {
+		t.Errorf("ParseInbound inline got: %s %s", a.Filename, a.ContentType)
+	}
+	if a := m.Attachments[1]; a.Filename != "nota fiscal nº42.pdf" || a.ContentType != "application/pdf" || string(a.Data) != "%PDF-1.4\n" {
+		t.Errorf("ParseInbound attachment got: %s %s %q", a.Filename, a.ContentType, a.Data)
+	}
+}
+
+func TestParseInboundCharsets(t *testing.T) {
+	t.Log("Decode windows-1252 and flag unknown charsets... (NOT expected some err)")
+
+	m, err := mailer.ParseInbound([]byte("From: ana@example.org\r\n" +
+		"Subject: =?windows-1252?q?=93Caf=E9=94?=\r\n" +
+		"Content-Type: text/plain; charset=windows-1252\r\n" +
+		"\r\n" +
+		"\x93Caf\xe9\x94 \x80 5 \x96 ok\r\n"))
+	if err != nil {
+		t.Fatalf("ParseInbound got: %s", err)
+	}
+	if m.Subject != "\u201cCafé\u201d" || m.Text != "\u201cCafé\u201d \u20ac 5 \u2013 ok\n" || len(m.UnknownCharsets) != 0 {
+		t.Errorf("windows-1252 got: %q %q %v", m.Subject, m.Text, m.UnknownCharsets)
+	}
+
+	m, err = mailer.ParseInbound([]byte("From: ana@example.org\r\n" +
+		"Content-Type: multipart/alternative; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\nContent-Type: text/plain; charset=x-unknown\r\n\r\ncaf\xe9\r\n" +
+		"--b\r\nContent-Type: text/html\r\n\r\n<p>caf\xe9</p>\r\n" +
+		"--b--\r\n"))
+	if err != nil {
+		t.Fatalf("ParseInbound got: %s", err)
+	}
+	if m.Text != "caf\ufffd" || m.HTML != "<p>caf\ufffd</p>" ||
+		strings.Join(m.UnknownCharsets, ",") != "x-unknown,us-ascii" {
+		t.Errorf("unknown charset got: %q %q %v", m.Text, m.HTML, m.UnknownCharsets)
+	}
+}
+
+func TestSendgridInbound(t *testing.T) {
+	t.Log("Parse Sendgrid Inbound Parse posts... (NOT expected some err)")
+
+	var got []*mailer.InboundMessage
+	h := mailer.NewSendgridInbound(func(m *mailer.InboundMessage) error {
+		got = append(got, m)
+		return nil
+	})
+	for _, file := range []string{"default_data.txt", "raw_data.txt", "raw_data_with_attachments.txt"} {
+		data, err := os.ReadFile(sendgridInboundData + file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status := postInbound(h, "multipart/form-data; boundary=xYzZY", data); status != http.StatusOK {
+			t.Errorf("post %s got: %d", file, status)
+		}
+	}
+	if len(got) != 3 {
+		t.Fatalf("handler got: %d messages", len(got))
+	}
+
+	for i, m := range got {
+		if m.Provider != "sendgrid" || m.From.Address != "test@example.com" || m.From.Name != "Example User" ||
+			len(m.To) != 1 || !strings.HasPrefix(m.To[0].Address, "inbound@") ||
+			m.EnvelopeFrom != "test@example.com" || len(m.EnvelopeTo) != 1 ||
+			m.SPF != "pass" || m.DKIM != "pass" ||
+			!strings.HasPrefix(m.Text, "Hello SendGrid!") || !strings.Contains(m.HTML, "<strong>Hello SendGrid!") {
+			t.Errorf("message %d got: %+v", i, m)
+		}
+	}
+	if got[0].Subject != "Inbound Parse Test Data" || got[0].Raw != nil {
+		t.Errorf("default message got: %s", got[0].Subject)
+	}
+	if got[1].Subject != "Inbound Parse Test Raw Data" || got[1].Raw == nil {
+		t.Errorf("raw message got: %s", got[1].Subject)
+	}
+	if len(got[2].Attachments) != 1 || got[2].Attachments[0].Filename != "SendGrid.jpg" ||
+		!bytes.HasPrefix(got[2].Attachments[0].Data, []byte("\xff\xd8")) {
+		t.Errorf("raw message attachments got: %v", len(got[2].Attachments))
+	}
+
+	// attachments of the default format are files of the post
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("headers", "From: a@host.com\nTo: b@host.com\nSubject: =?UTF-8?Q?Ol=C3=A1?=\n")
+	w.WriteField("text", "hi\r\n")
+	w.WriteField("attachments", "1")
+	w.WriteField("attachment-info", `{"attachment1":{"filename":"report.csv","type":"text/csv"}}`)
+	fw, _ := w.CreateFormFile("attachment1", "upload")
+	fw.Write([]byte("a,b\n"))
+	w.Close()
+	if status := postInbound(h, w.FormDataContentType(), body.Bytes()); status != http.StatusOK || len(got) != 4 {
+		t.Fatalf("post with attachment got: %d", status)
+	}
+	if m := got[3]; m.Subject != "Olá" || m.Text != "hi\n" || len(m.Attachments) != 1 ||
+		m.Attachments[0].Filename != "report.csv" || m.Attachments[0].ContentType != "text/csv" ||
+		string(m.Attachments[0].Data) != "a,b\n" || m.SPF != "" || m.DKIM != "" {
+		t.Errorf("message with attachment got: %+v", m)
+	}
+}
+
+// mailgunRouteForm signed form of a Mailgun route forward
+func mailgunRouteForm(key string) map[string]string {
+	ts, token, signature := mailgunSigned(key)
+	headers, _ := json.Marshal([][2]string{
+		{"From", "Ana <ana@example.org>"},
+		{"To", "support@example.com"},
+		{"Subject", "Re: Order 42"},
+		{"Message-Id", "<reply-2@example.org>"},
+		{"In-Reply-To", "<1760880000.abc@example.com>"},
+		{"X-Mailgun-Spf", "Pass"},
+		{"X-Mailgun-Dkim-Check-Result", "Fail"},
+	})
+	return map[string]string{
+		"timestamp":       ts,
+		"token":           token,
+		"signature":       signature,
+		"recipient":       "support@example.com",
+		"sender":          "bounce@example.org",
+		"subject":         "Re: Order 42",
+		"body-plain":      "It arrived broken.\r\n",
+		"body-html":       "<p>It arrived broken.</p>",
+		"message-headers": string(headers),
+	}
+}
+
+func TestMailgunInbound(t *testing.T) {
+	t.Log("Parse the posts of Mailgun routes... (NOT expected some err)")
+
+	var got []*mailer.InboundMessage
+	h := mailer.NewMailgunInbound("key", func(m *mailer.InboundMessage) error {
+		got = append(got, m)
+		return nil
+	})
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for k, v := range mailgunRouteForm("key") {
+		w.WriteField(k, v)
+	}
+	w.WriteField("attachment-count", "1")
+	part, _ := w.CreatePart(textproto.MIMEHeader{
+		"Content-Disposition": {`form-data; name="attachment-1"; filename="photo.jpg"`},
+		"Content-Type":        {"image/jpeg"},
+	})
+	part.Write([]byte("\xff\xd8jpeg"))
+	w.Close()
+	if status := postInbound(h, w.FormDataContentType(), body.Bytes()); status != http.StatusOK || len(got) != 1 {
+		t.Fatalf("post got: %d", status)
+	}
+	m := got[0]
+	if m.Provider != "mailgun" || m.From.Address != "ana@example.org" || m.Subject != "Re: Order 42" ||
+		m.MessageID != "<reply-2@example.org>" || m.InReplyTo != "<1760880000.abc@example.com>" ||
+		m.EnvelopeFrom != "bounce@example.org" || len(m.EnvelopeTo) != 1 ||
+		m.Text != "It arrived broken.\n" || m.HTML != "<p>It arrived broken.</p>" ||
+		m.SPF != "pass" || m.DKIM != "fail" {
+		t.Errorf("message got: %+v", m)
+	}
+	if len(m.Attachments) != 1 || m.Attachments[0].Filename != "photo.jpg" || m.Attachments[0].ContentType != "image/jpeg" {
+		t.Errorf("attachments got: %+v", m.Attachments)
+	}
+
+	// a route forwarding to a URL ending in mime posts the raw message
+	raw, err := os.ReadFile("testdata/inbound/reply.eml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{}
+	for k, v := range mailgunRouteForm("key") {
+		form.Set(k, v)
+	}
+	form.Del("message-headers")
+	form.Set("body-mime", string(raw))
+	if status := postInbound(h, "application/x-www-form-urlencoded", []byte(form.Encode())); status != http.StatusOK || len(got) != 2 {
+		t.Fatalf("mime post got: %d", status)
+	}
+	if m := got[1]; m.Provider != "mailgun" || m.MessageID != "<reply-1@example.org>" || len(m.Attachments) != 2 ||
+		m.EnvelopeFrom != "bounce@example.org" || m.SPF != "softfail" || m.Raw == nil {
+		t.Errorf("mime message got: %+v", m)
+	}
+
+	parse := func() (*mailer.InboundMessage, error) {
+		req := httptest.NewRequest(http.MethodPost, "/inbound", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return mailer.ParseMailgunInbound(req, "key")
+	}
+	if _, err := parse(); err == nil {
+		t.Errorf("ParseMailgunInbound of a post taken expected an error")
+	}
+	for k, v := range mailgunRouteForm("key") {
+		if k != "message-headers" {
+			form.Set(k, v)
+		}
+	}
+	if m, err := parse(); err != nil || m.MessageID != "<reply-1@example.org>" {
+		t.Errorf("ParseMailgunInbound got: %v", err)
+	}
+}
+
+func TestInboundErrors(t *testing.T) {
+	t.Log("Refuse bad inbound posts... (expected some err)")
+
+	failing := func(m *mailer.InboundMessage) error { return fmt.Errorf("store down") }
+	sg := mailer.NewSendgridInbound(failing)
+	mg := mailer.NewMailgunInbound("key", failing)
+	sgSmall := &mailer.SendgridInbound{Handle: failing, MaxSize: 100}
+	mgSmall := &mailer.MailgunInbound{APIKey: "key", Handle: failing, MaxSize: 100}
+
+	form := url.Values{}
+	for k, v := range mailgunRouteForm("other") {
+		form.Set(k, v)
+	}
+	forged := []byte(form.Encode())
+	for k, v := range mailgunRouteForm("key") {
+		form.Set(k, v)
+	}
+	signed := []byte(form.Encode())
+	form.Set("message-headers", "not json")
+	badHeaders := []byte(form.Encode())
+	sample, err := os.ReadFile(sendgridInboundData + "default_data.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var multipartBody bytes.Buffer
+	mw := multipart.NewWriter(&multipartBody)
+	mw.WriteField("body-plain", strings.Repeat("x", 1000))
+	mw.Close()
+
+	for _, c := range []struct {
+		name        string
+		h           http.Handler
+		contentType string
+		body        []byte
+		status      int
+	}{
+		{"sendgrid not multipart", sg, "application/json", []byte("{}"), http.StatusBadRequest},
+		{"sendgrid handler error", sg, "multipart/form-data; boundary=xYzZY", sample, http.StatusInternalServerError},
+		{"mailgun forged", mg, "application/x-www-form-urlencoded", forged, http.StatusUnauthorized},
+		{"mailgun bad headers", mg, "application/x-www-form-urlencoded", badHeaders, http.StatusBadRequest},
+		{"mailgun handler error", mg, "application/x-www-form-urlencoded", signed, http.StatusInternalServerError},
+		{"sendgrid too large", sgSmall, "multipart/form-data; boundary=xYzZY", sample, http.StatusRequestEntityTooLarge},
+		{"mailgun too large", mgSmall, "application/x-www-form-urlencoded", signed, http.StatusRequestEntityTooLarge},
+		{"mailgun multipart too large", mgSmall, mw.FormDataContentType(), multipartBody.Bytes(), http.StatusRequestEntityTooLarge},
+	} {
+		if status := postInbound(c.h, c.contentType, c.body); status != c.status {
+			t.Errorf("%s got: %d", c.name, status)
+		}
+	}
+
+	if _, err := mailer.ParseInbound([]byte("no header")); err == nil {
+		t.Errorf("ParseInbound expected an error")
+	}
+}
@@ -0,0 +1,305 @@
+package mailer
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultLocale last locale tried by the fallback chain
+const DefaultLocale = "en"
+
+// localeFormat number, currency and date conventions of a locale
+type localeFormat struct {
+	decimal     string
+	thousands   string
+	dateShort   string // time layout
+	dateLong    string // "{d}", "{month}" and "{yyyy}" placeholders
+	months      []string
+	symbolAfter bool // 1.234,56 € instead of € 1.234,56
+	symbolSpace bool
+	symbols     map[string]string
+}
+
+var monthsEN = []string{"January", "February", "March", "April", "May", "June",
+	"July", "August", "September", "October", "November", "December"}
+
+var monthsPT = []string{"janeiro", "fevereiro", "março", "abril", "maio", "junho",
+	"julho", "agosto", "setembro", "outubro", "novembro", "dezembro"}
+
+var monthsES = []string{"enero", "febrero", "marzo", "abril", "mayo", "junio",
+	"julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"}
+
+// currencySymbols default symbols, overridden per locale
+var currencySymbols = map[string]string{
+	"USD": "US$",
+	"BRL": "R$",
+	"EUR": "€",
+	"GBP": "£",
+	"JPY": "¥",
+	"MXN": "MX$",
+	"ARS": "AR$",
+}
+
+// currencyDecimals currencies without two minor digits
+var currencyDecimals = map[string]int{
+	"JPY": 0,
+}
+
+var localeFormats = map[string]localeFormat{
+	"en": {
+		decimal: ".", thousands: ",",
+		dateShort: "01/02/2006", dateLong: "{month} {d}, {yyyy}", months: monthsEN,
+		symbols: map[string]string{"USD": "$"},
+	},
+	"en-GB": {
+		decimal: ".", thousands: ",",
+		dateShort: "02/01/2006", dateLong: "{d} {month} {yyyy}", months: monthsEN,
+	},
+	"pt": {
+		decimal: ",", thousands: ".",
+		dateShort: "02/01/2006", dateLong: "{d} de {month} de {yyyy}", months: monthsPT,
+		symbolSpace: true,
+	},
+	"pt-PT": {
+		decimal: ",", thousands: "\u00a0",
+		dateShort: "02/01/2006", dateLong: "{d} de {month} de {yyyy}", months: monthsPT,
+		symbolAfter: true, symbolSpace: true,
+	},
+	"es": {
+		decimal: ",", thousands: ".",
+		dateShort: "02/01/2006", dateLong: "{d} de {month} de {yyyy}", months: monthsES,
+		symbolAfter: true, symbolSpace: true,
+	},
+	"es-MX": {
+		decimal: ".", thousands: ",",
+		dateShort: "02/01/2006", dateLong: "{d} de {month} de {yyyy}", months: monthsES,
+		symbols: map[string]string{"MXN": "$"},
+	},
+	"es-AR": {
+		decimal: ",", thousands: ".",
+		dateShort: "02/01/2006", dateLong: "{d} de {month} de {yyyy}", months: monthsES,
+		symbolSpace: true, symbols: map[string]string{"ARS": "$"},
+	},
+}
+
+var localePattern = regexp.MustCompile(`(?i)^[a-z]{2,3}([-_][a-z]{4})?([-_]([a-z]{2}|[0-9]{3}))?$`)
+
+// isoLanguages ISO 639-1 codes, and the ISO 639-2 codes of common locales
+// without one
+var isoLanguages = func() map[string]bool {
+	m := make(map[string]bool)
+	for _, code := range strings.Fields(`
+		aa ab ae af ak am an ar as av ay az ba be bg bh bi bm bn bo br bs ca ce ch co cr cs cu cv
+		cy da de dv dz ee el en eo es et eu fa ff fi fj fo fr fy ga gd gl gn gu gv ha he hi ho hr
+		ht hu hy hz ia id ie ig ii ik io is it iu ja jv ka kg ki kj kk kl km kn ko kr ks ku kv kw
+		ky la lb lg li ln lo lt lu lv mg mh mi mk ml mn mr ms mt my na nb nd ne ng nl nn no nr nv
+		ny oc oj om or os pa pi pl ps pt qu rm rn ro ru rw sa sc sd se sg si sk sl sm sn so sq sr
+		ss st su sv sw ta te tg th ti tk tl tn to tr ts tt tw ty ug uk ur uz ve vi vo wa wo xh yi
+		yo za zh zu
+		ast ceb chr ckb fil gsw haw kok yue`) {
+		m[code] = true
+	}
+	return m
+}()
+
+// isLocale report whether s is a BCP 47 tag in any case (en, pt-BR, pt_br,
+// zh-Hant-TW) of a known ISO 639 language
+func isLocale(s string) bool {
+	if !localePattern.MatchString(s) {
+		return false
+	}
+	lang := strings.FieldsFunc(s, func(r rune) bool { return r == '-' || r == '_' })[0]
+	return isoLanguages[strings.ToLower(lang)]
+}
+
+// NormalizeLocale canonical form of a locale tag (pt_br -> pt-BR)
+func NormalizeLocale(locale string) string {
+	parts := strings.FieldsFunc(locale, func(r rune) bool { return r == '-' || r == '_' })
+	for i, p := range parts {
+		switch {
+		case i == 0:
+			parts[i] = strings.ToLower(p)
+		case len(p) == 4:
+			parts[i] = strings.ToUpper(p[:1]) + strings.ToLower(p[1:])
+		default:
+			parts[i] = strings.ToUpper(p)
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// LocaleChain fallback chain of a locale, e.g. pt-BR -> pt -> en
+func LocaleChain(locale string, fallback string) []string {
+	var chain []string
+	add := func(l string) {
+		for _, c := range chain {
+			if c == l {
+				return
+			}
+		}
+		chain = append(chain, l)
+	}
+
+	for _, l := range []string{locale, fallback, DefaultLocale} {
+		l = NormalizeLocale(l)
+		for l != "" {
+			add(l)
+			i := strings.LastIndex(l, "-")
+			if i < 0 {
+				break
+			}
+			l = l[:i]
+		}
+	}
+	return chain
+}
+
+// lookupLocaleFormat first known format of the locale chain
+func lookupLocaleFormat(locale string) localeFormat {
+	for _, l := range LocaleChain(locale, DefaultLocale) {
+		if f, ok := localeFormats[l]; ok {
+			return f
+		}
+	}
+	return localeFormats[DefaultLocale]
+}
+
+// FormatNumber format v with the separators of locale
+func FormatNumber(locale string, v interface{}, decimals int) (string, error) {
+	f, err := toFloat(v)
+	if err != nil {
+		return "", err
+	}
+	return formatNumber(lookupLocaleFormat(locale), f, decimals), nil
+}
+
+// FormatCurrency format amount in currency (ISO 4217 code) for locale
+func FormatCurrency(locale string, amount interface{}, currency string) (string, error) {
+	f, err := toFloat(amount)
+	if err != nil {
+		return "", err
+	}
+
+	lf := lookupLocaleFormat(locale)
+	currency = strings.ToUpper(currency)
+
+	decimals, ok := currencyDecimals[currency]
+	if !ok {
+		decimals = 2
+	}
+
+	symbol, ok := lf.symbols[currency]
+	if !ok {
+		symbol, ok = currencySymbols[currency]
+	}
+	if !ok {
+		symbol = currency
+	}
+
+	sign := ""
+	if f < 0 {
+		sign = "-"
+		f = -f
+	}
+	num := formatNumber(lf, f, decimals)
+
+	sep := ""
+	if lf.symbolSpace {
+		sep = "\u00a0" // keep symbol and amount on the same line
+	}
+	if lf.symbolAfter {
+		return sign + num + sep + symbol, nil
+	}
+	return sign + symbol + sep + num, nil
+}
+
+// FormatDate short numeric date for locale (01/02/2006, 02/01/2006)
+func FormatDate(locale string, t time.Time) string {
+	return t.Format(lookupLocaleFormat(locale).dateShort)
+}
+
+// FormatDateLong date with the month name for locale
+func FormatDateLong(locale string, t time.Time) string {
+	lf := lookupLocaleFormat(locale)
+	r := strings.NewReplacer(
+		"{d}", strconv.Itoa(t.Day()),
+		"{month}", lf.months[t.Month()-1],
+		"{yyyy}", strconv.Itoa(t.Year()),
+	)
+	return r.Replace(lf.dateLong)
+}
+
+// formatNumber group the integer part and apply the decimal separator
+func formatNumber(lf localeFormat, f float64, decimals int) string {
+	if decimals < 0 {
+		decimals = 0
+	}
+
+	sign := ""
+	if f < 0 || (f == 0 && math.Signbit(f)) {
+		sign = "-"
+		f = -f
+	}
+
+	s := strconv.FormatFloat(f, 'f', decimals, 64)
+	intPart, fracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+	}
+
+	var b strings.Builder
+	for i, c := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteString(lf.thousands)
+		}
+		b.WriteRune(c)
+	}
+
+	if fracPart != "" {
+		b.WriteString(lf.decimal)
+		b.WriteString(fracPart)
+	}
+
+	// no "-0,00"
+	if strings.Trim(b.String(), "0"+lf.decimal+lf.thousands) == "" {
+		sign = ""
+	}
+	return sign + b.String()
+}
+
+// toFloat number of the basic numeric kinds (and numeric strings)
+func toFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case float32:
+		return float64(n), nil
+	case int:
+		return float64(n), nil
+	case int8:
+		return float64(n), nil
+	case int16:
+		return float64(n), nil
+	case int32:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case uint:
+		return float64(n), nil
+	case uint8:
+		return float64(n), nil
+	case uint16:
+		return float64(n), nil
+	case uint32:
+		return float64(n), nil
+	case uint64:
+		return float64(n), nil
+	case string:
+		return strconv.ParseFloat(n, 64)
+	}
+	return 0, fmt.Errorf("Not a number: %T", v)
+}
@@ -0,0 +1,57 @@
+package mailer_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/thiagozs/mailer-go"
+)
+
+func TestLocaleChain(t *testing.T) {
+	t.Log("LocaleChain(pt_BR)... (NOT expected some err)")
+	got := mailer.LocaleChain("pt_BR", "es")
+	want := []string{"pt-BR", "pt", "es", "en"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LocaleChain got: %v", got)
+	}
+}
+
+func TestLocaleFormatting(t *testing.T) {
+	t.Log("Format numbers, currency and dates... (NOT expected some err)")
+	date := time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		locale, number, currency, date, dateLong string
+	}{
+		{"en-US", "-1,234,567.891", "$1,234,567.89", "10/19/2026", "October 19, 2026"},
+		{"en-GB", "-1,234,567.891", "US$1,234,567.89", "19/10/2026", "19 October 2026"},
+		{"pt-BR", "-1.234.567,891", "US$\u00a01.234.567,89", "19/10/2026", "19 de outubro de 2026"},
+		{"es", "-1.234.567,891", "1.234.567,89\u00a0US$", "19/10/2026", "19 de octubre de 2026"},
+	}
+
+	for _, c := range cases {
+		n, err := mailer.FormatNumber(c.locale, -1234567.891, 3)
+		if err != nil || n != c.number {
+			t.Errorf("FormatNumber(%s) got: %q %v", c.locale, n, err)
+		}
+		m, err := mailer.FormatCurrency(c.locale, 1234567.891, "usd")
+		if err != nil || m != c.currency {
+			t.Errorf("FormatCurrency(%s) got: %q %v", c.locale, m, err)
+		}
+		if d := mailer.FormatDate(c.locale, date); d != c.date {
+			t.Errorf("FormatDate(%s) got: %q", c.locale, d)
+		}
+		if d := mailer.FormatDateLong(c.locale, date); d != c.dateLong {
+			t.Errorf("FormatDateLong(%s) got: %q", c.locale, d)
+		}
+	}
+
+	if m, _ := mailer.FormatCurrency("pt-BR", 1500, "JPY"); m != "¥\u00a01.500" {
+		t.Errorf("FormatCurrency(JPY) got: %q", m)
+	}
+
+	if _, err := mailer.FormatNumber("en", struct{}{}, 2); err == nil {
+		t.Errorf("FormatNumber(struct) got: nil")
+	}
+}
@@ -0,0 +1,373 @@
+package mailer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"gopkg.in/mailgun/mailgun-go.v1"
+)
+
+// MailgunMaxRecipients recipients Mailgun takes in one message
+const MailgunMaxRecipients = mailgun.MaxNumberOfRecipients
+
+// MailgunRecipient recipient of a batch send and its recipient variables,
+// used as %recipient.name% in the content
+type MailgunRecipient struct {
+	Email     string
+	Variables map[string]interface{}
+}
+
+// mailgunUnsubscribeVariable recipient variable of the List-Unsubscribe
+// header of a batch message
+const mailgunUnsubscribeVariable = "list_unsubscribe"
+
+// MailgunBatchResult outcome of one Mailgun message of a batch send
+type MailgunBatchResult struct {
+	Emails    []string
+	MessageID string
+	Err       error
+}
+
+// SendBatch send the message of ConfigEmail (EmailTo aside) to every
+// recipient, in messages of up to 1000 recipients. Recipient variables are
+// always sent, so each recipient gets an individual copy and never sees the
+// others. There is one result per message, and a last one with an Err
+// wrapping ErrSuppressed for the suppressed recipients left out; the error
+// reports failed messages after all of them were tried
+func (cfg *SDKConfigMailGun) SendBatch(recipients []MailgunRecipient) ([]MailgunBatchResult, error) {
+	if (len(cfg.ConfigEmail.ContentPlainText) == 0 &&
+		len(cfg.ConfigEmail.ContentHTML) == 0 &&
+		len(cfg.ConfigEmail.ContentMarkdown) == 0) ||
+		len(cfg.ConfigEmail.EmailFrom) == 0 ||
+		len(cfg.ConfigEmail.Subject) == 0 {
+		return nil, fmt.Errorf("Empty fields on ConfigEmail")
+	}
+
+	// suppressed recipients are left out
+	var suppressed []string
+	var send []MailgunRecipient
+	for _, r := range recipients {
+		if r.Email == "" {
+			return nil, fmt.Errorf("Empty Email in batch recipient")
+		}
+		if _, ok := cfg.Suppressions.Check(r.Email); ok {
+			suppressed = append(suppressed, r.Email)
+			continue
+		}
+		send = append(send, r)
+	}
+	recipients = send
+
+	sdk := cfg.newSDKMailGun()
+
+	var results []MailgunBatchResult
+	failed := 0
+	for start := 0; start < len(recipients); start += MailgunMaxRecipients {
+		end := start + MailgunMaxRecipients
+		if end > len(recipients) {
+			end = len(recipients)
+		}
+
+		if cfg.Delay > 0 {
+			time.Sleep(cfg.Delay)
+		}
+
+		result := MailgunBatchResult{}
+		msg, err := cfg.mailgunMessage(sdk.Mailgun)
+		if err != nil {
+			return results, err
+		}
+		for _, r := range recipients[start:end] {
+			vars := map[string]interface{}{}
+			for k, v := range r.Variables {
+				vars[k] = v
+			}
+			if cfg.Unsubscribe != nil {
+				unsubscribe, _ := cfg.Unsubscribe.Headers(r.Email)
+				vars[mailgunUnsubscribeVariable] = unsubscribe["List-Unsubscribe"]
+			}
+			if err := msg.AddRecipientAndVariables(r.Email, vars); err != nil {
+				return results, err
+			}
+			result.Emails = append(result.Emails, r.Email)
+		}
+
+		_, result.MessageID, result.Err = sdk.Mailgun.Send(msg)
+		if result.Err != nil {
+			failed++
+		}
+		results = append(results, result)
+	}
+
+	batches := len(results)
+	if len(suppressed) > 0 {
+		results = append(results, MailgunBatchResult{
+			Emails: suppressed,
+			Err:    fmt.Errorf("%w: %d recipients left out", ErrSuppressed, len(suppressed)),
+		})
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%d of %d Mailgun batches failed", failed, batches)
+	}
+	return results, nil
+}
+
+// mailgunMessage message of ConfigEmail to the given recipients, with its
+// tags, variables, headers, List-Unsubscribe headers, schedule and
+// tracking options
+func (cfg *SDKConfigMailGun) mailgunMessage(mg mailgun.Mailgun, to ...string) (*mailgun.Message, error) {
+	html, text := renderContent(cfg.ConfigEmail.ContentMarkdown,
+		cfg.ConfigEmail.ContentHTML,
+		cfg.ConfigEmail.ContentPlainText,
+		cfg.ConfigEmail.InlineCSS,
+	)
+
+	msg := mg.NewMessage(
+		cfg.ConfigEmail.EmailFrom,
+		cfg.ConfigEmail.Subject,
+		text,
+		to...,
+	)
+	if html != "" {
+		msg.SetHtml(html)
+	}
+
+	for _, tag := range cfg.ConfigEmail.Tags {
+		msg.AddTag(tag)
+	}
+	for name, value := range cfg.ConfigEmail.Variables {
+		if err := msg.AddVariable(name, value); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.Unsubscribe.check(); err != nil {
+		return nil, err
+	}
+	if cfg.Unsubscribe != nil && len(to) == 1 {
+		unsubscribe, _ := cfg.Unsubscribe.Headers(to[0])
+		for name, value := range unsubscribe {
+			msg.AddHeader(name, value)
+		}
+	} else if cfg.Unsubscribe != nil {
+		// Mailgun fills in the header of each recipient of a batch
+		msg.AddHeader("List-Unsubscribe", "%recipient."+mailgunUnsubscribeVariable+"%")
+		if cfg.Unsubscribe.URL != "" {
+			msg.AddHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+		}
+	}
+	for name, value := range cfg.ConfigEmail.Headers {
+		msg.AddHeader(name, value)
+	}
+	if !cfg.ConfigEmail.DeliveryTime.IsZero() {
+		msg.SetDeliveryTime(cfg.ConfigEmail.DeliveryTime)
+	}
+	if cfg.ConfigEmail.Tracking != nil {
+		msg.SetTracking(*cfg.ConfigEmail.Tracking)
+	}
+	if cfg.ConfigEmail.TrackingClicks != nil {
+		msg.SetTrackingClicks(*cfg.ConfigEmail.TrackingClicks)
+	}
+	if cfg.ConfigEmail.TrackingOpens != nil {
+		msg.SetTrackingOpens(*cfg.ConfigEmail.TrackingOpens)
+	}
+	if cfg.ConfigEmail.TestMode {
+		msg.EnableTestMode()
+	}
+	return msg, nil
+}
+
+// MailgunEventPoller reads the Mailgun Events API on an interval and passes
+// the events to Handle, keeping the last one read in the Checkpoint file
+type MailgunEventPoller struct {
+	Mailgun    mailgun.Mailgun
+	Checkpoint string        // file of the last event read
+	Interval   time.Duration // between polls, 15s when zero
+	// Lag leaves events younger than it to a later poll, as Mailgun may
+	// still store events older than the ones it lists; MailgunEventLag when
+	// zero
+	Lag    time.Duration
+	Handle EventHandler
+	// OnError is told of the poll errors Run retries (API, network,
+	// checkpoint file); they are logged when nil
+	OnError func(error)
+}
+
+// MailgunEventLag default Lag of the poller, the window Mailgun advises
+// for events to be stored
+const MailgunEventLag = 30 * time.Minute
+
+// mailgunPollMaxBackoff longest wait of Run after failed polls
+const mailgunPollMaxBackoff = 5 * time.Minute
+
+// mailgunHandleError error of Handle, ending Run
+type mailgunHandleError struct{ err error }
+
+// Error message of the Handle error
+func (e *mailgunHandleError) Error() string { return e.err.Error() }
+
+// Unwrap error of Handle
+func (e *mailgunHandleError) Unwrap() error { return e.err }
+
+// mailgunCheckpoint last events read, the ones at Timestamp by id
+type mailgunCheckpoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	IDs       []string  `json:"ids"`
+}
+
+// NewEventPoller return a poller of the events of the domain keeping its
+// checkpoint in the file
+func (cfg *SDKConfigMailGun) NewEventPoller(checkpoint string, handle EventHandler) *MailgunEventPoller {
+	return &MailgunEventPoller{
+		Mailgun:    cfg.newSDKMailGun().Mailgun,
+		Checkpoint: checkpoint,
+		Handle:     handle,
+	}
+}
+
+// Run poll until ctx is done or Handle fails. Other poll errors are passed
+// to OnError and the poll retried, waiting twice as long after each failure
+func (p *MailgunEventPoller) Run(ctx context.Context) error {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+
+	wait := interval
+	for {
+		err := p.Poll()
+		var he *mailgunHandleError
+		switch {
+		case errors.As(err, &he):
+			return he.err
+		case err != nil:
+			if p.OnError != nil {
+				p.OnError(err)
+			} else {
+				log.Printf("Mailgun event poll failed, retrying in %s: %s", wait, err)
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		switch {
+		case err == nil:
+			wait = interval
+		case wait < mailgunPollMaxBackoff:
+			wait *= 2
+			if wait > mailgunPollMaxBackoff {
+				wait = mailgunPollMaxBackoff
+			}
+		}
+	}
+}
+
+// Poll pass the events since the checkpoint and older than Lag to Handle,
+// once each. Without a checkpoint it starts Lag ago. The checkpoint is saved
+// up to the last event handled, so a failed one is passed again on the next
+// poll
+func (p *MailgunEventPoller) Poll() error {
+	cp, err := p.loadCheckpoint()
+	if err != nil {
+		return err
+	}
+	lag := p.Lag
+	if lag <= 0 {
+		lag = MailgunEventLag
+	}
+	end := time.Now().UTC().Add(-lag)
+	if cp.Timestamp.IsZero() {
+		cp.Timestamp = end
+	}
+	if !end.After(cp.Timestamp) {
+		return p.saveCheckpoint(cp)
+	}
+
+	opts := &mailgun.EventsOptions{
+		Begin:          cp.Timestamp,
+		End:            end,
+		ForceAscending: true,
+		Limit:          300,
+	}
+
+	it := p.Mailgun.ListEvents(opts)
+	var page []mailgun.Event
+	for it.Next(&page) {
+		for _, item := range page {
+			ts, err := item.ParseTimeStamp()
+			if err != nil {
+				p.saveCheckpoint(cp)
+				return err
+			}
+			id, _ := item["id"].(string)
+			// begin has seconds precision, events at the checkpoint come again
+			if ts.Before(cp.Timestamp) || (ts.Equal(cp.Timestamp) && containsString(cp.IDs, id)) {
+				continue
+			}
+
+			data, err := json.Marshal(item)
+			if err != nil {
+				p.saveCheckpoint(cp)
+				return err
+			}
+			event, ok, err := mailgunEvent(data)
+			if err != nil {
+				p.saveCheckpoint(cp)
+				return err
+			}
+			if ok && p.Handle != nil {
+				if err := p.Handle(event); err != nil {
+					p.saveCheckpoint(cp)
+					return &mailgunHandleError{err}
+				}
+			}
+
+			if ts.Equal(cp.Timestamp) {
+				cp.IDs = append(cp.IDs, id)
+			} else {
+				cp.Timestamp, cp.IDs = ts, []string{id}
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		p.saveCheckpoint(cp)
+		return err
+	}
+	return p.saveCheckpoint(cp)
+}
+
+// loadCheckpoint read the checkpoint file, empty when there is none
+func (p *MailgunEventPoller) loadCheckpoint() (mailgunCheckpoint, error) {
+	var cp mailgunCheckpoint
+	data, err := ioutil.ReadFile(p.Checkpoint)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return cp, err
+	}
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return cp, fmt.Errorf("Invalid Mailgun checkpoint %s: %s", p.Checkpoint, err)
+	}
+	return cp, nil
+}
+
+// saveCheckpoint write the checkpoint file
+func (p *MailgunEventPoller) saveCheckpoint(cp mailgunCheckpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p.Checkpoint, data)
+}
@@ -0,0 +1,443 @@
+package mailer_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thiagozs/mailer-go"
+)
+
+// mailgunStub local messages endpoint keeping the posted forms; a message
+// with a "fail" recipient is rejected
+func mailgunStub(t *testing.T) (*httptest.Server, *[]url.Values) {
+	var forms []url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/example.com/messages" {
+			t.Errorf("Mailgun stub got: %s", r.URL.Path)
+		}
+		// parts are read one by one, as batches go past the part limit of
+		// ParseMultipartForm
+		form := url.Values{}
+		if mr, err := r.MultipartReader(); err == nil {
+			for {
+				part, err := mr.NextPart()
+				if err != nil {
+					break
+				}
+				value, _ := ioutil.ReadAll(part)
+				form.Add(part.FormName(), string(value))
+			}
+		} else if err := r.ParseForm(); err != nil {
+			t.Errorf("Mailgun stub got: %s", err)
+		} else {
+			form = r.PostForm
+		}
+		forms = append(forms, form)
+		for _, to := range form["to"] {
+			if to == "fail@host.com" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"message":"rejected"}`)
+				return
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"id":      fmt.Sprintf("<%d.stub@example.com>", len(forms)),
+			"message": "Queued. Thank you.",
+		})
+	}))
+	return srv, &forms
+}
+
+func TestMailgunMessageOptions(t *testing.T) {
+	t.Log("SendMail(MailGun) tags, variables, headers, schedule and tracking... (NOT expected some err)")
+	srv, forms := mailgunStub(t)
+	defer srv.Close()
+
+	no, yes := false, true
+	deliver := time.Date(2026, 10, 20, 9, 0, 0, 0, time.UTC)
+	mg := mailer.NewMailerMailGun("example.com", "key", "")
+	mg.APIBase = srv.URL
+	mg.ConfigEmail = mailer.ConfigEmailMailGun{
+		ContentPlainText: "test",
+		ContentHTML:      "<b>test</b>",
+		EmailFrom:        "sender@example.com",
+		EmailTo:          "client@host.com",
+		Subject:          "Test",
+		Tags:             []string{"welcome", "2026"},
+		Variables:        map[string]interface{}{"user": map[string]int{"id": 42}},
+		Headers:          map[string]string{"X-Campaign": "launch"},
+		DeliveryTime:     deliver,
+		Tracking:         &yes,
+		TrackingClicks:   &no,
+		TestMode:         true,
+	}
+
+	if err := mg.SendMail(); err != nil {
+		t.Fatalf("SendMail got: %s", err)
+	}
+	if mg.MessageID != "<1.stub@example.com>" {
+		t.Errorf("MessageID got: %q", mg.MessageID)
+	}
+
+	form := (*forms)[0]
+	for k, v := range map[string]string{
+		"to":                "client@host.com",
+		"html":              "<b>test</b>",
+		"v:user":            `{"id":42}`,
+		"h:X-Campaign":      "launch",
+		"o:deliverytime":    "Tue, 20 Oct 2026 09:00:00 +0000",
+		"o:tracking":        "yes",
+		"o:tracking-clicks": "no",
+		"o:testmode":        "yes",
+	} {
+		if form.Get(k) != v {
+			t.Errorf("SendMail %s got: %q", k, form.Get(k))
+		}
+	}
+	if tags := form["o:tag"]; len(tags) != 2 || tags[0] != "welcome" || tags[1] != "2026" {
+		t.Errorf("SendMail o:tag got: %v", tags)
+	}
+	if _, ok := form["o:tracking-opens"]; ok {
+		t.Errorf("SendMail got o:tracking-opens without TrackingOpens")
+	}
+}
+
+func TestMailgunSendError(t *testing.T) {
+	t.Log("SendMail(MailGun) rejected by the API... (expected some err)")
+	srv, _ := mailgunStub(t)
+	defer srv.Close()
+
+	mg := mailer.NewMailerMailGun("example.com", "key", "")
+	mg.APIBase = srv.URL
+	mg.ConfigEmail = mailer.ConfigEmailMailGun{
+		ContentPlainText: "test",
+		EmailFrom:        "sender@example.com",
+		EmailTo:          "fail@host.com",
+		Subject:          "Test",
+	}
+
+	if err := mg.SendMail(); err == nil || mg.MessageID != "" {
+		t.Errorf("SendMail got: %v %q", err, mg.MessageID)
+	}
+}
+
+func TestMailgunSendBatch(t *testing.T) {
+	t.Log("SendBatch(MailGun) in messages of 1000 recipients... (NOT expected some err)")
+	srv, forms := mailgunStub(t)
+	defer srv.Close()
+
+	mg := mailer.NewMailerMailGun("example.com", "key", "")
+	mg.APIBase = srv.URL
+	mg.ConfigEmail = mailer.ConfigEmailMailGun{
+		ContentPlainText: "Hi %recipient.name%",
+		EmailFrom:        "sender@example.com",
+		Subject:          "Newsletter",
+		Tags:             []string{"newsletter"},
+	}
+
+	var recipients []mailer.MailgunRecipient
+	for i := 0; i < 2500; i++ {
+		recipients = append(recipients, mailer.MailgunRecipient{
+			Email:     fmt.Sprintf("user%d@host.com", i),
+			Variables: map[string]interface{}{"name": fmt.Sprintf("user %d", i)},
+		})
+	}
+	recipients[2499].Variables = nil
+	recipients[1500].Email = "fail@host.com"
+
+	results, err := mg.SendBatch(recipients)
+	if err == nil {
+		t.Errorf("SendBatch expected an error for the rejected message")
+	}
+	if len(results) != 3 || len(*forms) != 3 {
+		t.Fatalf("SendBatch got: %d results, %d calls", len(results), len(*forms))
+	}
+	for i, want := range []int{1000, 1000, 500} {
+		form := (*forms)[i]
+		if len(form["to"]) != want || len(results[i].Emails) != want || form.Get("o:tag") != "newsletter" {
+			t.Errorf("SendBatch message %d got: %d recipients", i, len(form["to"]))
+		}
+		vars := map[string]map[string]interface{}{}
+		if err := json.Unmarshal([]byte(form.Get("recipient-variables")), &vars); err != nil || len(vars) != want {
+			t.Errorf("SendBatch message %d recipient-variables got: %d %v", i, len(vars), err)
+		}
+	}
+
+	if results[0].MessageID != "<1.stub@example.com>" || results[0].Err != nil {
+		t.Errorf("SendBatch result got: %+v", results[0])
+	}
+	if results[1].Err == nil || results[1].MessageID != "" {
+		t.Errorf("SendBatch failed result got: %+v", results[1])
+	}
+	if results[2].MessageID != "<3.stub@example.com>" || results[2].Emails[499] != "user2499@host.com" {
+		t.Errorf("SendBatch result got: %s %s", results[2].MessageID, results[2].Emails[499])
+	}
+	if !strings.Contains((*forms)[2].Get("recipient-variables"), `"user2499@host.com":{}`) {
+		t.Errorf("SendBatch got no variables for a recipient without them")
+	}
+}
+
+// mailgunEventsStub local events endpoint listing events from begin to end,
+// in pages of limit; the returned pointer takes more events
+func mailgunEventsStub(t *testing.T) (*httptest.Server, *[]map[string]interface{}) {
+	events := []map[string]interface{}{}
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/example.com/events" {
+			t.Errorf("Mailgun events stub got: %s", r.URL.Path)
+		}
+		q := r.URL.Query()
+		if q.Get("ascending") != "yes" {
+			t.Errorf("Mailgun events stub got: ascending=%s", q.Get("ascending"))
+		}
+		layout := "Mon, 2 Jan 2006 15:04:05 -0700"
+		begin, err := time.Parse(layout, q.Get("begin"))
+		if err != nil {
+			t.Errorf("Mailgun events stub got: begin=%s", q.Get("begin"))
+		}
+		end := time.Now().Add(time.Hour)
+		if q.Get("end") != "" {
+			end, _ = time.Parse(layout, q.Get("end"))
+		}
+
+		var matched []map[string]interface{}
+		for _, e := range events {
+			sec := int64(e["timestamp"].(float64))
+			if sec >= begin.Unix() && sec <= end.Unix() {
+				matched = append(matched, e)
+			}
+		}
+		page, _ := strconv.Atoi(q.Get("page"))
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		items := []map[string]interface{}{}
+		for i := page * limit; i < len(matched) && i < (page+1)*limit; i++ {
+			items = append(items, matched[i])
+		}
+		q.Set("page", strconv.Itoa(page+1))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"items":  items,
+			"paging": map[string]string{"next": srv.URL + r.URL.Path + "?" + q.Encode()},
+		})
+	}))
+	return srv, &events
+}
+
+// mailgunEventItem Events API item of a recipient at ts
+func mailgunEventItem(id, event, recipient string, ts float64) map[string]interface{} {
+	return map[string]interface{}{
+		"id":        id,
+		"event":     event,
+		"recipient": recipient,
+		"timestamp": ts,
+		"message":   map[string]interface{}{"headers": map[string]string{"message-id": id + "@example.com"}},
+	}
+}
+
+func TestMailgunEventPoller(t *testing.T) {
+	t.Log("Poll Mailgun events with a checkpoint... (NOT expected some err)")
+	srv, items := mailgunEventsStub(t)
+	defer srv.Close()
+
+	base := float64(time.Now().Add(-time.Hour).Unix())
+	for i := 0; i < 350; i++ {
+		*items = append(*items, mailgunEventItem(fmt.Sprintf("d%d", i), "delivered", "a@host.com", base+float64(i/100)+0.25))
+	}
+	*items = append(*items, mailgunEventItem("s1", "accepted", "a@host.com", base+10))
+
+	var events []mailer.Event
+	mg := mailer.NewMailerMailGun("example.com", "key", "")
+	mg.APIBase = srv.URL
+	checkpoint := filepath.Join(t.TempDir(), "mailgun.json")
+	poller := mg.NewEventPoller(checkpoint, func(e mailer.Event) error {
+		events = append(events, e)
+		return nil
+	})
+
+	// a first checkpoint starting before the events
+	start := fmt.Sprintf(`{"timestamp":%q}`, time.Unix(int64(base), 0).UTC().Format(time.RFC3339))
+	if err := os.WriteFile(checkpoint, []byte(start), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := poller.Poll(); err != nil {
+		t.Fatalf("Poll got: %s", err)
+	}
+	if len(events) != 350 || events[0].Type != mailer.EventDelivered || events[0].MessageID != "<d0@example.com>" ||
+		events[349].MessageID != "<d349@example.com>" || events[0].Provider != "mailgun" {
+		t.Fatalf("Poll got: %d events", len(events))
+	}
+
+	// events at the checkpoint second come again and are skipped
+	events = nil
+	*items = append(*items,
+		mailgunEventItem("late", "failed", "b@host.com", base+10),
+		mailgunEventItem("o1", "opened", "a@host.com", base+11.5))
+	(*items)[len(*items)-2]["severity"] = "permanent"
+	if err := poller.Poll(); err != nil {
+		t.Fatalf("Poll got: %s", err)
+	}
+	if len(events) != 2 || events[0].Type != mailer.EventBounced || events[0].Email != "b@host.com" ||
+		events[1].Type != mailer.EventOpened {
+		t.Errorf("Poll got: %+v", events)
+	}
+
+	// a new poller goes on from the file
+	events = nil
+	again := mg.NewEventPoller(checkpoint, poller.Handle)
+	if err := again.Poll(); err != nil || len(events) != 0 {
+		t.Errorf("Poll from the checkpoint got: %d events, %v", len(events), err)
+	}
+
+	again.Interval = 10 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := again.Run(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Run got: %v", err)
+	}
+}
+
+func TestMailgunEventPollerRetry(t *testing.T) {
+	t.Log("Poll Mailgun events with a failing handler... (expected some err)")
+	srv, items := mailgunEventsStub(t)
+	defer srv.Close()
+
+	base := float64(time.Now().Add(-time.Hour).Unix())
+	for i := 0; i < 5; i++ {
+		*items = append(*items, mailgunEventItem(fmt.Sprintf("d%d", i), "delivered", "a@host.com", base+float64(i)))
+	}
+
+	var seen []string
+	fail := "<d2@example.com>"
+	mg := mailer.NewMailerMailGun("example.com", "key", "")
+	mg.APIBase = srv.URL
+	checkpoint := filepath.Join(t.TempDir(), "mailgun.json")
+	start := fmt.Sprintf(`{"timestamp":%q}`, time.Unix(int64(base), 0).UTC().Format(time.RFC3339))
+	if err := os.WriteFile(checkpoint, []byte(start), 0600); err != nil {
+		t.Fatal(err)
+	}
+	poller := mg.NewEventPoller(checkpoint, func(e mailer.Event) error {
+		if e.MessageID == fail {
+			return fmt.Errorf("store down")
+		}
+		seen = append(seen, e.MessageID)
+		return nil
+	})
+
+	if err := poller.Poll(); err == nil {
+		t.Errorf("Poll expected the handler error")
+	}
+	fail = ""
+	if err := poller.Poll(); err != nil {
+		t.Fatalf("Poll got: %s", err)
+	}
+	if strings.Join(seen, " ") != "<d0@example.com> <d1@example.com> <d2@example.com> <d3@example.com> <d4@example.com>" {
+		t.Errorf("Poll got: %v", seen)
+	}
+
+	if err := os.WriteFile(checkpoint, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := poller.Poll(); err == nil {
+		t.Errorf("Poll expected an error for a broken checkpoint")
+	}
+}
+
+func TestMailgunEventPollerLag(t *testing.T) {
+	t.Log("Poll Mailgun events stored late... (NOT expected some err)")
+	srv, items := mailgunEventsStub(t)
+	defer srv.Close()
+
+	now := time.Now()
+	*items = append(*items,
+		mailgunEventItem("old", "delivered", "a@host.com", float64(now.Add(-40*time.Minute).Unix())),
+		mailgunEventItem("recent", "delivered", "a@host.com", float64(now.Add(-time.Minute).Unix())))
+
+	var seen []string
+	mg := mailer.NewMailerMailGun("example.com", "key", "")
+	mg.APIBase = srv.URL
+	checkpoint := filepath.Join(t.TempDir(), "mailgun.json")
+	start := fmt.Sprintf(`{"timestamp":%q}`, now.Add(-time.Hour).UTC().Format(time.RFC3339))
+	if err := os.WriteFile(checkpoint, []byte(start), 0600); err != nil {
+		t.Fatal(err)
+	}
+	poller := mg.NewEventPoller(checkpoint, func(e mailer.Event) error {
+		seen = append(seen, e.MessageID)
+		return nil
+	})
+	if err := poller.Poll(); err != nil {
+		t.Fatalf("Poll got: %s", err)
+	}
+
+	// stored after the poll, older than the newest event listed
+	*items = append(*items, mailgunEventItem("late", "delivered", "a@host.com", float64(now.Add(-35*time.Minute).Unix())))
+	if err := poller.Poll(); err != nil {
+		t.Fatalf("Poll got: %s", err)
+	}
+	if strings.Join(seen, " ") != "<old@example.com> <late@example.com>" {
+		t.Errorf("Poll got: %v", seen)
+	}
+}
+
+func TestMailgunEventPollerRunRetry(t *testing.T) {
+	t.Log("Run through a failing Mailgun API... (NOT expected some err)")
+	stub, items := mailgunEventsStub(t)
+	defer stub.Close()
+	*items = append(*items, mailgunEventItem("d1", "delivered", "a@host.com", float64(time.Now().Add(-time.Hour).Unix())))
+
+	var mu sync.Mutex
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		first := requests == 1
+		mu.Unlock()
+		if first {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		stub.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	mg := mailer.NewMailerMailGun("example.com", "key", "")
+	mg.APIBase = srv.URL
+	checkpoint := filepath.Join(t.TempDir(), "mailgun.json")
+	start := fmt.Sprintf(`{"timestamp":%q}`, time.Now().Add(-2*time.Hour).UTC().Format(time.RFC3339))
+	if err := os.WriteFile(checkpoint, []byte(start), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var errs []error
+	poller := mg.NewEventPoller(checkpoint, func(e mailer.Event) error {
+		cancel()
+		return nil
+	})
+	poller.Interval = 10 * time.Millisecond
+	poller.OnError = func(err error) { errs = append(errs, err) }
+	if err := poller.Run(ctx); err != context.Canceled {
+		t.Errorf("Run got: %v", err)
+	}
+	if len(errs) != 1 {
+		t.Errorf("Run errors got: %v", errs)
+	}
+
+	// a Handle error ends Run
+	os.WriteFile(checkpoint, []byte(start), 0600)
+	poller.Handle = func(e mailer.Event) error { return fmt.Errorf("store down") }
+	if err := poller.Run(context.Background()); err == nil || err.Error() != "store down" {
+		t.Errorf("Run got: %v", err)
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net/smtp"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -21,8 +24,12 @@ import (
 // SDKConfigSengrid cfg SDKs
 type SDKConfigSengrid struct {
 	SendGridAPIKey string
+	Host           string // API host, https://api.sendgrid.com when empty
 	SDKName        string
 	Delay          time.Duration
+	Suppressions   *SuppressionList    // recipients refused by the sends
+	Unsubscribe    *UnsubscribeOptions // List-Unsubscribe headers of the sends
+	MessageID      string              // X-Message-Id of the last SendMail
 	ConfigEmail    ConfigEmailSendgrid
 }
 
@@ -31,39 +38,77 @@ type SDKConfigMailGun struct {
 	MailGunDomain string
 	MailGunAPIKey string
 	MailGunPUBKey string
+	APIBase       string // API base URL, https://api.mailgun.net/v3 when empty
 	SDKName       string
 	Delay         time.Duration
+	Suppressions  *SuppressionList    // recipients refused by the sends
+	Unsubscribe   *UnsubscribeOptions // List-Unsubscribe headers of the sends
+	MessageID     string              // Mailgun message ID of the last SendMail
 	ConfigEmail   ConfigEmailMailGun
 }
 
 // SDKConfigGmail cfg SDKs
 type SDKConfigGmail struct {
-	User        string
-	Password    string
-	SDKName     string
-	Delay       time.Duration
-	ConfigEmail ConfigEmailGmail
+	User         string
+	Password     string
+	SDKName      string
+	Delay        time.Duration
+	Suppressions *SuppressionList    // recipients refused by the sends
+	Unsubscribe  *UnsubscribeOptions // List-Unsubscribe headers of the sends
+	Tracking     *TrackingOptions    // open and click tracking of the HTML content
+	DKIM         *DKIMOptions
+	SMIME        *SMIMEOptions
+	PGP          *PGPOptions
+	MessageID    string // Message-ID of the last SendMail
+	ConfigEmail  ConfigEmailGmail
 }
 
 // SDKConfigAWSSES cfg SDKs
 type SDKConfigAWSSES struct {
-	SecretKey   string
-	AccessKey   string
-	Region      string
-	SDKName     string
-	Delay       time.Duration
-	ConfigEmail ConfigEmailAWSSES
+	SecretKey    string
+	AccessKey    string
+	Profile      string // shared config profile, used when AccessKey is empty
+	RoleARN      string // role assumed with the credentials above
+	Region       string
+	Endpoint     string // custom SES endpoint, e.g. a local emulator
+	SDKName      string
+	Delay        time.Duration
+	Suppressions *SuppressionList    // recipients refused by the sends
+	Unsubscribe  *UnsubscribeOptions // List-Unsubscribe headers of SendMail, then sent raw
+	DKIM         *DKIMOptions
+	SMIME        *SMIMEOptions
+	PGP          *PGPOptions
+	MessageID    string // SES message ID of the last SendMail
+	ConfigEmail  ConfigEmailAWSSES
+
+	mu        sync.Mutex
+	client    *ses.SES
+	clientKey sesClientKey
+}
+
+// sesClientKey fields of SDKConfigAWSSES the cached client was built from
+type sesClientKey struct {
+	accessKey, secretKey, profile, roleARN, region, endpoint string
 }
 
 // SDKConfigSMTPSSL cfg SDKs
 type SDKConfigSMTPSSL struct {
-	User        string
-	Password    string
-	Server      string
-	Port        string
-	SDKName     string
-	Delay       time.Duration
-	ConfigEmail ConfigEmailSMTPSSL
+	User         string
+	Password     string
+	Server       string
+	Port         string
+	SDKName      string
+	Delay        time.Duration
+	Suppressions *SuppressionList    // recipients refused by the sends
+	Unsubscribe  *UnsubscribeOptions // List-Unsubscribe headers of the sends
+	ReturnPath   string              // envelope sender (MAIL FROM), User when empty
+	VERP         *VERPOptions        // per-recipient envelope sender, over ReturnPath
+	Tracking     *TrackingOptions    // open and click tracking of the HTML content
+	DKIM         *DKIMOptions
+	SMIME        *SMIMEOptions
+	PGP          *PGPOptions
+	MessageID    string // Message-ID of the last SendMail
+	ConfigEmail  ConfigEmailSMTPSSL
 }
 
 // SDK wrapper of APIs
@@ -97,32 +142,67 @@ type ConfigEmailSendgrid struct {
 	EmailFromName    string
 	ContentHTML      string
 	ContentPlainText string
+	ContentMarkdown  string
+	InlineCSS        bool
 	Subject          string
+
+	TemplateID         string                 // dynamic template, replaces the content
+	TemplateData       map[string]interface{} // dynamic template data
+	Categories         []string
+	CustomArgs         map[string]string
+	ASMGroupID         int   // unsubscribe group
+	ASMGroupsToDisplay []int // unsubscribe groups shown on the preferences page
+	IPPool             string
+	SendAt             time.Time // scheduled delivery, now when zero
 }
 
 // ConfigEmailMailGun configuration of send
 type ConfigEmailMailGun struct {
 	EmailTo          string
 	EmailFrom        string
+	ContentHTML      string
 	ContentPlainText string
+	ContentMarkdown  string
+	InlineCSS        bool
 	Subject          string
+
+	Tags           []string
+	Variables      map[string]interface{} // v: custom data, sent back in events
+	Headers        map[string]string
+	DeliveryTime   time.Time // scheduled delivery, now when zero
+	Tracking       *bool     // account settings when nil
+	TrackingClicks *bool
+	TrackingOpens  *bool
+	TestMode       bool // accepted by Mailgun but not delivered
 }
 
 // ConfigEmailGmail configuration of send
 type ConfigEmailGmail struct {
-	EmailTo     string
-	EmailFrom   string
-	ContentHTML string
-	Subject     string
+	EmailTo          string
+	EmailFrom        string
+	ContentHTML      string
+	ContentPlainText string
+	ContentMarkdown  string
+	InlineCSS        bool
+	Subject          string
+	NoTracking       bool // send without the Tracking of the sender
 }
 
 // ConfigEmailAWSSES configuration of send.
 type ConfigEmailAWSSES struct {
-	EmailFrom        string
-	EmailTo          string
-	Subject          string
-	ContentPlainText string
-	ContentHTML      string
+	EmailFrom            string
+	EmailTo              string
+	Subject              string
+	ContentPlainText     string
+	ContentHTML          string
+	ContentMarkdown      string
+	InlineCSS            bool
+	ReplyTo              []string
+	ReturnPath           string // bounces and complaints address
+	ConfigurationSetName string
+	MessageTags          map[string]string
+	Headers              map[string]string // custom headers, sent raw; structural headers are refused
+	Attachments          []Attachment      // sent raw
 }
 
 // ConfigEmailSMTPSSL configuration of send.
@@ -132,23 +212,33 @@ type ConfigEmailSMTPSSL struct {
 	Subject          string
 	ContentPlainText string
 	ContentHTML      string
+	ContentMarkdown  string
+	InlineCSS        bool
+	MessageKey       string // encoded in the VERP envelope sender, e.g. a campaign id
+	NoTracking       bool   // send without the Tracking of the sender
 }
 
 // newSDKSendgrid get a SDKs
 func (cfg SDKConfigSengrid) newSDKSendgrid() *SDK {
+	request := sendgrid.GetRequest(cfg.SendGridAPIKey, "/v3/mail/send", cfg.Host)
+	request.Method = "POST"
 	return &SDK{
-		Sendgrid: sendgrid.NewSendClient(cfg.SendGridAPIKey),
+		Sendgrid: &sendgrid.Client{Request: request},
 	}
 }
 
 // newSDKMailGun get a SDKs
 func (cfg SDKConfigMailGun) newSDKMailGun() *SDK {
+	mg := mailgun.NewMailgun(
+		cfg.MailGunDomain,
+		cfg.MailGunAPIKey,
+		cfg.MailGunPUBKey,
+	)
+	if cfg.APIBase != "" {
+		mg.SetAPIBase(cfg.APIBase)
+	}
 	return &SDK{
-		Mailgun: mailgun.NewMailgun(
-			cfg.MailGunDomain,
-			cfg.MailGunAPIKey,
-			cfg.MailGunPUBKey,
-		),
+		Mailgun: mg,
 	}
 }
 
@@ -163,11 +253,54 @@ func (cfg SDKConfigGmail) newSDKGmail() *SDK {
 }
 
 // newSDKAWSSES get a SDKs
-func (cfg SDKConfigAWSSES) newSDKAWSSES() *SDK {
-	cred := credentials.NewStaticCredentials(cfg.AccessKey, cfg.SecretKey, "")
+func (cfg *SDKConfigAWSSES) newSDKAWSSES() (*SDK, error) {
+	client, err := cfg.Client()
+	if err != nil {
+		return nil, err
+	}
 	return &SDK{
-		AWSSES: ses.New(session.New(aws.NewConfig().WithRegion(cfg.Region).WithCredentials(cred))),
+		AWSSES: client,
+	}, nil
+}
+
+// Client SES client of the sender, created on first use and reused while
+// the credentials, Profile, RoleARN, Region and Endpoint stay the same:
+// static keys when AccessKey is set, otherwise the default AWS credential
+// chain (environment, shared config Profile, EC2/ECS role); RoleARN is
+// assumed on top when set
+func (cfg *SDKConfigAWSSES) Client() (*ses.SES, error) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	key := sesClientKey{cfg.AccessKey, cfg.SecretKey, cfg.Profile, cfg.RoleARN, cfg.Region, cfg.Endpoint}
+	if cfg.client != nil && cfg.clientKey == key {
+		return cfg.client, nil
+	}
+
+	config := aws.NewConfig()
+	if cfg.Region != "" {
+		config = config.WithRegion(cfg.Region)
+	}
+	if cfg.Endpoint != "" {
+		config = config.WithEndpoint(cfg.Endpoint)
+	}
+	if cfg.AccessKey != "" {
+		config = config.WithCredentials(credentials.NewStaticCredentials(cfg.AccessKey, cfg.SecretKey, ""))
 	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *config,
+		Profile:           cfg.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if cfg.RoleARN != "" {
+		sess = sess.Copy(aws.NewConfig().WithCredentials(stscreds.NewCredentials(sess, cfg.RoleARN)))
+	}
+
+	cfg.client, cfg.clientKey = ses.New(sess), key
+	return cfg.client, nil
 }
 
 // newSDKSMTPSSL get a SDKs
@@ -232,26 +365,41 @@ func NewMailerSMTPSSL(user string, password string, server string, port string)
 // SendMail sendemail
 func (cfg *SDKConfigSengrid) SendMail() error {
 	sdk := cfg.newSDKSendgrid()
+	cfg.MessageID = ""
 
 	if CheckIsEmptyCfg(cfg) {
 		return fmt.Errorf("Empty fields on ConfigEmail")
 	}
+	if err := cfg.Suppressions.check(cfg.ConfigEmail.EmailTo); err != nil {
+		return err
+	}
+	unsubscribe, err := cfg.Unsubscribe.Headers(cfg.ConfigEmail.EmailTo)
+	if err != nil {
+		return err
+	}
 
 	if cfg.Delay > 0 {
 		time.Sleep(cfg.Delay)
 	}
 
-	from := mail.NewEmail(cfg.ConfigEmail.EmailFromName, cfg.ConfigEmail.EmailFrom)
-	to := mail.NewEmail(cfg.ConfigEmail.EmailToName, cfg.ConfigEmail.EmailTo)
-	msg := mail.NewSingleEmail(from,
-		cfg.ConfigEmail.Subject,
-		to, cfg.ConfigEmail.ContentPlainText,
-		cfg.ConfigEmail.ContentHTML,
-	)
-	_, err := sdk.Sendgrid.Send(msg)
+	msg := cfg.sendgridMessage()
+	to := mail.NewPersonalization()
+	to.AddTos(mail.NewEmail(cfg.ConfigEmail.EmailToName, cfg.ConfigEmail.EmailTo))
+	for k, v := range unsubscribe {
+		to.SetHeader(k, v)
+	}
+
+	id, err := sdk.sendgridSend(&sendgridMail{
+		SGMailV3: msg,
+		Personalizations: []*sendgridPersonalization{{
+			Personalization:     to,
+			DynamicTemplateData: cfg.ConfigEmail.TemplateData,
+		}},
+	})
 	if err != nil {
 		return err
 	}
+	cfg.MessageID = id
 
 	return nil
 }
@@ -259,25 +407,28 @@ func (cfg *SDKConfigSengrid) SendMail() error {
 // SendMail sendemail
 func (cfg *SDKConfigMailGun) SendMail() error {
 	sdk := cfg.newSDKMailGun()
+	cfg.MessageID = ""
 
 	if CheckIsEmptyCfg(cfg) {
 		return fmt.Errorf("Empty fields on ConfigEmail")
 	}
+	if err := cfg.Suppressions.check(cfg.ConfigEmail.EmailTo); err != nil {
+		return err
+	}
 
 	if cfg.Delay > 0 {
 		time.Sleep(cfg.Delay)
 	}
 
-	msg := sdk.Mailgun.NewMessage(
-		cfg.ConfigEmail.EmailFrom,
-		cfg.ConfigEmail.Subject,
-		cfg.ConfigEmail.ContentPlainText,
-		cfg.ConfigEmail.EmailTo,
-	)
-	_, _, err := sdk.Mailgun.Send(msg)
+	msg, err := cfg.mailgunMessage(sdk.Mailgun, cfg.ConfigEmail.EmailTo)
+	if err != nil {
+		return err
+	}
+	_, id, err := sdk.Mailgun.Send(msg)
 	if err != nil {
 		return err
 	}
+	cfg.MessageID = id
 
 	return nil
 }
@@ -288,13 +439,16 @@ func CheckIsEmptyCfg(cfg interface{}) bool {
 	sg, ok1 := cfg.(*SDKConfigSengrid)
 	// fmt.Printf("Sendgrid %t\n", ok1)
 	if ok1 {
-		if len(sg.ConfigEmail.ContentHTML) == 0 ||
-			len(sg.ConfigEmail.ContentPlainText) == 0 ||
+		// a dynamic template brings its own content and subject
+		if (len(sg.ConfigEmail.ContentHTML) == 0 &&
+			len(sg.ConfigEmail.ContentPlainText) == 0 &&
+			len(sg.ConfigEmail.ContentMarkdown) == 0 &&
+			len(sg.ConfigEmail.TemplateID) == 0) ||
 			len(sg.ConfigEmail.EmailFrom) == 0 ||
 			len(sg.ConfigEmail.EmailFromName) == 0 ||
 			len(sg.ConfigEmail.EmailTo) == 0 ||
 			len(sg.ConfigEmail.EmailToName) == 0 ||
-			len(sg.ConfigEmail.Subject) == 0 {
+			(len(sg.ConfigEmail.Subject) == 0 && len(sg.ConfigEmail.TemplateID) == 0) {
 			return true
 		}
 	}
@@ -302,7 +456,9 @@ func CheckIsEmptyCfg(cfg interface{}) bool {
 	mg, ok2 := cfg.(*SDKConfigMailGun)
 	// fmt.Printf("MailGun %t\n", ok1)
 	if ok2 {
-		if len(mg.ConfigEmail.ContentPlainText) == 0 ||
+		if (len(mg.ConfigEmail.ContentPlainText) == 0 &&
+			len(mg.ConfigEmail.ContentHTML) == 0 &&
+			len(mg.ConfigEmail.ContentMarkdown) == 0) ||
 			len(mg.ConfigEmail.EmailFrom) == 0 ||
 			len(mg.ConfigEmail.EmailTo) == 0 ||
 			len(mg.ConfigEmail.Subject) == 0 {
@@ -313,7 +469,9 @@ func CheckIsEmptyCfg(cfg interface{}) bool {
 	gm, ok3 := cfg.(*SDKConfigGmail)
 	// fmt.Printf("Gmail %t\n", ok1)
 	if ok3 {
-		if len(gm.ConfigEmail.ContentHTML) == 0 ||
+		if (len(gm.ConfigEmail.ContentHTML) == 0 &&
+			len(gm.ConfigEmail.ContentPlainText) == 0 &&
+			len(gm.ConfigEmail.ContentMarkdown) == 0) ||
 			len(gm.ConfigEmail.EmailFrom) == 0 ||
 			len(gm.ConfigEmail.EmailTo) == 0 ||
 			len(gm.ConfigEmail.Subject) == 0 {
@@ -324,7 +482,9 @@ func CheckIsEmptyCfg(cfg interface{}) bool {
 	smtp, ok4 := cfg.(*SDKConfigSMTPSSL)
 	// fmt.Printf("SMTPSSL %t\n", ok1)
 	if ok4 {
-		if len(smtp.ConfigEmail.ContentHTML) == 0 ||
+		if (len(smtp.ConfigEmail.ContentHTML) == 0 &&
+			len(smtp.ConfigEmail.ContentPlainText) == 0 &&
+			len(smtp.ConfigEmail.ContentMarkdown) == 0) ||
 			len(smtp.ConfigEmail.EmailFrom) == 0 ||
 			len(smtp.ConfigEmail.EmailTo) == 0 ||
 			len(smtp.ConfigEmail.Subject) == 0 {
@@ -338,10 +498,18 @@ func CheckIsEmptyCfg(cfg interface{}) bool {
 // SendMail sendemail
 func (cfg *SDKConfigGmail) SendMail() error {
 	sdk := cfg.newSDKGmail()
+	cfg.MessageID = ""
 
 	if CheckIsEmptyCfg(cfg) {
 		return fmt.Errorf("Empty fields on ConfigEmail")
 	}
+	if err := cfg.Suppressions.check(cfg.ConfigEmail.EmailTo); err != nil {
+		return err
+	}
+	unsubscribe, err := cfg.Unsubscribe.Headers(cfg.ConfigEmail.EmailTo)
+	if err != nil {
+		return err
+	}
 
 	if cfg.Delay > 0 {
 		time.Sleep(cfg.Delay)
@@ -351,39 +519,46 @@ func (cfg *SDKConfigGmail) SendMail() error {
 	SMTPServerWithPort := "smtp.gmail.com:587"
 	SMTPServerNoPort := "smtp.gmail.com"
 
-	headerConf := make(map[string]string)
-	headerMail := make(map[string]string)
-
-	message := ""
-
-	headerConf["Content-Type"] = "text/html; charset=\"UTF-8\";"
-	headerConf["MIME-Version"] = "1.0;"
+	html, text := renderContent(cfg.ConfigEmail.ContentMarkdown,
+		cfg.ConfigEmail.ContentHTML,
+		cfg.ConfigEmail.ContentPlainText,
+		cfg.ConfigEmail.InlineCSS,
+	)
 
-	for key, value := range headerConf {
-		message += fmt.Sprintf("%s: %s\n", key, value)
+	message := newRawMessage(sdk.Gmail.User, cfg.ConfigEmail.EmailTo, cfg.ConfigEmail.Subject)
+	if err := message.setHeaders(unsubscribe); err != nil {
+		return err
 	}
-
-	headerMail["From"] = sdk.Gmail.User
-	headerMail["To"] = cfg.ConfigEmail.EmailTo
-	headerMail["Subject"] = cfg.ConfigEmail.Subject
-
-	for key, value := range headerMail {
-		message += fmt.Sprintf("%s: %s\n", key, value)
+	if cfg.Tracking != nil && !cfg.ConfigEmail.NoTracking && html != "" {
+		if html, err = cfg.Tracking.rewrite(html, cfg.ConfigEmail.EmailTo, message.header.get("Message-ID")); err != nil {
+			return err
+		}
+	}
+	message.setContent(text, html)
+	if err := message.applySMIME(cfg.SMIME); err != nil {
+		return err
+	}
+	if err := message.applyPGP(cfg.PGP); err != nil {
+		return err
 	}
 
-	message += "\n" + cfg.ConfigEmail.ContentHTML
+	raw, err := message.signedBytes(cfg.DKIM)
+	if err != nil {
+		return err
+	}
 
 	auth := smtp.PlainAuth("", sdk.Gmail.User, sdk.Gmail.Password, SMTPServerNoPort)
-	err := smtp.SendMail(SMTPServerWithPort,
+	err = smtp.SendMail(SMTPServerWithPort,
 		auth,
 		sdk.Gmail.User,
 		[]string{cfg.ConfigEmail.EmailTo},
-		[]byte(message),
+		raw,
 	)
 
 	if err != nil {
 		return err
 	}
+	cfg.MessageID = message.header.get("Message-ID")
 
 	return nil
 
@@ -391,43 +566,126 @@ func (cfg *SDKConfigGmail) SendMail() error {
 
 // SendMail sendemail
 func (cfg *SDKConfigAWSSES) SendMail() error {
-	if cfg.ConfigEmail.ContentHTML == "" {
-		cfg.ConfigEmail.ContentHTML = cfg.ConfigEmail.ContentPlainText
+	html, text := renderContent(cfg.ConfigEmail.ContentMarkdown,
+		cfg.ConfigEmail.ContentHTML,
+		cfg.ConfigEmail.ContentPlainText,
+		cfg.ConfigEmail.InlineCSS,
+	)
+
+	body := &ses.Body{}
+	if html != "" {
+		body.Html = &ses.Content{
+			Charset: aws.String("utf-8"),
+			Data:    &html,
+		}
+	}
+	if text != "" {
+		body.Text = &ses.Content{
+			Charset: aws.String("utf-8"),
+			Data:    &text,
+		}
 	}
 
-	sdk := cfg.newSDKAWSSES()
+	cfg.MessageID = ""
+	if err := cfg.Suppressions.check(cfg.ConfigEmail.EmailTo); err != nil {
+		return err
+	}
+	unsubscribe, err := cfg.Unsubscribe.Headers(cfg.ConfigEmail.EmailTo)
+	if err != nil {
+		return err
+	}
+	sdk, err := cfg.newSDKAWSSES()
+	if err != nil {
+		return err
+	}
+
+	tags := sesTags(cfg.ConfigEmail.MessageTags)
+	var configurationSet *string
+	if cfg.ConfigEmail.ConfigurationSetName != "" {
+		configurationSet = &cfg.ConfigEmail.ConfigurationSetName
+	}
+
+	// attachments, custom and List-Unsubscribe headers, DKIM, S/MIME and
+	// PGP/MIME need the raw message path
+	if len(cfg.ConfigEmail.Attachments) > 0 || len(cfg.ConfigEmail.Headers) > 0 || len(unsubscribe) > 0 ||
+		cfg.DKIM != nil || cfg.SMIME != nil || cfg.PGP != nil {
+		message := newRawMessage(cfg.ConfigEmail.EmailFrom, cfg.ConfigEmail.EmailTo, cfg.ConfigEmail.Subject)
+		if len(cfg.ConfigEmail.ReplyTo) > 0 {
+			var replyTo []string
+			for _, addr := range cfg.ConfigEmail.ReplyTo {
+				replyTo = append(replyTo, formatAddress(addr))
+			}
+			message.header.set("Reply-To", strings.Join(replyTo, ", "))
+		}
+		if err := message.setHeaders(unsubscribe); err != nil {
+			return err
+		}
+		if err := message.setHeaders(cfg.ConfigEmail.Headers); err != nil {
+			return err
+		}
+		message.setContent(text, html)
+		message.addAttachments(cfg.ConfigEmail.Attachments)
+		if err := message.applySMIME(cfg.SMIME); err != nil {
+			return err
+		}
+		if err := message.applyPGP(cfg.PGP); err != nil {
+			return err
+		}
+		raw, err := message.signedBytes(cfg.DKIM)
+		if err != nil {
+			return err
+		}
+
+		// the envelope sender of a raw message is its Source
+		source := cfg.ConfigEmail.EmailFrom
+		if cfg.ConfigEmail.ReturnPath != "" {
+			source = cfg.ConfigEmail.ReturnPath
+		}
+		out, err := sdk.AWSSES.SendRawEmail(&ses.SendRawEmailInput{
+			Source:               &source,
+			Destinations:         aws.StringSlice([]string{cfg.ConfigEmail.EmailTo}),
+			RawMessage:           &ses.RawMessage{Data: raw},
+			ConfigurationSetName: configurationSet,
+			Tags:                 tags,
+		})
+		if err != nil {
+			return err
+		}
+		cfg.MessageID = aws.StringValue(out.MessageId)
+		return nil
+	}
 
 	msg := &ses.Message{
 		Subject: &ses.Content{
 			Charset: aws.String("utf-8"),
 			Data:    &cfg.ConfigEmail.Subject,
 		},
-		Body: &ses.Body{
-			Html: &ses.Content{
-				Charset: aws.String("utf-8"),
-				Data:    &cfg.ConfigEmail.ContentHTML,
-			},
-			Text: &ses.Content{
-				Charset: aws.String("utf-8"),
-				Data:    &cfg.ConfigEmail.ContentPlainText,
-			},
-		},
+		Body: body,
 	}
 
 	dest := &ses.Destination{
 		ToAddresses: aws.StringSlice([]string{cfg.ConfigEmail.EmailTo}),
 	}
 
-	_, err := sdk.AWSSES.SendEmail(&ses.SendEmailInput{
-		Source:      &cfg.ConfigEmail.EmailFrom,
-		Destination: dest,
-		Message:     msg,
-		// ReplyToAddresses: aws.StringSlice(cfg.ConfigEmail.ReplyTo),
-	})
+	input := &ses.SendEmailInput{
+		Source:               &cfg.ConfigEmail.EmailFrom,
+		Destination:          dest,
+		Message:              msg,
+		ConfigurationSetName: configurationSet,
+		Tags:                 tags,
+	}
+	if len(cfg.ConfigEmail.ReplyTo) > 0 {
+		input.ReplyToAddresses = aws.StringSlice(cfg.ConfigEmail.ReplyTo)
+	}
+	if cfg.ConfigEmail.ReturnPath != "" {
+		input.ReturnPath = &cfg.ConfigEmail.ReturnPath
+	}
 
+	out, err := sdk.AWSSES.SendEmail(input)
 	if err != nil {
 		return err
 	}
+	cfg.MessageID = aws.StringValue(out.MessageId)
 
 	return nil
 }
@@ -435,10 +693,22 @@ func (cfg *SDKConfigAWSSES) SendMail() error {
 // SendMail sendemail
 func (cfg *SDKConfigSMTPSSL) SendMail() error {
 	sdk := cfg.newSDKSMTPSSL()
+	cfg.MessageID = ""
 
 	if CheckIsEmptyCfg(cfg) {
 		return fmt.Errorf("Empty fields on ConfigEmail")
 	}
+	if err := cfg.Suppressions.check(cfg.ConfigEmail.EmailTo); err != nil {
+		return err
+	}
+	sender, err := cfg.envelopeSender()
+	if err != nil {
+		return err
+	}
+	unsubscribe, err := cfg.Unsubscribe.Headers(cfg.ConfigEmail.EmailTo)
+	if err != nil {
+		return err
+	}
 
 	if cfg.Delay > 0 {
 		time.Sleep(cfg.Delay)
@@ -448,27 +718,33 @@ func (cfg *SDKConfigSMTPSSL) SendMail() error {
 	SMTPServerWithPort := fmt.Sprintf("%s:%s", cfg.Server, cfg.Port)
 	SMTPServerNoPort := cfg.Server
 
-	headerConf := make(map[string]string)
-	headerMail := make(map[string]string)
-
-	message := ""
-
-	headerConf["Content-Type"] = "text/html; charset=\"UTF-8\";"
-	headerConf["MIME-Version"] = "1.0;"
+	html, text := renderContent(cfg.ConfigEmail.ContentMarkdown,
+		cfg.ConfigEmail.ContentHTML,
+		cfg.ConfigEmail.ContentPlainText,
+		cfg.ConfigEmail.InlineCSS,
+	)
 
-	for key, value := range headerConf {
-		message += fmt.Sprintf("%s: %s\n", key, value)
+	message := newRawMessage(sdk.SMTPSSL.User, cfg.ConfigEmail.EmailTo, cfg.ConfigEmail.Subject)
+	if err := message.setHeaders(unsubscribe); err != nil {
+		return err
 	}
-
-	headerMail["From"] = sdk.SMTPSSL.User
-	headerMail["To"] = cfg.ConfigEmail.EmailTo
-	headerMail["Subject"] = cfg.ConfigEmail.Subject
-
-	for key, value := range headerMail {
-		message += fmt.Sprintf("%s: %s\n", key, value)
+	if cfg.Tracking != nil && !cfg.ConfigEmail.NoTracking && html != "" {
+		if html, err = cfg.Tracking.rewrite(html, cfg.ConfigEmail.EmailTo, message.header.get("Message-ID")); err != nil {
+			return err
+		}
+	}
+	message.setContent(text, html)
+	if err := message.applySMIME(cfg.SMIME); err != nil {
+		return err
+	}
+	if err := message.applyPGP(cfg.PGP); err != nil {
+		return err
 	}
 
-	message += "\n" + cfg.ConfigEmail.ContentHTML
+	raw, err := message.signedBytes(cfg.DKIM)
+	if err != nil {
+		return err
+	}
 
 	auth := smtp.PlainAuth("", sdk.SMTPSSL.User, sdk.SMTPSSL.Password, SMTPServerNoPort)
 
@@ -496,7 +772,7 @@ func (cfg *SDKConfigSMTPSSL) SendMail() error {
 	}
 
 	// To && From
-	if err = c.Mail(cfg.User); err != nil {
+	if err = c.Mail(sender); err != nil {
 		return err
 	}
 
@@ -511,7 +787,7 @@ func (cfg *SDKConfigSMTPSSL) SendMail() error {
 	}
 
 	// Write messsage
-	_, err = w.Write([]byte(message))
+	_, err = w.Write(raw)
 	if err != nil {
 		return err
 	}
@@ -523,7 +799,20 @@ func (cfg *SDKConfigSMTPSSL) SendMail() error {
 	}
 
 	c.Quit()
+	cfg.MessageID = message.header.get("Message-ID")
 
 	return nil
 
 }
+
+// envelopeSender MAIL FROM of the send: the VERP address of the recipient,
+// else ReturnPath, else User
+func (cfg *SDKConfigSMTPSSL) envelopeSender() (string, error) {
+	if cfg.VERP != nil {
+		return cfg.VERP.Encode(cfg.ConfigEmail.EmailTo, cfg.ConfigEmail.MessageKey)
+	}
+	if cfg.ReturnPath != "" {
+		return cfg.ReturnPath, nil
+	}
+	return cfg.User, nil
+}
@@ -1,9 +1,15 @@
 package mailer_test
 
 import (
+	"encoding/base64"
+	"fmt"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -15,7 +21,7 @@ import (
 func init() {
 	if err := godotenv.Load(); err != nil {
 		log.Printf("Error loading .env file! Try get a path...")
-		log.Printf("PATH = " + getPath() + "/.env")
+		log.Printf("PATH = %s/.env", getPath())
 		if err1 := godotenv.Load(getPath() + "/.env"); err1 != nil {
 			log.Printf("Fail...")
 			os.Exit(1)
@@ -233,3 +239,268 @@ func TestSendMailWithErrorFromGmail(t *testing.T) {
 
 	t.Errorf("SendMail got: %s", err)
 }
+
+// sesCall requests received by the SES stub
+type sesCall struct {
+	form  url.Values // last request
+	auth  string
+	forms []url.Values
+}
+
+// sesStub local SES endpoint keeping the requests; bulk sends get one
+// status per destination
+func sesStub(t *testing.T) (*httptest.Server, *sesCall) {
+	call := &sesCall{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("SES stub got: %s", err)
+		}
+		call.form = r.PostForm
+		call.auth = r.Header.Get("Authorization")
+		call.forms = append(call.forms, r.PostForm)
+
+		action := r.PostForm.Get("Action")
+		result := "<MessageId>0100-stub</MessageId>"
+		switch action {
+		case "SendBulkTemplatedEmail":
+			result = "<Status>"
+			for i := 1; r.PostForm.Get(fmt.Sprintf("Destinations.member.%d.Destination.ToAddresses.member.1", i)) != ""; i++ {
+				result += fmt.Sprintf("<member><Status>Success</Status><MessageId>bulk-%d</MessageId></member>", len(call.forms)*100+i)
+			}
+			result += "</Status>"
+		case "GetTemplate":
+			result = "<Template><TemplateName>" + r.PostForm.Get("TemplateName") + "</TemplateName>" +
+				"<SubjectPart>Hi {{name}}</SubjectPart><TextPart>Hello {{name}}</TextPart></Template>"
+		case "TestRenderTemplate":
+			result = "<RenderedTemplate>Subject: Hi Ana</RenderedTemplate>"
+		}
+		fmt.Fprintf(w, `<%[1]sResponse xmlns="http://ses.amazonaws.com/doc/2010-12-01/">
+<%[1]sResult>%[2]s</%[1]sResult>
+<ResponseMetadata><RequestId>stub</RequestId></ResponseMetadata>
+</%[1]sResponse>`, action, result)
+	}))
+	return srv, call
+}
+
+// setenv set environment variables for the test, restoring them after
+func setenv(t *testing.T, kv ...string) {
+	for i := 0; i < len(kv); i += 2 {
+		key := kv[i]
+		old, ok := os.LookupEnv(key)
+		os.Setenv(key, kv[i+1])
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(key, old)
+			} else {
+				os.Unsetenv(key)
+			}
+		})
+	}
+}
+
+func TestSendMailFromAWSSESStub(t *testing.T) {
+	t.Log("SendMail(AWS SES) against a local endpoint... (NOT expected some err)")
+	srv, call := sesStub(t)
+	defer srv.Close()
+
+	mg := mailer.NewMailerAWSSES("key", "secret", "us-east-1")
+	mg.Endpoint = srv.URL
+	mg.ConfigEmail = mailer.ConfigEmailAWSSES{
+		ContentPlainText:     "test",
+		EmailFrom:            "sender@host.com",
+		EmailTo:              "client@host.com",
+		Subject:              "Test",
+		ReplyTo:              []string{"reply@host.com"},
+		ReturnPath:           "bounces@host.com",
+		ConfigurationSetName: "tracking",
+		MessageTags:          map[string]string{"campaign": "launch"},
+	}
+
+	if err := mg.SendMail(); err != nil {
+		t.Fatalf("SendMail got: %s", err)
+	}
+	for k, v := range map[string]string{
+		"Action":                           "SendEmail",
+		"Source":                           "sender@host.com",
+		"Destination.ToAddresses.member.1": "client@host.com",
+		"ReplyToAddresses.member.1":        "reply@host.com",
+		"ReturnPath":                       "bounces@host.com",
+		"ConfigurationSetName":             "tracking",
+		"Tags.member.1.Name":               "campaign",
+		"Tags.member.1.Value":              "launch",
+	} {
+		if call.form.Get(k) != v {
+			t.Errorf("SendEmail %s got: %q", k, call.form.Get(k))
+		}
+	}
+}
+
+func TestSendRawMailFromAWSSESStub(t *testing.T) {
+	t.Log("SendMail(AWS SES) raw message against a local endpoint... (NOT expected some err)")
+	srv, call := sesStub(t)
+	defer srv.Close()
+
+	key := dkimKeys(t)["ed25519"]
+	mg := mailer.NewMailerAWSSES("key", "secret", "us-east-1")
+	mg.Endpoint = srv.URL
+	mg.DKIM = &mailer.DKIMOptions{Domain: "example.com", Selector: "mail", PrivateKey: key}
+	mg.ConfigEmail = mailer.ConfigEmailAWSSES{
+		ContentHTML:          "<b>test</b>",
+		EmailFrom:            "sender@example.com",
+		EmailTo:              "client@host.com",
+		Subject:              "Test",
+		ReplyTo:              []string{"reply@host.com"},
+		ReturnPath:           "bounces@example.com",
+		ConfigurationSetName: "tracking",
+		Headers:              map[string]string{"X-Campaign": "launch"},
+		Attachments: []mailer.Attachment{
+			{Filename: "report.csv", ContentType: "text/csv", Data: []byte("a,b\n1,2\n")},
+		},
+	}
+
+	if err := mg.SendMail(); err != nil {
+		t.Fatalf("SendMail got: %s", err)
+	}
+	for k, v := range map[string]string{
+		"Action":                "SendRawEmail",
+		"Source":                "bounces@example.com",
+		"Destinations.member.1": "client@host.com",
+		"ConfigurationSetName":  "tracking",
+	} {
+		if call.form.Get(k) != v {
+			t.Errorf("SendRawEmail %s got: %q", k, call.form.Get(k))
+		}
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(call.form.Get("RawMessage.Data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []string{
+		"Reply-To: <reply@host.com>\r\n",
+		"X-Campaign: launch\r\n",
+		"Content-Type: multipart/mixed;",
+		"Content-Type: text/csv; name=\"report.csv\"",
+		"Content-Disposition: attachment; filename=\"report.csv\"",
+	} {
+		if !strings.Contains(string(raw), c) {
+			t.Errorf("SendRawEmail missing %q\ngot: %s", c, raw)
+		}
+	}
+	if _, err := mailer.VerifyDKIM(raw, dkimLookup(t, key)); err != nil {
+		t.Errorf("VerifyDKIM got: %s", err)
+	}
+}
+
+func TestSendRawMailFromAWSSESHeaders(t *testing.T) {
+	t.Log("SendMail(AWS SES) refuse invalid and structural headers... (expected some err)")
+	srv, call := sesStub(t)
+	defer srv.Close()
+
+	mg := mailer.NewMailerAWSSES("key", "secret", "us-east-1")
+	mg.Endpoint = srv.URL
+	for _, h := range []string{"X-A\r\nBcc", "X-A: b", "", "Content-Type", "mime-version", "From", "Message-ID"} {
+		mg.ConfigEmail = mailer.ConfigEmailAWSSES{
+			ContentPlainText: "test",
+			EmailFrom:        "sender@host.com",
+			EmailTo:          "client@host.com",
+			Subject:          "Test",
+			Headers:          map[string]string{"X-Campaign": "launch", h: "evil@host.com"},
+		}
+		if err := mg.SendMail(); err == nil {
+			t.Errorf("SendMail %q expected an error", h)
+		}
+	}
+	if len(call.forms) > 0 {
+		t.Errorf("SendRawEmail got %d calls", len(call.forms))
+	}
+}
+
+func TestAWSSESClientReuse(t *testing.T) {
+	t.Log("AWS SES client and message ID... (NOT expected some err)")
+	srv, call := sesStub(t)
+	defer srv.Close()
+
+	mg := mailer.NewMailerAWSSES("key", "secret", "us-east-1")
+	mg.Endpoint = srv.URL
+	mg.ConfigEmail = mailer.ConfigEmailAWSSES{
+		ContentPlainText: "test",
+		EmailFrom:        "sender@host.com",
+		EmailTo:          "client@host.com",
+		Subject:          "Test",
+	}
+
+	first, err := mg.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mg.SendMail(); err != nil {
+		t.Fatalf("SendMail got: %s", err)
+	}
+	second, err := mg.Client()
+	if err != nil || first != second {
+		t.Errorf("Client got a new client: %v", err)
+	}
+	if mg.MessageID != "0100-stub" {
+		t.Errorf("MessageID got: %q", mg.MessageID)
+	}
+	if !strings.Contains(call.auth, "Credential=key/") {
+		t.Errorf("Authorization got: %s", call.auth)
+	}
+
+	// a change of the credentials or the endpoint builds a new client
+	mg.AccessKey = "rotated"
+	third, err := mg.Client()
+	if err != nil || third == second {
+		t.Errorf("Client after a key change got the cached client: %v", err)
+	}
+	if err := mg.SendMail(); err != nil {
+		t.Fatalf("SendMail got: %s", err)
+	}
+	if !strings.Contains(call.auth, "Credential=rotated/") {
+		t.Errorf("Authorization after a key change got: %s", call.auth)
+	}
+}
+
+func TestAWSSESCredentialChain(t *testing.T) {
+	t.Log("AWS SES default credential chain... (NOT expected some err)")
+	srv, call := sesStub(t)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	credentials := filepath.Join(dir, "credentials")
+	err := os.WriteFile(credentials, []byte("[mailer]\naws_access_key_id = PROFILEKEY\naws_secret_access_key = profilesecret\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setenv(t,
+		"AWS_ACCESS_KEY_ID", "ENVKEY",
+		"AWS_SECRET_ACCESS_KEY", "envsecret",
+		"AWS_SHARED_CREDENTIALS_FILE", credentials,
+		"AWS_CONFIG_FILE", filepath.Join(dir, "config"),
+	)
+
+	// environment first, then the profile once the environment is cleared
+	for _, c := range [][2]string{{"", "ENVKEY"}, {"mailer", "PROFILEKEY"}} {
+		profile, key := c[0], c[1]
+		if profile != "" {
+			os.Unsetenv("AWS_ACCESS_KEY_ID")
+			os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+		}
+		mg := mailer.NewMailerAWSSES("", "", "us-east-1")
+		mg.Profile = profile
+		mg.Endpoint = srv.URL
+		mg.ConfigEmail = mailer.ConfigEmailAWSSES{
+			ContentPlainText: "test",
+			EmailFrom:        "sender@host.com",
+			EmailTo:          "client@host.com",
+			Subject:          "Test",
+		}
+		if err := mg.SendMail(); err != nil {
+			t.Fatalf("SendMail %q got: %s", profile, err)
+		}
+		if !strings.Contains(call.auth, "Credential="+key+"/") {
+			t.Errorf("Authorization %q got: %s", profile, call.auth)
+		}
+	}
+}
@@ -0,0 +1,1023 @@
+package mailer
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Markdown subset understood by RenderMarkdown: ATX and setext headings,
+// paragraphs, hard line breaks, block quotes, bullet and ordered lists
+// (nested by indentation), fenced and indented code blocks, thematic
+// breaks, and the inlines *em*, **strong**, ~~del~~, `code`, [links](url),
+// ![images](src) and <autolinks>. Raw HTML is escaped, not passed through.
+
+type mdBlockKind int
+
+const (
+	mdParagraph mdBlockKind = iota
+	mdHeading
+	mdCode
+	mdQuote
+	mdList
+	mdItem
+	mdRule
+)
+
+type mdInlineKind int
+
+const (
+	mdText mdInlineKind = iota
+	mdSoftBreak
+	mdHardBreak
+	mdCodeSpan
+	mdEmphasis
+	mdStrong
+	mdStrike
+	mdLink
+	mdImage
+)
+
+// mdBlock block of a markdown document
+type mdBlock struct {
+	kind     mdBlockKind
+	level    int  // heading level
+	ordered  bool // list
+	start    int  // first number of an ordered list
+	tight    bool // list items without blank lines between them
+	lang     string
+	text     string // code block contents
+	inlines  []mdInline
+	children []*mdBlock
+}
+
+// mdInline inline of a paragraph or heading
+type mdInline struct {
+	kind     mdInlineKind
+	text     string
+	url      string
+	title    string
+	children []mdInline
+}
+
+// markdownStyles inline styles of the rendered HTML, since most mail
+// clients drop <style> blocks
+var markdownStyles = map[string]string{
+	"body":       "margin:0;padding:0;background:#ffffff;",
+	"wrapper":    "max-width:600px;margin:0 auto;padding:16px;font-family:Helvetica,Arial,sans-serif;font-size:16px;line-height:1.5;color:#222222;",
+	"h1":         "margin:0 0 16px;font-size:28px;line-height:1.25;",
+	"h2":         "margin:24px 0 16px;font-size:22px;line-height:1.25;",
+	"h3":         "margin:24px 0 16px;font-size:18px;line-height:1.25;",
+	"h4":         "margin:24px 0 16px;font-size:16px;line-height:1.25;",
+	"h5":         "margin:24px 0 16px;font-size:14px;line-height:1.25;",
+	"h6":         "margin:24px 0 16px;font-size:13px;line-height:1.25;color:#555555;",
+	"p":          "margin:0 0 16px;",
+	"a":          "color:#1a73e8;text-decoration:underline;",
+	"blockquote": "margin:0 0 16px;padding:0 16px;border-left:4px solid #dddddd;color:#555555;",
+	"ul":         "margin:0 0 16px;padding-left:24px;",
+	"ol":         "margin:0 0 16px;padding-left:24px;",
+	"li":         "margin:0 0 4px;",
+	"pre":        "margin:0 0 16px;padding:12px;background:#f6f8fa;border-radius:4px;overflow:auto;font-family:Menlo,Consolas,monospace;font-size:14px;line-height:1.4;",
+	"code":       "padding:2px 4px;background:#f6f8fa;border-radius:3px;font-family:Menlo,Consolas,monospace;font-size:14px;",
+	"hr":         "margin:24px 0;border:0;border-top:1px solid #dddddd;",
+	"img":        "max-width:100%;border:0;",
+}
+
+var (
+	mdATXHeading   = regexp.MustCompile(`^(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	mdRuleLine     = regexp.MustCompile(`^(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	mdSetextH1     = regexp.MustCompile(`^=+[ \t]*$`)
+	mdSetextH2     = regexp.MustCompile(`^-+[ \t]*$`)
+	mdFenceOpen    = regexp.MustCompile("^(`{3,}|~{3,})[ \t]*([^`]*?)[ \t]*$")
+	mdOrderedItem  = regexp.MustCompile(`^([0-9]{1,9})([.)])( +|$)`)
+	mdBulletItem   = regexp.MustCompile(`^([-*+])( +|$)`)
+	mdAutolink     = regexp.MustCompile(`^<([a-zA-Z][a-zA-Z0-9+.-]{1,31}:[^<>\s]*)>`)
+	mdEmailLink    = regexp.MustCompile(`^<([a-zA-Z0-9.!#$%&'*+/=?^_{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)*)>`)
+	mdSafeURL      = regexp.MustCompile(`(?i)^(?:https?:|mailto:|tel:|cid:|#|/|\./|\.\./|[^:/?#]*(?:[/?#]|$))`)
+	mdPunctuation  = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+	mdWrapperStart = `<!DOCTYPE html>` + "\n" +
+		`<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"></head>` + "\n"
+)
+
+// RenderMarkdown render a markdown document into a styled HTML document
+// and a plain text version (links as numbered footnotes)
+func RenderMarkdown(source string) (string, string) {
+	blocks := parseMarkdown(source)
+
+	var h strings.Builder
+	h.WriteString(mdWrapperStart)
+	fmt.Fprintf(&h, "<body style=\"%s\"><div style=\"%s\">\n", markdownStyles["body"], markdownStyles["wrapper"])
+	renderMarkdownHTML(&h, blocks, false)
+	h.WriteString("</div></body></html>\n")
+
+	tr := &mdTextRenderer{}
+	lines := append(tr.blocks(blocks), tr.notes.lines()...)
+
+	return h.String(), strings.Join(lines, "\n") + "\n"
+}
+
+// parseMarkdown split source into blocks
+func parseMarkdown(source string) []*mdBlock {
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	source = strings.ReplaceAll(source, "\r", "\n")
+	lines := strings.Split(source, "\n")
+	for i, l := range lines {
+		lines[i] = expandTabs(l)
+	}
+	return parseMarkdownBlocks(lines)
+}
+
+// parseMarkdownBlocks parse lines into blocks, recursively for quotes and
+// list items
+func parseMarkdownBlocks(lines []string) []*mdBlock {
+	var blocks []*mdBlock
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		if isBlankLine(line) {
+			i++
+			continue
+		}
+
+		indent := leadingSpaces(line)
+		if indent >= 4 {
+			var code []string
+			for i < len(lines) && (isBlankLine(lines[i]) || leadingSpaces(lines[i]) >= 4) {
+				if isBlankLine(lines[i]) {
+					code = append(code, "")
+				} else {
+					code = append(code, lines[i][4:])
+				}
+				i++
+			}
+			for len(code) > 0 && code[len(code)-1] == "" {
+				code = code[:len(code)-1]
+			}
+			blocks = append(blocks, &mdBlock{kind: mdCode, text: strings.Join(code, "\n")})
+			continue
+		}
+
+		trimmed := line[indent:]
+
+		if m := mdFenceOpen.FindStringSubmatch(trimmed); m != nil {
+			fence := m[1]
+			var code []string
+			i++
+			for i < len(lines) {
+				l := lines[i]
+				t := strings.TrimLeft(l, " ")
+				if leadingSpaces(l) < 4 && strings.HasPrefix(t, fence[:1]) &&
+					len(strings.TrimRight(t, " \t")) >= len(fence) &&
+					strings.Trim(strings.TrimRight(t, " \t"), fence[:1]) == "" {
+					i++
+					break
+				}
+				code = append(code, stripIndent(l, indent))
+				i++
+			}
+			lang := strings.Fields(m[2] + " ")
+			b := &mdBlock{kind: mdCode, text: strings.Join(code, "\n")}
+			if len(lang) > 0 {
+				b.lang = lang[0]
+			}
+			blocks = append(blocks, b)
+			continue
+		}
+
+		if m := mdATXHeading.FindStringSubmatch(trimmed); m != nil {
+			blocks = append(blocks, &mdBlock{
+				kind:    mdHeading,
+				level:   len(m[1]),
+				inlines: parseInlines(m[2]),
+			})
+			i++
+			continue
+		}
+
+		if mdRuleLine.MatchString(trimmed) {
+			blocks = append(blocks, &mdBlock{kind: mdRule})
+			i++
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, ">") {
+			var quoted []string
+			for i < len(lines) && !isBlankLine(lines[i]) {
+				t := strings.TrimLeft(lines[i], " ")
+				if strings.HasPrefix(t, ">") && leadingSpaces(lines[i]) < 4 {
+					t = strings.TrimPrefix(t[1:], " ")
+					quoted = append(quoted, t)
+				} else if len(quoted) > 0 && !startsMarkdownBlock(lines[i]) {
+					quoted = append(quoted, t)
+				} else {
+					break
+				}
+				i++
+			}
+			blocks = append(blocks, &mdBlock{kind: mdQuote, children: parseMarkdownBlocks(quoted)})
+			continue
+		}
+
+		if _, ok := parseListMarker(line); ok {
+			var list *mdBlock
+			list, i = parseMarkdownList(lines, i)
+			blocks = append(blocks, list)
+			continue
+		}
+
+		// Paragraph, possibly turned into a setext heading
+		para := []string{trimmed}
+		i++
+		level := 0
+		for i < len(lines) && !isBlankLine(lines[i]) {
+			t := strings.TrimLeft(lines[i], " ")
+			if leadingSpaces(lines[i]) < 4 && mdSetextH1.MatchString(t) {
+				level = 1
+				i++
+				break
+			}
+			if leadingSpaces(lines[i]) < 4 && mdSetextH2.MatchString(t) {
+				level = 2
+				i++
+				break
+			}
+			if startsMarkdownBlock(lines[i]) {
+				break
+			}
+			para = append(para, t)
+			i++
+		}
+
+		text := strings.TrimRight(strings.Join(para, "\n"), " \t")
+		if level > 0 {
+			blocks = append(blocks, &mdBlock{kind: mdHeading, level: level, inlines: parseInlines(text)})
+		} else {
+			blocks = append(blocks, &mdBlock{kind: mdParagraph, inlines: parseInlines(text)})
+		}
+	}
+
+	return blocks
+}
+
+// mdListMarker list item marker of a line
+type mdListMarker struct {
+	ordered   bool
+	start     int
+	delimiter byte // '-', '*', '+', '.' or ')'
+	width     int  // indentation of the item contents
+	content   string
+}
+
+// parseListMarker recognize "- item", "* item", "1. item" and "1) item"
+func parseListMarker(line string) (mdListMarker, bool) {
+	indent := leadingSpaces(line)
+	if indent >= 4 {
+		return mdListMarker{}, false
+	}
+	t := line[indent:]
+
+	if mdRuleLine.MatchString(t) {
+		return mdListMarker{}, false
+	}
+
+	if m := mdBulletItem.FindStringSubmatch(t); m != nil {
+		spaces := len(m[2])
+		if spaces > 4 || spaces == 0 {
+			spaces = 1
+		}
+		return mdListMarker{
+			delimiter: m[1][0],
+			width:     indent + 1 + spaces,
+			content:   strings.TrimLeft(t[len(m[0]):], " "),
+		}, true
+	}
+
+	if m := mdOrderedItem.FindStringSubmatch(t); m != nil {
+		start, _ := strconv.Atoi(m[1])
+		spaces := len(m[3])
+		if spaces > 4 || spaces == 0 {
+			spaces = 1
+		}
+		return mdListMarker{
+			ordered:   true,
+			start:     start,
+			delimiter: m[2][0],
+			width:     indent + len(m[1]) + 1 + spaces,
+			content:   strings.TrimLeft(t[len(m[0]):], " "),
+		}, true
+	}
+
+	return mdListMarker{}, false
+}
+
+// parseMarkdownList parse the list starting at lines[i]; returns the list
+// block and the index of the first line after it
+func parseMarkdownList(lines []string, i int) (*mdBlock, int) {
+	first, _ := parseListMarker(lines[i])
+	list := &mdBlock{kind: mdList, ordered: first.ordered, start: first.start, tight: true}
+
+	sameList := func(m mdListMarker) bool {
+		return m.ordered == first.ordered && m.delimiter == first.delimiter
+	}
+
+	for i < len(lines) {
+		m, ok := parseListMarker(lines[i])
+		if !ok || !sameList(m) {
+			break
+		}
+
+		item := []string{m.content}
+		i++
+		blank := false
+		for i < len(lines) {
+			l := lines[i]
+			if isBlankLine(l) {
+				item = append(item, "")
+				blank = true
+				i++
+				continue
+			}
+			if leadingSpaces(l) >= m.width {
+				item = append(item, l[m.width:])
+				blank = false
+				i++
+				continue
+			}
+			if _, ok := parseListMarker(l); ok || blank || startsMarkdownBlock(l) {
+				break
+			}
+			// lazy paragraph continuation
+			item = append(item, strings.TrimLeft(l, " "))
+			i++
+		}
+
+		// trailing blank lines separate items (loose list) or end the list
+		trailing := 0
+		for len(item) > 0 && item[len(item)-1] == "" {
+			item = item[:len(item)-1]
+			trailing++
+		}
+		if trailing > 0 && i < len(lines) {
+			if next, ok := parseListMarker(lines[i]); ok && sameList(next) {
+				list.tight = false
+			}
+		}
+
+		children := parseMarkdownBlocks(item)
+		if len(children) > 1 {
+			for _, l := range item {
+				if l == "" {
+					list.tight = false
+					break
+				}
+			}
+		}
+
+		list.children = append(list.children, &mdBlock{kind: mdItem, children: children})
+	}
+
+	return list, i
+}
+
+// startsMarkdownBlock report whether line interrupts a paragraph
+func startsMarkdownBlock(line string) bool {
+	indent := leadingSpaces(line)
+	if indent >= 4 {
+		return false
+	}
+	t := line[indent:]
+	if mdATXHeading.MatchString(t) || mdRuleLine.MatchString(t) ||
+		mdFenceOpen.MatchString(t) || strings.HasPrefix(t, ">") {
+		return true
+	}
+	if m, ok := parseListMarker(line); ok && m.content != "" {
+		return !m.ordered || m.start == 1
+	}
+	return false
+}
+
+// parseInlines parse the inline contents of a paragraph or heading
+func parseInlines(s string) []mdInline {
+	var out []mdInline
+	var text strings.Builder
+
+	flush := func() {
+		if text.Len() > 0 {
+			out = append(out, mdInline{kind: mdText, text: text.String()})
+			text.Reset()
+		}
+	}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && s[i+1] == '\n':
+			flush()
+			out = append(out, mdInline{kind: mdHardBreak})
+			i += 2
+			continue
+
+		case c == '\\' && i+1 < len(s) && strings.IndexByte(mdPunctuation, s[i+1]) >= 0:
+			text.WriteByte(s[i+1])
+			i += 2
+			continue
+
+		case c == '\n':
+			cur := text.String()
+			trimmed := strings.TrimRight(cur, " ")
+			hard := len(cur)-len(trimmed) >= 2
+			text.Reset()
+			text.WriteString(trimmed)
+			flush()
+			if hard {
+				out = append(out, mdInline{kind: mdHardBreak})
+			} else {
+				out = append(out, mdInline{kind: mdSoftBreak})
+			}
+			i++
+			for i < len(s) && s[i] == ' ' {
+				i++
+			}
+			continue
+
+		case c == '`':
+			n := runLength(s, i, '`')
+			if end := findCodeSpanEnd(s, i+n, n); end >= 0 {
+				code := strings.ReplaceAll(s[i+n:end], "\n", " ")
+				if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+					code = code[1 : len(code)-1]
+				}
+				flush()
+				out = append(out, mdInline{kind: mdCodeSpan, text: code})
+				i = end + n
+				continue
+			}
+			text.WriteString(s[i : i+n])
+			i += n
+			continue
+
+		case c == '!' && i+1 < len(s) && s[i+1] == '[':
+			if in, next, ok := parseLinkInline(s, i+1); ok {
+				flush()
+				in.kind = mdImage
+				out = append(out, in)
+				i = next
+				continue
+			}
+
+		case c == '[':
+			if in, next, ok := parseLinkInline(s, i); ok {
+				flush()
+				out = append(out, in)
+				i = next
+				continue
+			}
+
+		case c == '<':
+			if m := mdAutolink.FindStringSubmatch(s[i:]); m != nil {
+				flush()
+				out = append(out, mdInline{kind: mdLink, url: m[1],
+					children: []mdInline{{kind: mdText, text: m[1]}}})
+				i += len(m[0])
+				continue
+			}
+			if m := mdEmailLink.FindStringSubmatch(s[i:]); m != nil {
+				flush()
+				out = append(out, mdInline{kind: mdLink, url: "mailto:" + m[1],
+					children: []mdInline{{kind: mdText, text: m[1]}}})
+				i += len(m[0])
+				continue
+			}
+
+		case c == '*' || c == '_' || c == '~':
+			if in, next, ok := parseEmphasis(s, i); ok {
+				flush()
+				out = append(out, in)
+				i = next
+				continue
+			}
+			n := runLength(s, i, c)
+			text.WriteString(s[i : i+n])
+			i += n
+			continue
+		}
+
+		text.WriteByte(c)
+		i++
+	}
+
+	flush()
+	return out
+}
+
+// parseLinkInline parse [text](url "title") starting at the '['
+func parseLinkInline(s string, open int) (mdInline, int, bool) {
+	depth := 0
+	closeBracket := -1
+	for j := open; j < len(s) && closeBracket < 0; j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '`':
+			n := runLength(s, j, '`')
+			if end := findCodeSpanEnd(s, j+n, n); end >= 0 {
+				j = end + n - 1
+			} else {
+				j += n - 1
+			}
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				closeBracket = j
+			}
+		}
+	}
+	if closeBracket < 0 || closeBracket+1 >= len(s) || s[closeBracket+1] != '(' {
+		return mdInline{}, 0, false
+	}
+
+	j := closeBracket + 2
+	for j < len(s) && (s[j] == ' ' || s[j] == '\n') {
+		j++
+	}
+
+	var url string
+	if j < len(s) && s[j] == '<' {
+		end := strings.IndexByte(s[j:], '>')
+		if end < 0 {
+			return mdInline{}, 0, false
+		}
+		url = s[j+1 : j+end]
+		j += end + 1
+	} else {
+		start := j
+		parens := 0
+		for j < len(s) && s[j] != ' ' && s[j] != '\n' {
+			if s[j] == '(' {
+				parens++
+			} else if s[j] == ')' {
+				if parens == 0 {
+					break
+				}
+				parens--
+			}
+			j++
+		}
+		url = s[start:j]
+	}
+
+	for j < len(s) && (s[j] == ' ' || s[j] == '\n') {
+		j++
+	}
+
+	var title string
+	if j < len(s) && (s[j] == '"' || s[j] == '\'') {
+		q := s[j]
+		end := strings.IndexByte(s[j+1:], q)
+		if end < 0 {
+			return mdInline{}, 0, false
+		}
+		title = s[j+1 : j+1+end]
+		j += end + 2
+		for j < len(s) && (s[j] == ' ' || s[j] == '\n') {
+			j++
+		}
+	}
+
+	if j >= len(s) || s[j] != ')' {
+		return mdInline{}, 0, false
+	}
+
+	return mdInline{
+		kind:     mdLink,
+		url:      unescapeMarkdown(url),
+		title:    unescapeMarkdown(title),
+		children: parseInlines(s[open+1 : closeBracket]),
+	}, j + 1, true
+}
+
+// parseEmphasis parse *em*, **strong**, ***both*** and ~~strike~~ at i
+func parseEmphasis(s string, i int) (mdInline, int, bool) {
+	c := s[i]
+	n := runLength(s, i, c)
+	after := i + n
+
+	// an opener can't be followed by whitespace, and an intraword '_' is
+	// literal
+	if after >= len(s) || isSpaceByte(s[after]) {
+		return mdInline{}, 0, false
+	}
+	if c == '_' && i > 0 && isWordByte(s[i-1]) {
+		return mdInline{}, 0, false
+	}
+
+	if c == '~' {
+		if n != 2 {
+			return mdInline{}, 0, false
+		}
+		end := findDelimiterRun(s, after, c, 2)
+		if end < 0 {
+			return mdInline{}, 0, false
+		}
+		return mdInline{kind: mdStrike, children: parseInlines(s[after:end])}, end + 2, true
+	}
+
+	if n > 3 {
+		return mdInline{}, 0, false
+	}
+
+	end := findDelimiterRun(s, after, c, n)
+	if end < 0 {
+		return mdInline{}, 0, false
+	}
+
+	inner := parseInlines(s[after:end])
+	var in mdInline
+	switch n {
+	case 1:
+		in = mdInline{kind: mdEmphasis, children: inner}
+	case 2:
+		in = mdInline{kind: mdStrong, children: inner}
+	default:
+		in = mdInline{kind: mdEmphasis, children: []mdInline{{kind: mdStrong, children: inner}}}
+	}
+	return in, end + n, true
+}
+
+// findDelimiterRun index of the closing run of n c's at or after from,
+// skipping code spans and runs that belong to another emphasis level
+func findDelimiterRun(s string, from int, c byte, n int) int {
+	for j := from; j < len(s); {
+		switch {
+		case s[j] == '\\':
+			j += 2
+			continue
+		case s[j] == '`':
+			k := runLength(s, j, '`')
+			if end := findCodeSpanEnd(s, j+k, k); end >= 0 {
+				j = end + k
+			} else {
+				j += k
+			}
+			continue
+		case s[j] == c:
+			k := runLength(s, j, c)
+			closes := j > from && !isSpaceByte(s[j-1])
+			if c == '_' && j+k < len(s) && isWordByte(s[j+k]) {
+				closes = false
+			}
+			fits := k == n || (k > n && k != 2*n && n != 2) || (n == 2 && k == 3)
+			if closes && fits {
+				return j + k - n
+			}
+			j += k
+			continue
+		}
+		j++
+	}
+	return -1
+}
+
+// findCodeSpanEnd index of the next run of exactly n backticks
+func findCodeSpanEnd(s string, from int, n int) int {
+	for j := from; j < len(s); {
+		if s[j] != '`' {
+			j++
+			continue
+		}
+		k := runLength(s, j, '`')
+		if k == n {
+			return j
+		}
+		j += k
+	}
+	return -1
+}
+
+// renderMarkdownHTML write blocks as HTML; tight drops <p> in list items
+func renderMarkdownHTML(w *strings.Builder, blocks []*mdBlock, tight bool) {
+	for _, b := range blocks {
+		switch b.kind {
+		case mdParagraph:
+			if tight {
+				renderInlinesHTML(w, b.inlines)
+				w.WriteString("\n")
+				continue
+			}
+			fmt.Fprintf(w, "<p style=\"%s\">", markdownStyles["p"])
+			renderInlinesHTML(w, b.inlines)
+			w.WriteString("</p>\n")
+		case mdHeading:
+			tag := fmt.Sprintf("h%d", b.level)
+			fmt.Fprintf(w, "<%s style=\"%s\">", tag, markdownStyles[tag])
+			renderInlinesHTML(w, b.inlines)
+			fmt.Fprintf(w, "</%s>\n", tag)
+		case mdCode:
+			fmt.Fprintf(w, "<pre style=\"%s\"><code>%s\n</code></pre>\n",
+				markdownStyles["pre"], html.EscapeString(b.text))
+		case mdQuote:
+			fmt.Fprintf(w, "<blockquote style=\"%s\">\n", markdownStyles["blockquote"])
+			renderMarkdownHTML(w, b.children, false)
+			w.WriteString("</blockquote>\n")
+		case mdList:
+			tag := "ul"
+			start := ""
+			if b.ordered {
+				tag = "ol"
+				if b.start != 1 {
+					start = fmt.Sprintf(" start=\"%d\"", b.start)
+				}
+			}
+			fmt.Fprintf(w, "<%s%s style=\"%s\">\n", tag, start, markdownStyles[tag])
+			for _, item := range b.children {
+				fmt.Fprintf(w, "<li style=\"%s\">", markdownStyles["li"])
+				renderMarkdownHTML(w, item.children, b.tight)
+				w.WriteString("</li>\n")
+			}
+			fmt.Fprintf(w, "</%s>\n", tag)
+		case mdRule:
+			fmt.Fprintf(w, "<hr style=\"%s\">\n", markdownStyles["hr"])
+		}
+	}
+}
+
+// renderInlinesHTML write inlines as escaped HTML
+func renderInlinesHTML(w *strings.Builder, inlines []mdInline) {
+	for _, in := range inlines {
+		switch in.kind {
+		case mdText:
+			w.WriteString(html.EscapeString(in.text))
+		case mdSoftBreak:
+			w.WriteString("\n")
+		case mdHardBreak:
+			w.WriteString("<br>\n")
+		case mdCodeSpan:
+			fmt.Fprintf(w, "<code style=\"%s\">%s</code>", markdownStyles["code"], html.EscapeString(in.text))
+		case mdEmphasis:
+			w.WriteString("<em>")
+			renderInlinesHTML(w, in.children)
+			w.WriteString("</em>")
+		case mdStrong:
+			w.WriteString("<strong>")
+			renderInlinesHTML(w, in.children)
+			w.WriteString("</strong>")
+		case mdStrike:
+			w.WriteString("<del>")
+			renderInlinesHTML(w, in.children)
+			w.WriteString("</del>")
+		case mdLink:
+			fmt.Fprintf(w, "<a href=\"%s\"", html.EscapeString(safeMarkdownURL(in.url)))
+			if in.title != "" {
+				fmt.Fprintf(w, " title=\"%s\"", html.EscapeString(in.title))
+			}
+			fmt.Fprintf(w, " style=\"%s\">", markdownStyles["a"])
+			renderInlinesHTML(w, in.children)
+			w.WriteString("</a>")
+		case mdImage:
+			fmt.Fprintf(w, "<img src=\"%s\" alt=\"%s\"", html.EscapeString(safeMarkdownURL(in.url)),
+				html.EscapeString(inlinesText(in.children)))
+			if in.title != "" {
+				fmt.Fprintf(w, " title=\"%s\"", html.EscapeString(in.title))
+			}
+			fmt.Fprintf(w, " style=\"%s\">", markdownStyles["img"])
+		}
+	}
+}
+
+// mdTextRenderer plain text renderer; link targets become footnotes
+type mdTextRenderer struct {
+	notes textNotes
+}
+
+// blocks render blocks as lines separated by blank lines
+func (r *mdTextRenderer) blocks(blocks []*mdBlock) []string {
+	var lines []string
+	for i, b := range blocks {
+		if i > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, r.block(b)...)
+	}
+	return lines
+}
+
+// block render one block as lines
+func (r *mdTextRenderer) block(b *mdBlock) []string {
+	switch b.kind {
+	case mdParagraph:
+		return strings.Split(r.inlines(b.inlines), "\n")
+	case mdHeading:
+		text := strings.ReplaceAll(r.inlines(b.inlines), "\n", " ")
+		switch b.level {
+		case 1:
+			return []string{text, strings.Repeat("=", utf8.RuneCountInString(text))}
+		case 2:
+			return []string{text, strings.Repeat("-", utf8.RuneCountInString(text))}
+		}
+		return []string{text}
+	case mdCode:
+		var lines []string
+		for _, l := range strings.Split(b.text, "\n") {
+			lines = append(lines, strings.TrimRight("    "+l, " "))
+		}
+		return lines
+	case mdQuote:
+		var lines []string
+		for _, l := range r.blocks(b.children) {
+			lines = append(lines, strings.TrimRight("> "+l, " "))
+		}
+		return lines
+	case mdList:
+		var lines []string
+		for n, item := range b.children {
+			if n > 0 && !b.tight {
+				lines = append(lines, "")
+			}
+			marker := "- "
+			if b.ordered {
+				marker = fmt.Sprintf("%d. ", b.start+n)
+			}
+			pad := strings.Repeat(" ", len(marker))
+
+			var content []string
+			if b.tight {
+				for _, c := range item.children {
+					content = append(content, r.block(c)...)
+				}
+			} else {
+				content = r.blocks(item.children)
+			}
+			if len(content) == 0 {
+				content = []string{""}
+			}
+			for k, l := range content {
+				switch {
+				case k == 0:
+					lines = append(lines, strings.TrimRight(marker+l, " "))
+				case l == "":
+					lines = append(lines, "")
+				default:
+					lines = append(lines, pad+l)
+				}
+			}
+		}
+		return lines
+	case mdRule:
+		return []string{strings.Repeat("-", 40)}
+	}
+	return nil
+}
+
+// inlines render inlines as text
+func (r *mdTextRenderer) inlines(inlines []mdInline) string {
+	var w strings.Builder
+	for _, in := range inlines {
+		switch in.kind {
+		case mdText, mdCodeSpan:
+			w.WriteString(in.text)
+		case mdSoftBreak, mdHardBreak:
+			w.WriteString("\n")
+		case mdEmphasis:
+			w.WriteString("_" + r.inlines(in.children) + "_")
+		case mdStrong:
+			w.WriteString("*" + r.inlines(in.children) + "*")
+		case mdStrike:
+			w.WriteString(r.inlines(in.children))
+		case mdLink:
+			text := r.inlines(in.children)
+			if text == in.url || "mailto:"+text == in.url || safeMarkdownURL(in.url) != in.url {
+				w.WriteString(text)
+				continue
+			}
+			fmt.Fprintf(&w, "%s [%d]", text, r.notes.add(in.url))
+		case mdImage:
+			alt := inlinesText(in.children)
+			if alt == "" {
+				alt = "image"
+			}
+			fmt.Fprintf(&w, "[%s] [%d]", alt, r.notes.add(in.url))
+		}
+	}
+	return w.String()
+}
+
+// textNotes numbered link footnotes of a plain text version
+type textNotes []string
+
+// add footnote number of url, reusing an earlier one for the same url
+func (notes *textNotes) add(url string) int {
+	for i, n := range *notes {
+		if n == url {
+			return i + 1
+		}
+	}
+	*notes = append(*notes, url)
+	return len(*notes)
+}
+
+// lines footnote list, after a blank line; none without notes
+func (notes textNotes) lines() []string {
+	if len(notes) == 0 {
+		return nil
+	}
+	lines := []string{""}
+	for i, n := range notes {
+		lines = append(lines, fmt.Sprintf("[%d] %s", i+1, n))
+	}
+	return lines
+}
+
+// inlinesText text of inlines without any markup
+func inlinesText(inlines []mdInline) string {
+	var w strings.Builder
+	for _, in := range inlines {
+		switch in.kind {
+		case mdText, mdCodeSpan:
+			w.WriteString(in.text)
+		case mdSoftBreak, mdHardBreak:
+			w.WriteString(" ")
+		default:
+			w.WriteString(inlinesText(in.children))
+		}
+	}
+	return w.String()
+}
+
+// safeMarkdownURL drop javascript: and other active URL schemes
+func safeMarkdownURL(url string) string {
+	if mdSafeURL.MatchString(strings.TrimSpace(url)) {
+		return url
+	}
+	return "#"
+}
+
+// unescapeMarkdown drop backslash escapes from s
+func unescapeMarkdown(s string) string {
+	var w strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(mdPunctuation, s[i+1]) >= 0 {
+			i++
+		}
+		w.WriteByte(s[i])
+	}
+	return w.String()
+}
+
+// expandTabs replace tabs with spaces up to the next 4-column stop
+func expandTabs(s string) string {
+	if strings.IndexByte(s, '\t') < 0 {
+		return s
+	}
+	var w strings.Builder
+	col := 0
+	for _, r := range s {
+		if r == '\t' {
+			n := 4 - col%4
+			w.WriteString(strings.Repeat(" ", n))
+			col += n
+			continue
+		}
+		w.WriteRune(r)
+		col++
+	}
+	return w.String()
+}
+
+// stripIndent remove up to n leading spaces
+func stripIndent(s string, n int) string {
+	k := leadingSpaces(s)
+	if k > n {
+		k = n
+	}
+	return s[k:]
+}
+
+func leadingSpaces(s string) int {
+	return len(s) - len(strings.TrimLeft(s, " "))
+}
+
+func isBlankLine(s string) bool {
+	return strings.TrimSpace(s) == ""
+}
+
+func runLength(s string, i int, c byte) int {
+	n := 0
+	for i+n < len(s) && s[i+n] == c {
+		n++
+	}
+	return n
+}
+
+func isSpaceByte(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\t'
+}
+
+func isWordByte(c byte) bool {
+	return c >= utf8.RuneSelf || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
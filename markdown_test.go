@@ -0,0 +1,98 @@
+package mailer_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/thiagozs/mailer-go"
+)
+
+const markdownSample = `# Welcome *Ana*
+
+Your order is **ready**. See [the details](https://example.com/orders/1)
+or visit <https://example.com>.
+
+- Item one
+- Item two
+  1. first
+  2. second
+- Item three, again [the details](https://example.com/orders/1)
+
+> Thanks for buying with us.
+
+    code sample
+
+<script>alert(1)</script> [bad](javascript:alert(1))
+`
+
+func TestRenderMarkdownHTML(t *testing.T) {
+	t.Log("RenderMarkdown(html)... (NOT expected some err)")
+	html, _ := mailer.RenderMarkdown(markdownSample)
+
+	expected := []string{
+		"<!DOCTYPE html>",
+		"<h1 style=",
+		">Welcome <em>Ana</em></h1>",
+		"<strong>ready</strong>",
+		`<a href="https://example.com/orders/1" style=`,
+		">the details</a>",
+		"<ul style=",
+		"<ol style=",
+		"<li style=\"margin:0 0 4px;\">first\n</li>",
+		"<blockquote style=",
+		"<code>code sample\n</code></pre>",
+		"&lt;script&gt;alert(1)&lt;/script&gt;",
+		`<a href="#" style=`,
+	}
+	for _, e := range expected {
+		if !strings.Contains(html, e) {
+			t.Errorf("RenderMarkdown html missing %q in:\n%s", e, html)
+		}
+	}
+}
+
+func TestRenderMarkdownText(t *testing.T) {
+	t.Log("RenderMarkdown(text)... (NOT expected some err)")
+	_, text := mailer.RenderMarkdown(markdownSample)
+
+	expected := `Welcome _Ana_
+=============
+
+Your order is *ready*. See the details [1]
+or visit https://example.com.
+
+- Item one
+- Item two
+  1. first
+  2. second
+- Item three, again the details [1]
+
+> Thanks for buying with us.
+
+    code sample
+
+<script>alert(1)</script> bad
+
+[1] https://example.com/orders/1
+`
+	if text != expected {
+		t.Errorf("RenderMarkdown text got:\n%s", text)
+	}
+}
+
+func TestConfigFromMarkdown(t *testing.T) {
+	t.Log("CheckIsEmptyCfg with ContentMarkdown... (NOT expected some err)")
+	sg := mailer.NewMailerSendGrid("")
+	sg.ConfigEmail = mailer.ConfigEmailSendgrid{
+		ContentMarkdown: "**test**",
+		EmailFrom:       "sender@host.com",
+		EmailFromName:   "Sender name",
+		EmailTo:         "client@host.com",
+		EmailToName:     "Client name",
+		Subject:         "Test",
+	}
+
+	if mailer.CheckIsEmptyCfg(sg) {
+		t.Errorf("CheckIsEmptyCfg(Sendgrid) with markdown got: empty")
+	}
+}
@@ -0,0 +1,337 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// headerField one header line of a message
+type headerField struct {
+	key   string
+	value string
+}
+
+// mimeHeader header fields in the order they are written
+type mimeHeader []headerField
+
+// set replace the first field named key, or append it
+func (h *mimeHeader) set(key, value string) {
+	for i, f := range *h {
+		if strings.EqualFold(f.key, key) {
+			(*h)[i].value = value
+			return
+		}
+	}
+	h.add(key, value)
+}
+
+// add append a field, keeping existing ones with the same name
+func (h *mimeHeader) add(key, value string) {
+	*h = append(*h, headerField{key, value})
+}
+
+// get value of the first field named key
+func (h mimeHeader) get(key string) string {
+	for _, f := range h {
+		if strings.EqualFold(f.key, key) {
+			return f.value
+		}
+	}
+	return ""
+}
+
+// del remove every field named key
+func (h *mimeHeader) del(key string) {
+	out := (*h)[:0]
+	for _, f := range *h {
+		if !strings.EqualFold(f.key, key) {
+			out = append(out, f)
+		}
+	}
+	*h = out
+}
+
+// writeTo write the fields with CRLF line endings
+func (h mimeHeader) writeTo(b *bytes.Buffer) {
+	for _, f := range h {
+		b.WriteString(f.key)
+		b.WriteString(": ")
+		b.WriteString(f.value)
+		b.WriteString("\r\n")
+	}
+}
+
+// mimePart MIME entity: its content headers and either an encoded body or
+// the sub parts of a multipart
+type mimePart struct {
+	header mimeHeader
+	body   []byte
+	parts  []*mimePart
+}
+
+// newTextPart text/plain or text/html part, quoted-printable in UTF-8
+func newTextPart(subtype, text string) *mimePart {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	var b bytes.Buffer
+	qp := quotedprintable.NewWriter(&b)
+	qp.Write([]byte(text))
+	qp.Close()
+
+	p := &mimePart{body: b.Bytes()}
+	p.header.set("Content-Type", fmt.Sprintf("text/%s; charset=\"UTF-8\"", subtype))
+	p.header.set("Content-Transfer-Encoding", "quoted-printable")
+	return p
+}
+
+// newBase64Part part of the given content type holding data, base64
+// encoded in 76 column lines
+func newBase64Part(contentType string, data []byte) *mimePart {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var b bytes.Buffer
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded + "\r\n")
+
+	p := &mimePart{body: b.Bytes()}
+	p.header.set("Content-Type", contentType)
+	p.header.set("Content-Transfer-Encoding", "base64")
+	return p
+}
+
+// newMultipart multipart/<subtype> part holding parts
+func newMultipart(subtype string, parts ...*mimePart) *mimePart {
+	p := &mimePart{parts: parts}
+	p.header.set("Content-Type", fmt.Sprintf("multipart/%s; boundary=\"%s\"", subtype, newBoundary()))
+	return p
+}
+
+// boundary boundary parameter of a multipart
+func (p *mimePart) boundary() string {
+	_, params, err := mime.ParseMediaType(p.header.get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return params["boundary"]
+}
+
+// bytes serialize the entity: content headers, blank line and body
+func (p *mimePart) bytes() []byte {
+	var b bytes.Buffer
+	p.header.writeTo(&b)
+	b.WriteString("\r\n")
+	p.writeBody(&b)
+	return b.Bytes()
+}
+
+// writeBody write the body, or the boundary delimited sub parts
+func (p *mimePart) writeBody(b *bytes.Buffer) {
+	if len(p.parts) == 0 {
+		b.Write(p.body)
+		return
+	}
+	boundary := p.boundary()
+	for _, sub := range p.parts {
+		b.WriteString("--" + boundary + "\r\n")
+		b.Write(sub.bytes())
+		b.WriteString("\r\n")
+	}
+	b.WriteString("--" + boundary + "--\r\n")
+}
+
+// Attachment file sent along with the message
+type Attachment struct {
+	Filename    string
+	ContentType string // guessed from the file name when empty
+	Data        []byte
+}
+
+// NewAttachment attachment with the contents of the file at path
+func NewAttachment(path string) (Attachment, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Attachment{}, err
+	}
+	return Attachment{Filename: filepath.Base(path), Data: data}, nil
+}
+
+// rawMessage message headers and root entity, serialized for SMTP DATA
+type rawMessage struct {
+	header mimeHeader
+	body   *mimePart
+}
+
+// newRawMessage message with the usual From, To, Subject, Date and
+// Message-ID headers
+func newRawMessage(from, to, subject string) *rawMessage {
+	m := &rawMessage{}
+	m.header.set("From", formatAddress(from))
+	m.header.set("To", formatAddress(to))
+	m.header.set("Subject", mime.QEncoding.Encode("UTF-8", subject))
+	m.header.set("Date", time.Now().Format(time.RFC1123Z))
+	m.header.set("Message-ID", newMessageID(from))
+	m.header.set("MIME-Version", "1.0")
+	return m
+}
+
+// setContent text, HTML or multipart/alternative body, whichever applies
+func (m *rawMessage) setContent(text, html string) {
+	switch {
+	case text != "" && html != "":
+		m.body = newMultipart("alternative", newTextPart("plain", text), newTextPart("html", html))
+	case html != "":
+		m.body = newTextPart("html", html)
+	default:
+		m.body = newTextPart("plain", text)
+	}
+}
+
+// structuralHeaders header fields built by the message itself, refused as
+// custom headers
+var structuralHeaders = []string{
+	"Bcc", "Cc", "Content-Disposition", "Content-Transfer-Encoding", "Content-Type",
+	"Date", "DKIM-Signature", "From", "Message-ID", "MIME-Version", "Reply-To",
+	"Return-Path", "Sender", "Subject", "To",
+}
+
+// isHeaderName report whether name is a field name of RFC 5322, printable
+// ASCII without colon
+func isHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c < 33 || c > 126 || c == ':' {
+			return false
+		}
+	}
+	return true
+}
+
+// setHeaders set custom header fields, sorted by name; non-ASCII values are
+// encoded. Invalid names and the structural headers of the message are
+// refused before any field is set
+func (m *rawMessage) setHeaders(headers map[string]string) error {
+	keys := sortedKeys(headers)
+	for _, k := range keys {
+		if !isHeaderName(k) {
+			return fmt.Errorf("Invalid header name %q", k)
+		}
+		for _, h := range structuralHeaders {
+			if strings.EqualFold(k, h) {
+				return fmt.Errorf("Header %s can't be set as a custom header", k)
+			}
+		}
+	}
+	for _, k := range keys {
+		m.header.set(k, mime.QEncoding.Encode("UTF-8", headers[k]))
+	}
+	return nil
+}
+
+// addAttachments wrap the body in a multipart/mixed with the attachments
+func (m *rawMessage) addAttachments(attachments []Attachment) {
+	if len(attachments) == 0 {
+		return
+	}
+	parts := []*mimePart{m.body}
+	for _, a := range attachments {
+		contentType := a.ContentType
+		if contentType == "" {
+			contentType = mime.TypeByExtension(filepath.Ext(a.Filename))
+		}
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		name := mime.QEncoding.Encode("UTF-8", a.Filename)
+		p := newBase64Part(fmt.Sprintf("%s; name=\"%s\"", contentType, name), a.Data)
+		p.header.set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", name))
+		parts = append(parts, p)
+	}
+	m.body = newMultipart("mixed", parts...)
+}
+
+// bytes serialize headers and body with CRLF line endings
+func (m *rawMessage) bytes() []byte {
+	var b bytes.Buffer
+	m.header.writeTo(&b)
+	if m.body != nil {
+		b.Write(m.body.bytes())
+	} else {
+		b.WriteString("\r\n")
+	}
+	return b.Bytes()
+}
+
+// formatAddress RFC 5322 form of addr, encoding a non-ASCII display name
+func formatAddress(addr string) string {
+	a, err := mail.ParseAddress(addr)
+	if err != nil {
+		return addr
+	}
+	return a.String()
+}
+
+// addressDomain domain part of an address, "localhost" if there is none
+func addressDomain(addr string) string {
+	if a, err := mail.ParseAddress(addr); err == nil {
+		addr = a.Address
+	}
+	if i := strings.LastIndex(addr, "@"); i >= 0 && i < len(addr)-1 {
+		return addr[i+1:]
+	}
+	return "localhost"
+}
+
+// newMessageID unique Message-ID in the domain of from
+func newMessageID(from string) string {
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), randomHex(8), addressDomain(from))
+}
+
+// newBoundary random multipart boundary
+func newBoundary() string {
+	return "mailer-" + randomHex(12)
+}
+
+// randomHex n random bytes, hex encoded
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// parseRawMessage split a message into its header and body entity; the
+// Content-* fields belong to the entity
+func parseRawMessage(message []byte) *rawMessage {
+	fields, body := splitDKIMMessage(toCRLF(message))
+
+	m := &rawMessage{body: &mimePart{body: body}}
+	for _, f := range fields {
+		i := strings.IndexByte(f, ':')
+		if i < 0 {
+			continue
+		}
+		key, value := f[:i], strings.TrimLeft(f[i+1:], " \t")
+		if strings.HasPrefix(strings.ToLower(key), "content-") {
+			m.body.header.add(key, value)
+			continue
+		}
+		m.header.add(key, value)
+	}
+	if m.body.header.get("Content-Type") == "" {
+		m.body.header.set("Content-Type", "text/plain; charset=\"us-ascii\"")
+	}
+	return m
+}
@@ -0,0 +1,1588 @@
+package mailer
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"compress/zlib"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"mime"
+	"strings"
+	"time"
+)
+
+// OpenPGP (RFC 4880) subset behind PGP/MIME: v4 RSA, Ed25519 and
+// Curve25519 (RFC 6637, as generated by GnuPG) keys read from armored
+// keyrings, detached signatures, and encryption in SEIPD packets with MDC.
+
+const (
+	pgpTagPKESK        = 1
+	pgpTagSignature    = 2
+	pgpTagOnePassSig   = 4
+	pgpTagSecretKey    = 5
+	pgpTagPublicKey    = 6
+	pgpTagSecretSubkey = 7
+	pgpTagCompressed   = 8
+	pgpTagLiteral      = 11
+	pgpTagUserID       = 13
+	pgpTagPublicSubkey = 14
+	pgpTagSEIPD        = 18
+)
+
+const (
+	pgpAlgoRSA        = 1
+	pgpAlgoRSAEncrypt = 2
+	pgpAlgoRSASign    = 3
+	pgpAlgoECDH       = 18
+	pgpAlgoEdDSA      = 22
+)
+
+const (
+	pgpKeyFlagSign    = 0x02
+	pgpKeyFlagEncrypt = 0x0c
+)
+
+const (
+	pgpCipherAES128 = 7
+	pgpCipherAES192 = 8
+	pgpCipherAES256 = 9
+	pgpHashSHA256   = 8
+)
+
+var (
+	pgpOIDEd25519    = []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0xda, 0x47, 0x0f, 0x01}
+	pgpOIDCurve25519 = []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0x97, 0x55, 0x01, 0x05, 0x01}
+)
+
+// PGPKey OpenPGP key: primary key, subkeys and user IDs
+type PGPKey struct {
+	UserIDs []string
+	primary *pgpKeyPacket
+	subkeys []*pgpKeyPacket
+}
+
+// PGPKeyring keys of an armored keyring file
+type PGPKeyring []*PGPKey
+
+// PGPOptions PGP/MIME settings of a sender: messages are signed when
+// Signer is set (its secret key unlocked), and encrypted when Recipients are
+type PGPOptions struct {
+	Signer     *PGPKey
+	Recipients []*PGPKey
+}
+
+// pgpKeyPacket public or secret (sub)key packet
+type pgpKeyPacket struct {
+	algo        byte
+	public      []byte // public key packet body
+	fingerprint []byte
+	flags       byte // key flags of the self signature, 0 when unknown
+
+	rsaPub    *rsa.PublicKey
+	edPub     ed25519.PublicKey
+	xPub      []byte
+	curveOID  []byte
+	kdfHash   byte
+	kdfCipher byte
+
+	secret  []byte // secret part of a secret key packet, as stored
+	rsaPriv *rsa.PrivateKey
+	edPriv  ed25519.PrivateKey
+	xPriv   []byte
+}
+
+// pgpPacket tag and body of a packet
+type pgpPacket struct {
+	tag  int
+	body []byte
+}
+
+// NewPGPOptions load keyringFile, unlock the key of signer (a user ID
+// email, key ID or fingerprint; none when empty) with passphrase, and pick
+// the keys of recipients
+func NewPGPOptions(keyringFile, passphrase, signer string, recipients ...string) (*PGPOptions, error) {
+	keyring, err := LoadPGPKeyring(keyringFile)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &PGPOptions{}
+	if signer != "" {
+		if opts.Signer = keyring.Find(signer); opts.Signer == nil {
+			return nil, fmt.Errorf("No PGP key for %s", signer)
+		}
+		if err := opts.Signer.Unlock(passphrase); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range recipients {
+		key := keyring.Find(r)
+		if key == nil {
+			return nil, fmt.Errorf("No PGP key for %s", r)
+		}
+		opts.Recipients = append(opts.Recipients, key)
+	}
+	return opts, nil
+}
+
+// LoadPGPKeyring read the keys of an armored (or binary) keyring file
+func LoadPGPKeyring(path string) (PGPKeyring, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ReadPGPKeyring(data)
+}
+
+// ReadPGPKeyring parse the keys of armored (or binary) keyring data
+func ReadPGPKeyring(data []byte) (PGPKeyring, error) {
+	var raw [][]byte
+	if bytes.Contains(data, []byte("-----BEGIN PGP ")) {
+		for len(data) > 0 {
+			_, block, rest, err := pgpDearmor(data)
+			if err != nil {
+				if len(raw) > 0 {
+					break
+				}
+				return nil, err
+			}
+			raw = append(raw, block)
+			data = rest
+		}
+	} else {
+		raw = append(raw, data)
+	}
+
+	var keyring PGPKeyring
+	for _, block := range raw {
+		packets, err := readPGPPackets(block)
+		if err != nil {
+			return nil, err
+		}
+
+		var key *PGPKey
+		var last *pgpKeyPacket
+		for _, p := range packets {
+			switch p.tag {
+			case pgpTagPublicKey, pgpTagSecretKey:
+				k, err := parsePGPKeyPacket(p.body, p.tag == pgpTagSecretKey)
+				if err != nil {
+					return nil, err
+				}
+				key = &PGPKey{primary: k}
+				keyring = append(keyring, key)
+				last = k
+			case pgpTagPublicSubkey, pgpTagSecretSubkey:
+				if key == nil {
+					return nil, fmt.Errorf("PGP subkey without a primary key")
+				}
+				k, err := parsePGPKeyPacket(p.body, p.tag == pgpTagSecretSubkey)
+				if err != nil {
+					return nil, err
+				}
+				key.subkeys = append(key.subkeys, k)
+				last = k
+			case pgpTagUserID:
+				if key != nil {
+					key.UserIDs = append(key.UserIDs, string(p.body))
+				}
+			case pgpTagSignature:
+				// self signatures and subkey bindings carry the key flags
+				if last == nil {
+					continue
+				}
+				sig, err := parsePGPSignature(p.body)
+				if err == nil && sig.hasFlags && bytes.Equal(sig.issuer, key.primary.keyID()) {
+					last.flags |= sig.flags
+				}
+			}
+		}
+	}
+
+	if len(keyring) == 0 {
+		return nil, fmt.Errorf("No PGP key in keyring")
+	}
+	return keyring, nil
+}
+
+// Find key with a user ID holding query (an email), or with query as key
+// ID or fingerprint
+func (kr PGPKeyring) Find(query string) *PGPKey {
+	q := strings.ToLower(strings.TrimPrefix(strings.ReplaceAll(query, " ", ""), "0x"))
+	for _, k := range kr {
+		if fp := strings.ToLower(k.Fingerprint()); q == fp || (len(q) >= 8 && strings.HasSuffix(fp, q)) {
+			return k
+		}
+		for _, sub := range k.subkeys {
+			if fp := strings.ToLower(hex.EncodeToString(sub.fingerprint)); len(q) >= 8 && strings.HasSuffix(fp, q) {
+				return k
+			}
+		}
+	}
+	for _, k := range kr {
+		for _, uid := range k.UserIDs {
+			uid = strings.ToLower(uid)
+			if uid == q || strings.Contains(uid, "<"+q+">") {
+				return k
+			}
+		}
+	}
+	return nil
+}
+
+// Fingerprint hex fingerprint of the primary key
+func (k *PGPKey) Fingerprint() string {
+	return strings.ToUpper(hex.EncodeToString(k.primary.fingerprint))
+}
+
+// KeyID hex key ID of the primary key
+func (k *PGPKey) KeyID() string {
+	return strings.ToUpper(hex.EncodeToString(k.primary.keyID()))
+}
+
+// Unlock decrypt the secret keys with passphrase
+func (k *PGPKey) Unlock(passphrase string) error {
+	found := false
+	for _, p := range append([]*pgpKeyPacket{k.primary}, k.subkeys...) {
+		if p.secret == nil {
+			continue
+		}
+		if err := p.unlock(passphrase); err != nil {
+			return err
+		}
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("PGP key %s has no secret key", k.KeyID())
+	}
+	return nil
+}
+
+// signingKey (sub)key used to sign
+func (k *PGPKey) signingKey() *pgpKeyPacket {
+	for _, p := range append([]*pgpKeyPacket{k.primary}, k.subkeys...) {
+		if p.canSign() && p.hasSecret() && (p.flags == 0 || p.flags&pgpKeyFlagSign != 0) {
+			return p
+		}
+	}
+	return nil
+}
+
+// encryptionKey (sub)key messages are encrypted to; the newest subkey wins
+func (k *PGPKey) encryptionKey() *pgpKeyPacket {
+	for i := len(k.subkeys) - 1; i >= 0; i-- {
+		p := k.subkeys[i]
+		if p.canEncrypt() && (p.flags == 0 || p.flags&pgpKeyFlagEncrypt != 0) {
+			return p
+		}
+	}
+	if p := k.primary; p.canEncrypt() && (p.flags == 0 || p.flags&pgpKeyFlagEncrypt != 0) {
+		return p
+	}
+	return nil
+}
+
+// findKey (sub)key with the given key ID
+func (kr PGPKeyring) findKey(keyID []byte) (*PGPKey, *pgpKeyPacket) {
+	for _, k := range kr {
+		for _, p := range append([]*pgpKeyPacket{k.primary}, k.subkeys...) {
+			if bytes.Equal(p.keyID(), keyID) {
+				return k, p
+			}
+		}
+	}
+	return nil, nil
+}
+
+// ApplyPGP sign and/or encrypt a raw message as PGP/MIME (RFC 3156): its
+// Content-* header fields and body become the protected entity, the other
+// fields are kept
+func ApplyPGP(message []byte, opts *PGPOptions) ([]byte, error) {
+	m := parseRawMessage(message)
+	if err := m.applyPGP(opts); err != nil {
+		return nil, err
+	}
+	return m.bytes(), nil
+}
+
+// applyPGP sign and/or encrypt the body of the message
+func (m *rawMessage) applyPGP(opts *PGPOptions) error {
+	if opts == nil || m.body == nil {
+		return nil
+	}
+	if opts.Signer != nil {
+		signed, err := pgpSignPart(m.body, opts.Signer)
+		if err != nil {
+			return err
+		}
+		m.body = signed
+	}
+	if len(opts.Recipients) > 0 {
+		encrypted, err := pgpEncryptPart(m.body, opts.Recipients)
+		if err != nil {
+			return err
+		}
+		m.body = encrypted
+	}
+	return nil
+}
+
+// pgpSignPart multipart/signed holding part and its armored signature
+func pgpSignPart(part *mimePart, signer *PGPKey) (*mimePart, error) {
+	sig, err := pgpSign(signer.signingKey(), part.bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	sigPart := &mimePart{body: pgpArmor("SIGNATURE", sig)}
+	sigPart.header.set("Content-Type", "application/pgp-signature; name=\"signature.asc\"")
+	sigPart.header.set("Content-Description", "OpenPGP digital signature")
+	sigPart.header.set("Content-Disposition", "attachment; filename=\"signature.asc\"")
+
+	signed := &mimePart{parts: []*mimePart{part, sigPart}}
+	signed.header.set("Content-Type", fmt.Sprintf("multipart/signed; micalg=pgp-sha256; protocol=\"application/pgp-signature\"; boundary=\"%s\"", newBoundary()))
+	return signed, nil
+}
+
+// pgpEncryptPart multipart/encrypted holding part encrypted to recipients
+func pgpEncryptPart(part *mimePart, recipients []*PGPKey) (*mimePart, error) {
+	data, err := pgpEncrypt(part.bytes(), recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	control := &mimePart{body: []byte("Version: 1\r\n")}
+	control.header.set("Content-Type", "application/pgp-encrypted")
+	control.header.set("Content-Description", "PGP/MIME version identification")
+
+	payload := &mimePart{body: pgpArmor("MESSAGE", data)}
+	payload.header.set("Content-Type", "application/octet-stream; name=\"encrypted.asc\"")
+	payload.header.set("Content-Description", "OpenPGP encrypted message")
+	payload.header.set("Content-Disposition", "inline; filename=\"encrypted.asc\"")
+
+	encrypted := &mimePart{parts: []*mimePart{control, payload}}
+	encrypted.header.set("Content-Type", fmt.Sprintf("multipart/encrypted; protocol=\"application/pgp-encrypted\"; boundary=\"%s\"", newBoundary()))
+	return encrypted, nil
+}
+
+// VerifyPGP check the multipart/signed PGP/MIME message or entity against
+// the keys of keyring and return the signer
+func VerifyPGP(message []byte, keyring PGPKeyring) (*PGPKey, error) {
+	header, body, err := splitMIMEEntity(message)
+	if err != nil {
+		return nil, err
+	}
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/signed" {
+		return nil, fmt.Errorf("Not a multipart/signed message")
+	}
+	if strings.ToLower(params["protocol"]) != "application/pgp-signature" {
+		return nil, fmt.Errorf("Unsupported signature protocol %q", params["protocol"])
+	}
+
+	parts := splitMultipartRaw(body, params["boundary"])
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("multipart/signed must have two parts, got %d", len(parts))
+	}
+	sigHeader, sigBody, err := splitMIMEEntity(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	armored, err := decodeTransferEncoding(sigHeader.Get("Content-Transfer-Encoding"), sigBody)
+	if err != nil {
+		return nil, err
+	}
+	_, sig, _, err := pgpDearmor(armored)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgpVerify(sig, parts[0], keyring)
+}
+
+// DecryptPGP decrypt a multipart/encrypted PGP/MIME message or entity with
+// the unlocked secret keys of keyring; it returns the enclosed MIME entity
+func DecryptPGP(message []byte, keyring PGPKeyring) ([]byte, error) {
+	header, body, err := splitMIMEEntity(message)
+	if err != nil {
+		return nil, err
+	}
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/encrypted" {
+		return nil, fmt.Errorf("Not a multipart/encrypted message")
+	}
+	if strings.ToLower(params["protocol"]) != "application/pgp-encrypted" {
+		return nil, fmt.Errorf("Unsupported encryption protocol %q", params["protocol"])
+	}
+
+	parts := splitMultipartRaw(body, params["boundary"])
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("multipart/encrypted must have two parts, got %d", len(parts))
+	}
+	dataHeader, dataBody, err := splitMIMEEntity(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	armored, err := decodeTransferEncoding(dataHeader.Get("Content-Transfer-Encoding"), dataBody)
+	if err != nil {
+		return nil, err
+	}
+	_, data, _, err := pgpDearmor(armored)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgpDecrypt(data, keyring)
+}
+
+// parsePGPKeyPacket parse a v4 key packet body
+func parsePGPKeyPacket(body []byte, secret bool) (*pgpKeyPacket, error) {
+	if len(body) < 6 || body[0] != 4 {
+		return nil, fmt.Errorf("Unsupported PGP key packet version")
+	}
+	k := &pgpKeyPacket{algo: body[5]}
+	rest := body[6:]
+	var err error
+
+	switch k.algo {
+	case pgpAlgoRSA, pgpAlgoRSAEncrypt, pgpAlgoRSASign:
+		var n, e []byte
+		if n, rest, err = readPGPMPI(rest); err != nil {
+			return nil, err
+		}
+		if e, rest, err = readPGPMPI(rest); err != nil {
+			return nil, err
+		}
+		k.rsaPub = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+
+	case pgpAlgoEdDSA, pgpAlgoECDH:
+		if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+			return nil, fmt.Errorf("Invalid PGP curve OID")
+		}
+		k.curveOID = rest[1 : 1+rest[0]]
+		rest = rest[1+rest[0]:]
+		var q []byte
+		if q, rest, err = readPGPMPI(rest); err != nil {
+			return nil, err
+		}
+		if len(q) != 33 || q[0] != 0x40 {
+			return nil, fmt.Errorf("Unsupported PGP curve point")
+		}
+		if k.algo == pgpAlgoEdDSA {
+			if !bytes.Equal(k.curveOID, pgpOIDEd25519) {
+				return nil, fmt.Errorf("Unsupported PGP signing curve")
+			}
+			k.edPub = ed25519.PublicKey(q[1:])
+		} else {
+			if !bytes.Equal(k.curveOID, pgpOIDCurve25519) {
+				return nil, fmt.Errorf("Unsupported PGP encryption curve")
+			}
+			if len(rest) < 4 || rest[0] != 3 || rest[1] != 1 {
+				return nil, fmt.Errorf("Invalid PGP ECDH parameters")
+			}
+			k.xPub = q[1:]
+			k.kdfHash, k.kdfCipher = rest[2], rest[3]
+			rest = rest[4:]
+		}
+
+	default:
+		// kept so the rest of the keyring still loads, but unusable
+		k.public = body
+		k.fingerprint = pgpFingerprint(body)
+		return k, nil
+	}
+
+	k.public = body[:len(body)-len(rest)]
+	k.fingerprint = pgpFingerprint(k.public)
+	if secret {
+		k.secret = rest
+		if len(rest) > 0 && rest[0] == 0 {
+			if err := k.unlock(""); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return k, nil
+}
+
+// pgpFingerprint v4 fingerprint of a public key packet body
+func pgpFingerprint(public []byte) []byte {
+	h := sha1.New()
+	h.Write([]byte{0x99, byte(len(public) >> 8), byte(len(public))})
+	h.Write(public)
+	return h.Sum(nil)
+}
+
+// keyID low 64 bits of the fingerprint
+func (k *pgpKeyPacket) keyID() []byte {
+	return k.fingerprint[len(k.fingerprint)-8:]
+}
+
+func (k *pgpKeyPacket) canSign() bool {
+	return (k.algo == pgpAlgoRSA || k.algo == pgpAlgoRSASign) && k.rsaPub != nil || k.algo == pgpAlgoEdDSA && k.edPub != nil
+}
+
+func (k *pgpKeyPacket) canEncrypt() bool {
+	return (k.algo == pgpAlgoRSA || k.algo == pgpAlgoRSAEncrypt) && k.rsaPub != nil || k.algo == pgpAlgoECDH && k.xPub != nil
+}
+
+func (k *pgpKeyPacket) hasSecret() bool {
+	return k.rsaPriv != nil || k.edPriv != nil || k.xPriv != nil
+}
+
+// unlock decrypt and parse the secret key material
+func (k *pgpKeyPacket) unlock(passphrase string) error {
+	if k.hasSecret() {
+		return nil
+	}
+	s := k.secret
+	if len(s) == 0 {
+		return fmt.Errorf("PGP key has no secret part")
+	}
+
+	usage := s[0]
+	s = s[1:]
+	var material []byte
+
+	switch usage {
+	case 0:
+		if len(s) < 2 {
+			return fmt.Errorf("Invalid PGP secret key")
+		}
+		material = s[:len(s)-2]
+		if pgpChecksum(material) != binary.BigEndian.Uint16(s[len(s)-2:]) {
+			return fmt.Errorf("PGP secret key checksum mismatch")
+		}
+
+	case 254, 255:
+		if len(s) < 2 {
+			return fmt.Errorf("Invalid PGP secret key")
+		}
+		cipherAlgo := s[0]
+		if s[1] == 101 {
+			return fmt.Errorf("PGP secret key is not available (stub)")
+		}
+		key, rest, err := pgpS2K(s[1:], passphrase, cipherAlgo)
+		if err != nil {
+			return err
+		}
+		block, err := pgpCipher(cipherAlgo, key)
+		if err != nil {
+			return err
+		}
+		bs := block.BlockSize()
+		if len(rest) < bs {
+			return fmt.Errorf("Invalid PGP secret key")
+		}
+		plain := make([]byte, len(rest)-bs)
+		cipher.NewCFBDecrypter(block, rest[:bs]).XORKeyStream(plain, rest[bs:])
+
+		if usage == 254 {
+			if len(plain) < sha1.Size {
+				return fmt.Errorf("Invalid PGP secret key")
+			}
+			material = plain[:len(plain)-sha1.Size]
+			sum := sha1.Sum(material)
+			if subtle.ConstantTimeCompare(sum[:], plain[len(material):]) != 1 {
+				return fmt.Errorf("Wrong PGP passphrase")
+			}
+		} else {
+			if len(plain) < 2 {
+				return fmt.Errorf("Invalid PGP secret key")
+			}
+			material = plain[:len(plain)-2]
+			if pgpChecksum(material) != binary.BigEndian.Uint16(plain[len(material):]) {
+				return fmt.Errorf("Wrong PGP passphrase")
+			}
+		}
+
+	default:
+		return fmt.Errorf("Unsupported PGP secret key protection %d", usage)
+	}
+
+	var err error
+	switch k.algo {
+	case pgpAlgoRSA, pgpAlgoRSAEncrypt, pgpAlgoRSASign:
+		var d, p, q []byte
+		if d, material, err = readPGPMPI(material); err != nil {
+			return err
+		}
+		if p, material, err = readPGPMPI(material); err != nil {
+			return err
+		}
+		if q, _, err = readPGPMPI(material); err != nil {
+			return err
+		}
+		priv := &rsa.PrivateKey{
+			PublicKey: *k.rsaPub,
+			D:         new(big.Int).SetBytes(d),
+			Primes:    []*big.Int{new(big.Int).SetBytes(p), new(big.Int).SetBytes(q)},
+		}
+		if err := priv.Validate(); err != nil {
+			return fmt.Errorf("Invalid PGP RSA key: %s", err)
+		}
+		priv.Precompute()
+		k.rsaPriv = priv
+
+	case pgpAlgoEdDSA:
+		seed, _, err := readPGPMPI(material)
+		if err != nil {
+			return err
+		}
+		if len(seed) > ed25519.SeedSize {
+			return fmt.Errorf("Invalid PGP Ed25519 key")
+		}
+		seed = append(make([]byte, ed25519.SeedSize-len(seed)), seed...)
+		priv := ed25519.NewKeyFromSeed(seed)
+		if !bytes.Equal(priv.Public().(ed25519.PublicKey), k.edPub) {
+			return fmt.Errorf("PGP Ed25519 key does not match its public key")
+		}
+		k.edPriv = priv
+
+	case pgpAlgoECDH:
+		scalar, _, err := readPGPMPI(material)
+		if err != nil {
+			return err
+		}
+		if len(scalar) > 32 {
+			return fmt.Errorf("Invalid PGP Curve25519 key")
+		}
+		// stored big endian, X25519 wants it little endian
+		scalar = append(make([]byte, 32-len(scalar)), scalar...)
+		native := make([]byte, 32)
+		for i := range scalar {
+			native[i] = scalar[31-i]
+		}
+		priv, err := ecdh.X25519().NewPrivateKey(native)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(priv.PublicKey().Bytes(), k.xPub) {
+			return fmt.Errorf("PGP Curve25519 key does not match its public key")
+		}
+		k.xPriv = native
+
+	default:
+		return fmt.Errorf("Unsupported PGP key algorithm %d", k.algo)
+	}
+	return nil
+}
+
+// pgpS2K derive the key of cipherAlgo from passphrase with the S2K
+// specifier at the start of s; returns the key and what follows the
+// specifier
+func pgpS2K(s []byte, passphrase string, cipherAlgo byte) ([]byte, []byte, error) {
+	size, err := pgpCipherKeySize(cipherAlgo)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(s) < 2 {
+		return nil, nil, fmt.Errorf("Invalid PGP S2K specifier")
+	}
+	mode := s[0]
+	h, err := pgpHash(s[1])
+	if err != nil {
+		return nil, nil, err
+	}
+	s = s[2:]
+
+	var salt []byte
+	count := 0
+	switch mode {
+	case 0:
+	case 1, 3:
+		if len(s) < 8 {
+			return nil, nil, fmt.Errorf("Invalid PGP S2K specifier")
+		}
+		salt, s = s[:8], s[8:]
+		if mode == 3 {
+			if len(s) < 1 {
+				return nil, nil, fmt.Errorf("Invalid PGP S2K specifier")
+			}
+			c := int(s[0])
+			count = (16 + (c & 15)) << (uint(c>>4) + 6)
+			s = s[1:]
+		}
+	default:
+		return nil, nil, fmt.Errorf("Unsupported PGP S2K mode %d", mode)
+	}
+
+	input := append(append([]byte(nil), salt...), passphrase...)
+	if count < len(input) {
+		count = len(input)
+	}
+
+	var key []byte
+	for i := 0; len(key) < size; i++ {
+		d := h.New()
+		d.Write(make([]byte, i))
+		if mode == 3 {
+			for n := count; n > 0; n -= len(input) {
+				if n < len(input) {
+					d.Write(input[:n])
+					break
+				}
+				d.Write(input)
+			}
+		} else {
+			d.Write(input)
+		}
+		key = d.Sum(key)
+	}
+	return key[:size], s, nil
+}
+
+// pgpSignature fields of a parsed v4 signature packet
+type pgpSignature struct {
+	sigType  byte
+	algo     byte
+	hash     byte
+	hashed   []byte // version through the hashed subpackets
+	left16   []byte
+	mpis     [][]byte
+	issuer   []byte
+	flags    byte
+	hasFlags bool
+}
+
+// parsePGPSignature parse a v4 signature packet body
+func parsePGPSignature(body []byte) (*pgpSignature, error) {
+	if len(body) < 6 || body[0] != 4 {
+		return nil, fmt.Errorf("Unsupported PGP signature version")
+	}
+	sig := &pgpSignature{sigType: body[1], algo: body[2], hash: body[3]}
+	hashedLen := int(binary.BigEndian.Uint16(body[4:6]))
+	if len(body) < 6+hashedLen+2 {
+		return nil, fmt.Errorf("Invalid PGP signature")
+	}
+	sig.hashed = body[:6+hashedLen]
+	rest := body[6+hashedLen:]
+	unhashedLen := int(binary.BigEndian.Uint16(rest))
+	if len(rest) < 2+unhashedLen+2 {
+		return nil, fmt.Errorf("Invalid PGP signature")
+	}
+	unhashed := rest[2 : 2+unhashedLen]
+	rest = rest[2+unhashedLen:]
+	sig.left16, rest = rest[:2], rest[2:]
+
+	for i, area := range [][]byte{body[6 : 6+hashedLen], unhashed} {
+		hashedArea := i == 0
+		err := forEachPGPSubpacket(area, func(typ byte, data []byte) {
+			switch typ {
+			case 16:
+				if len(data) == 8 && sig.issuer == nil {
+					sig.issuer = data
+				}
+			case 33:
+				if len(data) == 21 {
+					sig.issuer = data[13:]
+				}
+			case 27:
+				if hashedArea && len(data) > 0 {
+					sig.flags, sig.hasFlags = data[0], true
+				}
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for len(rest) > 0 {
+		var m []byte
+		var err error
+		if m, rest, err = readPGPMPI(rest); err != nil {
+			return nil, err
+		}
+		sig.mpis = append(sig.mpis, m)
+	}
+	return sig, nil
+}
+
+// forEachPGPSubpacket call fn with the type and data of each subpacket
+func forEachPGPSubpacket(area []byte, fn func(typ byte, data []byte)) error {
+	for len(area) > 0 {
+		var n, hdr int
+		switch first := int(area[0]); {
+		case first < 192:
+			n, hdr = first, 1
+		case first < 255:
+			if len(area) < 2 {
+				return fmt.Errorf("Invalid PGP subpacket")
+			}
+			n, hdr = (first-192)<<8+int(area[1])+192, 2
+		default:
+			if len(area) < 5 {
+				return fmt.Errorf("Invalid PGP subpacket")
+			}
+			n, hdr = int(binary.BigEndian.Uint32(area[1:5])), 5
+		}
+		if n < 1 || len(area) < hdr+n {
+			return fmt.Errorf("Invalid PGP subpacket")
+		}
+		fn(area[hdr]&0x7f, area[hdr+1:hdr+n])
+		area = area[hdr+n:]
+	}
+	return nil
+}
+
+// pgpSign detached binary signature packet of data
+func pgpSign(key *pgpKeyPacket, data []byte) ([]byte, error) {
+	if key == nil || !key.hasSecret() {
+		return nil, fmt.Errorf("PGP signing needs an unlocked secret key")
+	}
+
+	var hashed bytes.Buffer
+	now := make([]byte, 4)
+	binary.BigEndian.PutUint32(now, uint32(time.Now().Unix()))
+	writePGPSubpacket(&hashed, 2, now)
+	writePGPSubpacket(&hashed, 33, append([]byte{4}, key.fingerprint...))
+	var unhashed bytes.Buffer
+	writePGPSubpacket(&unhashed, 16, key.keyID())
+
+	head := []byte{4, 0x00, key.algo, pgpHashSHA256, byte(hashed.Len() >> 8), byte(hashed.Len())}
+	head = append(head, hashed.Bytes()...)
+	digest := pgpSignatureDigest(crypto.SHA256, data, head)
+
+	var mpis [][]byte
+	switch {
+	case key.rsaPriv != nil:
+		s, err := rsa.SignPKCS1v15(rand.Reader, key.rsaPriv, crypto.SHA256, digest)
+		if err != nil {
+			return nil, err
+		}
+		mpis = [][]byte{s}
+	case key.edPriv != nil:
+		s := ed25519.Sign(key.edPriv, digest)
+		mpis = [][]byte{s[:32], s[32:]}
+	default:
+		return nil, fmt.Errorf("PGP key can't sign")
+	}
+
+	var body bytes.Buffer
+	body.Write(head)
+	body.Write([]byte{byte(unhashed.Len() >> 8), byte(unhashed.Len())})
+	body.Write(unhashed.Bytes())
+	body.Write(digest[:2])
+	for _, m := range mpis {
+		writePGPMPI(&body, m)
+	}
+
+	var out bytes.Buffer
+	writePGPPacket(&out, pgpTagSignature, body.Bytes())
+	return out.Bytes(), nil
+}
+
+// pgpVerify check a detached signature over data; returns the signer
+func pgpVerify(sigData, data []byte, keyring PGPKeyring) (*PGPKey, error) {
+	packets, err := readPGPPackets(sigData)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range packets {
+		if p.tag != pgpTagSignature {
+			continue
+		}
+		sig, err := parsePGPSignature(p.body)
+		if err != nil {
+			return nil, err
+		}
+		if sig.sigType != 0x00 && sig.sigType != 0x01 {
+			return nil, fmt.Errorf("Unexpected PGP signature type %#x", sig.sigType)
+		}
+		if sig.sigType == 0x01 {
+			data = toCRLF(data)
+		}
+
+		owner, key := keyring.findKey(sig.issuer)
+		if key == nil {
+			return nil, fmt.Errorf("No PGP key for signature issuer %X", sig.issuer)
+		}
+		h, err := pgpHash(sig.hash)
+		if err != nil {
+			return nil, err
+		}
+		digest := pgpSignatureDigest(h, data, sig.hashed)
+		if !bytes.Equal(digest[:2], sig.left16) {
+			return nil, fmt.Errorf("PGP signature mismatch")
+		}
+
+		switch {
+		case key.rsaPub != nil && sig.algo != pgpAlgoEdDSA:
+			if len(sig.mpis) != 1 || rsa.VerifyPKCS1v15(key.rsaPub, h, digest, sig.mpis[0]) != nil {
+				return nil, fmt.Errorf("PGP signature mismatch")
+			}
+		case key.edPub != nil && sig.algo == pgpAlgoEdDSA:
+			if len(sig.mpis) != 2 || len(sig.mpis[0]) > 32 || len(sig.mpis[1]) > 32 {
+				return nil, fmt.Errorf("Invalid PGP Ed25519 signature")
+			}
+			s := append(make([]byte, 32-len(sig.mpis[0])), sig.mpis[0]...)
+			s = append(s, make([]byte, 32-len(sig.mpis[1]))...)
+			s = append(s, sig.mpis[1]...)
+			if !ed25519.Verify(key.edPub, digest, s) {
+				return nil, fmt.Errorf("PGP signature mismatch")
+			}
+		default:
+			return nil, fmt.Errorf("Unsupported PGP signature algorithm %d", sig.algo)
+		}
+		return owner, nil
+	}
+	return nil, fmt.Errorf("No PGP signature packet")
+}
+
+// pgpSignatureDigest v4 signature hash of data and the hashed head
+func pgpSignatureDigest(h crypto.Hash, data, head []byte) []byte {
+	d := h.New()
+	d.Write(data)
+	d.Write(head)
+	d.Write([]byte{4, 0xff})
+	binary.Write(d, binary.BigEndian, uint32(len(head)))
+	return d.Sum(nil)
+}
+
+// pgpEncrypt PKESK packets for the recipients and the SEIPD packet of data
+func pgpEncrypt(data []byte, recipients []*PGPKey) ([]byte, error) {
+	sessionKey := make([]byte, 32)
+	if _, err := rand.Read(sessionKey); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	for _, r := range recipients {
+		key := r.encryptionKey()
+		if key == nil {
+			return nil, fmt.Errorf("PGP key %s has no encryption key", r.KeyID())
+		}
+		body, err := pgpEncryptSessionKey(key, pgpCipherAES256, sessionKey)
+		if err != nil {
+			return nil, err
+		}
+		writePGPPacket(&out, pgpTagPKESK, body)
+	}
+
+	var literal bytes.Buffer
+	literal.Write([]byte{'b', 0, 0, 0, 0, 0})
+	literal.Write(data)
+	var inner bytes.Buffer
+	writePGPPacket(&inner, pgpTagLiteral, literal.Bytes())
+
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	bs := block.BlockSize()
+	prefix := make([]byte, bs+2)
+	if _, err := rand.Read(prefix[:bs]); err != nil {
+		return nil, err
+	}
+	prefix[bs], prefix[bs+1] = prefix[bs-2], prefix[bs-1]
+
+	plain := append(prefix, inner.Bytes()...)
+	plain = append(plain, 0xd3, 0x14)
+	mdc := sha1.Sum(plain)
+	plain = append(plain, mdc[:]...)
+
+	seipd := make([]byte, 1+len(plain))
+	seipd[0] = 1
+	cipher.NewCFBEncrypter(block, make([]byte, bs)).XORKeyStream(seipd[1:], plain)
+	writePGPPacket(&out, pgpTagSEIPD, seipd)
+	return out.Bytes(), nil
+}
+
+// pgpEncryptSessionKey PKESK packet body wrapping the session key to key
+func pgpEncryptSessionKey(key *pgpKeyPacket, cipherAlgo byte, sessionKey []byte) ([]byte, error) {
+	m := append([]byte{cipherAlgo}, sessionKey...)
+	sum := pgpChecksum(sessionKey)
+	m = append(m, byte(sum>>8), byte(sum))
+
+	var body bytes.Buffer
+	body.WriteByte(3)
+	body.Write(key.keyID())
+	body.WriteByte(key.algo)
+
+	switch {
+	case key.rsaPub != nil:
+		c, err := rsa.EncryptPKCS1v15(rand.Reader, key.rsaPub, m)
+		if err != nil {
+			return nil, err
+		}
+		writePGPMPI(&body, c)
+
+	case key.xPub != nil:
+		eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		pub, err := ecdh.X25519().NewPublicKey(key.xPub)
+		if err != nil {
+			return nil, err
+		}
+		shared, err := eph.ECDH(pub)
+		if err != nil {
+			return nil, err
+		}
+		kek, err := pgpECDHKEK(key, shared)
+		if err != nil {
+			return nil, err
+		}
+		pad := 8 - len(m)%8
+		m = append(m, bytes.Repeat([]byte{byte(pad)}, pad)...)
+		wrapped, err := aesKeyWrap(kek, m)
+		if err != nil {
+			return nil, err
+		}
+		writePGPMPI(&body, append([]byte{0x40}, eph.PublicKey().Bytes()...))
+		body.WriteByte(byte(len(wrapped)))
+		body.Write(wrapped)
+
+	default:
+		return nil, fmt.Errorf("Unsupported PGP encryption key")
+	}
+	return body.Bytes(), nil
+}
+
+// pgpDecrypt literal data of an encrypted message, with the first unlocked
+// key of keyring among its recipients
+func pgpDecrypt(message []byte, keyring PGPKeyring) ([]byte, error) {
+	packets, err := readPGPPackets(message)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessionKey []byte
+	var cipherAlgo byte
+	var lastErr error = fmt.Errorf("PGP message is not encrypted to any key of the keyring")
+	for _, p := range packets {
+		switch p.tag {
+		case pgpTagPKESK:
+			if sessionKey != nil {
+				continue
+			}
+			if len(p.body) < 10 || p.body[0] != 3 {
+				continue
+			}
+			_, key := keyring.findKey(p.body[1:9])
+			if key == nil {
+				continue
+			}
+			if !key.hasSecret() {
+				lastErr = fmt.Errorf("PGP secret key %X is locked", key.keyID())
+				continue
+			}
+			algo, sk, err := pgpDecryptSessionKey(key, p.body[10:])
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			sessionKey, cipherAlgo = sk, algo
+
+		case pgpTagSEIPD:
+			if sessionKey == nil {
+				return nil, lastErr
+			}
+			return pgpDecryptSEIPD(p.body, cipherAlgo, sessionKey)
+
+		case 20:
+			return nil, fmt.Errorf("AEAD encrypted PGP messages are not supported")
+		}
+	}
+	return nil, fmt.Errorf("No encrypted data in PGP message")
+}
+
+// pgpDecryptSessionKey cipher and session key of a PKESK body (after the
+// algorithm byte)
+func pgpDecryptSessionKey(key *pgpKeyPacket, body []byte) (byte, []byte, error) {
+	var m []byte
+	switch {
+	case key.rsaPriv != nil:
+		c, _, err := readPGPMPI(body)
+		if err != nil {
+			return 0, nil, err
+		}
+		m, err = rsa.DecryptPKCS1v15(rand.Reader, key.rsaPriv, c)
+		if err != nil {
+			return 0, nil, fmt.Errorf("PGP session key decryption failed")
+		}
+
+	case key.xPriv != nil:
+		point, rest, err := readPGPMPI(body)
+		if err != nil {
+			return 0, nil, err
+		}
+		if len(point) != 33 || point[0] != 0x40 || len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+			return 0, nil, fmt.Errorf("Invalid PGP ECDH session key")
+		}
+		wrapped := rest[1 : 1+rest[0]]
+		priv, err := ecdh.X25519().NewPrivateKey(key.xPriv)
+		if err != nil {
+			return 0, nil, err
+		}
+		pub, err := ecdh.X25519().NewPublicKey(point[1:])
+		if err != nil {
+			return 0, nil, err
+		}
+		shared, err := priv.ECDH(pub)
+		if err != nil {
+			return 0, nil, err
+		}
+		kek, err := pgpECDHKEK(key, shared)
+		if err != nil {
+			return 0, nil, err
+		}
+		m, err = aesKeyUnwrap(kek, wrapped)
+		if err != nil {
+			return 0, nil, fmt.Errorf("PGP session key decryption failed")
+		}
+		pad := int(m[len(m)-1])
+		if pad == 0 || pad > 8 || pad > len(m) {
+			return 0, nil, fmt.Errorf("PGP session key decryption failed")
+		}
+		m = m[:len(m)-pad]
+
+	default:
+		return 0, nil, fmt.Errorf("Unsupported PGP decryption key")
+	}
+
+	if len(m) < 4 {
+		return 0, nil, fmt.Errorf("Invalid PGP session key")
+	}
+	sk := m[1 : len(m)-2]
+	if pgpChecksum(sk) != binary.BigEndian.Uint16(m[len(m)-2:]) {
+		return 0, nil, fmt.Errorf("PGP session key checksum mismatch")
+	}
+	return m[0], sk, nil
+}
+
+// pgpDecryptSEIPD check the MDC of a SEIPD body and return its literal data
+func pgpDecryptSEIPD(body []byte, cipherAlgo byte, sessionKey []byte) ([]byte, error) {
+	if len(body) < 1 || body[0] != 1 {
+		return nil, fmt.Errorf("Unsupported PGP encrypted data version")
+	}
+	block, err := pgpCipher(cipherAlgo, sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	bs := block.BlockSize()
+	plain := make([]byte, len(body)-1)
+	cipher.NewCFBDecrypter(block, make([]byte, bs)).XORKeyStream(plain, body[1:])
+
+	if len(plain) < bs+2+22 || plain[bs-2] != plain[bs] || plain[bs-1] != plain[bs+1] {
+		return nil, fmt.Errorf("PGP decryption failed")
+	}
+	mdc := plain[len(plain)-22:]
+	sum := sha1.Sum(plain[:len(plain)-20])
+	if mdc[0] != 0xd3 || mdc[1] != 0x14 || subtle.ConstantTimeCompare(sum[:], mdc[2:]) != 1 {
+		return nil, fmt.Errorf("PGP message integrity check failed")
+	}
+	return pgpLiteralData(plain[bs+2 : len(plain)-22])
+}
+
+// pgpLiteralData contents of the literal data packet, decompressing as needed
+func pgpLiteralData(data []byte) ([]byte, error) {
+	packets, err := readPGPPackets(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range packets {
+		switch p.tag {
+		case pgpTagCompressed:
+			if len(p.body) < 1 {
+				return nil, fmt.Errorf("Invalid PGP compressed data")
+			}
+			var r io.Reader
+			switch p.body[0] {
+			case 0:
+				r = bytes.NewReader(p.body[1:])
+			case 1:
+				r = flate.NewReader(bytes.NewReader(p.body[1:]))
+			case 2:
+				zr, err := zlib.NewReader(bytes.NewReader(p.body[1:]))
+				if err != nil {
+					return nil, err
+				}
+				r = zr
+			case 3:
+				r = bzip2.NewReader(bytes.NewReader(p.body[1:]))
+			default:
+				return nil, fmt.Errorf("Unsupported PGP compression %d", p.body[0])
+			}
+			inner, err := ioutil.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+			return pgpLiteralData(inner)
+		case pgpTagLiteral:
+			if len(p.body) < 6 || len(p.body) < 6+int(p.body[1]) {
+				return nil, fmt.Errorf("Invalid PGP literal data")
+			}
+			return p.body[6+int(p.body[1]):], nil
+		case pgpTagOnePassSig, pgpTagSignature:
+		}
+	}
+	return nil, fmt.Errorf("No literal data in PGP message")
+}
+
+// pgpECDHKEK key encryption key of RFC 6637 section 7 and 8
+func pgpECDHKEK(key *pgpKeyPacket, shared []byte) ([]byte, error) {
+	h, err := pgpHash(key.kdfHash)
+	if err != nil {
+		return nil, err
+	}
+	size, err := pgpCipherKeySize(key.kdfCipher)
+	if err != nil {
+		return nil, err
+	}
+
+	var param bytes.Buffer
+	param.WriteByte(byte(len(key.curveOID)))
+	param.Write(key.curveOID)
+	param.Write([]byte{pgpAlgoECDH, 3, 1, key.kdfHash, key.kdfCipher})
+	param.WriteString("Anonymous Sender    ")
+	param.Write(key.fingerprint)
+
+	d := h.New()
+	d.Write([]byte{0, 0, 0, 1})
+	d.Write(shared)
+	d.Write(param.Bytes())
+	sum := d.Sum(nil)
+	if len(sum) < size {
+		return nil, fmt.Errorf("PGP KDF hash too short for the cipher")
+	}
+	return sum[:size], nil
+}
+
+// aesKeyWrap AES key wrap of RFC 3394
+func aesKeyWrap(kek, plain []byte) ([]byte, error) {
+	if len(plain)%8 != 0 || len(plain) < 16 {
+		return nil, fmt.Errorf("Invalid key wrap input")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(plain) / 8
+	a := []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+	r := append([]byte(nil), plain...)
+	b := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 0; i < n; i++ {
+			copy(b, a)
+			copy(b[8:], r[8*i:8*i+8])
+			block.Encrypt(b, b)
+			t := uint64(n*j + i + 1)
+			binary.BigEndian.PutUint64(a, binary.BigEndian.Uint64(b[:8])^t)
+			copy(r[8*i:], b[8:])
+		}
+	}
+	return append(a, r...), nil
+}
+
+// aesKeyUnwrap AES key unwrap of RFC 3394
+func aesKeyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped)%8 != 0 || len(wrapped) < 24 {
+		return nil, fmt.Errorf("Invalid wrapped key")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(wrapped)/8 - 1
+	a := append([]byte(nil), wrapped[:8]...)
+	r := append([]byte(nil), wrapped[8:]...)
+	b := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n - 1; i >= 0; i-- {
+			t := uint64(n*j + i + 1)
+			binary.BigEndian.PutUint64(b, binary.BigEndian.Uint64(a)^t)
+			copy(b[8:], r[8*i:8*i+8])
+			block.Decrypt(b, b)
+			copy(a, b[:8])
+			copy(r[8*i:], b[8:])
+		}
+	}
+	if subtle.ConstantTimeCompare(a, []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}) != 1 {
+		return nil, fmt.Errorf("Key unwrap integrity check failed")
+	}
+	return r, nil
+}
+
+// pgpHash hash of an OpenPGP hash algorithm ID
+func pgpHash(id byte) (crypto.Hash, error) {
+	switch id {
+	case 2:
+		return crypto.SHA1, nil
+	case 8:
+		return crypto.SHA256, nil
+	case 9:
+		return crypto.SHA384, nil
+	case 10:
+		return crypto.SHA512, nil
+	case 11:
+		return crypto.SHA224, nil
+	}
+	return 0, fmt.Errorf("Unsupported PGP hash algorithm %d", id)
+}
+
+// pgpCipherKeySize key size of an OpenPGP symmetric algorithm ID
+func pgpCipherKeySize(id byte) (int, error) {
+	switch id {
+	case pgpCipherAES128:
+		return 16, nil
+	case pgpCipherAES192:
+		return 24, nil
+	case pgpCipherAES256:
+		return 32, nil
+	}
+	return 0, fmt.Errorf("Unsupported PGP cipher %d", id)
+}
+
+// pgpCipher block cipher of an OpenPGP symmetric algorithm ID
+func pgpCipher(id byte, key []byte) (cipher.Block, error) {
+	size, err := pgpCipherKeySize(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != size {
+		return nil, fmt.Errorf("Invalid PGP session key size")
+	}
+	return aes.NewCipher(key)
+}
+
+// pgpChecksum sum of the bytes modulo 65536
+func pgpChecksum(b []byte) uint16 {
+	var sum uint16
+	for _, c := range b {
+		sum += uint16(c)
+	}
+	return sum
+}
+
+// readPGPPackets split data into packets, old or new format, joining
+// partial body lengths
+func readPGPPackets(data []byte) ([]pgpPacket, error) {
+	var packets []pgpPacket
+	for len(data) > 0 {
+		ctb := data[0]
+		if ctb&0x80 == 0 {
+			return nil, fmt.Errorf("Invalid PGP packet header")
+		}
+		data = data[1:]
+
+		var p pgpPacket
+		if ctb&0x40 != 0 {
+			p.tag = int(ctb & 0x3f)
+			for {
+				if len(data) < 1 {
+					return nil, fmt.Errorf("Truncated PGP packet")
+				}
+				var n, hdr int
+				partial := false
+				switch first := int(data[0]); {
+				case first < 192:
+					n, hdr = first, 1
+				case first < 224:
+					if len(data) < 2 {
+						return nil, fmt.Errorf("Truncated PGP packet")
+					}
+					n, hdr = (first-192)<<8+int(data[1])+192, 2
+				case first == 255:
+					if len(data) < 5 {
+						return nil, fmt.Errorf("Truncated PGP packet")
+					}
+					n, hdr = int(binary.BigEndian.Uint32(data[1:5])), 5
+				default:
+					n, hdr, partial = 1<<uint(first&0x1f), 1, true
+				}
+				if len(data) < hdr+n {
+					return nil, fmt.Errorf("Truncated PGP packet")
+				}
+				p.body = append(p.body, data[hdr:hdr+n]...)
+				data = data[hdr+n:]
+				if !partial {
+					break
+				}
+			}
+		} else {
+			p.tag = int(ctb>>2) & 0x0f
+			var n int
+			switch ctb & 3 {
+			case 0:
+				if len(data) < 1 {
+					return nil, fmt.Errorf("Truncated PGP packet")
+				}
+				n, data = int(data[0]), data[1:]
+			case 1:
+				if len(data) < 2 {
+					return nil, fmt.Errorf("Truncated PGP packet")
+				}
+				n, data = int(binary.BigEndian.Uint16(data)), data[2:]
+			case 2:
+				if len(data) < 4 {
+					return nil, fmt.Errorf("Truncated PGP packet")
+				}
+				n, data = int(binary.BigEndian.Uint32(data)), data[4:]
+			default:
+				n = len(data)
+			}
+			if len(data) < n {
+				return nil, fmt.Errorf("Truncated PGP packet")
+			}
+			p.body, data = data[:n], data[n:]
+		}
+		packets = append(packets, p)
+	}
+	return packets, nil
+}
+
+// writePGPPacket new format packet with a definite length
+func writePGPPacket(w *bytes.Buffer, tag int, body []byte) {
+	w.WriteByte(0xc0 | byte(tag))
+	switch n := len(body); {
+	case n < 192:
+		w.WriteByte(byte(n))
+	case n < 8384:
+		n -= 192
+		w.Write([]byte{byte(n>>8) + 192, byte(n)})
+	default:
+		w.WriteByte(255)
+		binary.Write(w, binary.BigEndian, uint32(n))
+	}
+	w.Write(body)
+}
+
+// writePGPSubpacket signature subpacket (short bodies only)
+func writePGPSubpacket(w *bytes.Buffer, typ byte, data []byte) {
+	w.WriteByte(byte(len(data) + 1))
+	w.WriteByte(typ)
+	w.Write(data)
+}
+
+// readPGPMPI read a multiprecision integer
+func readPGPMPI(b []byte) ([]byte, []byte, error) {
+	if len(b) < 2 {
+		return nil, nil, fmt.Errorf("Truncated PGP MPI")
+	}
+	n := (int(binary.BigEndian.Uint16(b)) + 7) / 8
+	if len(b) < 2+n {
+		return nil, nil, fmt.Errorf("Truncated PGP MPI")
+	}
+	return b[2 : 2+n], b[2+n:], nil
+}
+
+// writePGPMPI write v as a multiprecision integer
+func writePGPMPI(w *bytes.Buffer, v []byte) {
+	v = bytes.TrimLeft(v, "\x00")
+	bits := 0
+	if len(v) > 0 {
+		bits = (len(v)-1)*8 + big.NewInt(int64(v[0])).BitLen()
+	}
+	w.Write([]byte{byte(bits >> 8), byte(bits)})
+	w.Write(v)
+}
+
+// pgpArmor ASCII armor of data, with CRLF line endings
+func pgpArmor(blockType string, data []byte) []byte {
+	var w bytes.Buffer
+	w.WriteString("-----BEGIN PGP " + blockType + "-----\r\n\r\n")
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 64 {
+		w.WriteString(encoded[:64] + "\r\n")
+		encoded = encoded[64:]
+	}
+	w.WriteString(encoded + "\r\n")
+	crc := pgpCRC24(data)
+	w.WriteString("=" + base64.StdEncoding.EncodeToString([]byte{byte(crc >> 16), byte(crc >> 8), byte(crc)}) + "\r\n")
+	w.WriteString("-----END PGP " + blockType + "-----\r\n")
+	return w.Bytes()
+}
+
+// pgpDearmor decode the first armored block of data; returns its type,
+// contents and the data after it
+func pgpDearmor(data []byte) (string, []byte, []byte, error) {
+	start := bytes.Index(data, []byte("-----BEGIN PGP "))
+	if start < 0 {
+		return "", nil, nil, fmt.Errorf("No PGP armor")
+	}
+	data = data[start+len("-----BEGIN PGP "):]
+	end := bytes.Index(data, []byte("-----"))
+	if end < 0 {
+		return "", nil, nil, fmt.Errorf("Invalid PGP armor")
+	}
+	blockType := string(data[:end])
+	data = data[end+5:]
+
+	tail := []byte("-----END PGP " + blockType + "-----")
+	stop := bytes.Index(data, tail)
+	if stop < 0 {
+		return "", nil, nil, fmt.Errorf("Unterminated PGP armor")
+	}
+	rest := data[stop+len(tail):]
+
+	lines := strings.Split(strings.ReplaceAll(string(data[:stop]), "\r", ""), "\n")
+	// armor headers end at the first blank line
+	i := 0
+	for i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+		i++
+	}
+	for j := i; j < len(lines); j++ {
+		if strings.Contains(lines[j], ": ") {
+			continue
+		}
+		if strings.TrimSpace(lines[j]) == "" {
+			i = j + 1
+		}
+		break
+	}
+
+	var b64, checksum strings.Builder
+	for _, l := range lines[i:] {
+		l = strings.TrimSpace(l)
+		if strings.HasPrefix(l, "=") {
+			checksum.WriteString(l[1:])
+			break
+		}
+		b64.WriteString(l)
+	}
+	body, err := base64.StdEncoding.DecodeString(b64.String())
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("Invalid PGP armor: %s", err)
+	}
+	if checksum.Len() > 0 {
+		sum, err := base64.StdEncoding.DecodeString(checksum.String())
+		crc := pgpCRC24(body)
+		if err != nil || len(sum) != 3 || !bytes.Equal(sum, []byte{byte(crc >> 16), byte(crc >> 8), byte(crc)}) {
+			return "", nil, nil, fmt.Errorf("PGP armor checksum mismatch")
+		}
+	}
+	return blockType, body, rest, nil
+}
+
+// pgpCRC24 armor checksum of RFC 4880 section 6.1
+func pgpCRC24(data []byte) uint32 {
+	crc := uint32(0xb704ce)
+	for _, b := range data {
+		crc ^= uint32(b) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= 0x1864cfb
+			}
+		}
+	}
+	return crc & 0xffffff
+}
@@ -0,0 +1,144 @@
+package mailer_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/thiagozs/mailer-go"
+)
+
+const pgpMessage = "From: alice@example.com\r\n" +
+	"To: bob@example.com\r\n" +
+	"Subject: Private\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: text/plain; charset=\"UTF-8\"\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Hello from OpenPGP.\r\n"
+
+func pgpKeyring(t *testing.T, path string) mailer.PGPKeyring {
+	keyring, err := mailer.LoadPGPKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func TestLoadPGPKeyring(t *testing.T) {
+	t.Log("Load armored keyrings... (NOT expected some err)")
+
+	keyring := pgpKeyring(t, "testdata/pgp/pubring.asc")
+	if len(keyring) != 2 {
+		t.Fatalf("LoadPGPKeyring got: %d keys", len(keyring))
+	}
+	alice := keyring.Find("alice@example.com")
+	if alice == nil || alice.UserIDs[0] != "Alice <alice@example.com>" {
+		t.Fatalf("Find got: %v", alice)
+	}
+	for _, q := range []string{alice.Fingerprint(), alice.KeyID(), "0x" + strings.ToLower(alice.KeyID())} {
+		if keyring.Find(q) != alice {
+			t.Errorf("Find %s got another key", q)
+		}
+	}
+	if keyring.Find("carol@example.com") != nil {
+		t.Errorf("Find expected no key for carol")
+	}
+
+	secret := pgpKeyring(t, "testdata/pgp/alice.sec.asc")
+	if err := secret[0].Unlock("wrong"); err == nil {
+		t.Errorf("Unlock expected an error for a wrong passphrase")
+	}
+	if err := secret[0].Unlock("secret"); err != nil {
+		t.Errorf("Unlock got: %s", err)
+	}
+	if err := alice.Unlock("secret"); err == nil {
+		t.Errorf("Unlock expected an error on a public key")
+	}
+}
+
+func TestPGPSign(t *testing.T) {
+	t.Log("PGP/MIME sign and verify... (NOT expected some err)")
+
+	keyring := pgpKeyring(t, "testdata/pgp/pubring.asc")
+	for _, signer := range []string{"alice@example.com", "bob@example.com"} {
+		file := "testdata/pgp/" + strings.Split(signer, "@")[0] + ".sec.asc"
+		opts, err := mailer.NewPGPOptions(file, "secret", signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signed, err := mailer.ApplyPGP([]byte(pgpMessage), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range []string{
+			"Subject: Private\r\n",
+			"Content-Type: multipart/signed; micalg=pgp-sha256; protocol=\"application/pgp-signature\";",
+			"Content-Type: text/plain; charset=\"UTF-8\"\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nHello from OpenPGP.\r\n",
+			"-----BEGIN PGP SIGNATURE-----\r\n",
+		} {
+			if !strings.Contains(string(signed), c) {
+				t.Errorf("ApplyPGP missing %q\ngot: %s", c, signed)
+			}
+		}
+
+		key, err := mailer.VerifyPGP(signed, keyring)
+		if err != nil {
+			t.Fatalf("VerifyPGP %s got: %s", signer, err)
+		}
+		if key != keyring.Find(signer) {
+			t.Errorf("VerifyPGP got: %v", key.UserIDs)
+		}
+
+		tampered := strings.Replace(string(signed), "Hello from", "Hello form", 1)
+		if _, err := mailer.VerifyPGP([]byte(tampered), keyring); err == nil {
+			t.Errorf("VerifyPGP %s accepted a changed body", signer)
+		}
+	}
+}
+
+func TestPGPSignAndEncrypt(t *testing.T) {
+	t.Log("PGP/MIME sign, encrypt and decrypt... (NOT expected some err)")
+
+	opts, err := mailer.NewPGPOptions("testdata/pgp/alice.sec.asc", "secret", "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	public := pgpKeyring(t, "testdata/pgp/pubring.asc")
+	opts.Recipients = mailer.PGPKeyring{public.Find("alice@example.com"), public.Find("bob@example.com")}
+
+	encrypted, err := mailer.ApplyPGP([]byte(pgpMessage), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(encrypted), "Content-Type: multipart/encrypted; protocol=\"application/pgp-encrypted\";") ||
+		!strings.Contains(string(encrypted), "Version: 1\r\n") ||
+		strings.Contains(string(encrypted), "Hello from") {
+		t.Errorf("ApplyPGP got: %s", encrypted)
+	}
+
+	// each recipient reads it with its own key
+	alice := pgpKeyring(t, "testdata/pgp/alice.sec.asc")
+	if _, err := mailer.DecryptPGP(encrypted, alice); err == nil {
+		t.Errorf("DecryptPGP expected an error with a locked key")
+	}
+	if err := alice[0].Unlock("secret"); err != nil {
+		t.Fatal(err)
+	}
+	for _, keyring := range []mailer.PGPKeyring{alice, pgpKeyring(t, "testdata/pgp/bob.sec.asc")} {
+		entity, err := mailer.DecryptPGP(encrypted, keyring)
+		if err != nil {
+			t.Fatalf("DecryptPGP got: %s", err)
+		}
+		if _, err := mailer.VerifyPGP(entity, public); err != nil {
+			t.Errorf("VerifyPGP got: %s", err)
+		}
+		if !strings.Contains(string(entity), "Hello from OpenPGP.") {
+			t.Errorf("DecryptPGP got: %s", entity)
+		}
+	}
+
+	if _, err := mailer.DecryptPGP(encrypted, public); err == nil {
+		t.Errorf("DecryptPGP expected an error without secret keys")
+	}
+}
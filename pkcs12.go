@@ -0,0 +1,594 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"hash"
+	"math/bits"
+	"unicode/utf16"
+)
+
+// Reader of PKCS#12 (RFC 7292) files as exported by OpenSSL, browsers and
+// Windows: password integrity (HMAC-SHA1/SHA-256), pbeWithSHAAnd3-KeyTripleDES
+// and pbeWithSHAAnd40BitRC2 (legacy exports) and PBES2 with PBKDF2 and AES
+// or 3DES (OpenSSL 3 default).
+
+var (
+	oidPKCS7Data          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPKCS7EncryptedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+
+	oidKeyBag             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 1}
+	oidShroudedKeyBag     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509CertificateBag = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+
+	oidPBEWithSHAAnd3DES  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPBEWithSHAAnd40RC2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 6}
+	oidPBES2              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidDESEDE3CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+type pfxPDU struct {
+	Version  int
+	AuthSafe pkcs7ContentInfo
+	MacData  pfxMacData `asn1:"optional"`
+}
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type pfxMacData struct {
+	Mac        pfxDigestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type pfxDigestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type pkcs7EncryptedData struct {
+	Version              int
+	EncryptedContentInfo pkcs7EncryptedContentInfo
+}
+
+type pkcs7EncryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type pfxSafeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pfxBagAttribute `asn1:"set,optional"`
+}
+
+type pfxBagAttribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type pfxCertBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type pkcs8EncryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	PRF        pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// decodePKCS12 private key and certificates of a PKCS#12 file; the first
+// certificate is the one matching the key
+func decodePKCS12(data []byte, password string) (crypto.Signer, []*x509.Certificate, error) {
+	var pfx pfxPDU
+	if rest, err := asn1.Unmarshal(data, &pfx); err != nil {
+		return nil, nil, fmt.Errorf("Invalid PKCS#12 data: %s", err)
+	} else if len(rest) > 0 {
+		return nil, nil, fmt.Errorf("Invalid PKCS#12 data: trailing bytes")
+	}
+	if pfx.Version != 3 {
+		return nil, nil, fmt.Errorf("Unsupported PKCS#12 version %d", pfx.Version)
+	}
+	if !pfx.AuthSafe.ContentType.Equal(oidPKCS7Data) {
+		return nil, nil, fmt.Errorf("Unsupported PKCS#12 public key integrity mode")
+	}
+
+	var authSafe []byte
+	if _, err := asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafe); err != nil {
+		return nil, nil, fmt.Errorf("Invalid PKCS#12 data: %s", err)
+	}
+
+	if len(pfx.MacData.Mac.Digest) > 0 {
+		if err := verifyPKCS12Mac(pfx.MacData, authSafe, password); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var contents []pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(authSafe, &contents); err != nil {
+		return nil, nil, fmt.Errorf("Invalid PKCS#12 data: %s", err)
+	}
+
+	var key crypto.Signer
+	var certs []*x509.Certificate
+	for _, ci := range contents {
+		var safe []byte
+		switch {
+		case ci.ContentType.Equal(oidPKCS7Data):
+			if _, err := asn1.Unmarshal(ci.Content.Bytes, &safe); err != nil {
+				return nil, nil, fmt.Errorf("Invalid PKCS#12 data: %s", err)
+			}
+		case ci.ContentType.Equal(oidPKCS7EncryptedData):
+			var ed pkcs7EncryptedData
+			if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+				return nil, nil, fmt.Errorf("Invalid PKCS#12 data: %s", err)
+			}
+			var err error
+			safe, err = pbeDecrypt(ed.EncryptedContentInfo.ContentEncryptionAlgorithm,
+				ed.EncryptedContentInfo.EncryptedContent, password)
+			if err != nil {
+				return nil, nil, err
+			}
+		default:
+			return nil, nil, fmt.Errorf("Unsupported PKCS#12 content %s", ci.ContentType)
+		}
+
+		var bags []pfxSafeBag
+		if _, err := asn1.Unmarshal(safe, &bags); err != nil {
+			return nil, nil, fmt.Errorf("Invalid PKCS#12 safe contents: %s", err)
+		}
+
+		for _, bag := range bags {
+			switch {
+			case bag.ID.Equal(oidCertBag):
+				var cb pfxCertBag
+				if _, err := asn1.Unmarshal(bag.Value.Bytes, &cb); err != nil {
+					return nil, nil, fmt.Errorf("Invalid PKCS#12 certificate bag: %s", err)
+				}
+				if !cb.ID.Equal(oidX509CertificateBag) {
+					continue
+				}
+				cert, err := x509.ParseCertificate(cb.Data)
+				if err != nil {
+					return nil, nil, err
+				}
+				certs = append(certs, cert)
+
+			case bag.ID.Equal(oidKeyBag), bag.ID.Equal(oidShroudedKeyBag):
+				der := bag.Value.Bytes
+				if bag.ID.Equal(oidShroudedKeyBag) {
+					var epki pkcs8EncryptedPrivateKeyInfo
+					if _, err := asn1.Unmarshal(der, &epki); err != nil {
+						return nil, nil, fmt.Errorf("Invalid PKCS#12 key bag: %s", err)
+					}
+					var err error
+					der, err = pbeDecrypt(epki.Algorithm, epki.EncryptedData, password)
+					if err != nil {
+						return nil, nil, err
+					}
+				}
+				k, err := x509.ParsePKCS8PrivateKey(der)
+				if err != nil {
+					return nil, nil, err
+				}
+				signer, ok := k.(crypto.Signer)
+				if !ok {
+					return nil, nil, fmt.Errorf("Unsupported PKCS#12 key type %T", k)
+				}
+				if key == nil {
+					key = signer
+				}
+			}
+		}
+	}
+
+	if key == nil {
+		return nil, nil, fmt.Errorf("No private key in PKCS#12 data")
+	}
+
+	// the certificate of the key goes first
+	for i, c := range certs {
+		if publicKeyEqual(c.PublicKey, key.Public()) {
+			certs[0], certs[i] = certs[i], certs[0]
+			return key, certs, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("No certificate for the PKCS#12 private key")
+}
+
+// verifyPKCS12Mac check the password integrity HMAC
+func verifyPKCS12Mac(mac pfxMacData, content []byte, password string) error {
+	h, err := pkcs12Hash(mac.Mac.Algorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+	key := pkcs12KDF(h, bmpPassword(password), mac.MacSalt, mac.Iterations, 3, h().Size())
+	m := hmac.New(h, key)
+	m.Write(content)
+	if !hmac.Equal(m.Sum(nil), mac.Mac.Digest) {
+		return fmt.Errorf("PKCS#12 integrity check failed: wrong password?")
+	}
+	return nil
+}
+
+// pbeDecrypt decrypt data with a password based encryption scheme
+func pbeDecrypt(alg pkix.AlgorithmIdentifier, data []byte, password string) ([]byte, error) {
+	var block cipher.Block
+	var iv []byte
+
+	switch {
+	case alg.Algorithm.Equal(oidPBEWithSHAAnd3DES), alg.Algorithm.Equal(oidPBEWithSHAAnd40RC2):
+		var params pbeParams
+		if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+			return nil, fmt.Errorf("Invalid PBE parameters: %s", err)
+		}
+		pass := bmpPassword(password)
+		var err error
+		if alg.Algorithm.Equal(oidPBEWithSHAAnd3DES) {
+			key := pkcs12KDF(sha1.New, pass, params.Salt, params.Iterations, 1, 24)
+			iv = pkcs12KDF(sha1.New, pass, params.Salt, params.Iterations, 2, 8)
+			block, err = des.NewTripleDESCipher(key)
+		} else {
+			key := pkcs12KDF(sha1.New, pass, params.Salt, params.Iterations, 1, 5)
+			iv = pkcs12KDF(sha1.New, pass, params.Salt, params.Iterations, 2, 8)
+			block, err = newRC2Cipher(key, 40)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+	case alg.Algorithm.Equal(oidPBES2):
+		var params pbes2Params
+		if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+			return nil, fmt.Errorf("Invalid PBES2 parameters: %s", err)
+		}
+		if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+			return nil, fmt.Errorf("Unsupported PBES2 key derivation %s", params.KeyDerivationFunc.Algorithm)
+		}
+		var kdf pbkdf2Params
+		if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+			return nil, fmt.Errorf("Invalid PBKDF2 parameters: %s", err)
+		}
+
+		prf := sha1.New
+		switch {
+		case kdf.PRF.Algorithm == nil, kdf.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		case kdf.PRF.Algorithm.Equal(oidHMACWithSHA256):
+			prf = sha256.New
+		case kdf.PRF.Algorithm.Equal(oidHMACWithSHA512):
+			prf = sha512.New
+		default:
+			return nil, fmt.Errorf("Unsupported PBKDF2 function %s", kdf.PRF.Algorithm)
+		}
+
+		scheme := params.EncryptionScheme
+		if _, err := asn1.Unmarshal(scheme.Parameters.FullBytes, &iv); err != nil {
+			return nil, fmt.Errorf("Invalid PBES2 IV: %s", err)
+		}
+		keyLen, err := cbcKeyLength(scheme.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		key := pbkdf2Key([]byte(password), kdf.Salt, kdf.Iterations, keyLen, prf)
+		block, err = newCBCBlock(scheme.Algorithm, key)
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("Unsupported PKCS#12 encryption %s", alg.Algorithm)
+	}
+
+	out, err := cbcDecrypt(block, iv, data)
+	if err != nil {
+		return nil, fmt.Errorf("PKCS#12 decryption failed: wrong password?")
+	}
+	return out, nil
+}
+
+// cbcKeyLength key size of a CBC content encryption algorithm
+func cbcKeyLength(alg asn1.ObjectIdentifier) (int, error) {
+	switch {
+	case alg.Equal(oidAES128CBC):
+		return 16, nil
+	case alg.Equal(oidAES192CBC), alg.Equal(oidDESEDE3CBC):
+		return 24, nil
+	case alg.Equal(oidAES256CBC):
+		return 32, nil
+	}
+	return 0, fmt.Errorf("Unsupported cipher %s", alg)
+}
+
+// newCBCBlock block cipher of a CBC content encryption algorithm
+func newCBCBlock(alg asn1.ObjectIdentifier, key []byte) (cipher.Block, error) {
+	if alg.Equal(oidDESEDE3CBC) {
+		return des.NewTripleDESCipher(key)
+	}
+	return aes.NewCipher(key)
+}
+
+// cbcDecrypt decrypt and remove the PKCS#7 padding
+func cbcDecrypt(block cipher.Block, iv, data []byte) ([]byte, error) {
+	bs := block.BlockSize()
+	if len(iv) != bs || len(data) == 0 || len(data)%bs != 0 {
+		return nil, fmt.Errorf("Invalid CBC data")
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > bs || pad > len(out) {
+		return nil, fmt.Errorf("Invalid CBC padding")
+	}
+	if !bytes.Equal(out[len(out)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, fmt.Errorf("Invalid CBC padding")
+	}
+	return out[:len(out)-pad], nil
+}
+
+// cbcEncrypt add the PKCS#7 padding and encrypt
+func cbcEncrypt(block cipher.Block, iv, data []byte) []byte {
+	bs := block.BlockSize()
+	pad := bs - len(data)%bs
+	out := append(append([]byte(nil), data...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, out)
+	return out
+}
+
+// pkcs12Hash hash function of a digest algorithm
+func pkcs12Hash(alg asn1.ObjectIdentifier) (func() hash.Hash, error) {
+	switch {
+	case alg.Equal(oidSHA1):
+		return sha1.New, nil
+	case alg.Equal(oidSHA256):
+		return sha256.New, nil
+	case alg.Equal(oidSHA512):
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("Unsupported PKCS#12 MAC digest %s", alg)
+}
+
+// bmpPassword password as a NUL terminated big endian UTF-16 string
+func bmpPassword(password string) []byte {
+	var b []byte
+	for _, r := range utf16.Encode([]rune(password)) {
+		b = append(b, byte(r>>8), byte(r))
+	}
+	return append(b, 0, 0)
+}
+
+// pkcs12KDF key derivation of RFC 7292 appendix B.2; id is 1 for keys, 2
+// for IVs and 3 for MAC keys
+func pkcs12KDF(h func() hash.Hash, password, salt []byte, iterations int, id byte, size int) []byte {
+	u := h().Size()
+	v := h().BlockSize()
+
+	fill := func(s []byte) []byte {
+		if len(s) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(s)+v-1)/v))
+		for i := range out {
+			out[i] = s[i%len(s)]
+		}
+		return out
+	}
+
+	D := bytes.Repeat([]byte{id}, v)
+	I := append(fill(salt), fill(password)...)
+
+	var out []byte
+	for len(out) < size {
+		A := append(append([]byte(nil), D...), I...)
+		for i := 0; i < iterations; i++ {
+			d := h()
+			d.Write(A)
+			A = d.Sum(nil)
+		}
+		out = append(out, A...)
+
+		// I_j = (I_j + B + 1) mod 2^(8v)
+		B := make([]byte, v)
+		for i := range B {
+			B[i] = A[i%u]
+		}
+		for j := 0; j < len(I); j += v {
+			carry := uint16(1)
+			for k := v - 1; k >= 0; k-- {
+				sum := uint16(I[j+k]) + uint16(B[k]) + carry
+				I[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+	return out[:size]
+}
+
+// pbkdf2Key PBKDF2 of RFC 8018
+func pbkdf2Key(password, salt []byte, iterations, size int, h func() hash.Hash) []byte {
+	prf := hmac.New(h, password)
+	var out []byte
+	for block := uint32(1); len(out) < size; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		out = append(out, t...)
+	}
+	return out[:size]
+}
+
+// rc2Cipher RC2 block cipher (RFC 2268), still found in legacy PKCS#12
+// exports
+type rc2Cipher struct {
+	k [64]uint16
+}
+
+var rc2PiTable = [256]byte{
+	0xd9, 0x78, 0xf9, 0xc4, 0x19, 0xdd, 0xb5, 0xed, 0x28, 0xe9, 0xfd, 0x79, 0x4a, 0xa0, 0xd8, 0x9d,
+	0xc6, 0x7e, 0x37, 0x83, 0x2b, 0x76, 0x53, 0x8e, 0x62, 0x4c, 0x64, 0x88, 0x44, 0x8b, 0xfb, 0xa2,
+	0x17, 0x9a, 0x59, 0xf5, 0x87, 0xb3, 0x4f, 0x13, 0x61, 0x45, 0x6d, 0x8d, 0x09, 0x81, 0x7d, 0x32,
+	0xbd, 0x8f, 0x40, 0xeb, 0x86, 0xb7, 0x7b, 0x0b, 0xf0, 0x95, 0x21, 0x22, 0x5c, 0x6b, 0x4e, 0x82,
+	0x54, 0xd6, 0x65, 0x93, 0xce, 0x60, 0xb2, 0x1c, 0x73, 0x56, 0xc0, 0x14, 0xa7, 0x8c, 0xf1, 0xdc,
+	0x12, 0x75, 0xca, 0x1f, 0x3b, 0xbe, 0xe4, 0xd1, 0x42, 0x3d, 0xd4, 0x30, 0xa3, 0x3c, 0xb6, 0x26,
+	0x6f, 0xbf, 0x0e, 0xda, 0x46, 0x69, 0x07, 0x57, 0x27, 0xf2, 0x1d, 0x9b, 0xbc, 0x94, 0x43, 0x03,
+	0xf8, 0x11, 0xc7, 0xf6, 0x90, 0xef, 0x3e, 0xe7, 0x06, 0xc3, 0xd5, 0x2f, 0xc8, 0x66, 0x1e, 0xd7,
+	0x08, 0xe8, 0xea, 0xde, 0x80, 0x52, 0xee, 0xf7, 0x84, 0xaa, 0x72, 0xac, 0x35, 0x4d, 0x6a, 0x2a,
+	0x96, 0x1a, 0xd2, 0x71, 0x5a, 0x15, 0x49, 0x74, 0x4b, 0x9f, 0xd0, 0x5e, 0x04, 0x18, 0xa4, 0xec,
+	0xc2, 0xe0, 0x41, 0x6e, 0x0f, 0x51, 0xcb, 0xcc, 0x24, 0x91, 0xaf, 0x50, 0xa1, 0xf4, 0x70, 0x39,
+	0x99, 0x7c, 0x3a, 0x85, 0x23, 0xb8, 0xb4, 0x7a, 0xfc, 0x02, 0x36, 0x5b, 0x25, 0x55, 0x97, 0x31,
+	0x2d, 0x5d, 0xfa, 0x98, 0xe3, 0x8a, 0x92, 0xae, 0x05, 0xdf, 0x29, 0x10, 0x67, 0x6c, 0xba, 0xc9,
+	0xd3, 0x00, 0xe6, 0xcf, 0xe1, 0x9e, 0xa8, 0x2c, 0x63, 0x16, 0x01, 0x3f, 0x58, 0xe2, 0x89, 0xa9,
+	0x0d, 0x38, 0x34, 0x1b, 0xab, 0x33, 0xff, 0xb0, 0xbb, 0x48, 0x0c, 0x5f, 0xb9, 0xb1, 0xcd, 0x2e,
+	0xc5, 0xf3, 0xdb, 0x47, 0xe5, 0xa5, 0x9c, 0x77, 0x0a, 0xa6, 0x20, 0x68, 0xfe, 0x7f, 0xc1, 0xad,
+}
+
+// newRC2Cipher RC2 with an effective key size of bits
+func newRC2Cipher(key []byte, bits int) (cipher.Block, error) {
+	if len(key) == 0 || len(key) > 128 {
+		return nil, fmt.Errorf("Invalid RC2 key size %d", len(key))
+	}
+
+	var l [128]byte
+	copy(l[:], key)
+	t := len(key)
+	for i := t; i < 128; i++ {
+		l[i] = rc2PiTable[l[i-1]+l[i-t]]
+	}
+
+	t8 := (bits + 7) / 8
+	tm := byte(255 % (int(1) << uint(8+bits-8*t8)))
+	l[128-t8] = rc2PiTable[l[128-t8]&tm]
+	for i := 127 - t8; i >= 0; i-- {
+		l[i] = rc2PiTable[l[i+1]^l[i+t8]]
+	}
+
+	c := &rc2Cipher{}
+	for i := range c.k {
+		c.k[i] = uint16(l[2*i]) | uint16(l[2*i+1])<<8
+	}
+	return c, nil
+}
+
+func (c *rc2Cipher) BlockSize() int { return 8 }
+
+func (c *rc2Cipher) Encrypt(dst, src []byte) {
+	r := [4]uint16{
+		uint16(src[0]) | uint16(src[1])<<8, uint16(src[2]) | uint16(src[3])<<8,
+		uint16(src[4]) | uint16(src[5])<<8, uint16(src[6]) | uint16(src[7])<<8,
+	}
+	j := 0
+	mix := func() {
+		r[0] = bits.RotateLeft16(r[0]+c.k[j]+(r[3]&r[2])+(^r[3]&r[1]), 1)
+		j++
+		r[1] = bits.RotateLeft16(r[1]+c.k[j]+(r[0]&r[3])+(^r[0]&r[2]), 2)
+		j++
+		r[2] = bits.RotateLeft16(r[2]+c.k[j]+(r[1]&r[0])+(^r[1]&r[3]), 3)
+		j++
+		r[3] = bits.RotateLeft16(r[3]+c.k[j]+(r[2]&r[1])+(^r[2]&r[0]), 5)
+		j++
+	}
+	mash := func() {
+		r[0] += c.k[r[3]&63]
+		r[1] += c.k[r[0]&63]
+		r[2] += c.k[r[1]&63]
+		r[3] += c.k[r[2]&63]
+	}
+	for round := 0; round < 16; round++ {
+		mix()
+		if round == 4 || round == 10 {
+			mash()
+		}
+	}
+	for i, w := range r {
+		dst[2*i], dst[2*i+1] = byte(w), byte(w>>8)
+	}
+}
+
+func (c *rc2Cipher) Decrypt(dst, src []byte) {
+	r := [4]uint16{
+		uint16(src[0]) | uint16(src[1])<<8, uint16(src[2]) | uint16(src[3])<<8,
+		uint16(src[4]) | uint16(src[5])<<8, uint16(src[6]) | uint16(src[7])<<8,
+	}
+	j := 63
+	rmix := func() {
+		r[3] = bits.RotateLeft16(r[3], -5) - c.k[j] - (r[2] & r[1]) - (^r[2] & r[0])
+		j--
+		r[2] = bits.RotateLeft16(r[2], -3) - c.k[j] - (r[1] & r[0]) - (^r[1] & r[3])
+		j--
+		r[1] = bits.RotateLeft16(r[1], -2) - c.k[j] - (r[0] & r[3]) - (^r[0] & r[2])
+		j--
+		r[0] = bits.RotateLeft16(r[0], -1) - c.k[j] - (r[3] & r[2]) - (^r[3] & r[1])
+		j--
+	}
+	rmash := func() {
+		r[3] -= c.k[r[2]&63]
+		r[2] -= c.k[r[1]&63]
+		r[1] -= c.k[r[0]&63]
+		r[0] -= c.k[r[3]&63]
+	}
+	for round := 0; round < 16; round++ {
+		rmix()
+		if round == 4 || round == 10 {
+			rmash()
+		}
+	}
+	for i, w := range r {
+		dst[2*i], dst[2*i+1] = byte(w), byte(w>>8)
+	}
+}
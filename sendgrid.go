@@ -0,0 +1,205 @@
+package mailer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sendgrid/sendgrid-go"
+	"github.com/sendgrid/sendgrid-go/helpers/mail"
+)
+
+// SendgridMaxPersonalizations personalizations Sendgrid takes in one call
+const SendgridMaxPersonalizations = 1000
+
+// SendgridRecipient recipient of a batch send with its own personalization
+type SendgridRecipient struct {
+	Email         string
+	Name          string
+	Subject       string                 // replaces ConfigEmail.Subject
+	Substitutions map[string]string      // tags replaced in the content
+	TemplateData  map[string]interface{} // dynamic template data
+	Headers       map[string]string
+	CustomArgs    map[string]string
+}
+
+// SendgridBatchResult outcome of one Sendgrid call of a batch send
+type SendgridBatchResult struct {
+	Emails    []string
+	MessageID string
+	Err       error
+}
+
+// sendgridPersonalization personalization with the dynamic template data
+// the vendored helpers don't know about
+type sendgridPersonalization struct {
+	*mail.Personalization
+	DynamicTemplateData map[string]interface{} `json:"dynamic_template_data,omitempty"`
+}
+
+// sendgridMail v3 mail send body, its personalizations replacing the ones
+// of the helper
+type sendgridMail struct {
+	*mail.SGMailV3
+	Personalizations []*sendgridPersonalization `json:"personalizations,omitempty"`
+}
+
+// SendBatch send the message of ConfigEmail (EmailTo aside) to every
+// recipient with its own personalization, in calls of up to 1000
+// recipients. There is one result per call, and a last one with an Err
+// wrapping ErrSuppressed for the suppressed recipients left out; the error
+// reports failed calls after all of them were tried
+func (cfg *SDKConfigSengrid) SendBatch(recipients []SendgridRecipient) ([]SendgridBatchResult, error) {
+	if (len(cfg.ConfigEmail.ContentHTML) == 0 &&
+		len(cfg.ConfigEmail.ContentPlainText) == 0 &&
+		len(cfg.ConfigEmail.ContentMarkdown) == 0 &&
+		len(cfg.ConfigEmail.TemplateID) == 0) ||
+		len(cfg.ConfigEmail.EmailFrom) == 0 {
+		return nil, fmt.Errorf("Empty fields on ConfigEmail")
+	}
+	if err := cfg.Unsubscribe.check(); err != nil {
+		return nil, err
+	}
+
+	// suppressed recipients are left out
+	var suppressed []string
+	var send []SendgridRecipient
+	for _, r := range recipients {
+		if r.Email == "" {
+			return nil, fmt.Errorf("Empty Email in batch recipient")
+		}
+		if r.Subject == "" && cfg.ConfigEmail.Subject == "" && cfg.ConfigEmail.TemplateID == "" {
+			return nil, fmt.Errorf("Empty Subject for %s", r.Email)
+		}
+		if _, ok := cfg.Suppressions.Check(r.Email); ok {
+			suppressed = append(suppressed, r.Email)
+			continue
+		}
+		send = append(send, r)
+	}
+	recipients = send
+
+	sdk := cfg.newSDKSendgrid()
+	msg := cfg.sendgridMessage()
+
+	var results []SendgridBatchResult
+	failed := 0
+	for start := 0; start < len(recipients); start += SendgridMaxPersonalizations {
+		end := start + SendgridMaxPersonalizations
+		if end > len(recipients) {
+			end = len(recipients)
+		}
+
+		if cfg.Delay > 0 {
+			time.Sleep(cfg.Delay)
+		}
+
+		batch := &sendgridMail{SGMailV3: msg}
+		result := SendgridBatchResult{}
+		for _, r := range recipients[start:end] {
+			p := mail.NewPersonalization()
+			p.AddTos(mail.NewEmail(r.Name, r.Email))
+			p.Subject = r.Subject
+			for k, v := range r.Substitutions {
+				p.SetSubstitution(k, v)
+			}
+			unsubscribe, _ := cfg.Unsubscribe.Headers(r.Email)
+			for k, v := range unsubscribe {
+				p.SetHeader(k, v)
+			}
+			for k, v := range r.Headers {
+				p.SetHeader(k, v)
+			}
+			for k, v := range r.CustomArgs {
+				p.SetCustomArg(k, v)
+			}
+			data := r.TemplateData
+			if data == nil {
+				data = cfg.ConfigEmail.TemplateData
+			}
+			batch.Personalizations = append(batch.Personalizations, &sendgridPersonalization{
+				Personalization:     p,
+				DynamicTemplateData: data,
+			})
+			result.Emails = append(result.Emails, r.Email)
+		}
+
+		result.MessageID, result.Err = sdk.sendgridSend(batch)
+		if result.Err != nil {
+			failed++
+		}
+		results = append(results, result)
+	}
+
+	batches := len(results)
+	if len(suppressed) > 0 {
+		results = append(results, SendgridBatchResult{
+			Emails: suppressed,
+			Err:    fmt.Errorf("%w: %d recipients left out", ErrSuppressed, len(suppressed)),
+		})
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%d of %d Sendgrid batches failed", failed, batches)
+	}
+	return results, nil
+}
+
+// sendgridMessage message of ConfigEmail without its personalizations
+func (cfg *SDKConfigSengrid) sendgridMessage() *mail.SGMailV3 {
+	html, text := renderContent(cfg.ConfigEmail.ContentMarkdown,
+		cfg.ConfigEmail.ContentHTML,
+		cfg.ConfigEmail.ContentPlainText,
+		cfg.ConfigEmail.InlineCSS,
+	)
+
+	msg := new(mail.SGMailV3)
+	msg.SetFrom(mail.NewEmail(cfg.ConfigEmail.EmailFromName, cfg.ConfigEmail.EmailFrom))
+	msg.Subject = cfg.ConfigEmail.Subject
+	if text != "" {
+		msg.AddContent(mail.NewContent("text/plain", text))
+	}
+	if html != "" {
+		msg.AddContent(mail.NewContent("text/html", html))
+	}
+
+	msg.TemplateID = cfg.ConfigEmail.TemplateID
+	msg.AddCategories(cfg.ConfigEmail.Categories...)
+	for _, k := range sortedKeys(cfg.ConfigEmail.CustomArgs) {
+		msg.SetCustomArg(k, cfg.ConfigEmail.CustomArgs[k])
+	}
+	if cfg.ConfigEmail.ASMGroupID != 0 {
+		msg.SetASM(mail.NewASM().SetGroupID(cfg.ConfigEmail.ASMGroupID).
+			AddGroupsToDisplay(cfg.ConfigEmail.ASMGroupsToDisplay...))
+	}
+	msg.SetIPPoolID(cfg.ConfigEmail.IPPool)
+	if !cfg.ConfigEmail.SendAt.IsZero() {
+		msg.SetSendAt(int(cfg.ConfigEmail.SendAt.Unix()))
+	}
+	return msg
+}
+
+// sendgridSend post msg to the mail send endpoint and return the
+// X-Message-Id of the accepted message
+func (sdk *SDK) sendgridSend(msg *sendgridMail) (string, error) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+
+	request := sdk.Sendgrid.Request
+	request.Body = body
+	res, err := sendgrid.API(request)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode >= 300 {
+		return "", fmt.Errorf("Sendgrid error %d: %s", res.StatusCode, res.Body)
+	}
+	for k, v := range res.Headers {
+		if len(v) > 0 && http.CanonicalHeaderKey(k) == "X-Message-Id" {
+			return v[0], nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,194 @@
+package mailer_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/thiagozs/mailer-go"
+)
+
+// sendgridStub local mail send endpoint keeping the decoded request bodies
+func sendgridStub(t *testing.T, status int) (*httptest.Server, *[]map[string]interface{}) {
+	var bodies []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/mail/send" || r.Header.Get("Authorization") != "Bearer key" {
+			t.Errorf("Sendgrid stub got: %s %v", r.URL.Path, r.Header)
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		body := map[string]interface{}{}
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("Sendgrid stub got: %s", err)
+		}
+		bodies = append(bodies, body)
+		if status >= 300 {
+			w.WriteHeader(status)
+			w.Write([]byte(`{"errors":[{"message":"bad request"}]}`))
+			return
+		}
+		w.Header().Set("X-Message-Id", "sg-stub")
+		w.WriteHeader(status)
+	}))
+	return srv, &bodies
+}
+
+func TestSendgridDynamicTemplate(t *testing.T) {
+	t.Log("SendMail(Sendgrid) dynamic template and options... (NOT expected some err)")
+	srv, bodies := sendgridStub(t, http.StatusAccepted)
+	defer srv.Close()
+
+	sendAt := time.Date(2026, 10, 20, 9, 0, 0, 0, time.UTC)
+	sg := mailer.NewMailerSendGrid("key")
+	sg.Host = srv.URL
+	sg.ConfigEmail = mailer.ConfigEmailSendgrid{
+		EmailFrom:          "sender@host.com",
+		EmailFromName:      "Sender",
+		EmailTo:            "client@host.com",
+		EmailToName:        "Client",
+		TemplateID:         "d-123",
+		TemplateData:       map[string]interface{}{"name": "Ana", "items": []string{"a", "b"}},
+		Categories:         []string{"welcome"},
+		CustomArgs:         map[string]string{"user_id": "42"},
+		ASMGroupID:         7,
+		ASMGroupsToDisplay: []int{7, 8},
+		IPPool:             "marketing",
+		SendAt:             sendAt,
+	}
+
+	if err := sg.SendMail(); err != nil {
+		t.Fatalf("SendMail got: %s", err)
+	}
+	if sg.MessageID != "sg-stub" {
+		t.Errorf("MessageID got: %q", sg.MessageID)
+	}
+
+	body := (*bodies)[0]
+	p := body["personalizations"].([]interface{})[0].(map[string]interface{})
+	data, _ := json.Marshal(p["dynamic_template_data"])
+	asm, _ := json.Marshal(body["asm"])
+	for name, c := range map[string][2]interface{}{
+		"template_id":           {body["template_id"], "d-123"},
+		"dynamic_template_data": {string(data), `{"items":["a","b"],"name":"Ana"}`},
+		"to":                    {p["to"].([]interface{})[0].(map[string]interface{})["email"], "client@host.com"},
+		"categories":            {body["categories"].([]interface{})[0], "welcome"},
+		"custom_args":           {body["custom_args"].(map[string]interface{})["user_id"], "42"},
+		"asm":                   {string(asm), `{"group_id":7,"groups_to_display":[7,8]}`},
+		"ip_pool_name":          {body["ip_pool_name"], "marketing"},
+		"send_at":               {body["send_at"], float64(sendAt.Unix())},
+	} {
+		if c[0] != c[1] {
+			t.Errorf("SendMail %s got: %v", name, c[0])
+		}
+	}
+	if _, ok := body["content"]; ok {
+		t.Errorf("SendMail got content with a template: %v", body["content"])
+	}
+}
+
+func TestSendgridErrorStatus(t *testing.T) {
+	t.Log("SendMail(Sendgrid) rejected by the API... (expected some err)")
+	srv, _ := sendgridStub(t, http.StatusBadRequest)
+	defer srv.Close()
+
+	sg := mailer.NewMailerSendGrid("key")
+	sg.Host = srv.URL
+	sg.ConfigEmail = mailer.ConfigEmailSendgrid{
+		ContentPlainText: "test",
+		EmailFrom:        "sender@host.com",
+		EmailFromName:    "Sender",
+		EmailTo:          "client@host.com",
+		EmailToName:      "Client",
+		Subject:          "Test",
+	}
+
+	if err := sg.SendMail(); err == nil || sg.MessageID != "" {
+		t.Errorf("SendMail got: %v %q", err, sg.MessageID)
+	}
+}
+
+func TestSendgridSendBatch(t *testing.T) {
+	t.Log("SendBatch(Sendgrid) in calls of 1000 personalizations... (NOT expected some err)")
+	srv, bodies := sendgridStub(t, http.StatusAccepted)
+	defer srv.Close()
+
+	sg := mailer.NewMailerSendGrid("key")
+	sg.Host = srv.URL
+	sg.ConfigEmail = mailer.ConfigEmailSendgrid{
+		ContentPlainText: "Hi -name-",
+		EmailFrom:        "sender@host.com",
+		EmailFromName:    "Sender",
+		Subject:          "Newsletter",
+		Categories:       []string{"newsletter"},
+	}
+
+	var recipients []mailer.SendgridRecipient
+	for i := 0; i < 2500; i++ {
+		recipients = append(recipients, mailer.SendgridRecipient{
+			Email:         fmt.Sprintf("user%d@host.com", i),
+			Substitutions: map[string]string{"-name-": fmt.Sprintf("user %d", i)},
+		})
+	}
+	recipients[0].Subject = "Special edition"
+	recipients[0].Headers = map[string]string{"X-Tier": "gold"}
+
+	results, err := sg.SendBatch(recipients)
+	if err != nil {
+		t.Fatalf("SendBatch got: %s", err)
+	}
+	if len(results) != 3 || len(*bodies) != 3 {
+		t.Fatalf("SendBatch got: %d results, %d calls", len(results), len(*bodies))
+	}
+	for i, want := range []int{1000, 1000, 500} {
+		ps := (*bodies)[i]["personalizations"].([]interface{})
+		if len(ps) != want || len(results[i].Emails) != want || results[i].MessageID != "sg-stub" || results[i].Err != nil {
+			t.Errorf("SendBatch call %d got: %d personalizations, %+v", i, len(ps), results[i].Err)
+		}
+		if (*bodies)[i]["subject"] != "Newsletter" || (*bodies)[i]["categories"].([]interface{})[0] != "newsletter" {
+			t.Errorf("SendBatch call %d got: %v", i, (*bodies)[i]["subject"])
+		}
+	}
+	if results[2].Emails[499] != "user2499@host.com" {
+		t.Errorf("SendBatch got: %s", results[2].Emails[499])
+	}
+
+	first := (*bodies)[0]["personalizations"].([]interface{})[0].(map[string]interface{})
+	if first["subject"] != "Special edition" ||
+		first["headers"].(map[string]interface{})["X-Tier"] != "gold" ||
+		first["substitutions"].(map[string]interface{})["-name-"] != "user 0" {
+		t.Errorf("SendBatch personalization got: %v", first)
+	}
+}
+
+func TestSendgridSendBatchErrors(t *testing.T) {
+	t.Log("SendBatch(Sendgrid) with failing calls... (expected some err)")
+	srv, bodies := sendgridStub(t, http.StatusBadRequest)
+	defer srv.Close()
+
+	sg := mailer.NewMailerSendGrid("key")
+	sg.Host = srv.URL
+	sg.ConfigEmail = mailer.ConfigEmailSendgrid{
+		TemplateID: "d-123",
+		EmailFrom:  "sender@host.com",
+	}
+
+	results, err := sg.SendBatch([]mailer.SendgridRecipient{{Email: "a@host.com"}, {Email: "b@host.com"}})
+	if err == nil || len(results) != 1 || results[0].Err == nil {
+		t.Errorf("SendBatch got: %v %+v", err, results)
+	}
+
+	if _, err := sg.SendBatch([]mailer.SendgridRecipient{{Name: "no email"}}); err == nil {
+		t.Errorf("SendBatch expected an error for a recipient without email")
+	}
+	sg.ConfigEmail.TemplateID = ""
+	sg.ConfigEmail.ContentPlainText = "no subject"
+	if _, err := sg.SendBatch([]mailer.SendgridRecipient{{Email: "a@host.com"}}); err == nil {
+		t.Errorf("SendBatch expected an error without a subject")
+	}
+	if len(*bodies) != 1 {
+		t.Errorf("SendBatch got: %d calls", len(*bodies))
+	}
+}
@@ -0,0 +1,378 @@
+package mailer
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ses"
+)
+
+// SESBulkMaxDestinations destinations SES takes in one bulk templated call
+const SESBulkMaxDestinations = 50
+
+// SESTemplate stored SES template; parts use {{name}} replacement tags
+type SESTemplate struct {
+	Name    string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// SESBulkDestination recipient of a bulk templated send
+type SESBulkDestination struct {
+	EmailTo string
+	Data    interface{}       // replacement data, marshaled to JSON
+	Tags    map[string]string // message tags replacing the default ones
+}
+
+// SESBulkResult outcome of a bulk templated send for one destination
+type SESBulkResult struct {
+	EmailTo   string
+	MessageID string
+	Status    string // "Success" or the SES error code
+	Error     string
+}
+
+// The SES operations below are newer than the vendored SDK, so their
+// inputs and outputs are declared here and sent through the SDK client
+
+type sesTemplate struct {
+	_            struct{} `type:"structure"`
+	TemplateName *string  `type:"string"`
+	SubjectPart  *string  `type:"string"`
+	TextPart     *string  `type:"string"`
+	HtmlPart     *string  `type:"string"`
+}
+
+type sesTemplateInput struct {
+	_        struct{}     `type:"structure"`
+	Template *sesTemplate `type:"structure"`
+}
+
+type sesTemplateNameInput struct {
+	_            struct{} `type:"structure"`
+	TemplateName *string  `type:"string"`
+}
+
+type sesGetTemplateOutput struct {
+	_        struct{}     `type:"structure"`
+	Template *sesTemplate `type:"structure"`
+}
+
+type sesTestRenderTemplateInput struct {
+	_            struct{} `type:"structure"`
+	TemplateName *string  `type:"string"`
+	TemplateData *string  `type:"string"`
+}
+
+type sesTestRenderTemplateOutput struct {
+	_                struct{} `type:"structure"`
+	RenderedTemplate *string  `type:"string"`
+}
+
+type sesSendTemplatedEmailInput struct {
+	_                    struct{}          `type:"structure"`
+	ConfigurationSetName *string           `type:"string"`
+	Destination          *ses.Destination  `type:"structure"`
+	ReplyToAddresses     []*string         `type:"list"`
+	ReturnPath           *string           `type:"string"`
+	Source               *string           `type:"string"`
+	Tags                 []*ses.MessageTag `type:"list"`
+	Template             *string           `type:"string"`
+	TemplateData         *string           `type:"string"`
+}
+
+type sesSendTemplatedEmailOutput struct {
+	_         struct{} `type:"structure"`
+	MessageId *string  `type:"string"`
+}
+
+type sesBulkEmailDestination struct {
+	_                       struct{}          `type:"structure"`
+	Destination             *ses.Destination  `type:"structure"`
+	ReplacementTags         []*ses.MessageTag `type:"list"`
+	ReplacementTemplateData *string           `type:"string"`
+}
+
+type sesSendBulkTemplatedEmailInput struct {
+	_                    struct{}                   `type:"structure"`
+	ConfigurationSetName *string                    `type:"string"`
+	DefaultTags          []*ses.MessageTag          `type:"list"`
+	DefaultTemplateData  *string                    `type:"string"`
+	Destinations         []*sesBulkEmailDestination `type:"list"`
+	ReplyToAddresses     []*string                  `type:"list"`
+	ReturnPath           *string                    `type:"string"`
+	Source               *string                    `type:"string"`
+	Template             *string                    `type:"string"`
+}
+
+type sesBulkEmailDestinationStatus struct {
+	_         struct{} `type:"structure"`
+	Error     *string  `type:"string"`
+	MessageId *string  `type:"string"`
+	Status    *string  `type:"string"`
+}
+
+type sesSendBulkTemplatedEmailOutput struct {
+	_      struct{}                         `type:"structure"`
+	Status []*sesBulkEmailDestinationStatus `type:"list"`
+}
+
+type sesEmptyOutput struct {
+	_ struct{} `type:"structure"`
+}
+
+// sesCall run the SES operation named op
+func (cfg *SDKConfigAWSSES) sesCall(op string, input, output interface{}) error {
+	client, err := cfg.Client()
+	if err != nil {
+		return err
+	}
+	req := client.NewRequest(&request.Operation{
+		Name:       op,
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}, input, output)
+	return req.Send()
+}
+
+// CreateTemplate store a new SES template
+func (cfg *SDKConfigAWSSES) CreateTemplate(tpl SESTemplate) error {
+	if tpl.Name == "" {
+		return fmt.Errorf("Empty SES template name")
+	}
+	return cfg.sesCall("CreateTemplate", &sesTemplateInput{Template: tpl.input()}, &sesEmptyOutput{})
+}
+
+// UpdateTemplate replace the parts of a stored SES template
+func (cfg *SDKConfigAWSSES) UpdateTemplate(tpl SESTemplate) error {
+	if tpl.Name == "" {
+		return fmt.Errorf("Empty SES template name")
+	}
+	return cfg.sesCall("UpdateTemplate", &sesTemplateInput{Template: tpl.input()}, &sesEmptyOutput{})
+}
+
+// DeleteTemplate remove a stored SES template
+func (cfg *SDKConfigAWSSES) DeleteTemplate(name string) error {
+	return cfg.sesCall("DeleteTemplate", &sesTemplateNameInput{TemplateName: &name}, &sesEmptyOutput{})
+}
+
+// GetTemplate read a stored SES template
+func (cfg *SDKConfigAWSSES) GetTemplate(name string) (*SESTemplate, error) {
+	out := &sesGetTemplateOutput{}
+	if err := cfg.sesCall("GetTemplate", &sesTemplateNameInput{TemplateName: &name}, out); err != nil {
+		return nil, err
+	}
+	if out.Template == nil {
+		return nil, fmt.Errorf("SES template %s not found", name)
+	}
+	return &SESTemplate{
+		Name:    aws.StringValue(out.Template.TemplateName),
+		Subject: aws.StringValue(out.Template.SubjectPart),
+		Text:    aws.StringValue(out.Template.TextPart),
+		HTML:    aws.StringValue(out.Template.HtmlPart),
+	}, nil
+}
+
+// TestRenderTemplate raw MIME message SES renders from a stored template
+// and data
+func (cfg *SDKConfigAWSSES) TestRenderTemplate(name string, data interface{}) (string, error) {
+	templateData, err := sesTemplateData(data)
+	if err != nil {
+		return "", err
+	}
+	out := &sesTestRenderTemplateOutput{}
+	err = cfg.sesCall("TestRenderTemplate", &sesTestRenderTemplateInput{
+		TemplateName: &name,
+		TemplateData: templateData,
+	}, out)
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(out.RenderedTemplate), nil
+}
+
+// SendTemplatedMail send a stored template filled with data to
+// ConfigEmail.EmailTo; content fields of ConfigEmail are not used
+func (cfg *SDKConfigAWSSES) SendTemplatedMail(template string, data interface{}) error {
+	cfg.MessageID = ""
+	if len(cfg.ConfigEmail.EmailFrom) == 0 || len(cfg.ConfigEmail.EmailTo) == 0 || template == "" {
+		return fmt.Errorf("Empty fields on ConfigEmail")
+	}
+	if err := cfg.Suppressions.check(cfg.ConfigEmail.EmailTo); err != nil {
+		return err
+	}
+	templateData, err := sesTemplateData(data)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Delay > 0 {
+		time.Sleep(cfg.Delay)
+	}
+
+	input := &sesSendTemplatedEmailInput{
+		Source:       &cfg.ConfigEmail.EmailFrom,
+		Destination:  &ses.Destination{ToAddresses: aws.StringSlice([]string{cfg.ConfigEmail.EmailTo})},
+		Template:     &template,
+		TemplateData: templateData,
+		Tags:         sesTags(cfg.ConfigEmail.MessageTags),
+	}
+	cfg.setTemplatedOptions(&input.ReplyToAddresses, &input.ReturnPath, &input.ConfigurationSetName)
+
+	out := &sesSendTemplatedEmailOutput{}
+	if err := cfg.sesCall("SendTemplatedEmail", input, out); err != nil {
+		return err
+	}
+	cfg.MessageID = aws.StringValue(out.MessageId)
+	return nil
+}
+
+// SendBulkTemplatedMail send a stored template to every destination, with
+// its own replacement data over defaultData; SES takes 50 destinations per
+// call, so larger lists are split. Results follow the order of
+// destinations, suppressed ones with the Status "Suppressed"; on a failed
+// call the results before it are returned with the error
+func (cfg *SDKConfigAWSSES) SendBulkTemplatedMail(template string, defaultData interface{}, destinations []SESBulkDestination) ([]SESBulkResult, error) {
+	if len(cfg.ConfigEmail.EmailFrom) == 0 || template == "" {
+		return nil, fmt.Errorf("Empty fields on ConfigEmail")
+	}
+	defaults, err := sesTemplateData(defaultData)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []SESBulkResult
+	var chunk []SESBulkDestination
+	var at []int // index in results of each destination of chunk
+	sent := func() []SESBulkResult {
+		if len(at) > 0 {
+			return results[:at[0]]
+		}
+		return results
+	}
+	for n, d := range destinations {
+		if d.EmailTo == "" {
+			return sent(), fmt.Errorf("Empty EmailTo in bulk destination")
+		}
+		if s, ok := cfg.Suppressions.Check(d.EmailTo); ok {
+			results = append(results, SESBulkResult{EmailTo: d.EmailTo, Status: "Suppressed", Error: s.Reason})
+		} else {
+			at = append(at, len(results))
+			results = append(results, SESBulkResult{EmailTo: d.EmailTo})
+			chunk = append(chunk, d)
+		}
+		last := n == len(destinations)-1
+		if len(chunk) == 0 || (len(chunk) < SESBulkMaxDestinations && !last) {
+			continue
+		}
+
+		out, err := cfg.sendBulkChunk(template, defaults, chunk)
+		if err != nil {
+			return sent(), err
+		}
+		for i, k := range at {
+			if i < len(out.Status) {
+				results[k].MessageID = aws.StringValue(out.Status[i].MessageId)
+				results[k].Status = aws.StringValue(out.Status[i].Status)
+				results[k].Error = aws.StringValue(out.Status[i].Error)
+			}
+		}
+		chunk, at = nil, nil
+	}
+	return results, nil
+}
+
+// sendBulkChunk send a stored template to up to 50 destinations
+func (cfg *SDKConfigAWSSES) sendBulkChunk(template string, defaults *string, chunk []SESBulkDestination) (*sesSendBulkTemplatedEmailOutput, error) {
+	if cfg.Delay > 0 {
+		time.Sleep(cfg.Delay)
+	}
+
+	input := &sesSendBulkTemplatedEmailInput{
+		Source:              &cfg.ConfigEmail.EmailFrom,
+		Template:            &template,
+		DefaultTemplateData: defaults,
+		DefaultTags:         sesTags(cfg.ConfigEmail.MessageTags),
+	}
+	cfg.setTemplatedOptions(&input.ReplyToAddresses, &input.ReturnPath, &input.ConfigurationSetName)
+	for _, d := range chunk {
+		dest := &sesBulkEmailDestination{
+			Destination:     &ses.Destination{ToAddresses: aws.StringSlice([]string{d.EmailTo})},
+			ReplacementTags: sesTags(d.Tags),
+		}
+		if d.Data != nil {
+			data, err := sesTemplateData(d.Data)
+			if err != nil {
+				return nil, err
+			}
+			dest.ReplacementTemplateData = data
+		}
+		input.Destinations = append(input.Destinations, dest)
+	}
+
+	out := &sesSendBulkTemplatedEmailOutput{}
+	if err := cfg.sesCall("SendBulkTemplatedEmail", input, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// setTemplatedOptions reply-to, return path and configuration set of the
+// templated sends
+func (cfg *SDKConfigAWSSES) setTemplatedOptions(replyTo *[]*string, returnPath, configurationSet **string) {
+	if len(cfg.ConfigEmail.ReplyTo) > 0 {
+		*replyTo = aws.StringSlice(cfg.ConfigEmail.ReplyTo)
+	}
+	if cfg.ConfigEmail.ReturnPath != "" {
+		*returnPath = &cfg.ConfigEmail.ReturnPath
+	}
+	if cfg.ConfigEmail.ConfigurationSetName != "" {
+		*configurationSet = &cfg.ConfigEmail.ConfigurationSetName
+	}
+}
+
+// input SES form of the template
+func (tpl SESTemplate) input() *sesTemplate {
+	t := &sesTemplate{TemplateName: aws.String(tpl.Name), SubjectPart: aws.String(tpl.Subject)}
+	if tpl.Text != "" {
+		t.TextPart = aws.String(tpl.Text)
+	}
+	if tpl.HTML != "" {
+		t.HtmlPart = aws.String(tpl.HTML)
+	}
+	return t
+}
+
+// sesTemplateData JSON replacement data; strings and byte slices are taken
+// as JSON already, nil is an empty object
+func sesTemplateData(data interface{}) (*string, error) {
+	switch d := data.(type) {
+	case nil:
+		return aws.String("{}"), nil
+	case string:
+		return aws.String(d), nil
+	case []byte:
+		return aws.String(string(d)), nil
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return aws.String(string(b)), nil
+}
+
+// sesTags message tags of m, sorted by name
+func sesTags(m map[string]string) []*ses.MessageTag {
+	var tags []*ses.MessageTag
+	for _, name := range sortedKeys(m) {
+		tags = append(tags, &ses.MessageTag{
+			Name:  aws.String(name),
+			Value: aws.String(m[name]),
+		})
+	}
+	return tags
+}
@@ -0,0 +1,143 @@
+package mailer_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/thiagozs/mailer-go"
+)
+
+func sesStubMailer(endpoint string) *mailer.SDKConfigAWSSES {
+	mg := mailer.NewMailerAWSSES("key", "secret", "us-east-1")
+	mg.Endpoint = endpoint
+	mg.ConfigEmail = mailer.ConfigEmailAWSSES{
+		EmailFrom:            "sender@host.com",
+		EmailTo:              "client@host.com",
+		ConfigurationSetName: "tracking",
+		MessageTags:          map[string]string{"campaign": "launch"},
+	}
+	return mg
+}
+
+func TestSESTemplates(t *testing.T) {
+	t.Log("SES template create, update, get, render and delete... (NOT expected some err)")
+	srv, call := sesStub(t)
+	defer srv.Close()
+	mg := sesStubMailer(srv.URL)
+
+	tpl := mailer.SESTemplate{Name: "welcome", Subject: "Hi {{name}}", Text: "Hello {{name}}"}
+	if err := mg.CreateTemplate(tpl); err != nil {
+		t.Fatalf("CreateTemplate got: %s", err)
+	}
+	for k, v := range map[string]string{
+		"Action":                "CreateTemplate",
+		"Template.TemplateName": "welcome",
+		"Template.SubjectPart":  "Hi {{name}}",
+		"Template.TextPart":     "Hello {{name}}",
+		"Template.HtmlPart":     "",
+	} {
+		if call.form.Get(k) != v {
+			t.Errorf("CreateTemplate %s got: %q", k, call.form.Get(k))
+		}
+	}
+
+	tpl.HTML = "<p>Hello {{name}}</p>"
+	if err := mg.UpdateTemplate(tpl); err != nil || call.form.Get("Template.HtmlPart") != tpl.HTML {
+		t.Errorf("UpdateTemplate got: %v %v", err, call.form)
+	}
+
+	got, err := mg.GetTemplate("welcome")
+	if err != nil || got.Name != "welcome" || got.Subject != "Hi {{name}}" || got.Text != "Hello {{name}}" {
+		t.Errorf("GetTemplate got: %+v %v", got, err)
+	}
+
+	rendered, err := mg.TestRenderTemplate("welcome", map[string]string{"name": "Ana"})
+	if err != nil || rendered != "Subject: Hi Ana" {
+		t.Errorf("TestRenderTemplate got: %q %v", rendered, err)
+	}
+	if call.form.Get("TemplateData") != `{"name":"Ana"}` {
+		t.Errorf("TestRenderTemplate TemplateData got: %s", call.form.Get("TemplateData"))
+	}
+
+	if err := mg.DeleteTemplate("welcome"); err != nil || call.form.Get("Action") != "DeleteTemplate" ||
+		call.form.Get("TemplateName") != "welcome" {
+		t.Errorf("DeleteTemplate got: %v %v", err, call.form)
+	}
+
+	if err := mg.CreateTemplate(mailer.SESTemplate{}); err == nil {
+		t.Errorf("CreateTemplate expected an error without a name")
+	}
+}
+
+func TestSESSendTemplatedMail(t *testing.T) {
+	t.Log("SES templated send... (NOT expected some err)")
+	srv, call := sesStub(t)
+	defer srv.Close()
+	mg := sesStubMailer(srv.URL)
+
+	if err := mg.SendTemplatedMail("welcome", `{"name":"Ana"}`); err != nil {
+		t.Fatalf("SendTemplatedMail got: %s", err)
+	}
+	for k, v := range map[string]string{
+		"Action":                           "SendTemplatedEmail",
+		"Source":                           "sender@host.com",
+		"Destination.ToAddresses.member.1": "client@host.com",
+		"Template":                         "welcome",
+		"TemplateData":                     `{"name":"Ana"}`,
+		"ConfigurationSetName":             "tracking",
+		"Tags.member.1.Name":               "campaign",
+	} {
+		if call.form.Get(k) != v {
+			t.Errorf("SendTemplatedEmail %s got: %q", k, call.form.Get(k))
+		}
+	}
+	if mg.MessageID != "0100-stub" {
+		t.Errorf("MessageID got: %q", mg.MessageID)
+	}
+}
+
+func TestSESSendBulkTemplatedMail(t *testing.T) {
+	t.Log("SES bulk templated send in chunks of 50... (NOT expected some err)")
+	srv, call := sesStub(t)
+	defer srv.Close()
+	mg := sesStubMailer(srv.URL)
+
+	var destinations []mailer.SESBulkDestination
+	for i := 0; i < 120; i++ {
+		destinations = append(destinations, mailer.SESBulkDestination{
+			EmailTo: fmt.Sprintf("user%d@host.com", i),
+			Data:    map[string]int{"n": i},
+		})
+	}
+	destinations[0].Tags = map[string]string{"campaign": "vip"}
+
+	results, err := mg.SendBulkTemplatedMail("welcome", map[string]string{"name": "friend"}, destinations)
+	if err != nil {
+		t.Fatalf("SendBulkTemplatedMail got: %s", err)
+	}
+	if len(call.forms) != 3 {
+		t.Fatalf("SendBulkTemplatedMail got: %d calls", len(call.forms))
+	}
+	for i, want := range []int{50, 50, 20} {
+		form := call.forms[i]
+		last := fmt.Sprintf("Destinations.member.%d.Destination.ToAddresses.member.1", want)
+		if form.Get(last) == "" || form.Get(fmt.Sprintf("Destinations.member.%d.Destination.ToAddresses.member.1", want+1)) != "" {
+			t.Errorf("SendBulkTemplatedMail call %d got: %d destinations expected", i, want)
+		}
+		if form.Get("DefaultTemplateData") != `{"name":"friend"}` || form.Get("DefaultTags.member.1.Value") != "launch" {
+			t.Errorf("SendBulkTemplatedMail call %d got: %v", i, form)
+		}
+	}
+	first := call.forms[0]
+	if first.Get("Destinations.member.1.ReplacementTemplateData") != `{"n":0}` ||
+		first.Get("Destinations.member.1.ReplacementTags.member.1.Value") != "vip" {
+		t.Errorf("SendBulkTemplatedMail destination got: %v", first)
+	}
+
+	if len(results) != 120 {
+		t.Fatalf("SendBulkTemplatedMail got: %d results", len(results))
+	}
+	if r := results[119]; r.EmailTo != "user119@host.com" || r.Status != "Success" || r.MessageID != "bulk-320" {
+		t.Errorf("SendBulkTemplatedMail result got: %+v", r)
+	}
+}
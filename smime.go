@@ -0,0 +1,696 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"mime"
+	"mime/quotedprintable"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	oidPKCS7SignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidPKCS7EnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+
+	oidAttributeContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttributeMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttributeSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+
+	oidRSAEncryption   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSHA256WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+// SMIMEOptions S/MIME settings of a sender: messages are signed when
+// Certificate and PrivateKey are set, and encrypted when Recipients are
+type SMIMEOptions struct {
+	Certificate   *x509.Certificate
+	PrivateKey    crypto.Signer
+	Intermediates []*x509.Certificate
+	Recipients    []*x509.Certificate
+}
+
+// LoadSMIMEPKCS12 signing options from a PKCS#12 (.p12/.pfx) file; other
+// certificates of the file are sent as intermediates
+func LoadSMIMEPKCS12(path, password string) (*SMIMEOptions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, certs, err := decodePKCS12(data, password)
+	if err != nil {
+		return nil, err
+	}
+	return &SMIMEOptions{Certificate: certs[0], PrivateKey: key, Intermediates: certs[1:]}, nil
+}
+
+// LoadSMIMEPEM signing options from a PEM certificate chain and key
+func LoadSMIMEPEM(certFile, keyFile string) (*SMIMEOptions, error) {
+	certs, err := LoadSMIMECertificates(certFile)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePEMPrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	for i, c := range certs {
+		if publicKeyEqual(c.PublicKey, key.Public()) {
+			certs[0], certs[i] = certs[i], certs[0]
+			return &SMIMEOptions{Certificate: certs[0], PrivateKey: key, Intermediates: certs[1:]}, nil
+		}
+	}
+	return nil, fmt.Errorf("No certificate in %s for the private key", certFile)
+}
+
+// LoadSMIMECertificates certificates of a PEM file, e.g. the recipients
+// of encrypted mail
+func LoadSMIMECertificates(path string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("No certificate in %s", path)
+	}
+	return certs, nil
+}
+
+// parsePEMPrivateKey PKCS#1, SEC 1 or PKCS#8 PEM private key
+func parsePEMPrivateKey(data []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("No private key in PEM data")
+		}
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			return x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			return x509.ParseECPrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			signer, ok := key.(crypto.Signer)
+			if !ok {
+				return nil, fmt.Errorf("Unsupported private key type %T", key)
+			}
+			return signer, nil
+		case "ENCRYPTED PRIVATE KEY":
+			return nil, fmt.Errorf("Encrypted PEM keys are not supported, use PKCS#12")
+		}
+	}
+}
+
+// publicKeyEqual report whether a and b are the same public key
+func publicKeyEqual(a, b crypto.PublicKey) bool {
+	k, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && k.Equal(b)
+}
+
+// ApplySMIME sign and/or encrypt a raw message: its Content-* header
+// fields and body become the protected entity, the other fields are kept
+func ApplySMIME(message []byte, opts *SMIMEOptions) ([]byte, error) {
+	m := parseRawMessage(message)
+	if err := m.applySMIME(opts); err != nil {
+		return nil, err
+	}
+	return m.bytes(), nil
+}
+
+// applySMIME sign and/or encrypt the body of the message
+func (m *rawMessage) applySMIME(opts *SMIMEOptions) error {
+	if opts == nil || m.body == nil {
+		return nil
+	}
+	if opts.Certificate != nil || opts.PrivateKey != nil {
+		signed, err := smimeSign(m.body, opts)
+		if err != nil {
+			return err
+		}
+		m.body = signed
+	}
+	if len(opts.Recipients) > 0 {
+		encrypted, err := smimeEncrypt(m.body, opts.Recipients)
+		if err != nil {
+			return err
+		}
+		m.body = encrypted
+	}
+	return nil
+}
+
+// smimeSign multipart/signed holding part and its detached signature
+func smimeSign(part *mimePart, opts *SMIMEOptions) (*mimePart, error) {
+	if opts.Certificate == nil || opts.PrivateKey == nil {
+		return nil, fmt.Errorf("S/MIME signing needs a certificate and a private key")
+	}
+	sig, err := pkcs7SignDetached(part.bytes(), opts.Certificate, opts.PrivateKey, opts.Intermediates)
+	if err != nil {
+		return nil, err
+	}
+
+	sigPart := newBase64Part("application/pkcs7-signature; name=\"smime.p7s\"", sig)
+	sigPart.header.set("Content-Disposition", "attachment; filename=\"smime.p7s\"")
+	sigPart.header.set("Content-Description", "S/MIME Cryptographic Signature")
+
+	signed := &mimePart{parts: []*mimePart{part, sigPart}}
+	signed.header.set("Content-Type", fmt.Sprintf("multipart/signed; protocol=\"application/pkcs7-signature\"; micalg=sha-256; boundary=\"%s\"", newBoundary()))
+	return signed, nil
+}
+
+// smimeEncrypt application/pkcs7-mime enveloping part for recipients
+func smimeEncrypt(part *mimePart, recipients []*x509.Certificate) (*mimePart, error) {
+	env, err := pkcs7Envelope(part.bytes(), recipients)
+	if err != nil {
+		return nil, err
+	}
+	p := newBase64Part("application/pkcs7-mime; smime-type=enveloped-data; name=\"smime.p7m\"", env)
+	p.header.set("Content-Disposition", "attachment; filename=\"smime.p7m\"")
+	p.header.set("Content-Description", "S/MIME Encrypted Message")
+	return p, nil
+}
+
+// VerifySMIME check the multipart/signed S/MIME message or entity and
+// return the signer certificate; the chain is checked against roots (the
+// system pool when nil)
+func VerifySMIME(message []byte, roots *x509.CertPool) (*x509.Certificate, error) {
+	header, body, err := splitMIMEEntity(message)
+	if err != nil {
+		return nil, err
+	}
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/signed" {
+		return nil, fmt.Errorf("Not a multipart/signed message")
+	}
+	protocol := strings.ToLower(params["protocol"])
+	if protocol != "application/pkcs7-signature" && protocol != "application/x-pkcs7-signature" {
+		return nil, fmt.Errorf("Unsupported signature protocol %q", params["protocol"])
+	}
+
+	parts := splitMultipartRaw(body, params["boundary"])
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("multipart/signed must have two parts, got %d", len(parts))
+	}
+	sigHeader, sigBody, err := splitMIMEEntity(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	sig, err := decodeTransferEncoding(sigHeader.Get("Content-Transfer-Encoding"), sigBody)
+	if err != nil {
+		return nil, err
+	}
+
+	return pkcs7VerifyDetached(sig, parts[0], roots)
+}
+
+// DecryptSMIME decrypt an application/pkcs7-mime message or entity with
+// the recipient certificate and key; it returns the enclosed MIME entity
+func DecryptSMIME(message []byte, cert *x509.Certificate, key crypto.Signer) ([]byte, error) {
+	header, body, err := splitMIMEEntity(message)
+	if err != nil {
+		return nil, err
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || (mediaType != "application/pkcs7-mime" && mediaType != "application/x-pkcs7-mime") {
+		return nil, fmt.Errorf("Not an application/pkcs7-mime message")
+	}
+	der, err := decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body)
+	if err != nil {
+		return nil, err
+	}
+	return pkcs7Decrypt(der, cert, key)
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue     `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue     `asn1:"optional,tag:1"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7SignerInfo struct {
+	Version            int
+	IssuerAndSerial    pkcs7IssuerAndSerial
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+}
+
+type pkcs7IssuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+type pkcs7EnvelopedData struct {
+	Version              int
+	RecipientInfos       []pkcs7RecipientInfo `asn1:"set"`
+	EncryptedContentInfo pkcs7EncryptedContentInfo
+}
+
+type pkcs7RecipientInfo struct {
+	Version                int
+	IssuerAndSerial        pkcs7IssuerAndSerial
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+// pkcs7SignDetached DER ContentInfo of a detached SignedData over content
+func pkcs7SignDetached(content []byte, cert *x509.Certificate, key crypto.Signer, chain []*x509.Certificate) ([]byte, error) {
+	var sigAlg pkix.AlgorithmIdentifier
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	case *ecdsa.PrivateKey:
+		sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}
+	default:
+		return nil, fmt.Errorf("Unsupported S/MIME key type %T", key)
+	}
+	if !publicKeyEqual(cert.PublicKey, key.Public()) {
+		return nil, fmt.Errorf("S/MIME certificate does not match the private key")
+	}
+
+	digest := sha256.Sum256(content)
+	attrs, err := derSetOf(
+		pkcs7Attr(oidAttributeContentType, oidPKCS7Data),
+		pkcs7Attr(oidAttributeSigningTime, time.Now().UTC()),
+		pkcs7Attr(oidAttributeMessageDigest, digest[:]),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// the signature covers the attributes DER encoded as a SET
+	attrsSet, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: attrs})
+	if err != nil {
+		return nil, err
+	}
+	attrsDigest := sha256.Sum256(attrsSet)
+	signature, err := key.Sign(rand.Reader, attrsDigest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []byte
+	for _, c := range append([]*x509.Certificate{cert}, chain...) {
+		certs = append(certs, c.Raw...)
+	}
+
+	sd := pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidSHA256}},
+		ContentInfo:      pkcs7ContentInfo{ContentType: oidPKCS7Data},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certs},
+		SignerInfos: []pkcs7SignerInfo{{
+			Version:            1,
+			IssuerAndSerial:    pkcs7IssuerAndSerial{Issuer: asn1.RawValue{FullBytes: cert.RawIssuer}, Serial: cert.SerialNumber},
+			DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			SignedAttributes:   asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrs},
+			SignatureAlgorithm: sigAlg,
+			Signature:          signature,
+		}},
+	}
+	return pkcs7Wrap(oidPKCS7SignedData, sd)
+}
+
+// pkcs7VerifyDetached check a detached SignedData over content and return
+// the signer certificate
+func pkcs7VerifyDetached(der, content []byte, roots *x509.CertPool) (*x509.Certificate, error) {
+	var sd pkcs7SignedData
+	if err := pkcs7Unwrap(der, oidPKCS7SignedData, &sd); err != nil {
+		return nil, err
+	}
+	if len(sd.SignerInfos) == 0 {
+		return nil, fmt.Errorf("No signer in the S/MIME signature")
+	}
+
+	var certs []*x509.Certificate
+	if len(sd.Certificates.Bytes) > 0 {
+		var err error
+		certs, err = x509.ParseCertificates(sd.Certificates.Bytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	si := sd.SignerInfos[0]
+	var signer *x509.Certificate
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, si.IssuerAndSerial.Issuer.FullBytes) && c.SerialNumber.Cmp(si.IssuerAndSerial.Serial) == 0 {
+			signer = c
+		}
+	}
+	if signer == nil {
+		return nil, fmt.Errorf("Signer certificate not found in the S/MIME signature")
+	}
+
+	h, err := pkcs7DigestHash(si.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	signed := content
+	if len(si.SignedAttributes.Bytes) > 0 {
+		var attrs []pkcs7Attribute
+		rest := si.SignedAttributes.Bytes
+		for len(rest) > 0 {
+			var a pkcs7Attribute
+			if rest, err = asn1.Unmarshal(rest, &a); err != nil {
+				return nil, fmt.Errorf("Invalid S/MIME signed attributes: %s", err)
+			}
+			attrs = append(attrs, a)
+		}
+
+		var digest []byte
+		for _, a := range attrs {
+			if a.Type.Equal(oidAttributeMessageDigest) {
+				if _, err := asn1.Unmarshal(a.Values.Bytes, &digest); err != nil {
+					return nil, fmt.Errorf("Invalid S/MIME message digest: %s", err)
+				}
+			}
+		}
+		d := h.New()
+		d.Write(content)
+		if digest == nil || !bytes.Equal(d.Sum(nil), digest) {
+			return nil, fmt.Errorf("S/MIME content digest mismatch")
+		}
+
+		signed, err = asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: si.SignedAttributes.Bytes})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := pkcs7CheckSignature(signer, si.SignatureAlgorithm.Algorithm, h, signed, si.Signature); err != nil {
+		return nil, err
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range certs {
+		if c != signer {
+			intermediates.AddCert(c)
+		}
+	}
+	_, err = signer.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return signer, nil
+}
+
+// pkcs7CheckSignature verify signature over data with the certificate key
+func pkcs7CheckSignature(cert *x509.Certificate, alg asn1.ObjectIdentifier, h crypto.Hash, data, signature []byte) error {
+	d := h.New()
+	d.Write(data)
+	digest := d.Sum(nil)
+
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if !alg.Equal(oidRSAEncryption) && !alg.Equal(oidSHA256WithRSA) {
+			return fmt.Errorf("Unsupported S/MIME signature algorithm %s", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, h, digest, signature); err != nil {
+			return fmt.Errorf("S/MIME signature mismatch")
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest, signature) {
+			return fmt.Errorf("S/MIME signature mismatch")
+		}
+	case ed25519.PublicKey:
+		return fmt.Errorf("Ed25519 S/MIME signatures are not supported")
+	default:
+		return fmt.Errorf("Unsupported S/MIME key type %T", pub)
+	}
+	return nil
+}
+
+// pkcs7DigestHash hash of a SignerInfo digest algorithm
+func pkcs7DigestHash(alg asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case alg.Equal(oidSHA256):
+		return crypto.SHA256, nil
+	case alg.Equal(oidSHA512):
+		return crypto.SHA512, nil
+	case alg.Equal(oidSHA1):
+		return crypto.SHA1, nil
+	}
+	return 0, fmt.Errorf("Unsupported S/MIME digest %s", alg)
+}
+
+// pkcs7Envelope DER ContentInfo of an AES-256-CBC EnvelopedData of
+// content, its key wrapped to each RSA recipient
+func pkcs7Envelope(content []byte, recipients []*x509.Certificate) ([]byte, error) {
+	key := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	encrypted := cbcEncrypt(block, iv, content)
+
+	var infos []pkcs7RecipientInfo
+	for _, r := range recipients {
+		pub, ok := r.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("S/MIME encryption needs RSA recipient keys, got %T", r.PublicKey)
+		}
+		wrapped, err := rsa.EncryptPKCS1v15(rand.Reader, pub, key)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, pkcs7RecipientInfo{
+			IssuerAndSerial:        pkcs7IssuerAndSerial{Issuer: asn1.RawValue{FullBytes: r.RawIssuer}, Serial: r.SerialNumber},
+			KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue},
+			EncryptedKey:           wrapped,
+		})
+	}
+
+	ivDER, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	ed := pkcs7EnvelopedData{
+		RecipientInfos: infos,
+		EncryptedContentInfo: pkcs7EncryptedContentInfo{
+			ContentType:                oidPKCS7Data,
+			ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivDER}},
+			EncryptedContent:           encrypted,
+		},
+	}
+	return pkcs7Wrap(oidPKCS7EnvelopedData, ed)
+}
+
+// pkcs7Decrypt content of an EnvelopedData for the given recipient
+func pkcs7Decrypt(der []byte, cert *x509.Certificate, key crypto.Signer) ([]byte, error) {
+	var ed pkcs7EnvelopedData
+	if err := pkcs7Unwrap(der, oidPKCS7EnvelopedData, &ed); err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("S/MIME decryption needs an RSA key, got %T", key)
+	}
+
+	for _, ri := range ed.RecipientInfos {
+		if !bytes.Equal(ri.IssuerAndSerial.Issuer.FullBytes, cert.RawIssuer) || ri.IssuerAndSerial.Serial.Cmp(cert.SerialNumber) != 0 {
+			continue
+		}
+		cek, err := rsa.DecryptPKCS1v15(rand.Reader, rsaKey, ri.EncryptedKey)
+		if err != nil {
+			return nil, fmt.Errorf("S/MIME key decryption failed")
+		}
+
+		eci := ed.EncryptedContentInfo
+		var iv []byte
+		if _, err := asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil {
+			return nil, fmt.Errorf("Invalid S/MIME IV: %s", err)
+		}
+		if size, err := cbcKeyLength(eci.ContentEncryptionAlgorithm.Algorithm); err != nil {
+			return nil, err
+		} else if size != len(cek) {
+			return nil, fmt.Errorf("S/MIME content key has the wrong size")
+		}
+		block, err := newCBCBlock(eci.ContentEncryptionAlgorithm.Algorithm, cek)
+		if err != nil {
+			return nil, err
+		}
+		return cbcDecrypt(block, iv, eci.EncryptedContent)
+	}
+	return nil, fmt.Errorf("S/MIME message is not encrypted to this certificate")
+}
+
+// pkcs7Wrap DER ContentInfo holding content
+func pkcs7Wrap(contentType asn1.ObjectIdentifier, content interface{}) ([]byte, error) {
+	inner, err := asn1.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs7ContentInfo{
+		ContentType: contentType,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: inner},
+	})
+}
+
+// pkcs7Unwrap parse a DER ContentInfo of the expected type into out
+func pkcs7Unwrap(der []byte, contentType asn1.ObjectIdentifier, out interface{}) error {
+	var ci pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return fmt.Errorf("Invalid PKCS#7 data: %s", err)
+	}
+	if !ci.ContentType.Equal(contentType) {
+		return fmt.Errorf("Unexpected PKCS#7 content %s", ci.ContentType)
+	}
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, out); err != nil {
+		return fmt.Errorf("Invalid PKCS#7 data: %s", err)
+	}
+	return nil
+}
+
+// pkcs7Attr attribute with a single value
+func pkcs7Attr(oid asn1.ObjectIdentifier, value interface{}) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		v, err := asn1.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return asn1.Marshal(pkcs7Attribute{
+			Type:   oid,
+			Values: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: v},
+		})
+	}
+}
+
+// derSetOf contents of a DER SET OF: the elements sorted by encoding
+func derSetOf(elems ...func() ([]byte, error)) ([]byte, error) {
+	var encoded [][]byte
+	for _, e := range elems {
+		b, err := e()
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, b)
+	}
+	sort.Slice(encoded, func(i, j int) bool { return bytes.Compare(encoded[i], encoded[j]) < 0 })
+	return bytes.Join(encoded, nil), nil
+}
+
+// splitMIMEEntity header and body of a message or MIME entity
+func splitMIMEEntity(entity []byte) (textproto.MIMEHeader, []byte, error) {
+	entity = toCRLF(entity)
+	i := bytes.Index(entity, []byte("\r\n\r\n"))
+	if i < 0 {
+		return nil, nil, fmt.Errorf("MIME entity without header")
+	}
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(entity[:i+4])))
+	header, err := r.ReadMIMEHeader()
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, entity[i+4:], nil
+}
+
+// splitMultipartRaw parts of a multipart body as they were written,
+// without the CRLF preceding each delimiter
+func splitMultipartRaw(body []byte, boundary string) [][]byte {
+	if boundary == "" {
+		return nil
+	}
+	delim := []byte("--" + boundary)
+	var parts [][]byte
+
+	// the first delimiter may start the body
+	body = append([]byte("\r\n"), body...)
+	sep := append([]byte("\r\n"), delim...)
+	chunks := bytes.Split(body, sep)
+	for i, c := range chunks[1:] {
+		if bytes.HasPrefix(c, []byte("--")) {
+			break
+		}
+		if i == len(chunks)-2 {
+			break // no closing delimiter
+		}
+		// skip transport padding and the CRLF after the delimiter
+		if j := bytes.Index(c, []byte("\r\n")); j >= 0 {
+			parts = append(parts, c[j+2:])
+		}
+	}
+	return parts
+}
+
+// decodeTransferEncoding decode a base64, quoted-printable or identity
+// encoded body
+func decodeTransferEncoding(encoding string, body []byte) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		return ioutil.ReadAll(quotedprintable.NewReader(bytes.NewReader(body)))
+	case "base64":
+		clean := bytes.Map(func(r rune) rune {
+			if r == '\r' || r == '\n' || r == ' ' || r == '\t' {
+				return -1
+			}
+			return r
+		}, body)
+		return base64.StdEncoding.DecodeString(string(clean))
+	case "", "7bit", "8bit", "binary":
+		return body, nil
+	}
+	return nil, fmt.Errorf("Unsupported transfer encoding %q", encoding)
+}
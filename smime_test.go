@@ -0,0 +1,131 @@
+package mailer_test
+
+import (
+	"crypto/x509"
+	"strings"
+	"testing"
+
+	"github.com/thiagozs/mailer-go"
+)
+
+const smimeMessage = "From: sender@example.com\r\n" +
+	"To: recipient@example.com\r\n" +
+	"Subject: Signed\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: text/plain; charset=\"UTF-8\"\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Hello from S/MIME.\r\n"
+
+func smimeRoots(t *testing.T, path string) *x509.CertPool {
+	certs, err := mailer.LoadSMIMECertificates(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	for _, c := range certs {
+		pool.AddCert(c)
+	}
+	return pool
+}
+
+func TestLoadSMIMEPKCS12(t *testing.T) {
+	t.Log("Load PKCS#12 files... (NOT expected some err)")
+
+	for _, file := range []string{"sender.p12", "sender-3des.p12", "sender-rc2.p12"} {
+		opts, err := mailer.LoadSMIMEPKCS12("testdata/smime/"+file, "secret")
+		if err != nil {
+			t.Errorf("LoadSMIMEPKCS12 %s got: %s", file, err)
+			continue
+		}
+		if opts.Certificate.Subject.CommonName != "sender@example.com" {
+			t.Errorf("LoadSMIMEPKCS12 %s got: %s", file, opts.Certificate.Subject)
+		}
+
+		if _, err := mailer.LoadSMIMEPKCS12("testdata/smime/"+file, "wrong"); err == nil {
+			t.Errorf("LoadSMIMEPKCS12 %s expected an error for a wrong password", file)
+		}
+	}
+}
+
+func TestSMIMESign(t *testing.T) {
+	t.Log("S/MIME sign and verify... (NOT expected some err)")
+
+	opts, err := mailer.LoadSMIMEPEM("testdata/smime/sender.pem", "testdata/smime/sender.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := mailer.ApplySMIME([]byte(smimeMessage), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []string{
+		"Subject: Signed\r\n",
+		"Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\"; micalg=sha-256;",
+		"Content-Type: text/plain; charset=\"UTF-8\"\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nHello from S/MIME.\r\n",
+		"Content-Type: application/pkcs7-signature; name=\"smime.p7s\"",
+	} {
+		if !strings.Contains(string(signed), c) {
+			t.Errorf("ApplySMIME missing %q\ngot: %s", c, signed)
+		}
+	}
+
+	cert, err := mailer.VerifySMIME(signed, smimeRoots(t, "testdata/smime/sender.pem"))
+	if err != nil {
+		t.Fatalf("VerifySMIME got: %s", err)
+	}
+	if cert.Subject.CommonName != "sender@example.com" {
+		t.Errorf("VerifySMIME got: %s", cert.Subject)
+	}
+
+	tampered := strings.Replace(string(signed), "Hello from", "Hello form", 1)
+	if _, err := mailer.VerifySMIME([]byte(tampered), smimeRoots(t, "testdata/smime/sender.pem")); err == nil {
+		t.Errorf("VerifySMIME accepted a changed body")
+	}
+	if _, err := mailer.VerifySMIME(signed, smimeRoots(t, "testdata/smime/recipient.pem")); err == nil {
+		t.Errorf("VerifySMIME accepted an untrusted signer")
+	}
+}
+
+func TestSMIMESignAndEncrypt(t *testing.T) {
+	t.Log("S/MIME sign, encrypt and decrypt... (NOT expected some err)")
+
+	opts, err := mailer.LoadSMIMEPKCS12("testdata/smime/sender.p12", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipients, err := mailer.LoadSMIMECertificates("testdata/smime/recipient.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Recipients = recipients
+
+	encrypted, err := mailer.ApplySMIME([]byte(smimeMessage), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(encrypted), "Content-Type: application/pkcs7-mime; smime-type=enveloped-data;") ||
+		strings.Contains(string(encrypted), "Hello from") {
+		t.Errorf("ApplySMIME got: %s", encrypted)
+	}
+
+	recipient, err := mailer.LoadSMIMEPEM("testdata/smime/recipient.pem", "testdata/smime/recipient.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity, err := mailer.DecryptSMIME(encrypted, recipient.Certificate, recipient.PrivateKey)
+	if err != nil {
+		t.Fatalf("DecryptSMIME got: %s", err)
+	}
+	if _, err := mailer.VerifySMIME(entity, smimeRoots(t, "testdata/smime/sender.pem")); err != nil {
+		t.Errorf("VerifySMIME got: %s", err)
+	}
+	if !strings.Contains(string(entity), "Hello from S/MIME.") {
+		t.Errorf("DecryptSMIME got: %s", entity)
+	}
+
+	if _, err := mailer.DecryptSMIME(encrypted, opts.Certificate, opts.PrivateKey); err == nil {
+		t.Errorf("DecryptSMIME expected an error for another certificate")
+	}
+}
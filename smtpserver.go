@@ -0,0 +1,601 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSMTPServerClosed error of Serve after Close
+var ErrSMTPServerClosed = errors.New("SMTP server closed")
+
+// SMTPServer receiving SMTP server (RFC 5321) passing each message to
+// Handle, behind an MX or as a local capture server for tests
+type SMTPServer struct {
+	Hostname      string        // name of the greeting and the Received header, os.Hostname when empty
+	TLSConfig     *tls.Config   // STARTTLS offered when set
+	RequireTLS    bool          // refuse mail before STARTTLS
+	MaxSize       int64         // message size limit, 10 MiB when zero
+	MaxRecipients int           // recipients of a message, 100 when zero
+	Recipients    []string      // accepted addresses and @domains, any when empty
+	Timeout       time.Duration // of every read and write, 5 minutes when zero
+	MaxSession    time.Duration // of a whole connection, 30 minutes when zero
+	MaxConns      int           // open connections, 1000 when zero; more are answered 421
+	// Auth enables AUTH PLAIN over TLS, e.g. to capture the sends of
+	// SDKConfigSMTPSSL in tests; mail is accepted without it
+	Auth   func(username, password string) bool
+	Handle SMTPHandler
+
+	mu        sync.Mutex
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool
+	closed    bool
+}
+
+// SMTPMessage message received by an SMTPServer
+type SMTPMessage struct {
+	From       string // reverse path, empty for bounces
+	To         []string
+	Data       []byte // with a Received header on top
+	Helo       string
+	RemoteAddr net.Addr
+	TLS        bool
+}
+
+// SMTPHandler receives the messages of an SMTPServer; an *SMTPError sets
+// the reply, any other error answers 451 so the client tries again later,
+// its text kept out of the reply
+type SMTPHandler func(*SMTPMessage) error
+
+// SMTPError reply of a refused message, e.g. 550 5.7.1 Rejected; a code
+// out of 4xx and 5xx answers 451
+type SMTPError struct {
+	Code    int
+	Message string
+}
+
+// Error reply line of the error
+func (e *SMTPError) Error() string {
+	return fmt.Sprintf("%d %s", e.Code, e.Message)
+}
+
+// NewSMTPServer return a server passing the messages to handle
+func NewSMTPServer(handle SMTPHandler) *SMTPServer {
+	return &SMTPServer{Handle: handle}
+}
+
+// Inbound parsed message, with the envelope of the SMTP session
+func (m *SMTPMessage) Inbound() (*InboundMessage, error) {
+	in, err := ParseInbound(m.Data)
+	if err != nil {
+		return nil, err
+	}
+	in.Provider = "smtp"
+	in.EnvelopeFrom = m.From
+	in.EnvelopeTo = m.To
+	return in, nil
+}
+
+// ListenAndServe listen on the TCP address addr and serve
+func (s *SMTPServer) ListenAndServe(addr string) error {
+	if addr == "" {
+		addr = ":25"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accept connections on l, each served in its own goroutine, until
+// Close. Connections of a TLS listener are TLS from the start
+func (s *SMTPServer) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrSMTPServerClosed
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]bool)
+	}
+	s.listeners[l] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrSMTPServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		if !s.track(conn) {
+			conn.Close()
+			return ErrSMTPServerClosed
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Close stop the listeners and drop the open connections
+func (s *SMTPServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	return nil
+}
+
+// track add conn to the open connections, unless the server is closed
+func (s *SMTPServer) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]bool)
+	}
+	s.conns[conn] = true
+	return true
+}
+
+// hostname name of the server
+func (s *SMTPServer) hostname() string {
+	if s.Hostname != "" {
+		return s.Hostname
+	}
+	if h, err := os.Hostname(); err == nil {
+		return h
+	}
+	return "localhost"
+}
+
+// maxSize message size limit
+func (s *SMTPServer) maxSize() int64 {
+	if s.MaxSize > 0 {
+		return s.MaxSize
+	}
+	return 10 << 20
+}
+
+// maxConns limit of open connections
+func (s *SMTPServer) maxConns() int {
+	if s.MaxConns > 0 {
+		return s.MaxConns
+	}
+	return 1000
+}
+
+// accepts report whether rcpt is in the allow-list
+func (s *SMTPServer) accepts(rcpt string) bool {
+	if len(s.Recipients) == 0 {
+		return true
+	}
+	rcpt = strings.ToLower(rcpt)
+	for _, r := range s.Recipients {
+		r = strings.ToLower(r)
+		if r == rcpt || (strings.HasPrefix(r, "@") && strings.HasSuffix(rcpt, r)) {
+			return true
+		}
+	}
+	return false
+}
+
+// smtpMaxLine length of a command line; RFC 5321 asks for 512 at least
+const smtpMaxLine = 2048
+
+// smtpConn connection whose reads and writes time out, and that ends at
+// the deadline of the session however busy the client keeps it
+type smtpConn struct {
+	net.Conn
+	timeout  time.Duration
+	deadline time.Time
+}
+
+// fresh deadline of the next read or write
+func (c *smtpConn) fresh() time.Time {
+	if t := time.Now().Add(c.timeout); t.Before(c.deadline) {
+		return t
+	}
+	return c.deadline
+}
+
+// Read read with a fresh deadline
+func (c *smtpConn) Read(b []byte) (int, error) {
+	c.Conn.SetReadDeadline(c.fresh())
+	return c.Conn.Read(b)
+}
+
+// Write write with a fresh deadline
+func (c *smtpConn) Write(b []byte) (int, error) {
+	c.Conn.SetWriteDeadline(c.fresh())
+	return c.Conn.Write(b)
+}
+
+// smtpSession state of a connection
+type smtpSession struct {
+	s        *SMTPServer
+	conn     net.Conn
+	r        *bufio.Reader
+	w        *bufio.Writer
+	tls      bool
+	helo     string
+	authed   bool
+	from     string
+	mail     bool
+	to       []string
+	hostname string
+}
+
+// serveConn run the session of conn
+func (s *SMTPServer) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Minute
+	}
+	maxSession := s.MaxSession
+	if maxSession <= 0 {
+		maxSession = 30 * time.Minute
+	}
+	_, isTLS := conn.(*tls.Conn)
+	c := &smtpConn{Conn: conn, timeout: timeout, deadline: time.Now().Add(maxSession)}
+	sess := &smtpSession{s: s, conn: c, r: bufio.NewReader(c), w: bufio.NewWriter(c), tls: isTLS, hostname: s.hostname()}
+
+	s.mu.Lock()
+	open := len(s.conns)
+	s.mu.Unlock()
+	if open > s.maxConns() {
+		sess.reply(421, sess.hostname+" 4.3.2 Too many connections, try again later")
+		return
+	}
+
+	sess.reply(220, sess.hostname+" ESMTP ready")
+	for {
+		line, err := sess.readLine()
+		if err == bufio.ErrBufferFull {
+			sess.reply(500, "5.5.6 Line too long")
+			continue
+		}
+		if err != nil {
+			return
+		}
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		if !sess.command(strings.ToUpper(verb), arg) {
+			return
+		}
+	}
+}
+
+// readLine read a command line without its line end; a longer line than
+// smtpMaxLine is skipped with bufio.ErrBufferFull
+func (sess *smtpSession) readLine() (string, error) {
+	var line []byte
+	for {
+		chunk, err := sess.r.ReadSlice('\n')
+		if len(line)+len(chunk) > smtpMaxLine {
+			line = nil
+			for err == bufio.ErrBufferFull {
+				_, err = sess.r.ReadSlice('\n')
+			}
+			if err != nil {
+				return "", err
+			}
+			return "", bufio.ErrBufferFull
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
+
+// reply write a reply, one line per text line
+func (sess *smtpSession) reply(code int, text ...string) {
+	for i, t := range text {
+		sep := "-"
+		if i == len(text)-1 {
+			sep = " "
+		}
+		fmt.Fprintf(sess.w, "%d%s%s\r\n", code, sep, t)
+	}
+	sess.w.Flush()
+}
+
+// reset drop the envelope of the current message
+func (sess *smtpSession) reset() {
+	sess.from, sess.mail, sess.to = "", false, nil
+}
+
+// command run one command; false ends the session
+func (sess *smtpSession) command(verb, arg string) bool {
+	s := sess.s
+	switch verb {
+	case "HELO", "EHLO":
+		if arg == "" {
+			sess.reply(501, "5.5.4 Syntax: "+verb+" hostname")
+			return true
+		}
+		sess.helo = arg
+		sess.reset()
+		if verb == "HELO" {
+			sess.reply(250, sess.hostname)
+			return true
+		}
+		ext := []string{sess.hostname, "PIPELINING", "8BITMIME", "ENHANCEDSTATUSCODES",
+			"SIZE " + strconv.FormatInt(s.maxSize(), 10)}
+		if s.TLSConfig != nil && !sess.tls {
+			ext = append(ext, "STARTTLS")
+		}
+		if s.Auth != nil && sess.tls {
+			ext = append(ext, "AUTH PLAIN")
+		}
+		sess.reply(250, ext...)
+
+	case "STARTTLS":
+		if s.TLSConfig == nil || sess.tls {
+			sess.reply(502, "5.5.1 STARTTLS not available")
+			return true
+		}
+		sess.reply(220, "2.0.0 Ready to start TLS")
+		tlsConn := tls.Server(sess.conn, s.TLSConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return false
+		}
+		sess.r = bufio.NewReader(tlsConn)
+		sess.w = bufio.NewWriter(tlsConn)
+		sess.tls = true
+		// the client starts over with EHLO
+		sess.helo = ""
+		sess.authed = false
+		sess.reset()
+
+	case "AUTH":
+		sess.auth(arg)
+
+	case "MAIL":
+		switch {
+		case sess.helo == "":
+			sess.reply(503, "5.5.1 Send HELO or EHLO first")
+		case s.RequireTLS && !sess.tls:
+			sess.reply(530, "5.7.0 Must issue a STARTTLS command first")
+		case sess.mail:
+			sess.reply(503, "5.5.1 Nested MAIL command")
+		default:
+			addr, params, ok := smtpPath(arg, "FROM:")
+			if !ok {
+				sess.reply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+				return true
+			}
+			if size, err := strconv.ParseInt(params["SIZE"], 10, 64); err == nil && size > s.maxSize() {
+				sess.reply(552, "5.3.4 Message size exceeds fixed maximum message size")
+				return true
+			}
+			sess.from, sess.mail = addr, true
+			sess.reply(250, "2.1.0 OK")
+		}
+
+	case "RCPT":
+		maxRecipients := s.MaxRecipients
+		if maxRecipients <= 0 {
+			maxRecipients = 100
+		}
+		addr, _, ok := smtpPath(arg, "TO:")
+		switch {
+		case !sess.mail:
+			sess.reply(503, "5.5.1 Send MAIL first")
+		case !ok || addr == "":
+			sess.reply(501, "5.5.4 Syntax: RCPT TO:<address>")
+		case len(sess.to) >= maxRecipients:
+			sess.reply(452, "4.5.3 Too many recipients")
+		case !s.accepts(addr):
+			sess.reply(550, "5.1.1 <"+addr+">: Recipient address rejected")
+		default:
+			sess.to = append(sess.to, addr)
+			sess.reply(250, "2.1.5 OK")
+		}
+
+	case "DATA":
+		if len(sess.to) == 0 {
+			sess.reply(503, "5.5.1 Send RCPT first")
+			return true
+		}
+		sess.reply(354, "End data with <CR><LF>.<CR><LF>")
+		return sess.data()
+
+	case "RSET":
+		sess.reset()
+		sess.reply(250, "2.0.0 OK")
+	case "NOOP":
+		sess.reply(250, "2.0.0 OK")
+	case "VRFY":
+		sess.reply(252, "2.5.0 Cannot VRFY user")
+	case "QUIT":
+		sess.reply(221, "2.0.0 Bye")
+		return false
+	default:
+		sess.reply(500, "5.5.2 Command not recognized")
+	}
+	return true
+}
+
+// auth check AUTH PLAIN credentials, sent with the command or after it
+func (sess *smtpSession) auth(arg string) {
+	s := sess.s
+	fields := strings.Fields(arg)
+	switch {
+	case s.Auth == nil || !sess.tls:
+		sess.reply(502, "5.5.1 AUTH not available")
+		return
+	case sess.authed:
+		sess.reply(503, "5.5.1 Already authenticated")
+		return
+	case len(fields) == 0 || !strings.EqualFold(fields[0], "PLAIN"):
+		sess.reply(504, "5.5.4 Unrecognized authentication type")
+		return
+	}
+
+	response := ""
+	if len(fields) > 1 {
+		response = fields[1]
+	} else {
+		sess.reply(334, "")
+		line, err := sess.readLine()
+		if err != nil {
+			return
+		}
+		response = line
+	}
+	decoded, err := base64.StdEncoding.DecodeString(response)
+	parts := strings.Split(string(decoded), "\x00")
+	if err != nil || len(parts) != 3 {
+		sess.reply(501, "5.5.2 Invalid AUTH PLAIN response")
+		return
+	}
+	if !s.Auth(parts[1], parts[2]) {
+		sess.reply(535, "5.7.8 Authentication credentials invalid")
+		return
+	}
+	sess.authed = true
+	sess.reply(235, "2.7.0 Authentication successful")
+}
+
+// data read the message and pass it to the handler; false ends the
+// session
+func (sess *smtpSession) data() bool {
+	s := sess.s
+	limit := s.maxSize()
+	dot := textproto.NewReader(sess.r).DotReader()
+	data, err := ioutil.ReadAll(io.LimitReader(dot, limit+1))
+	if err != nil {
+		return false
+	}
+	if int64(len(data)) > limit {
+		if _, err := io.Copy(ioutil.Discard, dot); err != nil {
+			return false
+		}
+		sess.reset()
+		sess.reply(552, "5.3.4 Message size exceeds fixed maximum message size")
+		return true
+	}
+
+	with := "ESMTP"
+	if sess.tls {
+		with = "ESMTPS"
+	}
+	remote := sess.conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "Received: from %s ([%s])\r\n\tby %s with %s;\r\n\t%s\r\n",
+		sess.helo, remote, sess.hostname, with, time.Now().Format(time.RFC1123Z))
+	msg.Write(toCRLF(data))
+
+	m := &SMTPMessage{
+		From:       sess.from,
+		To:         sess.to,
+		Data:       msg.Bytes(),
+		Helo:       sess.helo,
+		RemoteAddr: sess.conn.RemoteAddr(),
+		TLS:        sess.tls,
+	}
+	sess.reset()
+
+	if s.Handle != nil {
+		if err := s.Handle(m); err != nil {
+			var se *SMTPError
+			if errors.As(err, &se) && se.Code >= 400 && se.Code <= 599 {
+				// a line end in the message would forge a reply, so each
+				// line is sent as a line of a multiline reply
+				lines := strings.FieldsFunc(se.Message, func(r rune) bool { return r == '\r' || r == '\n' })
+				if len(lines) == 0 {
+					lines = []string{"Rejected"}
+				}
+				sess.reply(se.Code, lines...)
+			} else {
+				sess.reply(451, "4.3.0 Temporary failure, try again later")
+			}
+			return true
+		}
+	}
+	sess.reply(250, "2.0.0 OK: queued")
+	return true
+}
+
+// smtpPath address and parameters of a MAIL FROM:<a> or RCPT TO:<a>
+// argument
+func smtpPath(arg, prefix string) (string, map[string]string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, false
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(arg, "<") {
+		return "", nil, false
+	}
+	end := strings.IndexByte(arg, '>')
+	if end < 0 {
+		return "", nil, false
+	}
+	addr := arg[1:end]
+	// source routes (@a,@b:user@host) are ignored
+	if i := strings.IndexByte(addr, ':'); i >= 0 && strings.HasPrefix(addr, "@") {
+		addr = addr[i+1:]
+	}
+	params := map[string]string{}
+	for _, p := range strings.Fields(arg[end+1:]) {
+		k, v := p, ""
+		if i := strings.IndexByte(p, '='); i >= 0 {
+			k, v = p[:i], p[i+1:]
+		}
+		params[strings.ToUpper(k)] = v
+	}
+	return addr, params, true
+}
@@ -0,0 +1,320 @@
+package mailer_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thiagozs/mailer-go"
+)
+
+// testCertificate self-signed certificate of 127.0.0.1
+func testCertificate(t *testing.T) tls.Certificate {
+	srv := httptest.NewUnstartedServer(nil)
+	srv.StartTLS()
+	defer srv.Close()
+	return srv.TLS.Certificates[0]
+}
+
+// serveSMTP serve srv on a local port, closed with the test
+func serveSMTP(t *testing.T, srv *mailer.SMTPServer) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return l.Addr().String()
+}
+
+// smtpCode reply code of an error of net/smtp, 0 for other errors
+func smtpCode(err error) int {
+	var te *textproto.Error
+	if errors.As(err, &te) {
+		return te.Code
+	}
+	return 0
+}
+
+const smtpServerMessage = "From: Sender <sender@example.com>\r\n" +
+	"To: client@host.com\r\n" +
+	"Subject: Hello\r\n" +
+	"Message-ID: <1@example.com>\r\n" +
+	"\r\n" +
+	"Hello there\r\n" +
+	".leading dot\r\n"
+
+func TestSMTPServer(t *testing.T) {
+	t.Log("Receive a message over STARTTLS... (NOT expected some err)")
+
+	messages := make(chan *mailer.SMTPMessage, 1)
+	srv := mailer.NewSMTPServer(func(m *mailer.SMTPMessage) error {
+		messages <- m
+		return nil
+	})
+	srv.Hostname = "mx.host.com"
+	srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}}
+	srv.RequireTLS = true
+	srv.Recipients = []string{"client@host.com", "@support.host.com"}
+	addr := serveSMTP(t, srv)
+
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatalf("Dial got: %s", err)
+	}
+	defer c.Close()
+	if err := c.Hello("client.example.com"); err != nil {
+		t.Fatalf("Hello got: %s", err)
+	}
+	if ok, _ := c.Extension("STARTTLS"); !ok {
+		t.Errorf("STARTTLS not offered")
+	}
+	if ok, size := c.Extension("SIZE"); !ok || size != "10485760" {
+		t.Errorf("SIZE got: %s", size)
+	}
+	if err := c.StartTLS(&tls.Config{InsecureSkipVerify: true}); err != nil {
+		t.Fatalf("StartTLS got: %s", err)
+	}
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		t.Errorf("STARTTLS offered after STARTTLS")
+	}
+	if err := c.Mail("sender@example.com"); err != nil {
+		t.Fatalf("Mail got: %s", err)
+	}
+	for _, rcpt := range []string{"Client@Host.com", "help@support.host.com"} {
+		if err := c.Rcpt(rcpt); err != nil {
+			t.Errorf("Rcpt %s got: %s", rcpt, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		t.Fatalf("Data got: %s", err)
+	}
+	w.Write([]byte(smtpServerMessage))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Data close got: %s", err)
+	}
+	if err := c.Quit(); err != nil {
+		t.Errorf("Quit got: %s", err)
+	}
+
+	m := <-messages
+	if m.From != "sender@example.com" || strings.Join(m.To, ",") != "Client@Host.com,help@support.host.com" {
+		t.Errorf("Envelope got: %s %v", m.From, m.To)
+	}
+	if !m.TLS || m.Helo != "client.example.com" {
+		t.Errorf("Session got: %v %s", m.TLS, m.Helo)
+	}
+	if !strings.HasPrefix(string(m.Data), "Received: from client.example.com ([127.0.0.1])\r\n\tby mx.host.com with ESMTPS;") {
+		t.Errorf("Received got: %s", m.Data)
+	}
+	if !strings.HasSuffix(string(m.Data), "\r\n\r\nHello there\r\n.leading dot\r\n") {
+		t.Errorf("Data got: %q", m.Data)
+	}
+
+	in, err := m.Inbound()
+	if err != nil {
+		t.Fatalf("Inbound got: %s", err)
+	}
+	if in.Provider != "smtp" || in.Subject != "Hello" || in.From.Address != "sender@example.com" ||
+		in.EnvelopeFrom != "sender@example.com" || len(in.EnvelopeTo) != 2 || in.MessageID != "<1@example.com>" {
+		t.Errorf("Inbound got: %+v", in)
+	}
+}
+
+func TestSMTPServerErrors(t *testing.T) {
+	t.Log("Refuse recipients, sizes and sessions... (expected some err)")
+
+	var handled int
+	srv := mailer.NewSMTPServer(func(m *mailer.SMTPMessage) error {
+		handled++
+		switch m.To[0] {
+		case "retry@host.com":
+			return errors.New("Database down")
+		case "spam@host.com":
+			return &mailer.SMTPError{Code: 554, Message: "5.7.1 Rejected as spam"}
+		case "inject@host.com":
+			return errors.New("Database down\r\n250 2.0.0 OK")
+		case "multiline@host.com":
+			return &mailer.SMTPError{Code: 550, Message: "5.7.1 Rejected\r\n250 2.0.0 OK"}
+		case "ok@host.com":
+			return &mailer.SMTPError{Code: 250, Message: "2.0.0 OK"}
+		}
+		return nil
+	})
+	srv.MaxSize = 100
+	srv.MaxRecipients = 2
+	srv.Recipients = []string{"@host.com"}
+	srv.Timeout = 200 * time.Millisecond
+	addr := serveSMTP(t, srv)
+
+	send := func(rcpts []string, body string) error {
+		c, err := smtp.Dial(addr)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		if err := c.Mail("sender@example.com"); err != nil {
+			return err
+		}
+		for _, rcpt := range rcpts {
+			if err := c.Rcpt(rcpt); err != nil {
+				return err
+			}
+		}
+		w, err := c.Data()
+		if err != nil {
+			return err
+		}
+		w.Write([]byte(body))
+		if err := w.Close(); err != nil {
+			return err
+		}
+		return c.Quit()
+	}
+
+	for _, c := range []struct {
+		name  string
+		rcpts []string
+		body  string
+		code  int
+	}{
+		{"other domain", []string{"user@other.com"}, "Subject: Hi\r\n\r\nHi\r\n", 550},
+		{"too many recipients", []string{"a@host.com", "b@host.com", "c@host.com"}, "Subject: Hi\r\n\r\nHi\r\n", 452},
+		{"too big", []string{"user@host.com"}, "Subject: Hi\r\n\r\n" + strings.Repeat("Hi\r\n", 50), 552},
+		{"handler error", []string{"retry@host.com"}, "Subject: Hi\r\n\r\nHi\r\n", 451},
+		{"handler SMTPError", []string{"spam@host.com"}, "Subject: Hi\r\n\r\nHi\r\n", 554},
+		{"handler error with line ends", []string{"inject@host.com"}, "Subject: Hi\r\n\r\nHi\r\n", 451},
+		{"handler SMTPError with line ends", []string{"multiline@host.com"}, "Subject: Hi\r\n\r\nHi\r\n", 550},
+		{"handler SMTPError not an error", []string{"ok@host.com"}, "Subject: Hi\r\n\r\nHi\r\n", 451},
+	} {
+		if err := send(c.rcpts, c.body); smtpCode(err) != c.code {
+			t.Errorf("%s got: %v", c.name, err)
+		}
+	}
+	if err := send([]string{"user@host.com"}, "Subject: Hi\r\n\r\nHi\r\n"); err != nil {
+		t.Errorf("Send after errors got: %s", err)
+	}
+	if err := send([]string{"inject@host.com"}, "Subject: Hi\r\n\r\nHi\r\n"); err == nil || strings.Contains(err.Error(), "Database") {
+		t.Errorf("Handler error reply got: %v", err)
+	}
+	var te *textproto.Error
+	if err := send([]string{"multiline@host.com"}, "Subject: Hi\r\n\r\nHi\r\n"); !errors.As(err, &te) || te.Msg != "5.7.1 Rejected\n250 2.0.0 OK" {
+		t.Errorf("Handler SMTPError reply got: %v", err)
+	}
+	if handled != 8 {
+		t.Errorf("Handled got: %d", handled)
+	}
+
+	// commands out of order and the SIZE of MAIL
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.ReadResponse(220)
+	for _, c := range []struct {
+		cmd  string
+		code int
+	}{
+		{"MAIL FROM:<sender@example.com>", 503},
+		{"EHLO client.example.com", 250},
+		{"STARTTLS", 502},
+		{"AUTH PLAIN", 502},
+		{"RCPT TO:<user@host.com>", 503},
+		{"MAIL FROM:<sender@example.com> SIZE=101", 552},
+		{"MAIL FROM:sender@example.com", 501},
+		{"MAIL FROM:<> BODY=8BITMIME", 250},
+		{"MAIL FROM:<sender@example.com>", 503},
+		{"DATA", 503},
+		{"RCPT TO:<user@host.com>", 250},
+		{"RSET", 250},
+		{"DATA", 503},
+		{"HELP", 500},
+		{strings.Repeat("X", 3000), 500},
+		{"NOOP", 250},
+	} {
+		id, err := tp.Cmd("%s", c.cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tp.StartResponse(id)
+		code, _, err := tp.ReadResponse(0)
+		tp.EndResponse(id)
+		if code != c.code {
+			t.Errorf("%.20s got: %d %v", c.cmd, code, err)
+		}
+	}
+
+	// an idle client is dropped
+	if _, err := bufio.NewReader(conn).ReadString('\n'); err == nil {
+		t.Errorf("Idle connection got no error")
+	}
+
+	srv.Close()
+	if _, err := smtp.Dial(addr); err == nil {
+		t.Errorf("Dial after Close expected an error")
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Serve(l); err != mailer.ErrSMTPServerClosed {
+		t.Errorf("Serve after Close got: %v", err)
+	}
+}
+
+func TestSMTPServerLimits(t *testing.T) {
+	t.Log("Limit the connections and the length of a session... (expected some err)")
+
+	srv := mailer.NewSMTPServer(nil)
+	srv.MaxConns = 1
+	srv.Timeout = time.Second
+	srv.MaxSession = 300 * time.Millisecond
+	addr := serveSMTP(t, srv)
+
+	first, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	tp := textproto.NewConn(first)
+	if _, _, err := tp.ReadResponse(220); err != nil {
+		t.Fatalf("Greeting got: %s", err)
+	}
+
+	// over MaxConns a client is told to come back later
+	if _, err := smtp.Dial(addr); smtpCode(err) != 421 {
+		t.Errorf("Dial over MaxConns got: %v", err)
+	}
+
+	// a client busy within Timeout is still dropped at MaxSession
+	start := time.Now()
+	for time.Since(start) < 2*time.Second {
+		if err := tp.PrintfLine("NOOP"); err != nil {
+			break
+		}
+		if _, _, err := tp.ReadResponse(250); err != nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Session got: %s", d)
+	}
+
+	// the slot is free again
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatalf("Dial after the session got: %s", err)
+	}
+	c.Close()
+}
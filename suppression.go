@@ -0,0 +1,437 @@
+package mailer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mailgun/mailgun-go.v1"
+)
+
+// ErrSuppressed error of sends to a suppressed address
+var ErrSuppressed = errors.New("Suppressed recipient")
+
+// suppression reasons set from events and provider lists
+const (
+	SuppressionBounced      = string(EventBounced)
+	SuppressionComplained   = string(EventComplained)
+	SuppressionUnsubscribed = string(EventUnsubscribed)
+)
+
+// Suppression address no mail is sent to
+type Suppression struct {
+	Email   string    `json:"email"`
+	Reason  string    `json:"reason"`           // bounced, complained, unsubscribed or any other
+	Detail  string    `json:"detail,omitempty"` // e.g. the bounce message
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"` // never when zero
+}
+
+// SuppressionList addresses checked by every send. A list loaded from a
+// file writes every change back to it
+type SuppressionList struct {
+	path    string
+	mu      sync.RWMutex
+	entries map[string]Suppression
+}
+
+// NewSuppressionList return an in-memory suppression list
+func NewSuppressionList() *SuppressionList {
+	return &SuppressionList{entries: make(map[string]Suppression)}
+}
+
+// LoadSuppressionList return the suppression list kept in the JSON file at
+// path, which is created on the first change
+func LoadSuppressionList(path string) (*SuppressionList, error) {
+	l := NewSuppressionList()
+	l.path = path
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []Suppression
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("Invalid suppression list %s: %s", path, err)
+	}
+	for _, s := range entries {
+		l.entries[suppressionKey(s.Email)] = s
+	}
+	return l, nil
+}
+
+// Add suppress email for reason, for ttl or for good when ttl is zero
+func (l *SuppressionList) Add(email, reason, detail string, ttl time.Duration) error {
+	s := Suppression{Email: email, Reason: reason, Detail: detail, Created: time.Now().UTC()}
+	if ttl > 0 {
+		s.Expires = s.Created.Add(ttl)
+	}
+	return l.Put(s)
+}
+
+// Put add or replace the suppression of s.Email
+func (l *SuppressionList) Put(entries ...Suppression) error {
+	for _, s := range entries {
+		if strings.TrimSpace(s.Email) == "" {
+			return fmt.Errorf("Empty Email in suppression")
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, s := range entries {
+		s.Email = strings.TrimSpace(s.Email)
+		if s.Created.IsZero() {
+			s.Created = time.Now().UTC()
+		}
+		l.entries[suppressionKey(s.Email)] = s
+	}
+	return l.save()
+}
+
+// Remove lift the suppression of email
+func (l *SuppressionList) Remove(email string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, suppressionKey(email))
+	return l.save()
+}
+
+// Check return the suppression of email, if any and not expired
+func (l *SuppressionList) Check(email string) (Suppression, bool) {
+	if l == nil {
+		return Suppression{}, false
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	s, ok := l.entries[suppressionKey(email)]
+	if !ok || s.expired(time.Now()) {
+		return Suppression{}, false
+	}
+	return s, true
+}
+
+// List suppressions in force, by address
+func (l *SuppressionList) List() []Suppression {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	now := time.Now()
+	var list []Suppression
+	for _, s := range l.entries {
+		if !s.expired(now) {
+			list = append(list, s)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return suppressionKey(list[i].Email) < suppressionKey(list[j].Email)
+	})
+	return list
+}
+
+// HandleEvent suppress the addresses of bounced, complained and
+// unsubscribed events; it is an EventHandler for the webhooks and pollers
+func (l *SuppressionList) HandleEvent(e Event) error {
+	switch e.Type {
+	case EventBounced, EventComplained, EventUnsubscribed:
+		if e.Email == "" {
+			return nil
+		}
+		return l.Put(Suppression{Email: e.Email, Reason: string(e.Type), Detail: e.Reason, Created: e.Timestamp})
+	}
+	return nil
+}
+
+// Export write the suppressions in force as CSV with an email, reason,
+// detail, created and expires header
+func (l *SuppressionList) Export(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"email", "reason", "detail", "created", "expires"})
+	for _, s := range l.List() {
+		expires := ""
+		if !s.Expires.IsZero() {
+			expires = s.Expires.Format(time.RFC3339)
+		}
+		cw.Write([]string{s.Email, s.Reason, s.Detail, s.Created.Format(time.RFC3339), expires})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Import read suppressions from CSV with a header naming the columns; only
+// email is required, as in the exports of the providers. It returns the
+// count of suppressions read
+func (l *SuppressionList) Import(r io.Reader) (int, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return 0, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	email, ok := columns["email"]
+	if !ok {
+		if email, ok = columns["address"]; !ok {
+			return 0, fmt.Errorf("No email column in suppression CSV")
+		}
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	parseTime := func(record []string, name string) (time.Time, error) {
+		v := field(record, name)
+		if v == "" {
+			return time.Time{}, nil
+		}
+		return time.Parse(time.RFC3339, v)
+	}
+
+	var entries []Suppression
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if email >= len(record) || strings.TrimSpace(record[email]) == "" {
+			continue
+		}
+		s := Suppression{Email: record[email], Reason: field(record, "reason"), Detail: field(record, "detail")}
+		if s.Created, err = parseTime(record, "created"); err != nil {
+			return 0, err
+		}
+		if s.Expires, err = parseTime(record, "expires"); err != nil {
+			return 0, err
+		}
+		entries = append(entries, s)
+	}
+	if err := l.Put(entries...); err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// check return an error wrapping ErrSuppressed for a suppressed email
+func (l *SuppressionList) check(email string) error {
+	if s, ok := l.Check(email); ok {
+		return fmt.Errorf("%w: %s (%s)", ErrSuppressed, email, s.Reason)
+	}
+	return nil
+}
+
+// save write the list to its file, if any; l.mu is held
+func (l *SuppressionList) save() error {
+	if l.path == "" {
+		return nil
+	}
+	entries := make([]Suppression, 0, len(l.entries))
+	for _, s := range l.entries {
+		entries = append(entries, s)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return suppressionKey(entries[i].Email) < suppressionKey(entries[j].Email)
+	})
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(l.path, data)
+}
+
+// expired report whether s expired at now
+func (s Suppression) expired(now time.Time) bool {
+	return !s.Expires.IsZero() && !now.Before(s.Expires)
+}
+
+// suppressionKey case-insensitive key of an address
+func suppressionKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// SyncSuppressions merge the bounces, complaints and unsubscribes of the
+// Mailgun domain into l, then add to Mailgun the permanent suppressions of l
+// with those reasons it is missing. It returns the counts pulled and pushed
+func (cfg *SDKConfigMailGun) SyncSuppressions(l *SuppressionList) (int, int, error) {
+	mg := cfg.newSDKMailGun().Mailgun
+	remote := map[string]map[string]bool{
+		SuppressionBounced:      {},
+		SuppressionComplained:   {},
+		SuppressionUnsubscribed: {},
+	}
+
+	var pulled []Suppression
+	add := func(email, reason, detail, createdAt string) {
+		created, _ := time.Parse("Mon, 2 Jan 2006 15:04:05 MST", createdAt)
+		remote[reason][suppressionKey(email)] = true
+		pulled = append(pulled, Suppression{Email: email, Reason: reason, Detail: detail, Created: created.UTC()})
+	}
+
+	for _, reason := range []string{SuppressionBounced, SuppressionComplained, SuppressionUnsubscribed} {
+		entries, err := mailgunSuppressions(mg, mailgunSuppressionEndpoints[reason])
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, e := range entries {
+			detail := e.Error
+			if reason == SuppressionUnsubscribed {
+				detail = strings.Join(e.Tags, ",")
+			}
+			add(e.Address, reason, detail, e.CreatedAt)
+		}
+	}
+
+	// local entries go first, so the pulled ones don't shadow them
+	local := l.List()
+	var keep []Suppression
+	for _, s := range pulled {
+		if existing, ok := l.Check(s.Email); !ok || existing.Reason == s.Reason {
+			keep = append(keep, s)
+		}
+	}
+	if err := l.Put(keep...); err != nil {
+		return 0, 0, err
+	}
+
+	pushed := 0
+	for _, s := range local {
+		known, ok := remote[s.Reason]
+		if !ok || !s.Expires.IsZero() || known[suppressionKey(s.Email)] {
+			continue
+		}
+		if err := pushMailgunSuppression(mg, s); err != nil {
+			return len(keep), pushed, err
+		}
+		pushed++
+	}
+	return len(keep), pushed, nil
+}
+
+// mailgunSuppressionEndpoints Mailgun list of each suppression reason
+var mailgunSuppressionEndpoints = map[string]string{
+	SuppressionBounced:      "bounces",
+	SuppressionComplained:   "complaints",
+	SuppressionUnsubscribed: "unsubscribes",
+}
+
+// mailgunSuppressionPageLimit entries per page and mailgunSuppressionMaxPages
+// pages read of a Mailgun suppression list
+const (
+	mailgunSuppressionPageLimit = 1000
+	mailgunSuppressionMaxPages  = 1000
+)
+
+// mailgunSuppression entry of a Mailgun bounces, complaints or
+// unsubscribes list
+type mailgunSuppression struct {
+	Address   string   `json:"address"`
+	Error     string   `json:"error"`
+	CreatedAt string   `json:"created_at"`
+	Tags      []string `json:"tags"`
+}
+
+// mailgunSuppressions entries of the Mailgun list endpoint. The v3 lists
+// page with the paging.next cursor and ignore the skip of the client, so
+// the cursor is followed until an empty, repeated or last allowed page
+func mailgunSuppressions(mg mailgun.Mailgun, endpoint string) ([]mailgunSuppression, error) {
+	client := mg.Client()
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	var entries []mailgunSuppression
+	seen := map[string]bool{} // cursors and pages read
+	next := fmt.Sprintf("%s/%s/%s?limit=%d", mg.ApiBase(), mg.Domain(), endpoint, mailgunSuppressionPageLimit)
+	for pages := 0; next != "" && !seen[next]; pages++ {
+		if pages == mailgunSuppressionMaxPages {
+			return nil, fmt.Errorf("More than %d pages of Mailgun %s", mailgunSuppressionMaxPages, endpoint)
+		}
+		seen[next] = true
+
+		req, err := http.NewRequest(http.MethodGet, next, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.SetBasicAuth("api", mg.ApiKey())
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Items  []mailgunSuppression `json:"items"`
+			Paging mailgun.Paging       `json:"paging"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("Mailgun %s got status %d", endpoint, resp.StatusCode)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid Mailgun %s page: %s", endpoint, err)
+		}
+		if len(page.Items) == 0 {
+			break
+		}
+
+		// a cursor ignored by the API gives back a page read already
+		first, last := page.Items[0], page.Items[len(page.Items)-1]
+		key := first.Address + "\x00" + first.CreatedAt + "\x00" + last.Address + "\x00" + last.CreatedAt
+		if seen[key] {
+			break
+		}
+		seen[key] = true
+		entries = append(entries, page.Items...)
+		next = page.Paging.Next
+	}
+	return entries, nil
+}
+
+// pushMailgunSuppression add s to the Mailgun list of its reason
+func pushMailgunSuppression(mg mailgun.Mailgun, s Suppression) error {
+	switch s.Reason {
+	case SuppressionBounced:
+		return mg.AddBounce(s.Email, "550", s.Detail)
+	case SuppressionComplained:
+		return mg.CreateComplaint(s.Email)
+	default:
+		return mg.Unsubscribe(s.Email, "*")
+	}
+}
+
+// writeFileAtomic write data to path through a rename, so a crash never
+// leaves half of it
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
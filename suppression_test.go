@@ -0,0 +1,310 @@
+package mailer_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thiagozs/mailer-go"
+)
+
+func TestSuppressionList(t *testing.T) {
+	t.Log("Suppression list in a file... (NOT expected some err)")
+
+	path := filepath.Join(t.TempDir(), "suppressions.json")
+	list, err := mailer.LoadSuppressionList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := list.Add("Bounce@Host.com", mailer.SuppressionBounced, "550 unknown user", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := list.Add("later@host.com", "manual", "", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := list.Put(mailer.Suppression{Email: "old@host.com", Reason: "manual", Expires: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := list.Add(" ", "manual", "", 0); err == nil {
+		t.Errorf("Add expected an error for an empty email")
+	}
+
+	s, ok := list.Check("bounce@host.com")
+	if !ok || s.Reason != mailer.SuppressionBounced || s.Detail != "550 unknown user" {
+		t.Errorf("Check got: %+v %t", s, ok)
+	}
+	if _, ok := list.Check("old@host.com"); ok {
+		t.Errorf("Check got an expired suppression")
+	}
+	if s, ok := list.Check("later@host.com"); !ok || s.Expires.IsZero() {
+		t.Errorf("Check got: %+v %t", s, ok)
+	}
+
+	// a new load reads the file
+	again, err := mailer.LoadSuppressionList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := again.List(); len(l) != 2 || l[0].Email != "Bounce@Host.com" || l[1].Email != "later@host.com" {
+		t.Errorf("LoadSuppressionList got: %+v", l)
+	}
+	if err := again.Remove("BOUNCE@host.com"); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ = mailer.LoadSuppressionList(path); len(again.List()) != 1 {
+		t.Errorf("Remove got: %+v", again.List())
+	}
+
+	events := mailer.NewSuppressionList()
+	for _, e := range []mailer.Event{
+		{Type: mailer.EventBounced, Email: "a@host.com", Reason: "550"},
+		{Type: mailer.EventComplained, Email: "b@host.com"},
+		{Type: mailer.EventUnsubscribed, Email: "c@host.com"},
+		{Type: mailer.EventDeferred, Email: "d@host.com"},
+		{Type: mailer.EventDelivered, Email: "e@host.com"},
+	} {
+		if err := events.HandleEvent(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if l := events.List(); len(l) != 3 || l[0].Reason != "bounced" || l[1].Reason != "complained" || l[2].Reason != "unsubscribed" {
+		t.Errorf("HandleEvent got: %+v", l)
+	}
+}
+
+func TestSuppressionImportExport(t *testing.T) {
+	t.Log("Suppression list CSV import and export... (NOT expected some err)")
+
+	list := mailer.NewSuppressionList()
+	n, err := list.Import(strings.NewReader("Address,Reason,Created\n" +
+		"a@host.com,bounced,2026-10-01T10:00:00Z\n" +
+		"b@host.com,,\n" +
+		",ignored,\n"))
+	if err != nil || n != 2 {
+		t.Fatalf("Import got: %d %v", n, err)
+	}
+	if s, ok := list.Check("a@host.com"); !ok || !s.Created.Equal(time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Import got: %+v", s)
+	}
+	list.Add("c@host.com", "manual", "asked by phone", 24*time.Hour)
+
+	var out bytes.Buffer
+	if err := list.Export(&out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 || lines[0] != "email,reason,detail,created,expires" ||
+		lines[1] != "a@host.com,bounced,,2026-10-01T10:00:00Z," ||
+		!strings.HasPrefix(lines[3], "c@host.com,manual,asked by phone,") {
+		t.Errorf("Export got: %s", out.String())
+	}
+
+	copied := mailer.NewSuppressionList()
+	if n, err := copied.Import(&out); err != nil || n != 3 {
+		t.Errorf("Import of an export got: %d %v", n, err)
+	}
+	if s, ok := copied.Check("c@host.com"); !ok || s.Expires.IsZero() || s.Detail != "asked by phone" {
+		t.Errorf("Import of an export got: %+v", s)
+	}
+
+	if _, err := list.Import(strings.NewReader("name,reason\nx,y\n")); err == nil {
+		t.Errorf("Import expected an error without an email column")
+	}
+}
+
+func TestSuppressedSends(t *testing.T) {
+	t.Log("Sends to suppressed recipients... (expected some err)")
+
+	list := mailer.NewSuppressionList()
+	list.Add("client@host.com", mailer.SuppressionComplained, "", 0)
+
+	sg := mailer.NewMailerSendGrid("key")
+	sg.Host = "http://127.0.0.1:1"
+	sg.Suppressions = list
+	sg.ConfigEmail = mailer.ConfigEmailSendgrid{EmailTo: "client@host.com", EmailToName: "Client",
+		EmailFrom: "sender@host.com", EmailFromName: "Sender", Subject: "Hi", ContentPlainText: "test"}
+
+	mg := mailer.NewMailerMailGun("example.com", "key", "")
+	mg.APIBase = "http://127.0.0.1:1"
+	mg.Suppressions = list
+	mg.ConfigEmail = mailer.ConfigEmailMailGun{EmailTo: "Client@host.com", EmailFrom: "sender@host.com",
+		Subject: "Hi", ContentPlainText: "test"}
+
+	gm := mailer.NewMailerGmail("sender@host.com", "password")
+	gm.Suppressions = list
+	gm.ConfigEmail = mailer.ConfigEmailGmail{EmailTo: "client@host.com", EmailFrom: "sender@host.com",
+		Subject: "Hi", ContentPlainText: "test"}
+
+	smtp := mailer.NewMailerSMTPSSL("sender@host.com", "password", "127.0.0.1", "1")
+	smtp.Suppressions = list
+	smtp.ConfigEmail = mailer.ConfigEmailSMTPSSL{EmailTo: "client@host.com", EmailFrom: "sender@host.com",
+		Subject: "Hi", ContentPlainText: "test"}
+
+	ses := sesStubMailer("http://127.0.0.1:1")
+	ses.Suppressions = list
+	ses.ConfigEmail.Subject = "Hi"
+	ses.ConfigEmail.ContentPlainText = "test"
+
+	for name, send := range map[string]func() error{
+		"sendgrid":         sg.SendMail,
+		"mailgun":          mg.SendMail,
+		"gmail":            gm.SendMail,
+		"smtpssl":          smtp.SendMail,
+		"awsses":           ses.SendMail,
+		"awsses templated": func() error { return ses.SendTemplatedMail("welcome", nil) },
+	} {
+		err := send()
+		if !errors.Is(err, mailer.ErrSuppressed) || !strings.Contains(err.Error(), "complained") {
+			t.Errorf("SendMail %s got: %v", name, err)
+		}
+	}
+}
+
+func TestSuppressedBatches(t *testing.T) {
+	t.Log("Batch sends leaving suppressed recipients out... (NOT expected some err)")
+
+	list := mailer.NewSuppressionList()
+	list.Add("user1@host.com", mailer.SuppressionBounced, "", 0)
+	list.Add("user3@host.com", mailer.SuppressionUnsubscribed, "", 0)
+
+	srv, call := sesStub(t)
+	defer srv.Close()
+	ses := sesStubMailer(srv.URL)
+	ses.Suppressions = list
+	var destinations []mailer.SESBulkDestination
+	for i := 0; i < 53; i++ {
+		destinations = append(destinations, mailer.SESBulkDestination{EmailTo: fmt.Sprintf("user%d@host.com", i)})
+	}
+	results, err := ses.SendBulkTemplatedMail("welcome", nil, destinations)
+	if err != nil {
+		t.Fatalf("SendBulkTemplatedMail got: %s", err)
+	}
+	if len(results) != 53 || len(call.forms) != 2 {
+		t.Fatalf("SendBulkTemplatedMail got: %d results, %d calls", len(results), len(call.forms))
+	}
+	for i, r := range results {
+		suppressed := i == 1 || i == 3
+		if r.EmailTo != destinations[i].EmailTo || (r.Status == "Suppressed") != suppressed || (r.MessageID == "") != suppressed {
+			t.Errorf("SendBulkTemplatedMail result %d got: %+v", i, r)
+		}
+	}
+	if results[1].Error != "bounced" || call.forms[1].Get("Destinations.member.1.Destination.ToAddresses.member.1") != "user52@host.com" {
+		t.Errorf("SendBulkTemplatedMail got: %+v, %v", results[1], call.forms[1])
+	}
+
+	mgSrv, forms := mailgunStub(t)
+	defer mgSrv.Close()
+	mg := mailer.NewMailerMailGun("example.com", "key", "")
+	mg.APIBase = mgSrv.URL
+	mg.Suppressions = list
+	mg.ConfigEmail = mailer.ConfigEmailMailGun{EmailFrom: "sender@host.com", Subject: "Hi", ContentPlainText: "test"}
+	batch, err := mg.SendBatch([]mailer.MailgunRecipient{{Email: "user1@host.com"}, {Email: "user2@host.com"}, {Email: "USER3@host.com"}})
+	if err != nil {
+		t.Fatalf("SendBatch got: %s", err)
+	}
+	if len(batch) != 2 || len((*forms)[0]["to"]) != 1 || batch[0].Emails[0] != "user2@host.com" ||
+		strings.Join(batch[1].Emails, " ") != "user1@host.com USER3@host.com" || !errors.Is(batch[1].Err, mailer.ErrSuppressed) {
+		t.Errorf("SendBatch got: %+v", batch)
+	}
+}
+
+func TestMailgunSyncSuppressions(t *testing.T) {
+	t.Log("Sync suppressions with Mailgun... (NOT expected some err)")
+
+	added := map[string][]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		list := strings.TrimPrefix(r.URL.Path, "/example.com/")
+		if r.Method == http.MethodPost {
+			r.ParseForm()
+			added[list] = append(added[list], r.PostForm.Get("address"))
+			fmt.Fprint(w, `{"message":"ok"}`)
+			return
+		}
+		var items []map[string]interface{}
+		if r.URL.Query().Get("page") == "" {
+			switch list {
+			case "bounces":
+				items = append(items, map[string]interface{}{"address": "bounce@host.com", "error": "550 unknown user",
+					"created_at": "Mon, 19 Oct 2026 10:00:00 UTC"})
+			case "complaints":
+				items = append(items, map[string]interface{}{"address": "spam@host.com", "created_at": "Mon, 19 Oct 2026 10:00:00 UTC"})
+			case "unsubscribes":
+				items = append(items, map[string]interface{}{"address": "local@host.com", "tags": []string{"*"}})
+			default:
+				t.Errorf("Mailgun suppressions stub got: %s", r.URL.Path)
+			}
+		}
+		next := "http://" + r.Host + r.URL.Path + "?page=next&limit=1000"
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": items, "paging": map[string]string{"next": next}})
+	}))
+	defer srv.Close()
+
+	list := mailer.NewSuppressionList()
+	list.Add("local@host.com", mailer.SuppressionBounced, "", 0)
+	list.Add("gone@host.com", mailer.SuppressionBounced, "550", 0)
+	list.Add("angry@host.com", mailer.SuppressionComplained, "", 0)
+	list.Add("brief@host.com", mailer.SuppressionUnsubscribed, "", time.Hour)
+	list.Add("vip@host.com", "manual", "", 0)
+
+	mg := mailer.NewMailerMailGun("example.com", "key", "")
+	mg.APIBase = srv.URL
+	pulled, pushed, err := mg.SyncSuppressions(list)
+	if err != nil {
+		t.Fatalf("SyncSuppressions got: %s", err)
+	}
+	if pulled != 2 || pushed != 3 {
+		t.Errorf("SyncSuppressions got: %d pulled, %d pushed", pulled, pushed)
+	}
+	if s, ok := list.Check("bounce@host.com"); !ok || s.Reason != "bounced" || s.Detail != "550 unknown user" ||
+		!s.Created.Equal(time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("SyncSuppressions bounce got: %+v", s)
+	}
+	if s, _ := list.Check("local@host.com"); s.Reason != "bounced" {
+		t.Errorf("SyncSuppressions replaced a local suppression: %+v", s)
+	}
+	if _, ok := list.Check("spam@host.com"); !ok {
+		t.Errorf("SyncSuppressions missing the complaint")
+	}
+	if fmt.Sprint(added) != "map[bounces:[gone@host.com local@host.com] complaints:[angry@host.com]]" {
+		t.Errorf("SyncSuppressions pushed: %v", added)
+	}
+}
+
+func TestMailgunSyncSuppressionsPaging(t *testing.T) {
+	t.Log("Sync suppressions with a Mailgun ignoring the cursor... (NOT expected some err)")
+
+	requests := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		list := strings.TrimPrefix(r.URL.Path, "/example.com/")
+		requests[list]++
+		// the same full page for any cursor, with a new next each time
+		var items []map[string]interface{}
+		for i := 0; i < 1000; i++ {
+			items = append(items, map[string]interface{}{"address": fmt.Sprintf("%s%d@host.com", list, i)})
+		}
+		next := fmt.Sprintf("http://%s%s?page=%d", r.Host, r.URL.Path, requests[list])
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": items, "paging": map[string]string{"next": next}})
+	}))
+	defer srv.Close()
+
+	list := mailer.NewSuppressionList()
+	mg := mailer.NewMailerMailGun("example.com", "key", "")
+	mg.APIBase = srv.URL
+	pulled, _, err := mg.SyncSuppressions(list)
+	if err != nil {
+		t.Fatalf("SyncSuppressions got: %s", err)
+	}
+	if pulled != 3000 {
+		t.Errorf("SyncSuppressions got: %d pulled", pulled)
+	}
+	if fmt.Sprint(requests) != "map[bounces:2 complaints:2 unsubscribes:2]" {
+		t.Errorf("SyncSuppressions requests: %v", requests)
+	}
+}
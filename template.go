@@ -0,0 +1,422 @@
+package mailer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Template file layout understood by NewTemplatesFromDir / NewTemplatesFromFS:
+//
+//	layouts/*.html, layouts/*.txt    shared layouts
+//	partials/*.html, partials/*.txt  shared partials
+//	locales/<locale>.json            message catalog of a locale
+//	<name>[.<locale>].html           HTML body
+//	<name>[.<locale>].txt            plain text body
+//	<name>.subject[.<locale>].txt    subject line
+//
+// Every .html file is parsed with html/template (auto-escaped) and every
+// .txt file with text/template. Layouts and partials are visible from all
+// templates of the same kind, so a body can call {{template "base" .}} and
+// define the blocks the layout expects.
+//
+// A catalog is a flat JSON object of message keys. The "<name>.subject" key
+// is used as the subject template of a locale without a subject file, and
+// any key can be looked up from a template with {{t "key"}}.
+const (
+	templateLayoutsDir  = "layouts"
+	templatePartialsDir = "partials"
+	templateLocalesDir  = "locales"
+	templateExtHTML     = ".html"
+	templateExtText     = ".txt"
+	templateSubject     = ".subject"
+)
+
+// templateKey template name and locale ("" for the unsuffixed file)
+type templateKey struct {
+	name   string
+	locale string
+}
+
+// Templates set of named email templates
+type Templates struct {
+	// DefaultLocale last locale of every fallback chain, "en" if empty
+	DefaultLocale string
+
+	html    map[templateKey]*htmltemplate.Template
+	text    map[templateKey]*texttemplate.Template
+	subject map[templateKey]*texttemplate.Template
+	catalog map[string]map[string]string
+}
+
+// RenderedEmail subject and bodies produced by Templates.Render
+type RenderedEmail struct {
+	Subject          string
+	ContentHTML      string
+	ContentPlainText string
+}
+
+// NewTemplatesFromDir load templates from a directory
+func NewTemplatesFromDir(dir string) (*Templates, error) {
+	return NewTemplatesFromFS(os.DirFS(dir))
+}
+
+// NewTemplatesFromFS load templates from a fs.FS (e.g. embed.FS)
+func NewTemplatesFromFS(fsys fs.FS) (*Templates, error) {
+	files, err := readTemplateFiles(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog, err := readTemplateCatalogs(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	funcs := templateFuncs(nil, "")
+
+	baseHTML := htmltemplate.New("").Option("missingkey=error").Funcs(funcs)
+	baseText := texttemplate.New("").Option("missingkey=error").Funcs(funcs)
+
+	for _, name := range sortedKeys(files) {
+		if !isSharedTemplate(name) {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(name, templateExtHTML):
+			if _, err := baseHTML.New(name).Parse(files[name]); err != nil {
+				return nil, fmt.Errorf("Template %s: %v", name, err)
+			}
+		case strings.HasSuffix(name, templateExtText):
+			if _, err := baseText.New(name).Parse(files[name]); err != nil {
+				return nil, fmt.Errorf("Template %s: %v", name, err)
+			}
+		}
+	}
+
+	t := &Templates{
+		html:    make(map[templateKey]*htmltemplate.Template),
+		text:    make(map[templateKey]*texttemplate.Template),
+		subject: make(map[templateKey]*texttemplate.Template),
+		catalog: catalog,
+	}
+
+	for _, name := range sortedKeys(files) {
+		if isSharedTemplate(name) {
+			continue
+		}
+		key, isSubject := parseTemplateFileName(name, catalog)
+		switch {
+		case isSubject:
+			tpl, err := texttemplate.New(name).Option("missingkey=error").Funcs(funcs).Parse(files[name])
+			if err != nil {
+				return nil, fmt.Errorf("Template %s: %v", name, err)
+			}
+			t.subject[key] = tpl
+		case strings.HasSuffix(name, templateExtHTML):
+			set, err := baseHTML.Clone()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := set.New(name).Parse(files[name]); err != nil {
+				return nil, fmt.Errorf("Template %s: %v", name, err)
+			}
+			t.html[key] = set.Lookup(name)
+		case strings.HasSuffix(name, templateExtText):
+			set, err := baseText.Clone()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := set.New(name).Parse(files[name]); err != nil {
+				return nil, fmt.Errorf("Template %s: %v", name, err)
+			}
+			t.text[key] = set.Lookup(name)
+		}
+	}
+
+	// Catalog subjects fill the locales without a subject file
+	for locale, messages := range catalog {
+		for msgKey, msg := range messages {
+			if !strings.HasSuffix(msgKey, templateSubject) {
+				continue
+			}
+			key := templateKey{strings.TrimSuffix(msgKey, templateSubject), locale}
+			if _, ok := t.subject[key]; ok {
+				continue
+			}
+			name := path.Join(templateLocalesDir, locale+".json") + ":" + msgKey
+			tpl, err := texttemplate.New(name).Option("missingkey=error").Funcs(funcs).Parse(msg)
+			if err != nil {
+				return nil, fmt.Errorf("Template %s: %v", name, err)
+			}
+			t.subject[key] = tpl
+		}
+	}
+
+	return t, nil
+}
+
+// Names list the templates available for Render
+func (t *Templates) Names() []string {
+	seen := make(map[string]string)
+	for key := range t.html {
+		seen[key.name] = key.name
+	}
+	for key := range t.text {
+		seen[key.name] = key.name
+	}
+	return sortedKeys(seen)
+}
+
+// Render execute the templates of name in the default locale
+func (t *Templates) Render(name string, data interface{}) (*RenderedEmail, error) {
+	return t.RenderLocale(name, t.defaultLocale(), data)
+}
+
+// RenderLocale execute the subject, HTML and text templates of name with
+// data, picking each one from the fallback chain of locale
+// (pt-BR -> pt -> DefaultLocale -> unsuffixed file)
+func (t *Templates) RenderLocale(name string, locale string, data interface{}) (*RenderedEmail, error) {
+	chain := append(LocaleChain(locale, t.defaultLocale()), "")
+	locale = chain[0]
+
+	var htmlTpl *htmltemplate.Template
+	var textTpl, subjectTpl *texttemplate.Template
+	for i := len(chain) - 1; i >= 0; i-- {
+		key := templateKey{name, chain[i]}
+		if tpl, ok := t.html[key]; ok {
+			htmlTpl = tpl
+		}
+		if tpl, ok := t.text[key]; ok {
+			textTpl = tpl
+		}
+		if tpl, ok := t.subject[key]; ok {
+			subjectTpl = tpl
+		}
+	}
+
+	if htmlTpl == nil && textTpl == nil {
+		return nil, fmt.Errorf("Template %s not found", name)
+	}
+
+	funcs := templateFuncs(t, locale)
+	r := &RenderedEmail{}
+
+	if subjectTpl != nil {
+		tpl, err := subjectTpl.Clone()
+		if err != nil {
+			return nil, err
+		}
+		var sb strings.Builder
+		if err := tpl.Funcs(funcs).Execute(&sb, data); err != nil {
+			return nil, fmt.Errorf("Template %s: %v", tpl.Name(), err)
+		}
+		r.Subject = strings.Join(strings.Fields(sb.String()), " ")
+	}
+
+	if htmlTpl != nil {
+		tpl, err := htmlTpl.Clone()
+		if err != nil {
+			return nil, err
+		}
+		var sb strings.Builder
+		if err := tpl.Funcs(funcs).Execute(&sb, data); err != nil {
+			return nil, fmt.Errorf("Template %s: %v", tpl.Name(), err)
+		}
+		r.ContentHTML = sb.String()
+	}
+
+	if textTpl != nil {
+		tpl, err := textTpl.Clone()
+		if err != nil {
+			return nil, err
+		}
+		var sb strings.Builder
+		if err := tpl.Funcs(funcs).Execute(&sb, data); err != nil {
+			return nil, fmt.Errorf("Template %s: %v", tpl.Name(), err)
+		}
+		r.ContentPlainText = sb.String()
+	}
+
+	return r, nil
+}
+
+// Translate look up key in the catalogs of the locale chain; args, if any,
+// are applied with fmt.Sprintf
+func (t *Templates) Translate(locale string, key string, args ...interface{}) (string, error) {
+	for _, l := range LocaleChain(locale, t.defaultLocale()) {
+		if msg, ok := t.catalog[l][key]; ok {
+			if len(args) > 0 {
+				return fmt.Sprintf(msg, args...), nil
+			}
+			return msg, nil
+		}
+	}
+	return "", fmt.Errorf("Translation %s missing for locale %s", key, locale)
+}
+
+// defaultLocale DefaultLocale or the package default
+func (t *Templates) defaultLocale() string {
+	if t.DefaultLocale == "" {
+		return DefaultLocale
+	}
+	return t.DefaultLocale
+}
+
+// templateFuncs func map bound to locale; t may be nil while parsing
+func templateFuncs(t *Templates, locale string) map[string]interface{} {
+	return map[string]interface{}{
+		"locale": func() string {
+			return locale
+		},
+		"t": func(key string, args ...interface{}) (string, error) {
+			if t == nil {
+				return "", nil
+			}
+			return t.Translate(locale, key, args...)
+		},
+		"formatNumber": func(v interface{}, decimals int) (string, error) {
+			return FormatNumber(locale, v, decimals)
+		},
+		"formatCurrency": func(amount interface{}, currency string) (string, error) {
+			return FormatCurrency(locale, amount, currency)
+		},
+		"formatDate": func(tm time.Time) string {
+			return FormatDate(locale, tm)
+		},
+		"formatDateLong": func(tm time.Time) string {
+			return FormatDateLong(locale, tm)
+		},
+	}
+}
+
+// ApplyTo copy the rendered subject and bodies into the ConfigEmail of cfg
+func (r *RenderedEmail) ApplyTo(cfg interface{}) error {
+	switch c := cfg.(type) {
+	case *SDKConfigSengrid:
+		c.ConfigEmail.Subject = r.Subject
+		c.ConfigEmail.ContentHTML = r.ContentHTML
+		c.ConfigEmail.ContentPlainText = r.ContentPlainText
+	case *SDKConfigMailGun:
+		c.ConfigEmail.Subject = r.Subject
+		c.ConfigEmail.ContentHTML = r.ContentHTML
+		c.ConfigEmail.ContentPlainText = r.ContentPlainText
+	case *SDKConfigGmail:
+		c.ConfigEmail.Subject = r.Subject
+		c.ConfigEmail.ContentHTML = r.ContentHTML
+		c.ConfigEmail.ContentPlainText = r.ContentPlainText
+	case *SDKConfigAWSSES:
+		c.ConfigEmail.Subject = r.Subject
+		c.ConfigEmail.ContentHTML = r.ContentHTML
+		c.ConfigEmail.ContentPlainText = r.ContentPlainText
+	case *SDKConfigSMTPSSL:
+		c.ConfigEmail.Subject = r.Subject
+		c.ConfigEmail.ContentHTML = r.ContentHTML
+		c.ConfigEmail.ContentPlainText = r.ContentPlainText
+	default:
+		return fmt.Errorf("Unsupported config type %T", cfg)
+	}
+	return nil
+}
+
+// readTemplateFiles read every .html and .txt file of fsys keyed by slash path
+func readTemplateFiles(fsys fs.FS) (map[string]string, error) {
+	files := make(map[string]string)
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		ext := path.Ext(p)
+		if ext != templateExtHTML && ext != templateExtText {
+			return nil
+		}
+		b, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		files[p] = string(b)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// readTemplateCatalogs read locales/<locale>.json keyed by normalized locale
+func readTemplateCatalogs(fsys fs.FS) (map[string]map[string]string, error) {
+	catalog := make(map[string]map[string]string)
+
+	entries, err := fs.ReadDir(fsys, templateLocalesDir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return catalog, nil
+		}
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() || path.Ext(e.Name()) != ".json" {
+			continue
+		}
+		p := path.Join(templateLocalesDir, e.Name())
+		b, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return nil, err
+		}
+		messages := make(map[string]string)
+		if err := json.Unmarshal(b, &messages); err != nil {
+			return nil, fmt.Errorf("Catalog %s: %v", p, err)
+		}
+		catalog[NormalizeLocale(strings.TrimSuffix(e.Name(), ".json"))] = messages
+	}
+
+	return catalog, nil
+}
+
+// parseTemplateFileName split welcome.subject.pt-BR.txt into its name,
+// locale and whether it is a subject template. The last segment is a locale
+// of a known language or of a catalog, so order.new.html is named order.new
+func parseTemplateFileName(file string, catalog map[string]map[string]string) (templateKey, bool) {
+	ext := path.Ext(file)
+	base := strings.TrimSuffix(file, ext)
+
+	key := templateKey{name: base}
+	if i := strings.LastIndex(base, "."); i >= 0 &&
+		(isLocale(base[i+1:]) || catalog[NormalizeLocale(base[i+1:])] != nil) {
+		key.name, key.locale = base[:i], NormalizeLocale(base[i+1:])
+	}
+
+	if ext == templateExtText && strings.HasSuffix(key.name, templateSubject) {
+		key.name = strings.TrimSuffix(key.name, templateSubject)
+		return key, true
+	}
+	return key, false
+}
+
+// isSharedTemplate report whether name lives in the layouts or partials dir
+func isSharedTemplate(name string) bool {
+	return strings.HasPrefix(name, templateLayoutsDir+"/") ||
+		strings.HasPrefix(name, templatePartialsDir+"/")
+}
+
+// sortedKeys keys of m in order, for deterministic output
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,197 @@
+package mailer_test
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/thiagozs/mailer-go"
+)
+
+func templateFS() fstest.MapFS {
+	return fstest.MapFS{
+		"layouts/base.html": {Data: []byte(
+			`<html><body>{{block "content" .}}{{end}}{{template "footer" .}}</body></html>`)},
+		"partials/footer.html": {Data: []byte(
+			`{{define "footer"}}<p>Bye {{.Name}}</p>{{end}}`)},
+		"partials/footer.txt": {Data: []byte(
+			`{{define "footer"}}-- bye {{.Name}}{{end}}`)},
+		"welcome.subject.txt": {Data: []byte("Welcome,\n {{.Name}}!\n")},
+		"welcome.html": {Data: []byte(
+			`{{template "layouts/base.html" .}}{{define "content"}}<h1>Hi {{.Name}}</h1>{{end}}`)},
+		"welcome.txt": {Data: []byte("Hi {{.Name}}\n{{template \"footer\" .}}")},
+		"reset.html":  {Data: []byte(`<a href="{{.Link}}">reset</a>`)},
+		"order.html": {Data: []byte(
+			`<p>{{t "total"}}: {{formatCurrency .Total "BRL"}}</p>`)},
+		"order.pt-BR.html": {Data: []byte(
+			`<p>{{t "total"}} em {{formatDate .Date}}: {{formatCurrency .Total "BRL"}}</p>`)},
+		"order.subject.es.txt": {Data: []byte(`Pedido {{.ID}}`)},
+		"locales/en.json": {Data: []byte(
+			`{"order.subject": "Order {{.ID}}", "total": "Total"}`)},
+		"locales/pt.json": {Data: []byte(
+			`{"order.subject": "Pedido {{.ID}} recebido", "total": "Valor"}`)},
+		"locales/es.json": {Data: []byte(`{"total": "Importe"}`)},
+	}
+}
+
+func TestTemplatesRender(t *testing.T) {
+	t.Log("Render(welcome) with layout and partials... (NOT expected some err)")
+	tpls, err := mailer.NewTemplatesFromFS(templateFS())
+	if err != nil {
+		t.Fatalf("NewTemplatesFromFS got: %s", err)
+	}
+
+	r, err := tpls.Render("welcome", map[string]string{"Name": "<Ana>"})
+	if err != nil {
+		t.Fatalf("Render got: %s", err)
+	}
+
+	if r.Subject != "Welcome, <Ana>!" {
+		t.Errorf("Render subject got: %q", r.Subject)
+	}
+	if r.ContentHTML != "<html><body><h1>Hi &lt;Ana&gt;</h1><p>Bye &lt;Ana&gt;</p></body></html>" {
+		t.Errorf("Render html got: %q", r.ContentHTML)
+	}
+	if r.ContentPlainText != "Hi <Ana>\n-- bye <Ana>" {
+		t.Errorf("Render text got: %q", r.ContentPlainText)
+	}
+}
+
+func TestTemplatesMissingVariable(t *testing.T) {
+	t.Log("Render(reset) without Link... (expected some err)")
+	tpls, err := mailer.NewTemplatesFromFS(templateFS())
+	if err != nil {
+		t.Fatalf("NewTemplatesFromFS got: %s", err)
+	}
+
+	_, err = tpls.Render("reset", map[string]string{"Name": "Ana"})
+	if err == nil || !strings.Contains(err.Error(), "reset.html") {
+		t.Errorf("Render missing variable got: %v", err)
+	}
+
+	_, err = tpls.Render("welcome", struct{ Other string }{"x"})
+	if err == nil {
+		t.Errorf("Render missing struct field got: nil")
+	}
+
+	if _, err = tpls.Render("nope", nil); err == nil {
+		t.Errorf("Render unknown template got: nil")
+	}
+}
+
+func TestTemplatesApplyTo(t *testing.T) {
+	t.Log("ApplyTo(Sendgrid)... (NOT expected some err)")
+	r := &mailer.RenderedEmail{
+		Subject:          "Test",
+		ContentHTML:      "<b>test</b>",
+		ContentPlainText: "test",
+	}
+
+	sg := mailer.NewMailerSendGrid("")
+	if err := r.ApplyTo(sg); err != nil {
+		t.Fatalf("ApplyTo got: %s", err)
+	}
+	if sg.ConfigEmail.ContentHTML != r.ContentHTML || sg.ConfigEmail.Subject != r.Subject {
+		t.Errorf("ApplyTo got: %+v", sg.ConfigEmail)
+	}
+
+	if err := r.ApplyTo(sg.ConfigEmail); err == nil {
+		t.Errorf("ApplyTo unsupported type got: nil")
+	}
+}
+
+func TestTemplatesRenderLocale(t *testing.T) {
+	t.Log("RenderLocale(order) with fallback chain... (NOT expected some err)")
+	tpls, err := mailer.NewTemplatesFromFS(templateFS())
+	if err != nil {
+		t.Fatalf("NewTemplatesFromFS got: %s", err)
+	}
+
+	data := map[string]interface{}{
+		"ID":    42,
+		"Total": 1234.5,
+		"Date":  time.Date(2026, time.March, 9, 0, 0, 0, 0, time.UTC),
+	}
+
+	cases := []struct {
+		locale  string
+		subject string
+		html    string
+	}{
+		{"pt_br", "Pedido 42 recebido", "<p>Valor em 09/03/2026: R$\u00a01.234,50</p>"},
+		{"pt-PT", "Pedido 42 recebido", "<p>Valor: 1\u00a0234,50\u00a0R$</p>"},
+		{"es-MX", "Pedido 42", "<p>Importe: R$1,234.50</p>"},
+		{"fr", "Order 42", "<p>Total: R$1,234.50</p>"},
+	}
+
+	for _, c := range cases {
+		r, err := tpls.RenderLocale("order", c.locale, data)
+		if err != nil {
+			t.Fatalf("RenderLocale(%s) got: %s", c.locale, err)
+		}
+		if r.Subject != c.subject {
+			t.Errorf("RenderLocale(%s) subject got: %q", c.locale, r.Subject)
+		}
+		if r.ContentHTML != c.html {
+			t.Errorf("RenderLocale(%s) html got: %q", c.locale, r.ContentHTML)
+		}
+	}
+
+	if _, err := tpls.Translate("pt-BR", "nope"); err == nil {
+		t.Errorf("Translate missing key got: nil")
+	}
+}
+
+func TestTemplatesLocaleFileNames(t *testing.T) {
+	t.Log("Locale of file names in any case... (NOT expected some err)")
+	fsys := fstest.MapFS{
+		"welcome.html":              {Data: []byte(`<p>Hi</p>`)},
+		"welcome.pt-br.html":        {Data: []byte(`<p>Oi</p>`)},
+		"welcome.subject.PT_BR.txt": {Data: []byte(`Bem-vindo`)},
+		"welcome.zh_hant_tw.html":   {Data: []byte(`<p>你好</p>`)},
+		"welcome.tlh.html":          {Data: []byte(`<p>nuqneH</p>`)},
+		"locales/tlh.json":          {Data: []byte(`{"hello": "nuqneH"}`)},
+		"order.new.html":            {Data: []byte(`<p>New order</p>`)},
+		"user.add.html":             {Data: []byte(`<p>User added</p>`)},
+		"invoice.old.txt":           {Data: []byte(`Old invoice`)},
+	}
+	tpls, err := mailer.NewTemplatesFromFS(fsys)
+	if err != nil {
+		t.Fatalf("NewTemplatesFromFS got: %s", err)
+	}
+
+	for _, c := range []struct{ locale, subject, html string }{
+		{"pt-BR", "Bem-vindo", "<p>Oi</p>"},
+		{"pt_br", "Bem-vindo", "<p>Oi</p>"},
+		{"zh-Hant-TW", "", "<p>你好</p>"},
+		{"tlh", "", "<p>nuqneH</p>"},
+		{"en", "", "<p>Hi</p>"},
+	} {
+		r, err := tpls.RenderLocale("welcome", c.locale, nil)
+		if err != nil {
+			t.Fatalf("RenderLocale(%s) got: %s", c.locale, err)
+		}
+		if r.Subject != c.subject || r.ContentHTML != c.html {
+			t.Errorf("RenderLocale(%s) got: %q %q", c.locale, r.Subject, r.ContentHTML)
+		}
+	}
+	if _, err := tpls.Render("welcome.pt-br", nil); err == nil {
+		t.Errorf("Render(welcome.pt-br) expected an error")
+	}
+
+	// a last segment that is no language is part of the name
+	for name, want := range map[string]string{
+		"order.new":   "<p>New order</p>",
+		"user.add":    "<p>User added</p>",
+		"invoice.old": "Old invoice",
+	} {
+		r, err := tpls.Render(name, nil)
+		if err != nil {
+			t.Fatalf("Render(%s) got: %s", name, err)
+		}
+		if r.ContentHTML+r.ContentPlainText != want {
+			t.Errorf("Render(%s) got: %q %q", name, r.ContentHTML, r.ContentPlainText)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package mailer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TrackingOptions open and click tracking of the HTML content of the SMTPSSL
+// and Gmail sends: a pixel is added and the links point to signed redirects
+// of the TrackingHandler. A link with a data-notrack attribute is left as is
+type TrackingOptions struct {
+	URL      string // URL of the TrackingHandler, e.g. https://t.example.com/t
+	Secret   []byte // HMAC key of the tracking URLs
+	NoOpens  bool   // no pixel
+	NoClicks bool   // no redirects
+}
+
+// TrackingHandler http.Handler of the tracking URLs. It answers the pixel
+// with a transparent GIF and a link with a redirect to it, passing opened
+// and clicked events to Handle. A click redirects even when Handle fails,
+// as the recipient must get to the link
+type TrackingHandler struct {
+	Options *TrackingOptions
+	Handle  EventHandler
+}
+
+// NewTrackingHandler return a handler passing the opens and clicks of the
+// URLs of opts to handle
+func NewTrackingHandler(opts *TrackingOptions, handle EventHandler) *TrackingHandler {
+	return &TrackingHandler{Options: opts, Handle: handle}
+}
+
+// trackingPixel 1x1 transparent GIF
+var trackingPixel = []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00\x00\x00\x00\x00\x00\x00" +
+	"!\xf9\x04\x01\x00\x00\x00\x00,\x00\x00\x00\x00\x01\x00\x01\x00\x00\x02\x02D\x01\x00;")
+
+// rewrite add the pixel and the redirects to the HTML of a message to
+// email with messageID
+func (o *TrackingOptions) rewrite(src, email, messageID string) (string, error) {
+	if o.URL == "" || len(o.Secret) == 0 {
+		return "", fmt.Errorf("Empty URL or Secret in TrackingOptions")
+	}
+	if _, err := url.Parse(o.URL); err != nil {
+		return "", fmt.Errorf("Invalid tracking URL: %s", err)
+	}
+
+	doc := parseHTML(src)
+	doc.walk(func(n *htmlNode) bool {
+		if n.typ != htmlElementNode || n.tag != "a" {
+			return n.tag != "head"
+		}
+		if _, ok := n.attr("data-notrack"); ok {
+			n.delAttr("data-notrack")
+			return true
+		}
+		href, _ := n.attr("href")
+		if o.NoClicks || !isTrackableLink(href) {
+			return true
+		}
+		n.setAttr("href", o.trackingURL("c", email, messageID, strings.TrimSpace(href)))
+		return true
+	})
+
+	if !o.NoOpens {
+		pixel := &htmlNode{typ: htmlElementNode, tag: "img", attrs: []htmlAttr{
+			{"src", o.trackingURL("o", email, messageID, "")},
+			{"width", "1"}, {"height", "1"}, {"alt", ""},
+			{"style", "display:block;width:1px;height:1px;border:0"},
+		}}
+		if body := doc.find("body"); body != nil {
+			body.appendChild(pixel)
+		} else {
+			doc.appendChild(pixel)
+		}
+	}
+	return doc.String(), nil
+}
+
+// isTrackableLink report whether href is an absolute http(s) link
+func isTrackableLink(href string) bool {
+	u, err := url.Parse(strings.TrimSpace(href))
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// trackingURL URL of the handler for kind o (open) or c (click) of link
+func (o *TrackingOptions) trackingURL(kind, email, messageID, link string) string {
+	payload := email + "\x00" + messageID + "\x00" + link
+	token := base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + o.mac(kind, payload)
+
+	u, _ := url.Parse(o.URL)
+	q := u.Query()
+	q.Set(kind, token)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// parseToken email, message ID and link of a token of kind
+func (o *TrackingOptions) parseToken(kind, token string) (string, string, string, error) {
+	fields := strings.Split(token, ".")
+	if len(fields) != 2 {
+		return "", "", "", fmt.Errorf("Invalid tracking token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(fields[0])
+	if err != nil {
+		return "", "", "", fmt.Errorf("Invalid tracking token")
+	}
+	if !hmac.Equal([]byte(fields[1]), []byte(o.mac(kind, string(payload)))) {
+		return "", "", "", fmt.Errorf("Bad tracking token signature")
+	}
+	parts := strings.SplitN(string(payload), "\x00", 3)
+	if len(parts) != 3 {
+		return "", "", "", fmt.Errorf("Invalid tracking token")
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+// mac HMAC of a payload of kind, so an open token can't redirect
+func (o *TrackingOptions) mac(kind, payload string) string {
+	h := hmac.New(sha256.New, o.Secret)
+	h.Write([]byte(kind))
+	h.Write([]byte{0})
+	h.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:16])
+}
+
+// ServeHTTP record the open or click of the o or c parameter
+func (h *TrackingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	kind, typ := "o", EventOpened
+	if q.Get("c") != "" {
+		kind, typ = "c", EventClicked
+	}
+	email, messageID, link, err := h.Options.parseToken(kind, q.Get(kind))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// HEAD requests are prefetches of proxies and scanners
+	if h.Handle != nil && r.Method == http.MethodGet {
+		err = h.Handle(Event{
+			Provider:  "tracking",
+			Type:      typ,
+			Email:     email,
+			MessageID: messageID,
+			Timestamp: time.Now().UTC(),
+			URL:       link,
+		})
+	}
+
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	if typ == EventClicked {
+		http.Redirect(w, r, link, http.StatusFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/gif")
+	w.Write(trackingPixel)
+}
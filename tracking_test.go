@@ -0,0 +1,181 @@
+package mailer_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/thiagozs/mailer-go"
+)
+
+// trackingHTML content with a tracked, an opted-out, a mailto and a local
+// link
+const trackingHTML = `<html><body><p>Hi!</p>
+<a href="https://example.com/offer?id=1&amp;src=mail">Offer</a>
+<a href="https://example.com/private" data-notrack>Private</a>
+<a href="mailto:help@example.com">Help</a>
+<a href="#top">Top</a>
+</body></html>`
+
+// trackedSend send trackingHTML through the SMTP stub, returning the HTML
+// and text parts as received
+func trackedSend(t *testing.T, tracking *mailer.TrackingOptions, noTracking bool) (*mailer.SDKConfigSMTPSSL, string, string) {
+	host, port, messages := smtpStub(t)
+	smtp := mailer.NewMailerSMTPSSL("sender@example.com", "password", host, port)
+	smtp.Tracking = tracking
+	smtp.ConfigEmail = mailer.ConfigEmailSMTPSSL{EmailTo: "client@host.com", EmailFrom: "sender@example.com",
+		Subject: "Hello", ContentHTML: trackingHTML, NoTracking: noTracking}
+	if err := smtp.SendMail(); err != nil {
+		t.Fatalf("SendMail got: %s", err)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader((<-messages).Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	parts := map[string]string{}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		mediaType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		data, _ := ioutil.ReadAll(part)
+		parts[mediaType] = string(data)
+	}
+	return smtp, parts["text/html"], parts["text/plain"]
+}
+
+// trackingHrefs href and src attributes of an HTML content
+var trackingHrefs = regexp.MustCompile(`(?:href|src)="([^"]*)"`)
+
+func TestTracking(t *testing.T) {
+	t.Log("Track opens and clicks of an SMTPSSL send... (NOT expected some err)")
+
+	opts := &mailer.TrackingOptions{URL: "https://t.example.com/t", Secret: []byte("secret")}
+	smtp, html, text := trackedSend(t, opts, false)
+
+	var links []string
+	for _, m := range trackingHrefs.FindAllStringSubmatch(html, -1) {
+		links = append(links, strings.ReplaceAll(m[1], "&amp;", "&"))
+	}
+	if len(links) != 5 || !strings.HasPrefix(links[0], "https://t.example.com/t?c=") ||
+		links[1] != "https://example.com/private" || links[2] != "mailto:help@example.com" ||
+		links[3] != "#top" || !strings.HasPrefix(links[4], "https://t.example.com/t?o=") {
+		t.Fatalf("HTML links got: %v", links)
+	}
+	if strings.Contains(html, "data-notrack") || !strings.Contains(html, `width="1" height="1"`) ||
+		!strings.HasSuffix(strings.TrimSpace(html), "</body></html>") {
+		t.Errorf("HTML got: %s", html)
+	}
+	if !strings.Contains(text, "https://example.com/offer?id=1&src=mail") {
+		t.Errorf("text got: %s", text)
+	}
+
+	var events []mailer.Event
+	h := mailer.NewTrackingHandler(opts, func(e mailer.Event) error {
+		events = append(events, e)
+		return nil
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, links[0], nil))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://example.com/offer?id=1&src=mail" {
+		t.Errorf("click got: %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+
+	// proxies prefetch with HEAD, which is not an open
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, links[4], nil))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, links[4], nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/gif" || !bytes.HasPrefix(rec.Body.Bytes(), []byte("GIF89a")) {
+		t.Errorf("open got: %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("events got: %d", len(events))
+	}
+	for i, typ := range []mailer.EventType{mailer.EventClicked, mailer.EventOpened} {
+		e := events[i]
+		if e.Type != typ || e.Provider != "tracking" || e.Email != "client@host.com" ||
+			e.MessageID == "" || e.MessageID != smtp.MessageID || e.Timestamp.IsZero() {
+			t.Errorf("event %d got: %+v", i, e)
+		}
+	}
+	if events[0].URL != "https://example.com/offer?id=1&src=mail" || events[1].URL != "" {
+		t.Errorf("event URLs got: %s %s", events[0].URL, events[1].URL)
+	}
+}
+
+func TestTrackingOptOut(t *testing.T) {
+	t.Log("Send without part or all of the tracking... (NOT expected some err)")
+
+	opts := &mailer.TrackingOptions{URL: "https://t.example.com/t", Secret: []byte("secret")}
+	if _, html, _ := trackedSend(t, opts, true); strings.Contains(html, "t.example.com") {
+		t.Errorf("HTML of an opted out message got: %s", html)
+	}
+
+	opts.NoClicks = true
+	_, html, _ := trackedSend(t, opts, false)
+	if strings.Contains(html, "?c=") || !strings.Contains(html, "?o=") || !strings.Contains(html, "https://example.com/offer") {
+		t.Errorf("HTML without click tracking got: %s", html)
+	}
+
+	opts.NoClicks, opts.NoOpens = false, true
+	_, html, _ = trackedSend(t, opts, false)
+	if !strings.Contains(html, "?c=") || strings.Contains(html, "?o=") {
+		t.Errorf("HTML without open tracking got: %s", html)
+	}
+}
+
+func TestTrackingErrors(t *testing.T) {
+	t.Log("Refuse bad tracking requests... (expected some err)")
+
+	opts := &mailer.TrackingOptions{URL: "https://t.example.com/t", Secret: []byte("secret")}
+	_, html, _ := trackedSend(t, opts, false)
+	links := trackingHrefs.FindAllStringSubmatch(html, -1)
+	click := strings.ReplaceAll(links[0][1], "&amp;", "&")
+	open := strings.ReplaceAll(links[4][1], "&amp;", "&")
+
+	failing := mailer.NewTrackingHandler(opts, func(e mailer.Event) error {
+		return fmt.Errorf("store down")
+	})
+	for _, c := range []struct {
+		name, method, target string
+		status               int
+	}{
+		{"no token", http.MethodGet, "/t", http.StatusBadRequest},
+		{"forged", http.MethodGet, strings.Replace(click, "?c=", "?c=x", 1), http.StatusBadRequest},
+		{"open as click", http.MethodGet, strings.Replace(open, "?o=", "?c=", 1), http.StatusBadRequest},
+		{"method", http.MethodPost, open, http.StatusMethodNotAllowed},
+		{"open handler error", http.MethodGet, open, http.StatusInternalServerError},
+		// the recipient still gets to the link
+		{"click handler error", http.MethodGet, click, http.StatusFound},
+	} {
+		rec := httptest.NewRecorder()
+		failing.ServeHTTP(rec, httptest.NewRequest(c.method, c.target, nil))
+		if rec.Code != c.status {
+			t.Errorf("%s got: %d", c.name, rec.Code)
+		}
+	}
+
+	host, port, _ := smtpStub(t)
+	smtp := mailer.NewMailerSMTPSSL("sender@example.com", "password", host, port)
+	smtp.Tracking = &mailer.TrackingOptions{URL: "https://t.example.com/t"}
+	smtp.ConfigEmail = mailer.ConfigEmailSMTPSSL{EmailTo: "client@host.com", EmailFrom: "sender@example.com",
+		Subject: "Hello", ContentHTML: trackingHTML}
+	if err := smtp.SendMail(); err == nil {
+		t.Errorf("SendMail without Secret expected an error")
+	}
+}
@@ -0,0 +1,174 @@
+package mailer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// UnsubscribeOptions List-Unsubscribe headers of the sends (RFC 2369) with
+// one-click unsubscribe (RFC 8058). Each recipient gets a token naming it
+// and List, signed with an HMAC of Secret
+type UnsubscribeOptions struct {
+	URL    string // https URL of the UnsubscribeHandler, the token added as the token parameter
+	Mailto string // address taking unsubscribe mails, the token in the subject; optional
+	List   string // list or category the recipients leave, e.g. newsletter
+	Secret []byte // HMAC key of the tokens
+}
+
+// UnsubscribeHandler http.Handler of the List-Unsubscribe URL. A POST, as
+// sent by the one-click of the mailbox providers, passes an unsubscribed
+// event of the token recipient to Handle; a GET answers a page confirming
+// with that POST, as link scanners follow every GET
+type UnsubscribeHandler struct {
+	Options *UnsubscribeOptions
+	Handle  EventHandler
+}
+
+// NewUnsubscribeHandler return a handler passing the unsubscribes of the
+// tokens of opts to handle
+func NewUnsubscribeHandler(opts *UnsubscribeOptions, handle EventHandler) *UnsubscribeHandler {
+	return &UnsubscribeHandler{Options: opts, Handle: handle}
+}
+
+// Token signed unsubscribe token of email
+func (o *UnsubscribeOptions) Token(email string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(email)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(o.List)) + "." +
+		o.mac(email, o.List)
+}
+
+// ParseToken email and list of a token made by Token, e.g. read from the
+// subject of an unsubscribe mail
+func (o *UnsubscribeOptions) ParseToken(token string) (string, string, error) {
+	fields := strings.Split(strings.TrimSpace(token), ".")
+	if len(fields) != 3 {
+		return "", "", fmt.Errorf("Invalid unsubscribe token")
+	}
+	email, err := base64.RawURLEncoding.DecodeString(fields[0])
+	if err != nil || len(email) == 0 {
+		return "", "", fmt.Errorf("Invalid unsubscribe token")
+	}
+	list, err := base64.RawURLEncoding.DecodeString(fields[1])
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid unsubscribe token")
+	}
+	if !hmac.Equal([]byte(fields[2]), []byte(o.mac(string(email), string(list)))) {
+		return "", "", fmt.Errorf("Bad unsubscribe token signature")
+	}
+	return string(email), string(list), nil
+}
+
+// Headers List-Unsubscribe and, with a URL, List-Unsubscribe-Post headers
+// of a message to email; none when o is nil
+func (o *UnsubscribeOptions) Headers(email string) (map[string]string, error) {
+	if o == nil {
+		return nil, nil
+	}
+	if err := o.check(); err != nil {
+		return nil, err
+	}
+	token := o.Token(email)
+
+	var uris []string
+	if o.URL != "" {
+		u, _ := url.Parse(o.URL)
+		q := u.Query()
+		q.Set("token", token)
+		u.RawQuery = q.Encode()
+		uris = append(uris, "<"+u.String()+">")
+	}
+	if o.Mailto != "" {
+		uris = append(uris, "<mailto:"+o.Mailto+"?subject="+url.PathEscape("unsubscribe "+token)+">")
+	}
+
+	headers := map[string]string{"List-Unsubscribe": strings.Join(uris, ", ")}
+	if o.URL != "" {
+		headers["List-Unsubscribe-Post"] = "List-Unsubscribe=One-Click"
+	}
+	return headers, nil
+}
+
+// check return an error for options Headers can't use, as a URL other
+// than https; none when o is nil
+func (o *UnsubscribeOptions) check() error {
+	if o == nil {
+		return nil
+	}
+	if len(o.Secret) == 0 || (o.URL == "" && o.Mailto == "") {
+		return fmt.Errorf("Empty Secret, or URL and Mailto in UnsubscribeOptions")
+	}
+	if o.URL == "" {
+		return nil
+	}
+	u, err := url.Parse(o.URL)
+	if err != nil {
+		return fmt.Errorf("Invalid unsubscribe URL: %s", err)
+	}
+	// one-click unsubscribe (RFC 8058) takes an https URI only
+	if u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("Unsubscribe URL is not an absolute https URL: %s", o.URL)
+	}
+	return nil
+}
+
+// mac HMAC of email and list; the email is lowered, as the token of an
+// address is the same in any case
+func (o *UnsubscribeOptions) mac(email, list string) string {
+	h := hmac.New(sha256.New, o.Secret)
+	h.Write([]byte(list))
+	h.Write([]byte{0})
+	h.Write([]byte(strings.ToLower(email)))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:16])
+}
+
+// unsubscribePage confirmation page of a GET
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Unsubscribe</title></head>
+<body><form method="post">
+<p>Unsubscribe {{.Email}}{{if .List}} from {{.List}}{{end}}?</p>
+<input type="hidden" name="List-Unsubscribe" value="One-Click">
+<button type="submit">Unsubscribe</button>
+</form></body></html>
+`))
+
+// ServeHTTP unsubscribe the recipient of the token parameter
+func (h *UnsubscribeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	email, list, err := h.Options.ParseToken(r.URL.Query().Get("token"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		unsubscribePage.Execute(w, map[string]string{"Email": email, "List": list})
+		return
+	}
+
+	if h.Handle != nil {
+		e := Event{
+			Provider:  "unsubscribe",
+			Type:      EventUnsubscribed,
+			Email:     email,
+			Timestamp: time.Now().UTC(),
+			Reason:    list,
+		}
+		if err := h.Handle(e); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "Unsubscribed")
+}
@@ -0,0 +1,250 @@
+package mailer_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/thiagozs/mailer-go"
+)
+
+// unsubscribeOptions options of the tests, with a URL and a mailto
+func unsubscribeOptions() *mailer.UnsubscribeOptions {
+	return &mailer.UnsubscribeOptions{
+		URL:    "https://example.com/unsubscribe?lang=en",
+		Mailto: "unsubscribe@example.com",
+		List:   "newsletter",
+		Secret: []byte("secret"),
+	}
+}
+
+// unsubscribeToken token of the https URI of a List-Unsubscribe header
+func unsubscribeToken(t *testing.T, header string) string {
+	uri := strings.Trim(strings.Split(header, ",")[0], "<> ")
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "https" || u.Query().Get("lang") != "en" {
+		t.Errorf("List-Unsubscribe got: %s", header)
+		return ""
+	}
+	return u.Query().Get("token")
+}
+
+func TestUnsubscribeHeaders(t *testing.T) {
+	t.Log("List-Unsubscribe headers with signed tokens... (NOT expected some err)")
+
+	opts := unsubscribeOptions()
+	headers, err := opts.Headers("Client@host.com")
+	if err != nil {
+		t.Fatalf("Headers got: %s", err)
+	}
+	if headers["List-Unsubscribe-Post"] != "List-Unsubscribe=One-Click" ||
+		!strings.Contains(headers["List-Unsubscribe"], ", <mailto:unsubscribe@example.com?subject=unsubscribe%20") {
+		t.Errorf("Headers got: %v", headers)
+	}
+	email, list, err := opts.ParseToken(unsubscribeToken(t, headers["List-Unsubscribe"]))
+	if err != nil || email != "Client@host.com" || list != "newsletter" {
+		t.Errorf("ParseToken got: %s %s %v", email, list, err)
+	}
+
+	// a mailto alone can't be one-click
+	mailto := &mailer.UnsubscribeOptions{Mailto: "unsubscribe@example.com", Secret: []byte("secret")}
+	headers, err = mailto.Headers("client@host.com")
+	if err != nil || headers["List-Unsubscribe-Post"] != "" || !strings.HasPrefix(headers["List-Unsubscribe"], "<mailto:") {
+		t.Errorf("Headers got: %v %v", headers, err)
+	}
+
+	var none *mailer.UnsubscribeOptions
+	if headers, err := none.Headers("client@host.com"); headers != nil || err != nil {
+		t.Errorf("Headers of nil options got: %v %v", headers, err)
+	}
+}
+
+func TestUnsubscribeTokenErrors(t *testing.T) {
+	t.Log("Parse forged and broken tokens... (expected some err)")
+
+	opts := unsubscribeOptions()
+	token := opts.Token("client@host.com")
+	other := &mailer.UnsubscribeOptions{List: "newsletter", Secret: []byte("other")}
+	fields := strings.Split(token, ".")
+
+	for name, tok := range map[string]string{
+		"forged":       other.Token("client@host.com"),
+		"other email":  "YWRtaW5AaG9zdC5jb20." + fields[1] + "." + fields[2],
+		"other list":   fields[0] + ".YWxs." + fields[2],
+		"no signature": fields[0] + "." + fields[1],
+		"empty":        "",
+		"not base64":   "!!." + fields[1] + "." + fields[2],
+	} {
+		if _, _, err := opts.ParseToken(tok); err == nil {
+			t.Errorf("ParseToken %s expected an error", name)
+		}
+	}
+
+	for name, o := range map[string]*mailer.UnsubscribeOptions{
+		"no secret": {URL: "https://example.com/u"},
+		"no uri":    {Secret: []byte("secret")},
+		"bad url":   {URL: "https://exa mple.com/%zz", Secret: []byte("secret")},
+		"http url":  {URL: "http://example.com/u", Secret: []byte("secret")},
+		"relative":  {URL: "/u", Mailto: "unsubscribe@example.com", Secret: []byte("secret")},
+	} {
+		if _, err := o.Headers("client@host.com"); err == nil {
+			t.Errorf("Headers %s expected an error", name)
+		}
+	}
+}
+
+func TestUnsubscribeHandler(t *testing.T) {
+	t.Log("Unsubscribe through the one-click handler... (NOT expected some err)")
+
+	opts := unsubscribeOptions()
+	list := mailer.NewSuppressionList()
+	var events []mailer.Event
+	h := mailer.NewUnsubscribeHandler(opts, func(e mailer.Event) error {
+		events = append(events, e)
+		return list.HandleEvent(e)
+	})
+	target := "/unsubscribe?token=" + url.QueryEscape(opts.Token("client@host.com"))
+
+	// a GET only asks for confirmation
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `<form method="post">`) ||
+		!strings.Contains(rec.Body.String(), "client@host.com from newsletter") || len(events) != 0 {
+		t.Errorf("GET got: %d %s", rec.Code, rec.Body.String())
+	}
+
+	// the one-click POST of RFC 8058
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader("List-Unsubscribe=One-Click"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || len(events) != 1 {
+		t.Fatalf("POST got: %d, %d events", rec.Code, len(events))
+	}
+	if e := events[0]; e.Type != mailer.EventUnsubscribed || e.Provider != "unsubscribe" ||
+		e.Email != "client@host.com" || e.Reason != "newsletter" || e.Timestamp.IsZero() {
+		t.Errorf("Event got: %+v", e)
+	}
+	if s, ok := list.Check("client@host.com"); !ok || s.Reason != mailer.SuppressionUnsubscribed || s.Detail != "newsletter" {
+		t.Errorf("Check got: %+v %v", s, ok)
+	}
+}
+
+func TestUnsubscribeHandlerErrors(t *testing.T) {
+	t.Log("Refuse bad unsubscribe requests... (expected some err)")
+
+	opts := unsubscribeOptions()
+	failing := mailer.NewUnsubscribeHandler(opts, func(e mailer.Event) error {
+		return fmt.Errorf("store down")
+	})
+	token := url.QueryEscape(opts.Token("client@host.com"))
+
+	for _, c := range []struct {
+		name, method, target string
+		status               int
+	}{
+		{"no token", http.MethodPost, "/unsubscribe", http.StatusBadRequest},
+		{"forged token", http.MethodPost, "/unsubscribe?token=" + url.QueryEscape(
+			(&mailer.UnsubscribeOptions{Secret: []byte("other")}).Token("client@host.com")), http.StatusBadRequest},
+		{"method", http.MethodPut, "/unsubscribe?token=" + token, http.StatusMethodNotAllowed},
+		{"handler error", http.MethodPost, "/unsubscribe?token=" + token, http.StatusInternalServerError},
+	} {
+		rec := httptest.NewRecorder()
+		failing.ServeHTTP(rec, httptest.NewRequest(c.method, c.target, nil))
+		if rec.Code != c.status {
+			t.Errorf("%s got: %d", c.name, rec.Code)
+		}
+	}
+}
+
+func TestUnsubscribeSends(t *testing.T) {
+	t.Log("Send with List-Unsubscribe headers... (NOT expected some err)")
+
+	opts := unsubscribeOptions()
+	checkToken := func(name, header, email string) {
+		if got, _, err := opts.ParseToken(unsubscribeToken(t, header)); err != nil || got != email {
+			t.Errorf("%s List-Unsubscribe got: %s (%s %v)", name, header, got, err)
+		}
+	}
+
+	// Sendgrid sets them on the personalization
+	sgSrv, bodies := sendgridStub(t, http.StatusAccepted)
+	defer sgSrv.Close()
+	sg := mailer.NewMailerSendGrid("key")
+	sg.Host = sgSrv.URL
+	sg.Unsubscribe = opts
+	sg.ConfigEmail = mailer.ConfigEmailSendgrid{EmailTo: "client@host.com", EmailToName: "Client",
+		EmailFrom: "sender@host.com", EmailFromName: "Sender", Subject: "Hello", ContentPlainText: "Hello"}
+	if err := sg.SendMail(); err != nil {
+		t.Fatalf("SendMail(Sendgrid) got: %s", err)
+	}
+	if _, err := sg.SendBatch([]mailer.SendgridRecipient{{Email: "a@host.com"}, {Email: "b@host.com"}}); err != nil {
+		t.Fatalf("SendBatch(Sendgrid) got: %s", err)
+	}
+	for i, email := range []string{"client@host.com", "a@host.com", "b@host.com"} {
+		call, p := 0, i
+		if i > 0 {
+			call, p = 1, i-1
+		}
+		headers := (*bodies)[call]["personalizations"].([]interface{})[p].(map[string]interface{})["headers"].(map[string]interface{})
+		if headers["List-Unsubscribe-Post"] != "List-Unsubscribe=One-Click" {
+			t.Errorf("Sendgrid headers got: %v", headers)
+		}
+		checkToken("Sendgrid", headers["List-Unsubscribe"].(string), email)
+	}
+
+	// Mailgun sends them on a message, or as recipient variables of a batch
+	mgSrv, forms := mailgunStub(t)
+	defer mgSrv.Close()
+	mg := mailer.NewMailerMailGun("example.com", "key", "")
+	mg.APIBase = mgSrv.URL
+	mg.Unsubscribe = opts
+	mg.ConfigEmail = mailer.ConfigEmailMailGun{EmailTo: "client@host.com", EmailFrom: "sender@example.com",
+		Subject: "Hello", ContentPlainText: "Hello"}
+	if err := mg.SendMail(); err != nil {
+		t.Fatalf("SendMail(MailGun) got: %s", err)
+	}
+	checkToken("Mailgun", (*forms)[0].Get("h:List-Unsubscribe"), "client@host.com")
+	if _, err := mg.SendBatch([]mailer.MailgunRecipient{
+		{Email: "a@host.com", Variables: map[string]interface{}{"name": "A"}},
+		{Email: "b@host.com"},
+	}); err != nil {
+		t.Fatalf("SendBatch(MailGun) got: %s", err)
+	}
+	batch := (*forms)[1]
+	if batch.Get("h:List-Unsubscribe") != "%recipient.list_unsubscribe%" ||
+		batch.Get("h:List-Unsubscribe-Post") != "List-Unsubscribe=One-Click" {
+		t.Errorf("Mailgun batch headers got: %v", batch)
+	}
+	vars := map[string]map[string]string{}
+	if err := json.Unmarshal([]byte(batch.Get("recipient-variables")), &vars); err != nil || vars["a@host.com"]["name"] != "A" {
+		t.Errorf("Mailgun recipient-variables got: %v %v", vars, err)
+	}
+	for _, email := range []string{"a@host.com", "b@host.com"} {
+		checkToken("Mailgun batch", vars[email]["list_unsubscribe"], email)
+	}
+
+	// SMTP puts them in the raw message
+	host, port, messages := smtpStub(t)
+	smtp := mailer.NewMailerSMTPSSL("sender@example.com", "password", host, port)
+	smtp.Unsubscribe = opts
+	smtp.ConfigEmail = mailer.ConfigEmailSMTPSSL{EmailTo: "client@host.com", EmailFrom: "sender@example.com",
+		Subject: "Hello", ContentPlainText: "Hello"}
+	if err := smtp.SendMail(); err != nil {
+		t.Fatalf("SendMail(SMTPSSL) got: %s", err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader([]byte((<-messages).Data)))
+	if err != nil {
+		t.Fatalf("SMTPSSL message got: %s", err)
+	}
+	if msg.Header.Get("List-Unsubscribe-Post") != "List-Unsubscribe=One-Click" {
+		t.Errorf("SMTPSSL headers got: %v", msg.Header)
+	}
+	checkToken("SMTPSSL", msg.Header.Get("List-Unsubscribe"), "client@host.com")
+}
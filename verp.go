@@ -0,0 +1,98 @@
+package mailer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"strings"
+)
+
+// VERPOptions variable envelope return path of the sends: every recipient
+// gets its own envelope sender, so a bounce tells whom it is about
+// without being parsed. An address looks like
+// bounce-<mac>-<key>-user=host.com@Domain, where key is the message key
+// and mac an HMAC of both, so forged bounces are refused. As RFC 5321 limits
+// a local part to 64 octets, long recipients and keys can't be encoded
+type VERPOptions struct {
+	Domain string // domain receiving the bounces, e.g. bounces.example.com
+	Prefix string // local part prefix, bounce by default
+	Secret []byte // HMAC key of the addresses
+}
+
+// verpMaxLocalPart octets of the local part of an address (RFC 5321)
+const verpMaxLocalPart = 64
+
+// verpEncoding case-insensitive encoding of the keys and MACs, without the
+// separator of the address
+var verpEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// Encode envelope sender of a message with key to recipient; an error when
+// its local part is longer than the 64 octets MTAs accept
+func (o *VERPOptions) Encode(recipient, key string) (string, error) {
+	if o.Domain == "" || len(o.Secret) == 0 {
+		return "", fmt.Errorf("Empty Domain or Secret in VERPOptions")
+	}
+	at := strings.LastIndex(recipient, "@")
+	if at <= 0 || at == len(recipient)-1 {
+		return "", fmt.Errorf("Invalid recipient %q", recipient)
+	}
+	local := fmt.Sprintf("%s-%s-%s-%s=%s", o.prefix(), o.mac(recipient, key),
+		verpEncoding.EncodeToString([]byte(key)), recipient[:at], recipient[at+1:])
+	if len(local) > verpMaxLocalPart {
+		return "", fmt.Errorf("VERP local part of %s longer than %d octets: %s", recipient, verpMaxLocalPart, local)
+	}
+	return local + "@" + o.Domain, nil
+}
+
+// Decode recipient and message key of an envelope sender made by Encode,
+// e.g. the To of a bounce
+func (o *VERPOptions) Decode(address string) (string, string, error) {
+	address = strings.Trim(strings.TrimSpace(address), "<>")
+	at := strings.LastIndex(address, "@")
+	if at < 0 || !strings.EqualFold(address[at+1:], o.Domain) {
+		return "", "", fmt.Errorf("Not a VERP address of %s: %s", o.Domain, address)
+	}
+	local := address[:at]
+	prefix := o.prefix() + "-"
+	if len(local) < len(prefix) || !strings.EqualFold(local[:len(prefix)], prefix) {
+		return "", "", fmt.Errorf("Not a VERP address of %s: %s", o.Domain, address)
+	}
+
+	fields := strings.SplitN(local[len(prefix):], "-", 3)
+	if len(fields) != 3 {
+		return "", "", fmt.Errorf("Invalid VERP address %s", address)
+	}
+	key, err := verpEncoding.DecodeString(strings.ToLower(fields[1]))
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid VERP address %s: %s", address, err)
+	}
+	eq := strings.LastIndex(fields[2], "=")
+	if eq <= 0 || eq == len(fields[2])-1 {
+		return "", "", fmt.Errorf("Invalid VERP address %s", address)
+	}
+	recipient := fields[2][:eq] + "@" + fields[2][eq+1:]
+
+	if !hmac.Equal([]byte(strings.ToLower(fields[0])), []byte(o.mac(recipient, string(key)))) {
+		return "", "", fmt.Errorf("Bad VERP signature in %s", address)
+	}
+	return recipient, string(key), nil
+}
+
+// prefix local part prefix of the addresses
+func (o *VERPOptions) prefix() string {
+	if o.Prefix == "" {
+		return "bounce"
+	}
+	return o.Prefix
+}
+
+// mac HMAC of recipient and key, cut to 80 bits. The recipient is lowered,
+// as some MTAs change the case of the addresses they return
+func (o *VERPOptions) mac(recipient, key string) string {
+	h := hmac.New(sha256.New, o.Secret)
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(strings.ToLower(recipient)))
+	return verpEncoding.EncodeToString(h.Sum(nil)[:10])
+}
@@ -0,0 +1,161 @@
+package mailer_test
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/thiagozs/mailer-go"
+)
+
+func TestVERP(t *testing.T) {
+	t.Log("Encode and decode VERP addresses... (NOT expected some err)")
+
+	verp := &mailer.VERPOptions{Domain: "bounces.example.com", Secret: []byte("secret")}
+	for _, c := range []struct{ recipient, key string }{
+		{"user@host.com", "campaign-42"},
+		{"first.last+tag@sub.host.com", ""},
+		{"odd=local-part@host.com", "ünï key"},
+	} {
+		address, err := verp.Encode(c.recipient, c.key)
+		if err != nil {
+			t.Errorf("Encode %s got: %s", c.recipient, err)
+			continue
+		}
+		if !strings.HasPrefix(address, "bounce-") || !strings.HasSuffix(address, "@bounces.example.com") {
+			t.Errorf("Encode %s got: %s", c.recipient, address)
+		}
+		// MTAs may return the address in another case, in angle brackets
+		for _, returned := range []string{address, "<" + address + ">", strings.ToUpper(address)} {
+			recipient, key, err := verp.Decode(returned)
+			if err != nil || !strings.EqualFold(recipient, c.recipient) || key != c.key {
+				t.Errorf("Decode %s got: %s %q %v", returned, recipient, key, err)
+			}
+		}
+	}
+
+	custom := &mailer.VERPOptions{Domain: "example.com", Prefix: "rp", Secret: []byte("secret")}
+	address, _ := custom.Encode("user@host.com", "k")
+	if recipient, _, err := custom.Decode(address); !strings.HasPrefix(address, "rp-") || err != nil || recipient != "user@host.com" {
+		t.Errorf("Decode %s got: %s %v", address, recipient, err)
+	}
+}
+
+func TestVERPErrors(t *testing.T) {
+	t.Log("Decode forged and foreign addresses... (expected some err)")
+
+	verp := &mailer.VERPOptions{Domain: "bounces.example.com", Secret: []byte("secret")}
+	address, err := verp.Encode("user@host.com", "campaign-42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := &mailer.VERPOptions{Domain: "bounces.example.com", Secret: []byte("other")}
+	forged, _ := other.Encode("user@host.com", "campaign-42")
+
+	for name, a := range map[string]string{
+		"forged":          forged,
+		"other recipient": strings.Replace(address, "user=host.com", "admin=host.com", 1),
+		"other domain":    strings.Replace(address, "@bounces.example.com", "@example.com", 1),
+		"no prefix":       "user@bounces.example.com",
+		"no recipient":    "bounce-abc-def-nohost@bounces.example.com",
+		"short":           "bounce-abc@bounces.example.com",
+	} {
+		if _, _, err := verp.Decode(a); err == nil {
+			t.Errorf("Decode %s expected an error", name)
+		}
+	}
+
+	if _, err := verp.Encode("no-at-sign", ""); err == nil {
+		t.Errorf("Encode expected an error")
+	}
+	if _, err := (&mailer.VERPOptions{Domain: "example.com"}).Encode("user@host.com", ""); err == nil {
+		t.Errorf("Encode without Secret expected an error")
+	}
+
+	// the local part is at most 64 octets
+	for _, c := range []struct {
+		recipient, key string
+		ok             bool
+	}{
+		{"user@host.com", strings.Repeat("k", 16), true},
+		{"user@host.com", strings.Repeat("k", 17), false},
+		{"first.last@customer-mail.example.org", "campaign-2026-10", false},
+	} {
+		address, err := verp.Encode(c.recipient, c.key)
+		if c.ok && (err != nil || len(address[:strings.LastIndex(address, "@")]) > 64) {
+			t.Errorf("Encode %s %s got: %s %v", c.recipient, c.key, address, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("Encode %s %s expected an error, got: %s", c.recipient, c.key, address)
+		}
+	}
+}
+
+// smtpMessage message taken by smtpStub
+type smtpMessage struct {
+	From string
+	Data string
+}
+
+// smtpStub TLS SMTP server taking the messages, sent on the returned channel
+func smtpStub(t *testing.T) (string, string, <-chan smtpMessage) {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make(chan smtpMessage, 1)
+	srv := mailer.NewSMTPServer(func(m *mailer.SMTPMessage) error {
+		messages <- smtpMessage{From: m.From, Data: string(m.Data)}
+		return nil
+	})
+	srv.Hostname = "stub"
+	srv.Auth = func(username, password string) bool { return true }
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	return host, port, messages
+}
+
+func TestSMTPSSLEnvelopeSender(t *testing.T) {
+	t.Log("Send with the envelope sender of the config... (NOT expected some err)")
+
+	verp := &mailer.VERPOptions{Domain: "bounces.example.com", Secret: []byte("secret")}
+	for name, c := range map[string]struct {
+		returnPath string
+		verp       *mailer.VERPOptions
+	}{
+		"user":        {},
+		"return path": {returnPath: "returns@example.com"},
+		"verp":        {returnPath: "returns@example.com", verp: verp},
+	} {
+		host, port, messages := smtpStub(t)
+		smtp := mailer.NewMailerSMTPSSL("sender@example.com", "password", host, port)
+		smtp.ReturnPath = c.returnPath
+		smtp.VERP = c.verp
+		smtp.ConfigEmail = mailer.ConfigEmailSMTPSSL{EmailTo: "client@host.com", EmailFrom: "sender@example.com",
+			Subject: "Hello", ContentPlainText: "Hello", MessageKey: "welcome"}
+		if err := smtp.SendMail(); err != nil {
+			t.Errorf("SendMail %s got: %s", name, err)
+			continue
+		}
+
+		got := (<-messages).From
+		switch name {
+		case "user":
+			if got != "sender@example.com" {
+				t.Errorf("MAIL FROM %s got: %s", name, got)
+			}
+		case "return path":
+			if got != "returns@example.com" {
+				t.Errorf("MAIL FROM %s got: %s", name, got)
+			}
+		case "verp":
+			recipient, key, err := verp.Decode(got)
+			if err != nil || recipient != "client@host.com" || key != "welcome" {
+				t.Errorf("MAIL FROM %s got: %s (%s %s %v)", name, got, recipient, key, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,827 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventType delivery event kind, the same for every provider
+type EventType string
+
+// delivery event kinds
+const (
+	EventDelivered    EventType = "delivered"
+	EventBounced      EventType = "bounced"
+	EventDeferred     EventType = "deferred"
+	EventOpened       EventType = "opened"
+	EventClicked      EventType = "clicked"
+	EventComplained   EventType = "complained"
+	EventUnsubscribed EventType = "unsubscribed"
+)
+
+// Event delivery event reported by a provider
+type Event struct {
+	Provider  string // SDKName of the provider (sendgrid, mailgun, awsses), or arf, unsubscribe or tracking
+	Type      EventType
+	Email     string
+	MessageID string // as in MessageID after the send
+	Timestamp time.Time
+	Reason    string // bounce, deferral or complaint detail
+	URL       string // clicked link
+	Raw       []byte // provider payload of the event
+}
+
+// EventHandler receives the events of a webhook; an error makes the
+// webhook answer 500 so the provider posts again later
+type EventHandler func(Event) error
+
+// SendgridWebhook http.Handler of the Sendgrid Event Webhook
+type SendgridWebhook struct {
+	Handle EventHandler
+}
+
+// MailgunWebhook http.Handler of the Mailgun webhooks, in the JSON and the
+// legacy form formats
+type MailgunWebhook struct {
+	APIKey string // key signing the posts (webhook signing key on new accounts)
+	Handle EventHandler
+}
+
+// SESWebhook http.Handler of SES notifications and event publishing
+// delivered by SNS
+type SESWebhook struct {
+	TopicARNs   []string     // topics accepted, any when empty
+	AutoConfirm bool         // confirm SNS subscriptions
+	Client      *http.Client // fetch of signing certificates, http.DefaultClient when nil
+	Handle      EventHandler
+
+	mu    sync.Mutex
+	certs map[string]*x509.Certificate
+}
+
+// NewSendgridWebhook return a webhook passing Sendgrid events to handle
+func NewSendgridWebhook(handle EventHandler) *SendgridWebhook {
+	return &SendgridWebhook{Handle: handle}
+}
+
+// NewMailgunWebhook return a webhook passing Mailgun events signed with
+// apiKey to handle
+func NewMailgunWebhook(apiKey string, handle EventHandler) *MailgunWebhook {
+	return &MailgunWebhook{APIKey: apiKey, Handle: handle}
+}
+
+// NewSESWebhook return a webhook passing SES events of the topics to handle,
+// confirming the SNS subscriptions
+func NewSESWebhook(handle EventHandler, topicARNs ...string) *SESWebhook {
+	return &SESWebhook{TopicARNs: topicARNs, AutoConfirm: true, Handle: handle}
+}
+
+// ServeHTTP parse the posted events
+func (h *SendgridWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, ok := readWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	var raws []json.RawMessage
+	if err := json.Unmarshal(body, &raws); err != nil {
+		http.Error(w, "Invalid Sendgrid events", http.StatusBadRequest)
+		return
+	}
+
+	var events []Event
+	for _, raw := range raws {
+		var e struct {
+			Email       string `json:"email"`
+			Timestamp   int64  `json:"timestamp"`
+			Event       string `json:"event"`
+			SGMessageID string `json:"sg_message_id"`
+			Reason      string `json:"reason"`
+			Response    string `json:"response"`
+			URL         string `json:"url"`
+			Type        string `json:"type"` // bounce or blocked of a bounce
+		}
+		if err := json.Unmarshal(raw, &e); err != nil {
+			http.Error(w, "Invalid Sendgrid event", http.StatusBadRequest)
+			return
+		}
+
+		event := Event{
+			Provider: "sendgrid",
+			Email:    e.Email,
+			// sg_message_id is the X-Message-Id followed by the filter
+			MessageID: strings.SplitN(e.SGMessageID, ".", 2)[0],
+			Timestamp: time.Unix(e.Timestamp, 0).UTC(),
+			Reason:    e.Reason,
+			URL:       e.URL,
+			Raw:       raw,
+		}
+		switch e.Event {
+		case "delivered":
+			event.Type = EventDelivered
+		case "bounce":
+			// a block is a temporary refusal of the sending IP or content
+			event.Type = EventBounced
+			if e.Type == "blocked" {
+				event.Type = EventDeferred
+			}
+		case "dropped":
+			// drops of other reasons (a bad SMTPAPI header, a suppression
+			// of Sendgrid) say nothing of the address
+			if e.Reason != "Bounced Address" && e.Reason != "Invalid" {
+				continue
+			}
+			event.Type = EventBounced
+		case "deferred":
+			event.Type = EventDeferred
+			if event.Reason == "" {
+				event.Reason = e.Response
+			}
+		case "open":
+			event.Type = EventOpened
+		case "click":
+			event.Type = EventClicked
+		case "spamreport":
+			event.Type = EventComplained
+		case "unsubscribe", "group_unsubscribe":
+			event.Type = EventUnsubscribed
+		default:
+			continue
+		}
+		events = append(events, event)
+	}
+
+	handleEvents(w, h.Handle, events)
+}
+
+// ServeHTTP check the signature and parse the posted event
+func (h *MailgunWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		body, ok := readWebhook(w, r)
+		if !ok {
+			return
+		}
+		var payload struct {
+			Signature struct {
+				Timestamp string `json:"timestamp"`
+				Token     string `json:"token"`
+				Signature string `json:"signature"`
+			} `json:"signature"`
+			EventData json.RawMessage `json:"event-data"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			http.Error(w, "Invalid Mailgun event", http.StatusBadRequest)
+			return
+		}
+		release, err := checkMailgunSignature(h.APIKey, payload.Signature.Timestamp, payload.Signature.Token, payload.Signature.Signature)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		event, ok, err := mailgunEvent(payload.EventData)
+		if err != nil {
+			release()
+			http.Error(w, "Invalid Mailgun event", http.StatusBadRequest)
+			return
+		}
+		var events []Event
+		if ok {
+			events = append(events, event)
+		}
+		if !handleEvents(w, h.Handle, events) {
+			release()
+		}
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// ParseMultipartForm drops the errors of a form that is not multipart
+	r.Body = http.MaxBytesReader(w, r.Body, webhookMaxBody)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid Mailgun event", http.StatusBadRequest)
+		return
+	}
+	if err := r.ParseMultipartForm(1 << 20); err != nil && err != http.ErrNotMultipart {
+		http.Error(w, "Invalid Mailgun event", http.StatusBadRequest)
+		return
+	}
+	release, err := checkMailgunSignature(h.APIKey, r.PostFormValue("timestamp"), r.PostFormValue("token"), r.PostFormValue("signature"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	event := Event{
+		Provider:  "mailgun",
+		Email:     r.PostFormValue("recipient"),
+		MessageID: mailgunMessageID(r.PostFormValue("Message-Id")),
+		Timestamp: mailgunTimestamp(r.PostFormValue("timestamp")),
+		URL:       r.PostFormValue("url"),
+		Raw:       []byte(r.PostForm.Encode()),
+	}
+	for _, k := range []string{"error", "description", "notification", "reason"} {
+		if v := r.PostFormValue(k); v != "" {
+			event.Reason = v
+			break
+		}
+	}
+	var events []Event
+	switch r.PostFormValue("event") {
+	case "delivered":
+		event.Type = EventDelivered
+	case "bounced", "dropped":
+		event.Type = EventBounced
+	case "opened":
+		event.Type = EventOpened
+	case "clicked":
+		event.Type = EventClicked
+	case "complained":
+		event.Type = EventComplained
+	case "unsubscribed":
+		event.Type = EventUnsubscribed
+	}
+	if event.Type != "" {
+		events = append(events, event)
+	}
+	if !handleEvents(w, h.Handle, events) {
+		release()
+	}
+}
+
+// MailgunTimestampTolerance difference from now of the timestamp of a
+// signed Mailgun post; an older or newer post is refused as a replay
+const MailgunTimestampTolerance = 5 * time.Minute
+
+// replayMaxKeys keys remembered by a replayCache
+const replayMaxKeys = 10000
+
+// mailgunTokens tokens of the Mailgun posts taken, shared by the webhooks
+// and inbound routes
+var mailgunTokens = &replayCache{}
+
+// snsMessageIDs MessageIds of the SNS messages taken
+var snsMessageIDs = &replayCache{}
+
+// replayCache keys of the signed posts taken (Mailgun tokens, SNS
+// MessageIds), with the time they can be forgotten as their timestamp is
+// out of tolerance
+type replayCache struct {
+	mu   sync.Mutex
+	keys map[string]time.Time
+}
+
+// take record key until expires; false when it is taken already
+func (c *replayCache) take(key string, expires, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.keys == nil {
+		c.keys = make(map[string]time.Time)
+	}
+	if _, ok := c.keys[key]; ok {
+		return false
+	}
+	if len(c.keys) >= replayMaxKeys {
+		oldest := ""
+		for k, exp := range c.keys {
+			if !exp.After(now) {
+				delete(c.keys, k)
+			} else if oldest == "" || exp.Before(c.keys[oldest]) {
+				oldest = k
+			}
+		}
+		if len(c.keys) >= replayMaxKeys {
+			delete(c.keys, oldest)
+		}
+	}
+	c.keys[key] = expires
+	return true
+}
+
+// release forget key, so the retry of a post that failed is taken
+func (c *replayCache) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.keys, key)
+}
+
+// checkMailgunSignature verify a signed Mailgun post: the HMAC, a timestamp
+// within MailgunTimestampTolerance of now and a token not taken by an
+// earlier post. The token is taken until release, called when handling the
+// post fails
+func checkMailgunSignature(apiKey, timestamp, token, signature string) (func(), error) {
+	if !verifyMailgunSignature(apiKey, timestamp, token, signature) {
+		return nil, fmt.Errorf("Invalid Mailgun signature")
+	}
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Mailgun signature")
+	}
+	now := time.Now()
+	ts := time.Unix(sec, 0)
+	if ts.Before(now.Add(-MailgunTimestampTolerance)) || ts.After(now.Add(MailgunTimestampTolerance)) {
+		return nil, fmt.Errorf("Stale Mailgun signature timestamp")
+	}
+	if !mailgunTokens.take(token, ts.Add(MailgunTimestampTolerance), now) {
+		return nil, fmt.Errorf("Replayed Mailgun signature token")
+	}
+	return func() { mailgunTokens.release(token) }, nil
+}
+
+// verifyMailgunSignature check the HMAC-SHA256 of timestamp and token
+// with the API key
+func verifyMailgunSignature(apiKey, timestamp, token, signature string) bool {
+	sig, err := hex.DecodeString(signature)
+	if err != nil || timestamp == "" || token == "" {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(apiKey))
+	mac.Write([]byte(timestamp + token))
+	return subtle.ConstantTimeCompare(sig, mac.Sum(nil)) == 1
+}
+
+// mailgunEvent event of Mailgun event data, as posted by webhooks and listed
+// by the events API; ok is false for events without an EventType
+func mailgunEvent(data []byte) (Event, bool, error) {
+	var e struct {
+		Event     string  `json:"event"`
+		Severity  string  `json:"severity"`
+		Recipient string  `json:"recipient"`
+		Timestamp float64 `json:"timestamp"`
+		URL       string  `json:"url"`
+		Reason    string  `json:"reason"`
+		Message   struct {
+			Headers struct {
+				MessageID string `json:"message-id"`
+			} `json:"headers"`
+		} `json:"message"`
+		DeliveryStatus struct {
+			Message     string `json:"message"`
+			Description string `json:"description"`
+		} `json:"delivery-status"`
+	}
+	if err := json.Unmarshal(data, &e); err != nil {
+		return Event{}, false, err
+	}
+
+	sec, frac := math.Modf(e.Timestamp)
+	event := Event{
+		Provider:  "mailgun",
+		Email:     e.Recipient,
+		MessageID: mailgunMessageID(e.Message.Headers.MessageID),
+		Timestamp: time.Unix(int64(sec), int64(frac*1e9)).UTC(),
+		Reason:    e.DeliveryStatus.Description,
+		URL:       e.URL,
+		Raw:       data,
+	}
+	if event.Reason == "" {
+		event.Reason = e.DeliveryStatus.Message
+	}
+	if event.Reason == "" {
+		event.Reason = e.Reason
+	}
+
+	switch e.Event {
+	case "delivered":
+		event.Type = EventDelivered
+	case "failed":
+		event.Type = EventBounced
+		if e.Severity == "temporary" {
+			event.Type = EventDeferred
+		}
+	case "opened":
+		event.Type = EventOpened
+	case "clicked":
+		event.Type = EventClicked
+	case "complained":
+		event.Type = EventComplained
+	case "unsubscribed":
+		event.Type = EventUnsubscribed
+	default:
+		return event, false, nil
+	}
+	return event, true, nil
+}
+
+// mailgunMessageID message id in angle brackets, as returned by sends
+func mailgunMessageID(id string) string {
+	id = strings.Trim(strings.TrimSpace(id), "<>")
+	if id == "" {
+		return ""
+	}
+	return "<" + id + ">"
+}
+
+// mailgunTimestamp time of a unix timestamp form value
+func mailgunTimestamp(v string) time.Time {
+	sec, _ := strconv.ParseInt(v, 10, 64)
+	return time.Unix(sec, 0).UTC()
+}
+
+// snsCertHost hosts serving SNS signing certificates
+var snsCertHost = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// snsEnvelope message posted by SNS
+type snsEnvelope struct {
+	Type             string
+	MessageId        string
+	Token            string
+	TopicArn         string
+	Subject          string
+	Message          string
+	Timestamp        string
+	SignatureVersion string
+	Signature        string
+	SigningCertURL   string
+	SubscribeURL     string
+}
+
+// ServeHTTP check the SNS signature, confirm subscriptions and parse the
+// SES events of notifications
+func (h *SESWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, ok := readWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	var msg snsEnvelope
+	if err := json.Unmarshal(body, &msg); err != nil || msg.Type == "" {
+		http.Error(w, "Invalid SNS message", http.StatusBadRequest)
+		return
+	}
+	if err := h.verify(&msg); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if len(h.TopicARNs) > 0 && !containsString(h.TopicARNs, msg.TopicArn) {
+		http.Error(w, "Unexpected SNS topic", http.StatusForbidden)
+		return
+	}
+	release, err := takeSNSMessage(&msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	switch msg.Type {
+	case "SubscriptionConfirmation":
+		if h.AutoConfirm {
+			if err := h.confirm(msg.SubscribeURL); err != nil {
+				release()
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	case "Notification":
+		events, err := sesEvents([]byte(msg.Message))
+		if err != nil {
+			release()
+			http.Error(w, "Invalid SES notification", http.StatusBadRequest)
+			return
+		}
+		if !handleEvents(w, h.Handle, events) {
+			release()
+		}
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// SNSTimestampTolerance age of the Timestamp of a signed SNS message, as SNS
+// retries a delivery for up to an hour; an older message is refused as a
+// replay
+const SNSTimestampTolerance = time.Hour
+
+// takeSNSMessage refuse a verified msg with a Timestamp out of tolerance or a
+// MessageId taken by an earlier message. The MessageId is taken until
+// release, called when handling the message fails
+func takeSNSMessage(msg *snsEnvelope) (func(), error) {
+	ts, err := time.Parse(time.RFC3339Nano, msg.Timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid SNS Timestamp")
+	}
+	// a little clock skew of the sender is allowed
+	now := time.Now()
+	if ts.Before(now.Add(-SNSTimestampTolerance)) || ts.After(now.Add(5*time.Minute)) {
+		return nil, fmt.Errorf("Stale SNS Timestamp")
+	}
+	if msg.MessageId == "" || !snsMessageIDs.take(msg.MessageId, ts.Add(SNSTimestampTolerance), now) {
+		return nil, fmt.Errorf("Replayed SNS message")
+	}
+	return func() { snsMessageIDs.release(msg.MessageId) }, nil
+}
+
+// verify check the signature of msg with the certificate of its
+// SigningCertURL
+func (h *SESWebhook) verify(msg *snsEnvelope) error {
+	var hash crypto.Hash
+	switch msg.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return fmt.Errorf("Unsupported SNS SignatureVersion %q", msg.SignatureVersion)
+	}
+
+	var fields []string
+	switch msg.Type {
+	case "Notification":
+		fields = []string{"Message", msg.Message, "MessageId", msg.MessageId}
+		if msg.Subject != "" {
+			fields = append(fields, "Subject", msg.Subject)
+		}
+		fields = append(fields, "Timestamp", msg.Timestamp, "TopicArn", msg.TopicArn, "Type", msg.Type)
+	case "SubscriptionConfirmation", "UnsubscribeConfirmation":
+		fields = []string{"Message", msg.Message, "MessageId", msg.MessageId,
+			"SubscribeURL", msg.SubscribeURL, "Timestamp", msg.Timestamp,
+			"Token", msg.Token, "TopicArn", msg.TopicArn, "Type", msg.Type}
+	default:
+		return fmt.Errorf("Unsupported SNS Type %q", msg.Type)
+	}
+	signed := strings.Join(fields, "\n") + "\n"
+
+	sig, err := base64.StdEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		return fmt.Errorf("Invalid SNS Signature")
+	}
+	cert, err := h.certificate(msg.SigningCertURL)
+	if err != nil {
+		return err
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("SNS signing certificate is not RSA")
+	}
+
+	var digest []byte
+	if hash == crypto.SHA1 {
+		sum := sha1.Sum([]byte(signed))
+		digest = sum[:]
+	} else {
+		sum := sha256.Sum256([]byte(signed))
+		digest = sum[:]
+	}
+	if err := rsa.VerifyPKCS1v15(pub, hash, digest, sig); err != nil {
+		return fmt.Errorf("Invalid SNS Signature")
+	}
+	return nil
+}
+
+// certificate fetch, once, the SNS signing certificate at rawurl
+func (h *SESWebhook) certificate(rawurl string) (*x509.Certificate, error) {
+	if err := checkSNSURL(rawurl); err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	cert := h.certs[rawurl]
+	h.mu.Unlock()
+	if cert != nil {
+		return cert, nil
+	}
+
+	res, err := h.client().Get(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("SNS signing certificate error %d", res.StatusCode)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("Invalid SNS signing certificate")
+	}
+	cert, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	if h.certs == nil {
+		h.certs = make(map[string]*x509.Certificate)
+	}
+	h.certs[rawurl] = cert
+	h.mu.Unlock()
+	return cert, nil
+}
+
+// confirm visit the SubscribeURL of a subscription confirmation
+func (h *SESWebhook) confirm(rawurl string) error {
+	if err := checkSNSURL(rawurl); err != nil {
+		return err
+	}
+	res, err := h.client().Get(rawurl)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("SNS subscription confirmation error %d", res.StatusCode)
+	}
+	return nil
+}
+
+// client http.Client of the webhook
+func (h *SESWebhook) client() *http.Client {
+	if h.Client != nil {
+		return h.Client
+	}
+	return http.DefaultClient
+}
+
+// checkSNSURL refuse URLs not served by SNS over https
+func checkSNSURL(rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Scheme != "https" || !snsCertHost.MatchString(u.Hostname()) {
+		return fmt.Errorf("Unexpected SNS URL %q", rawurl)
+	}
+	return nil
+}
+
+// sesEvents events of an SES notification or event publishing message
+func sesEvents(data []byte) ([]Event, error) {
+	var n struct {
+		NotificationType string `json:"notificationType"`
+		EventType        string `json:"eventType"`
+		Mail             struct {
+			MessageID   string   `json:"messageId"`
+			Destination []string `json:"destination"`
+		} `json:"mail"`
+		Bounce struct {
+			BounceType        string `json:"bounceType"`
+			Timestamp         string `json:"timestamp"`
+			BouncedRecipients []struct {
+				EmailAddress   string `json:"emailAddress"`
+				DiagnosticCode string `json:"diagnosticCode"`
+			} `json:"bouncedRecipients"`
+		} `json:"bounce"`
+		Complaint struct {
+			ComplaintFeedbackType string `json:"complaintFeedbackType"`
+			Timestamp             string `json:"timestamp"`
+			ComplainedRecipients  []struct {
+				EmailAddress string `json:"emailAddress"`
+			} `json:"complainedRecipients"`
+		} `json:"complaint"`
+		Delivery struct {
+			Timestamp    string   `json:"timestamp"`
+			Recipients   []string `json:"recipients"`
+			SMTPResponse string   `json:"smtpResponse"`
+		} `json:"delivery"`
+		DeliveryDelay struct {
+			Timestamp         string `json:"timestamp"`
+			DelayType         string `json:"delayType"`
+			DelayedRecipients []struct {
+				EmailAddress   string `json:"emailAddress"`
+				DiagnosticCode string `json:"diagnosticCode"`
+			} `json:"delayedRecipients"`
+		} `json:"deliveryDelay"`
+		Open struct {
+			Timestamp string `json:"timestamp"`
+		} `json:"open"`
+		Click struct {
+			Timestamp string `json:"timestamp"`
+			Link      string `json:"link"`
+		} `json:"click"`
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		// SNS test and SES setup messages are plain text
+		return nil, nil
+	}
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, err
+	}
+
+	kind := n.NotificationType
+	if kind == "" {
+		kind = n.EventType
+	}
+	newEvent := func(t EventType, email, timestamp, reason string) Event {
+		ts, _ := time.Parse(time.RFC3339, timestamp)
+		return Event{
+			Provider:  "awsses",
+			Type:      t,
+			Email:     email,
+			MessageID: n.Mail.MessageID,
+			Timestamp: ts,
+			Reason:    reason,
+			Raw:       data,
+		}
+	}
+
+	var events []Event
+	switch kind {
+	case "Bounce":
+		t := EventBounced
+		if n.Bounce.BounceType == "Transient" {
+			t = EventDeferred
+		}
+		for _, r := range n.Bounce.BouncedRecipients {
+			events = append(events, newEvent(t, r.EmailAddress, n.Bounce.Timestamp, r.DiagnosticCode))
+		}
+	case "Complaint":
+		for _, r := range n.Complaint.ComplainedRecipients {
+			events = append(events, newEvent(EventComplained, r.EmailAddress,
+				n.Complaint.Timestamp, n.Complaint.ComplaintFeedbackType))
+		}
+	case "Delivery":
+		for _, email := range n.Delivery.Recipients {
+			events = append(events, newEvent(EventDelivered, email,
+				n.Delivery.Timestamp, n.Delivery.SMTPResponse))
+		}
+	case "DeliveryDelay":
+		for _, r := range n.DeliveryDelay.DelayedRecipients {
+			reason := r.DiagnosticCode
+			if reason == "" {
+				reason = n.DeliveryDelay.DelayType
+			}
+			events = append(events, newEvent(EventDeferred, r.EmailAddress, n.DeliveryDelay.Timestamp, reason))
+		}
+	case "Open":
+		for _, email := range n.Mail.Destination {
+			events = append(events, newEvent(EventOpened, email, n.Open.Timestamp, ""))
+		}
+	case "Click":
+		for _, email := range n.Mail.Destination {
+			e := newEvent(EventClicked, email, n.Click.Timestamp, "")
+			e.URL = n.Click.Link
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// webhookMaxBody size of a webhook post read
+const webhookMaxBody = 10 << 20
+
+// readWebhook body of a POST, answering the errors
+func readWebhook(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, webhookMaxBody))
+	if err != nil && len(body) >= webhookMaxBody {
+		http.Error(w, "Body too large", http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return nil, false
+	}
+	return body, true
+}
+
+// handleEvents pass events to handle and answer the webhook; false when
+// handle failed
+func handleEvents(w http.ResponseWriter, handle EventHandler, events []Event) bool {
+	for _, e := range events {
+		if handle == nil {
+			break
+		}
+		if err := handle(e); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return false
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	return true
+}
+
+// containsString report whether list holds s
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
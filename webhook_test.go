@@ -0,0 +1,501 @@
+package mailer_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thiagozs/mailer-go"
+)
+
+// postWebhook post body to h and return the status code
+func postWebhook(h http.Handler, contentType, body string) int {
+	req := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestSendgridWebhook(t *testing.T) {
+	t.Log("Sendgrid Event Webhook... (NOT expected some err)")
+
+	var events []mailer.Event
+	h := mailer.NewSendgridWebhook(func(e mailer.Event) error {
+		events = append(events, e)
+		return nil
+	})
+	body := `[
+		{"email":"a@host.com","timestamp":1760000000,"event":"processed","sg_message_id":"abc.filter1"},
+		{"email":"a@host.com","timestamp":1760000001,"event":"delivered","sg_message_id":"abc.filter1"},
+		{"email":"b@host.com","timestamp":1760000002,"event":"bounce","sg_message_id":"abc.filter2","reason":"550 unknown user"},
+		{"email":"c@host.com","timestamp":1760000003,"event":"deferred","sg_message_id":"abc.filter3","response":"421 try later"},
+		{"email":"a@host.com","timestamp":1760000004,"event":"open","sg_message_id":"abc.filter1"},
+		{"email":"a@host.com","timestamp":1760000005,"event":"click","sg_message_id":"abc.filter1","url":"https://example.com"},
+		{"email":"a@host.com","timestamp":1760000006,"event":"spamreport","sg_message_id":"abc.filter1"},
+		{"email":"a@host.com","timestamp":1760000007,"event":"group_unsubscribe","sg_message_id":"abc.filter1"}
+	]`
+	if code := postWebhook(h, "application/json", body); code != http.StatusOK {
+		t.Fatalf("SendgridWebhook got: %d", code)
+	}
+
+	want := []mailer.EventType{mailer.EventDelivered, mailer.EventBounced, mailer.EventDeferred,
+		mailer.EventOpened, mailer.EventClicked, mailer.EventComplained, mailer.EventUnsubscribed}
+	if len(events) != len(want) {
+		t.Fatalf("SendgridWebhook got: %d events", len(events))
+	}
+	for i, e := range events {
+		if e.Type != want[i] || e.Provider != "sendgrid" || e.MessageID != "abc" {
+			t.Errorf("SendgridWebhook event %d got: %+v", i, e)
+		}
+	}
+	if events[1].Reason != "550 unknown user" || events[2].Reason != "421 try later" ||
+		events[4].URL != "https://example.com" || !events[0].Timestamp.Equal(time.Unix(1760000001, 0)) {
+		t.Errorf("SendgridWebhook got: %+v", events)
+	}
+
+	failing := mailer.NewSendgridWebhook(func(mailer.Event) error { return fmt.Errorf("store down") })
+	if code := postWebhook(failing, "application/json", body); code != http.StatusInternalServerError {
+		t.Errorf("SendgridWebhook with a failing handler got: %d", code)
+	}
+	if code := postWebhook(h, "application/json", "{"); code != http.StatusBadRequest {
+		t.Errorf("SendgridWebhook with a bad body got: %d", code)
+	}
+}
+
+func TestSendgridWebhookBlocksAndDrops(t *testing.T) {
+	t.Log("Sendgrid blocks and drops... (NOT expected some err)")
+
+	list := mailer.NewSuppressionList()
+	var events []mailer.Event
+	h := mailer.NewSendgridWebhook(func(e mailer.Event) error {
+		events = append(events, e)
+		return list.HandleEvent(e)
+	})
+	body := `[
+		{"email":"blocked@host.com","timestamp":1760000000,"event":"bounce","type":"blocked","reason":"554 IP listed"},
+		{"email":"bounced@host.com","timestamp":1760000001,"event":"bounce","type":"bounce","reason":"550 unknown user"},
+		{"email":"header@host.com","timestamp":1760000002,"event":"dropped","reason":"Invalid SMTPAPI header"},
+		{"email":"gone@host.com","timestamp":1760000003,"event":"dropped","reason":"Bounced Address"},
+		{"email":"invalid@host.com","timestamp":1760000004,"event":"dropped","reason":"Invalid"}
+	]`
+	if code := postWebhook(h, "application/json", body); code != http.StatusOK {
+		t.Fatalf("SendgridWebhook got: %d", code)
+	}
+
+	var got []string
+	for _, e := range events {
+		got = append(got, e.Email+":"+string(e.Type))
+	}
+	if strings.Join(got, " ") != "blocked@host.com:deferred bounced@host.com:bounced gone@host.com:bounced invalid@host.com:bounced" {
+		t.Errorf("SendgridWebhook got: %v", got)
+	}
+	for email, suppressed := range map[string]bool{
+		"blocked@host.com": false, "header@host.com": false,
+		"bounced@host.com": true, "gone@host.com": true, "invalid@host.com": true,
+	} {
+		if _, ok := list.Check(email); ok != suppressed {
+			t.Errorf("Suppressed %s got: %v", email, ok)
+		}
+	}
+}
+
+// mailgunSignature signature fields of a Mailgun post
+func mailgunSignature(key, timestamp, token string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(timestamp + token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// mailgunSigned timestamp, new token and signature of a Mailgun post sent now
+func mailgunSigned(key string) (string, string, string) {
+	b := make([]byte, 16)
+	rand.Read(b)
+	timestamp, token := fmt.Sprint(time.Now().Unix()), hex.EncodeToString(b)
+	return timestamp, token, mailgunSignature(key, timestamp, token)
+}
+
+func TestMailgunWebhook(t *testing.T) {
+	t.Log("Mailgun webhooks, JSON and form posts... (NOT expected some err)")
+
+	var events []mailer.Event
+	h := mailer.NewMailgunWebhook("key", func(e mailer.Event) error {
+		events = append(events, e)
+		return nil
+	})
+
+	payload := func(key, event, severity string) string {
+		timestamp, token, signature := mailgunSigned(key)
+		body, _ := json.Marshal(map[string]interface{}{
+			"signature": map[string]string{
+				"timestamp": timestamp,
+				"token":     token,
+				"signature": signature,
+			},
+			"event-data": map[string]interface{}{
+				"event":     event,
+				"severity":  severity,
+				"recipient": "a@host.com",
+				"timestamp": 1760000000.5,
+				"url":       "https://example.com",
+				"message":   map[string]interface{}{"headers": map[string]string{"message-id": "1.stub@example.com"}},
+				"delivery-status": map[string]string{
+					"message":     "mailbox full",
+					"description": "",
+				},
+			},
+		})
+		return string(body)
+	}
+	post := func(key, event, severity string) int {
+		return postWebhook(h, "application/json", payload(key, event, severity))
+	}
+
+	for _, c := range []struct {
+		event, severity string
+		want            mailer.EventType
+	}{
+		{"delivered", "", mailer.EventDelivered},
+		{"failed", "permanent", mailer.EventBounced},
+		{"failed", "temporary", mailer.EventDeferred},
+		{"opened", "", mailer.EventOpened},
+		{"clicked", "", mailer.EventClicked},
+		{"complained", "", mailer.EventComplained},
+		{"unsubscribed", "", mailer.EventUnsubscribed},
+		{"accepted", "", ""},
+	} {
+		events = nil
+		if code := post("key", c.event, c.severity); code != http.StatusOK {
+			t.Fatalf("MailgunWebhook %s got: %d", c.event, code)
+		}
+		if c.want == "" {
+			if len(events) != 0 {
+				t.Errorf("MailgunWebhook %s got: %+v", c.event, events)
+			}
+			continue
+		}
+		if len(events) != 1 || events[0].Type != c.want || events[0].Email != "a@host.com" ||
+			events[0].MessageID != "<1.stub@example.com>" || events[0].Reason != "mailbox full" ||
+			!events[0].Timestamp.Equal(time.Unix(1760000000, 5e8)) {
+			t.Errorf("MailgunWebhook %s got: %+v", c.event, events)
+		}
+	}
+
+	if code := post("other", "delivered", ""); code != http.StatusUnauthorized {
+		t.Errorf("MailgunWebhook with a bad signature got: %d", code)
+	}
+
+	events = nil
+	timestamp, token, signature := mailgunSigned("key")
+	form := url.Values{
+		"event":      {"bounced"},
+		"recipient":  {"b@host.com"},
+		"Message-Id": {"<2.stub@example.com>"},
+		"error":      {"550 unknown user"},
+		"timestamp":  {timestamp},
+		"token":      {token},
+		"signature":  {signature},
+	}
+	if code := postWebhook(h, "application/x-www-form-urlencoded", form.Encode()); code != http.StatusOK {
+		t.Fatalf("MailgunWebhook form got: %d", code)
+	}
+	if len(events) != 1 || events[0].Type != mailer.EventBounced || events[0].Email != "b@host.com" ||
+		events[0].MessageID != "<2.stub@example.com>" || events[0].Reason != "550 unknown user" {
+		t.Errorf("MailgunWebhook form got: %+v", events)
+	}
+	form.Set("signature", mailgunSignature("other", timestamp, token))
+	if code := postWebhook(h, "application/x-www-form-urlencoded", form.Encode()); code != http.StatusUnauthorized {
+		t.Errorf("MailgunWebhook form with a bad signature got: %d", code)
+	}
+}
+
+func TestMailgunWebhookReplay(t *testing.T) {
+	t.Log("Mailgun webhooks replayed or stale... (expected some err)")
+
+	fail := true
+	h := mailer.NewMailgunWebhook("key", func(e mailer.Event) error {
+		if fail {
+			return fmt.Errorf("store down")
+		}
+		return nil
+	})
+	form := func(timestamp, token string) string {
+		return url.Values{
+			"event":     {"complained"},
+			"recipient": {"a@host.com"},
+			"timestamp": {timestamp},
+			"token":     {token},
+			"signature": {mailgunSignature("key", timestamp, token)},
+		}.Encode()
+	}
+	const contentType = "application/x-www-form-urlencoded"
+
+	timestamp, token, _ := mailgunSigned("key")
+	post := form(timestamp, token)
+	if code := postWebhook(h, contentType, post); code != http.StatusInternalServerError {
+		t.Errorf("MailgunWebhook handler error got: %d", code)
+	}
+	// the retry of a failed post is taken once
+	fail = false
+	if code := postWebhook(h, contentType, post); code != http.StatusOK {
+		t.Errorf("MailgunWebhook retry got: %d", code)
+	}
+	if code := postWebhook(h, contentType, post); code != http.StatusUnauthorized {
+		t.Errorf("MailgunWebhook replay got: %d", code)
+	}
+
+	for name, offset := range map[string]time.Duration{
+		"stale":  -mailer.MailgunTimestampTolerance - time.Minute,
+		"future": mailer.MailgunTimestampTolerance + time.Minute,
+	} {
+		ts := fmt.Sprint(time.Now().Add(offset).Unix())
+		if code := postWebhook(h, contentType, form(ts, name+"-token")); code != http.StatusUnauthorized {
+			t.Errorf("MailgunWebhook %s got: %d", name, code)
+		}
+	}
+
+	sg := mailer.NewSendgridWebhook(nil)
+	if code := postWebhook(sg, "application/json", "["+strings.Repeat(" ", 10<<20)+"]"); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("SendgridWebhook with a large body got: %d", code)
+	}
+	big := form(timestamp, "big") + "&pad=" + strings.Repeat("x", 10<<20)
+	if code := postWebhook(h, contentType, big); code != http.StatusBadRequest {
+		t.Errorf("MailgunWebhook with a large body got: %d", code)
+	}
+}
+
+// snsSigner signing certificate served as https://sns.us-east-1.amazonaws.com
+type snsSigner struct {
+	key    *rsa.PrivateKey
+	client *http.Client
+	hits   map[string]int
+}
+
+// newSNSSigner start a TLS server serving the certificate and answering the
+// subscription confirmations, reached by client whatever the host
+func newSNSSigner(t *testing.T) *snsSigner {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	s := &snsSigner{key: key, hits: map[string]int{}}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.hits[r.URL.Path]++
+		if r.URL.Path == "/cert.pem" {
+			w.Write(certPEM)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	transport := srv.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+	}
+	s.client = &http.Client{Transport: transport}
+	return s
+}
+
+// post sign an SNS message and post it to h, after the changes. A message
+// without them gets a new MessageId and the Timestamp of now
+func (s *snsSigner) post(t *testing.T, h http.Handler, version string, msg map[string]string, changes ...func(map[string]string)) int {
+	if _, ok := msg["MessageId"]; !ok {
+		id := make([]byte, 8)
+		rand.Read(id)
+		msg["MessageId"] = hex.EncodeToString(id)
+	}
+	if _, ok := msg["Timestamp"]; !ok {
+		msg["Timestamp"] = time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+	}
+	msg["SignatureVersion"] = version
+	msg["SigningCertURL"] = "https://sns.us-east-1.amazonaws.com/cert.pem"
+	keys := []string{"Message", "MessageId", "Subject", "SubscribeURL", "Timestamp", "Token", "TopicArn", "Type"}
+	var signed string
+	for _, k := range keys {
+		if v, ok := msg[k]; ok {
+			signed += k + "\n" + v + "\n"
+		}
+	}
+
+	hash, digest := crypto.SHA1, []byte(nil)
+	if version == "1" {
+		sum := sha1.Sum([]byte(signed))
+		digest = sum[:]
+	} else {
+		sum := sha256.Sum256([]byte(signed))
+		hash, digest = crypto.SHA256, sum[:]
+	}
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, hash, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg["Signature"] = base64.StdEncoding.EncodeToString(sig)
+	for _, change := range changes {
+		change(msg)
+	}
+
+	body, _ := json.Marshal(msg)
+	return postWebhook(h, "text/plain; charset=UTF-8", string(body))
+}
+
+func TestSESWebhook(t *testing.T) {
+	t.Log("SES notifications through SNS... (NOT expected some err)")
+
+	signer := newSNSSigner(t)
+	var events []mailer.Event
+	h := mailer.NewSESWebhook(func(e mailer.Event) error {
+		events = append(events, e)
+		return nil
+	}, "arn:aws:sns:us-east-1:123:ses")
+	h.Client = signer.client
+
+	code := signer.post(t, h, "1", map[string]string{
+		"Type":         "SubscriptionConfirmation",
+		"Token":        "tok",
+		"TopicArn":     "arn:aws:sns:us-east-1:123:ses",
+		"Message":      "You have chosen to subscribe",
+		"SubscribeURL": "https://sns.us-east-1.amazonaws.com/confirm",
+	})
+	if code != http.StatusOK || signer.hits["/confirm"] != 1 {
+		t.Errorf("SESWebhook subscription got: %d, %d confirmations", code, signer.hits["/confirm"])
+	}
+
+	notification := func(message string) map[string]string {
+		return map[string]string{
+			"Type":     "Notification",
+			"TopicArn": "arn:aws:sns:us-east-1:123:ses",
+			"Subject":  "Amazon SES Email Event Notification",
+			"Message":  message,
+		}
+	}
+	bounce := `{"notificationType":"Bounce","mail":{"messageId":"0100-stub","destination":["a@host.com","b@host.com"]},
+		"bounce":{"bounceType":"Permanent","timestamp":"2026-10-19T10:00:00Z",
+		"bouncedRecipients":[{"emailAddress":"a@host.com","diagnosticCode":"smtp; 550 unknown user"},{"emailAddress":"b@host.com"}]}}`
+	if code := signer.post(t, h, "2", notification(bounce)); code != http.StatusOK {
+		t.Fatalf("SESWebhook bounce got: %d", code)
+	}
+	if len(events) != 2 || events[0].Type != mailer.EventBounced || events[0].Email != "a@host.com" ||
+		events[0].MessageID != "0100-stub" || events[0].Reason != "smtp; 550 unknown user" ||
+		events[0].Provider != "awsses" || events[1].Email != "b@host.com" ||
+		!events[0].Timestamp.Equal(time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("SESWebhook bounce got: %+v", events)
+	}
+
+	for message, want := range map[string]mailer.EventType{
+		`{"notificationType":"Bounce","mail":{"messageId":"x"},"bounce":{"bounceType":"Transient","bouncedRecipients":[{"emailAddress":"a@host.com"}]}}`: mailer.EventDeferred,
+		`{"notificationType":"Complaint","mail":{"messageId":"x"},"complaint":{"complainedRecipients":[{"emailAddress":"a@host.com"}]}}`:                 mailer.EventComplained,
+		`{"notificationType":"Delivery","mail":{"messageId":"x"},"delivery":{"recipients":["a@host.com"]}}`:                                              mailer.EventDelivered,
+		`{"eventType":"DeliveryDelay","mail":{"messageId":"x"},"deliveryDelay":{"delayedRecipients":[{"emailAddress":"a@host.com"}]}}`:                   mailer.EventDeferred,
+		`{"eventType":"Open","mail":{"messageId":"x","destination":["a@host.com"]},"open":{}}`:                                                           mailer.EventOpened,
+		`{"eventType":"Click","mail":{"messageId":"x","destination":["a@host.com"]},"click":{"link":"https://example.com"}}`:                             mailer.EventClicked,
+	} {
+		events = nil
+		if code := signer.post(t, h, "1", notification(message)); code != http.StatusOK {
+			t.Fatalf("SESWebhook %s got: %d", want, code)
+		}
+		if len(events) != 1 || events[0].Type != want || events[0].Email != "a@host.com" {
+			t.Errorf("SESWebhook %s got: %+v", want, events)
+		}
+	}
+	if hits := signer.hits["/cert.pem"]; hits != 1 {
+		t.Errorf("SESWebhook fetched the certificate %d times", hits)
+	}
+}
+
+func TestSESWebhookErrors(t *testing.T) {
+	t.Log("SES notifications with bad signatures... (expected some err)")
+
+	signer := newSNSSigner(t)
+	h := mailer.NewSESWebhook(func(mailer.Event) error { return nil }, "arn:aws:sns:us-east-1:123:ses")
+	h.Client = signer.client
+
+	msg := func() map[string]string {
+		return map[string]string{
+			"Type":     "Notification",
+			"TopicArn": "arn:aws:sns:us-east-1:123:ses",
+			"Message":  `{"notificationType":"Delivery","mail":{"messageId":"x"},"delivery":{"recipients":["a@host.com"]}}`,
+		}
+	}
+
+	tamper := func(msg map[string]string) {
+		msg["Message"] = strings.Replace(msg["Message"], "a@host.com", "z@host.com", 1)
+	}
+	if code := signer.post(t, h, "1", msg(), tamper); code != http.StatusUnauthorized {
+		t.Errorf("SESWebhook with a changed message got: %d", code)
+	}
+
+	other := msg()
+	other["TopicArn"] = "arn:aws:sns:us-east-1:123:other"
+	if code := signer.post(t, h, "1", other); code != http.StatusForbidden {
+		t.Errorf("SESWebhook with another topic got: %d", code)
+	}
+
+	foreign := func(msg map[string]string) {
+		msg["SigningCertURL"] = "https://evil.example.com/cert.pem"
+	}
+	if code := signer.post(t, h, "1", msg(), foreign); code != http.StatusUnauthorized {
+		t.Errorf("SESWebhook with a foreign certificate got: %d", code)
+	}
+	if hits := signer.hits["/cert.pem"]; hits != 1 {
+		t.Errorf("SESWebhook fetched the certificates %d times", hits)
+	}
+
+	// a message is taken once, unless its handling failed
+	fail := true
+	once := mailer.NewSESWebhook(func(mailer.Event) error {
+		if fail {
+			return fmt.Errorf("store down")
+		}
+		return nil
+	})
+	once.Client = signer.client
+	replayed := msg()
+	if code := signer.post(t, once, "1", replayed); code != http.StatusInternalServerError {
+		t.Errorf("SESWebhook with a failing handler got: %d", code)
+	}
+	fail = false
+	body, _ := json.Marshal(replayed)
+	if code := postWebhook(once, "text/plain", string(body)); code != http.StatusOK {
+		t.Errorf("SESWebhook retry got: %d", code)
+	}
+	if code := postWebhook(once, "text/plain", string(body)); code != http.StatusUnauthorized {
+		t.Errorf("SESWebhook replay got: %d", code)
+	}
+	stale := msg()
+	stale["Timestamp"] = time.Now().Add(-mailer.SNSTimestampTolerance - time.Minute).UTC().Format(time.RFC3339)
+	if code := signer.post(t, once, "1", stale); code != http.StatusUnauthorized {
+		t.Errorf("SESWebhook stale got: %d", code)
+	}
+}